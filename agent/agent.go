@@ -0,0 +1,475 @@
+// Package agent implements a long-running certificate renewal agent.
+// The agent watches a set of certificate and key files, renews each
+// certificate through a remote CFSSL server once a configurable
+// fraction of its lifetime has elapsed, replaces the files atomically
+// and runs a reload hook so that the service using them can pick up
+// the renewed certificate.
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/bbandix/cfssl/api/client"
+	"github.com/bbandix/cfssl/csr"
+	cferr "github.com/bbandix/cfssl/errors"
+	"github.com/bbandix/cfssl/helpers"
+	"github.com/bbandix/cfssl/log"
+	"github.com/bbandix/cfssl/renew"
+)
+
+// Defaults for the agent configuration.
+const (
+	DefaultRenewAt          = 2.0 / 3.0
+	DefaultCheckInterval    = time.Minute
+	DefaultMinRetryInterval = 30 * time.Second
+	DefaultMaxRetryInterval = time.Hour
+	DefaultHookTimeout      = time.Minute
+)
+
+// A Certificate describes a certificate and key pair watched by the
+// agent.
+type Certificate struct {
+	// Cert and Key are the paths of the PEM-encoded certificate
+	// and private key.
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+
+	// Profile and Label select the signing profile and signer
+	// used on the remote for renewal.
+	Profile string `json:"profile"`
+	Label   string `json:"label"`
+
+	// RenewAt is the fraction of the certificate's lifetime after
+	// which it is renewed. If zero, the agent-wide value is used.
+	RenewAt float64 `json:"renew_at"`
+
+	// Rekey generates a new key of the same type and size on every
+	// renewal instead of renewing for the existing key.
+	Rekey bool `json:"rekey"`
+
+	// Hook is a shell command run after the certificate has been
+	// renewed and the files replaced. It is killed if it runs for
+	// longer than the agent's hook timeout.
+	Hook string `json:"hook"`
+}
+
+// Config is the agent configuration. Durations are given as strings
+// in the form accepted by time.ParseDuration.
+type Config struct {
+	Remote                 string         `json:"remote"`
+	RenewAt                float64        `json:"renew_at"`
+	CheckIntervalString    string         `json:"check_interval"`
+	MinRetryIntervalString string         `json:"min_retry_interval"`
+	MaxRetryIntervalString string         `json:"max_retry_interval"`
+	HookTimeoutString      string         `json:"hook_timeout"`
+	Certificates           []*Certificate `json:"certificates"`
+
+	CheckInterval    time.Duration `json:"-"`
+	MinRetryInterval time.Duration `json:"-"`
+	MaxRetryInterval time.Duration `json:"-"`
+	HookTimeout      time.Duration `json:"-"`
+}
+
+func invalidConfig(msg string) error {
+	return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy, errors.New(msg))
+}
+
+// parseDuration parses s into d, leaving d at def if s is empty.
+func parseDuration(s string, def time.Duration, d *time.Duration) error {
+	*d = def
+	if s == "" {
+		return nil
+	}
+
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy, err)
+	}
+	if dur <= 0 {
+		return invalidConfig("durations must be positive")
+	}
+	*d = dur
+	return nil
+}
+
+// LoadFile attempts to load the agent configuration stored at path.
+func LoadFile(path string) (*Config, error) {
+	log.Debugf("loading agent configuration file from %s", path)
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, invalidConfig("could not read agent configuration file")
+	}
+
+	return LoadConfig(body)
+}
+
+// LoadConfig attempts to load the agent configuration from a byte
+// slice, filling in defaults for any values that are not set. The
+// remote is not required here, as it may be given on the command
+// line.
+func LoadConfig(body []byte) (*Config, error) {
+	var cfg Config
+	err := json.Unmarshal(body, &cfg)
+	if err != nil {
+		return nil, invalidConfig("failed to unmarshal agent configuration: " + err.Error())
+	}
+
+	if err = parseDuration(cfg.CheckIntervalString, DefaultCheckInterval, &cfg.CheckInterval); err != nil {
+		return nil, err
+	}
+	if err = parseDuration(cfg.MinRetryIntervalString, DefaultMinRetryInterval, &cfg.MinRetryInterval); err != nil {
+		return nil, err
+	}
+	if err = parseDuration(cfg.MaxRetryIntervalString, DefaultMaxRetryInterval, &cfg.MaxRetryInterval); err != nil {
+		return nil, err
+	}
+	if err = parseDuration(cfg.HookTimeoutString, DefaultHookTimeout, &cfg.HookTimeout); err != nil {
+		return nil, err
+	}
+	if cfg.MaxRetryInterval < cfg.MinRetryInterval {
+		return nil, invalidConfig("max_retry_interval is shorter than min_retry_interval")
+	}
+
+	if cfg.RenewAt == 0 {
+		cfg.RenewAt = DefaultRenewAt
+	}
+
+	if len(cfg.Certificates) == 0 {
+		return nil, invalidConfig("no certificates to watch")
+	}
+
+	for _, c := range cfg.Certificates {
+		if c.Cert == "" || c.Key == "" {
+			return nil, invalidConfig("certificates need both a cert and a key path")
+		}
+		if c.RenewAt == 0 {
+			c.RenewAt = cfg.RenewAt
+		}
+		if c.RenewAt <= 0 || c.RenewAt >= 1 {
+			return nil, invalidConfig("renew_at must be between 0 and 1")
+		}
+	}
+
+	return &cfg, nil
+}
+
+// keyAlgo returns the csr package name of the algorithm of pub.
+func keyAlgo(pub crypto.PublicKey) string {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return "rsa"
+	case *ecdsa.PublicKey:
+		return "ecdsa"
+	default:
+		return ""
+	}
+}
+
+// watched is the renewal state of a watched certificate.
+type watched struct {
+	cert     *Certificate
+	failures int
+	next     time.Time
+}
+
+// An Agent renews the certificates in its configuration.
+type Agent struct {
+	remote  client.Remote
+	config  *Config
+	watched []*watched
+}
+
+// New returns an agent that renews the certificates in cfg through
+// remote.
+func New(cfg *Config, remote client.Remote) *Agent {
+	a := &Agent{remote: remote, config: cfg}
+	for _, c := range cfg.Certificates {
+		a.watched = append(a.watched, &watched{cert: c})
+	}
+	return a
+}
+
+// retryInterval returns the time to wait before the next renewal
+// attempt after the given number of consecutive failures: the minimum
+// retry interval doubled for every failure after the first and capped
+// at the maximum, with jitter so that agents which failed together do
+// not retry together.
+func (a *Agent) retryInterval(failures int) time.Duration {
+	d := a.config.MinRetryInterval
+	for i := 1; i < failures && d < a.config.MaxRetryInterval; i++ {
+		d *= 2
+	}
+	if d > a.config.MaxRetryInterval {
+		d = a.config.MaxRetryInterval
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Run checks every watched certificate each check interval, renewing
+// those that are due, until stop is closed. Certificates that fail to
+// renew are retried with exponential backoff.
+func (a *Agent) Run(stop <-chan struct{}) {
+	for {
+		now := time.Now()
+		for _, w := range a.watched {
+			if now.Before(w.next) {
+				continue
+			}
+
+			_, err := a.Check(w.cert)
+			if err != nil {
+				w.failures++
+				retry := a.retryInterval(w.failures)
+				w.next = now.Add(retry)
+				log.Warningf("failed to renew %s (attempt %d), retrying in %v: %v",
+					w.cert.Cert, w.failures, retry, err)
+				continue
+			}
+
+			w.failures = 0
+			w.next = time.Time{}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(a.config.CheckInterval):
+		}
+	}
+}
+
+// Check renews c if the configured fraction of its lifetime has
+// elapsed, replacing the certificate (and, when rekeying, the key) on
+// disk and running the hook. The files are only replaced if the
+// renewed certificate is for the key they will hold. It returns true
+// if the certificate was renewed. A failing hook is logged, but does not cause an error: the
+// certificate has been renewed at that point.
+func (a *Agent) Check(c *Certificate) (bool, error) {
+	certPEM, err := ioutil.ReadFile(c.Cert)
+	if err != nil {
+		return false, err
+	}
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		return false, err
+	}
+
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	due := cert.NotBefore.Add(time.Duration(float64(lifetime) * c.RenewAt))
+	if time.Now().Before(due) {
+		return false, nil
+	}
+
+	keyPEM, err := ioutil.ReadFile(c.Key)
+	if err != nil {
+		return false, err
+	}
+	priv, err := helpers.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return false, err
+	}
+
+	req := &renew.Request{
+		Certificate: string(certPEM),
+		Profile:     c.Profile,
+		Label:       c.Label,
+	}
+
+	var newKeyPEM []byte
+	if c.Rekey {
+		var csrPEM []byte
+		csrPEM, newKeyPEM, err = csr.ParseRequest(&csr.CertificateRequest{
+			CN: cert.Subject.CommonName,
+			KeyRequest: &csr.BasicKeyRequest{
+				A: keyAlgo(priv.Public()),
+				S: helpers.KeyLength(priv.Public()),
+			},
+		})
+		if err != nil {
+			return false, err
+		}
+		req.Request = string(csrPEM)
+	}
+
+	// The proof of possession is always made with the existing
+	// key, which is what the remote knows the holder by.
+	if err = req.Sign(priv); err != nil {
+		return false, err
+	}
+
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		return false, err
+	}
+
+	log.Infof("renewing %s (serial number %d, expires %v)", c.Cert, cert.SerialNumber, cert.NotAfter)
+	renewed, err := a.remote.Renew(reqJSON)
+	if err != nil {
+		return false, err
+	}
+
+	renewedCert, err := helpers.ParseCertificatePEM(renewed)
+	if err != nil {
+		return false, err
+	}
+
+	// The remote must have certified the key the files will hold,
+	// or the service would be left with a certificate it can't
+	// use.
+	pub := priv.Public()
+	if newKeyPEM != nil {
+		newKey, err := helpers.ParsePrivateKeyPEM(newKeyPEM)
+		if err != nil {
+			return false, err
+		}
+		pub = newKey.Public()
+	}
+	if err = checkPublicKey(renewedCert, pub); err != nil {
+		return false, err
+	}
+
+	if newKeyPEM != nil {
+		err = replaceKeyAndCert(c.Key, keyPEM, newKeyPEM, c.Cert, renewed)
+	} else {
+		err = replaceFile(c.Cert, renewed, 0644)
+	}
+	if err != nil {
+		return false, err
+	}
+	log.Infof("renewed %s", c.Cert)
+
+	if c.Hook != "" {
+		a.runHook(c)
+	}
+	return true, nil
+}
+
+// runHook runs the hook of c, killing it once the hook timeout has
+// passed, so that a hung hook doesn't stall the agent. Failures are
+// logged.
+func (a *Agent) runHook(c *Certificate) {
+	timeout := a.config.HookTimeout
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", c.Hook)
+	// Killing the shell leaves any commands it started running, and
+	// they may hold on to its output; stop waiting for them shortly
+	// after.
+	cmd.WaitDelay = time.Second
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Errorf("reload hook for %s timed out after %v: %s", c.Cert, timeout, out)
+	} else if err != nil {
+		log.Errorf("reload hook for %s failed: %v: %s", c.Cert, err, out)
+	}
+}
+
+// checkPublicKey returns an error unless cert is for the public key
+// pub.
+func checkPublicKey(cert *x509.Certificate, pub crypto.PublicKey) error {
+	pkix, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(pkix, cert.RawSubjectPublicKeyInfo) {
+		return cferr.New(cferr.PrivateKeyError, cferr.KeyMismatch)
+	}
+	return nil
+}
+
+// writeTemp writes data to a new temporary file in the same directory
+// as path, ready to be renamed over it, and returns the temporary
+// file's name. An existing file's permissions are kept; perm is used
+// for new files.
+func writeTemp(path string, data []byte, perm os.FileMode) (string, error) {
+	if fi, err := os.Stat(path); err == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// replaceFile replaces the file at path with data by writing it to a
+// temporary file in the same directory and renaming it into place, so
+// that readers see either the old or the new contents.
+func replaceFile(path string, data []byte, perm os.FileMode) error {
+	tmp, err := writeTemp(path, data, perm)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	return os.Rename(tmp, path)
+}
+
+// replaceKeyAndCert replaces the key and certificate files with a new
+// key and the certificate issued for it. Both are written out before
+// either is renamed into place, so that a failed write leaves both
+// files as they were; if the certificate can't be renamed into place,
+// the old key, oldKeyPEM, is put back. Readers may still see the new
+// key with the old certificate between the two renames.
+func replaceKeyAndCert(keyPath string, oldKeyPEM, keyPEM []byte, certPath string, certPEM []byte) error {
+	keyTmp, err := writeTemp(keyPath, keyPEM, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(keyTmp)
+
+	certTmp, err := writeTemp(certPath, certPEM, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(certTmp)
+
+	oldKeyTmp, err := writeTemp(keyPath, oldKeyPEM, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(oldKeyTmp)
+
+	if err = os.Rename(keyTmp, keyPath); err != nil {
+		return err
+	}
+	if err = os.Rename(certTmp, certPath); err != nil {
+		if rbErr := os.Rename(oldKeyTmp, keyPath); rbErr != nil {
+			log.Errorf("failed to restore %s after a failed renewal: %v", keyPath, rbErr)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,408 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bbandix/cfssl/api/client"
+	apirenew "github.com/bbandix/cfssl/api/renew"
+	"github.com/bbandix/cfssl/config"
+	"github.com/bbandix/cfssl/csr"
+	"github.com/bbandix/cfssl/helpers"
+	"github.com/bbandix/cfssl/signer"
+	"github.com/bbandix/cfssl/signer/local"
+)
+
+const (
+	testCaFile    = "../api/testdata/ca.pem"
+	testCaKeyFile = "../api/testdata/ca_key.pem"
+)
+
+// newTestCA starts a renewal server and returns it with its signer.
+func newTestCA(t *testing.T) (*httptest.Server, signer.Signer) {
+	s, err := local.NewSignerFromFile(testCaFile, testCaKeyFile, &config.Signing{
+		Profiles: map[string]*config.SigningProfile{},
+		Default: &config.SigningProfile{
+			Usage:  []string{"digital signature", "server auth"},
+			Expiry: time.Hour,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := apirenew.NewHandlerFromSigner(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(h), s
+}
+
+// newTestCertificate issues a certificate from s and writes it and its
+// key into dir.
+func newTestCertificate(t *testing.T, s signer.Signer, dir string) *Certificate {
+	csrPEM, keyPEM, err := csr.ParseRequest(&csr.CertificateRequest{
+		CN:         "agent.example.com",
+		Hosts:      []string{"agent.example.com"},
+		KeyRequest: csr.NewBasicKeyRequest(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM, err := s.Sign(signer.SignRequest{Request: string(csrPEM)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Certificate{
+		Cert: filepath.Join(dir, "cert.pem"),
+		Key:  filepath.Join(dir, "key.pem"),
+	}
+	if err = ioutil.WriteFile(c.Cert, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(c.Key, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func newTestAgent(t *testing.T, ts *httptest.Server, certs ...*Certificate) *Agent {
+	cfg := &Config{
+		CheckInterval:    DefaultCheckInterval,
+		MinRetryInterval: DefaultMinRetryInterval,
+		MaxRetryInterval: DefaultMaxRetryInterval,
+		Certificates:     certs,
+	}
+	return New(cfg, client.NewServer(strings.TrimPrefix(ts.URL, "http://")))
+}
+
+func readCert(t *testing.T, path string) *x509.Certificate {
+	certPEM, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestLoadConfig(t *testing.T) {
+	cfg, err := LoadConfig([]byte(`{
+		"remote": "ca.example.com",
+		"check_interval": "5m",
+		"certificates": [
+			{"cert": "a.pem", "key": "a-key.pem"},
+			{"cert": "b.pem", "key": "b-key.pem", "renew_at": 0.5}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.CheckInterval != 5*time.Minute || cfg.MaxRetryInterval != DefaultMaxRetryInterval ||
+		cfg.HookTimeout != DefaultHookTimeout {
+		t.Fatalf("durations not populated: %+v", cfg)
+	}
+	if cfg.Certificates[0].RenewAt != DefaultRenewAt || cfg.Certificates[1].RenewAt != 0.5 {
+		t.Fatal("renew_at not populated")
+	}
+
+	bad := []string{
+		`{}`,
+		`{"certificates": [{"cert": "a.pem"}]}`,
+		`{"certificates": [{"cert": "a.pem", "key": "a-key.pem", "renew_at": 1.5}]}`,
+		`{"check_interval": "soon", "certificates": [{"cert": "a.pem", "key": "a-key.pem"}]}`,
+		`{"min_retry_interval": "1h", "max_retry_interval": "1m", "certificates": [{"cert": "a.pem", "key": "a-key.pem"}]}`,
+		`{"hook_timeout": "-1s", "certificates": [{"cert": "a.pem", "key": "a-key.pem"}]}`,
+	}
+	for _, body := range bad {
+		if _, err = LoadConfig([]byte(body)); err == nil {
+			t.Fatalf("expected an error loading %s", body)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	ts, s := newTestCA(t)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "cfssl-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := newTestCertificate(t, s, dir)
+	c.Hook = "touch " + filepath.Join(dir, "reloaded")
+	a := newTestAgent(t, ts, c)
+	old := readCert(t, c.Cert)
+
+	// Not due yet.
+	c.RenewAt = 0.99
+	renewed, err := a.Check(c)
+	if err != nil || renewed {
+		t.Fatalf("certificate renewed early (%v)", err)
+	}
+
+	// The certificate is backdated, so a small fraction of its
+	// lifetime has already elapsed.
+	c.RenewAt = 0.01
+	renewed, err = a.Check(c)
+	if err != nil || !renewed {
+		t.Fatalf("certificate not renewed: %v", err)
+	}
+
+	cert := readCert(t, c.Cert)
+	if cert.SerialNumber.Cmp(old.SerialNumber) == 0 {
+		t.Fatal("certificate on disk was not replaced")
+	}
+	if !bytes.Equal(cert.RawSubjectPublicKeyInfo, old.RawSubjectPublicKeyInfo) {
+		t.Fatal("renewal without rekey changed the key")
+	}
+	if _, err = os.Stat(filepath.Join(dir, "reloaded")); err != nil {
+		t.Fatal("reload hook did not run")
+	}
+}
+
+func TestCheckHookTimeout(t *testing.T) {
+	ts, s := newTestCA(t)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "cfssl-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := newTestCertificate(t, s, dir)
+	c.RenewAt = 0.01
+	c.Hook = "sleep 60; sleep 60"
+	a := newTestAgent(t, ts, c)
+	a.config.HookTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	renewed, err := a.Check(c)
+	if err != nil || !renewed {
+		t.Fatalf("certificate not renewed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("hung hook was not killed, ran for %v", elapsed)
+	}
+}
+
+func TestCheckRekey(t *testing.T) {
+	ts, s := newTestCA(t)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "cfssl-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := newTestCertificate(t, s, dir)
+	c.RenewAt = 0.01
+	c.Rekey = true
+	a := newTestAgent(t, ts, c)
+	old := readCert(t, c.Cert)
+
+	if _, err = a.Check(c); err != nil {
+		t.Fatal(err)
+	}
+
+	cert := readCert(t, c.Cert)
+	if bytes.Equal(cert.RawSubjectPublicKeyInfo, old.RawSubjectPublicKeyInfo) {
+		t.Fatal("rekey kept the old key")
+	}
+
+	keyPEM, err := ioutil.ReadFile(c.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := helpers.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(priv.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cert.RawSubjectPublicKeyInfo, pub) {
+		t.Fatal("key on disk does not match the renewed certificate")
+	}
+
+	fi, err := os.Stat(c.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("key permissions changed to %v", fi.Mode().Perm())
+	}
+}
+
+func TestCheckUnavailable(t *testing.T) {
+	ts, s := newTestCA(t)
+
+	dir, err := ioutil.TempDir("", "cfssl-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := newTestCertificate(t, s, dir)
+	c.RenewAt = 0.01
+	a := newTestAgent(t, ts, c)
+	ts.Close()
+
+	old, err := ioutil.ReadFile(c.Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.Check(c); err == nil {
+		t.Fatal("expected an error with the CA unavailable")
+	}
+
+	cur, err := ioutil.ReadFile(c.Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(old, cur) {
+		t.Fatal("certificate on disk changed after a failed renewal")
+	}
+}
+
+// fixedRenewal is a remote that answers renewal requests with a fixed
+// certificate.
+type fixedRenewal struct {
+	client.Remote
+	certPEM []byte
+}
+
+func (r fixedRenewal) Renew(jsonData []byte) ([]byte, error) {
+	return r.certPEM, nil
+}
+
+func TestCheckKeyMismatch(t *testing.T) {
+	ts, s := newTestCA(t)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "cfssl-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err = os.Mkdir(filepath.Join(dir, "other"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	other, err := ioutil.ReadFile(newTestCertificate(t, s, filepath.Join(dir, "other")).Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestCertificate(t, s, dir)
+	c.RenewAt = 0.01
+	for _, rekey := range []bool{false, true} {
+		c.Rekey = rekey
+		a := newTestAgent(t, ts, c)
+		a.remote = fixedRenewal{a.remote, other}
+
+		certPEM, err := ioutil.ReadFile(c.Cert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyPEM, err := ioutil.ReadFile(c.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = a.Check(c); err == nil {
+			t.Fatalf("expected an error with a certificate for another key (rekey %v)", rekey)
+		}
+
+		cur, _ := ioutil.ReadFile(c.Cert)
+		curKey, _ := ioutil.ReadFile(c.Key)
+		if !bytes.Equal(certPEM, cur) || !bytes.Equal(keyPEM, curKey) {
+			t.Fatalf("files replaced with a certificate for another key (rekey %v)", rekey)
+		}
+	}
+}
+
+func TestRetryInterval(t *testing.T) {
+	a := New(&Config{
+		MinRetryInterval: time.Second,
+		MaxRetryInterval: time.Minute,
+	}, nil)
+
+	for failures, max := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		4:  8 * time.Second,
+		10: time.Minute,
+	} {
+		d := a.retryInterval(failures)
+		if d < max/2 || d > max {
+			t.Fatalf("retry interval after %d failures is %v, want between %v and %v",
+				failures, d, max/2, max)
+		}
+	}
+}
+
+func TestReplaceKeyAndCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cfssl-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyPath := filepath.Join(dir, "key.pem")
+	certPath := filepath.Join(dir, "cert.pem")
+	if err = ioutil.WriteFile(keyPath, []byte("old key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(certPath, []byte("old cert"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = replaceKeyAndCert(keyPath, []byte("old key"), []byte("new key"), certPath, []byte("new cert")); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{keyPath: "new key", certPath: "new cert"} {
+		if data, _ := ioutil.ReadFile(path); string(data) != want {
+			t.Fatalf("%s has %q, want %q", path, data, want)
+		}
+	}
+
+	// A directory can't be replaced by the certificate: the key is
+	// put back, and no temporary files are left behind.
+	certPath = filepath.Join(dir, "certdir")
+	if err = os.MkdirAll(filepath.Join(certPath, "busy"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = replaceKeyAndCert(keyPath, []byte("new key"), []byte("newer key"), certPath, []byte("newer cert")); err == nil {
+		t.Fatal("expected replacing a directory to fail")
+	}
+	if data, _ := ioutil.ReadFile(keyPath); string(data) != "new key" {
+		t.Fatalf("key not restored after a failed renewal, have %q", data)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range entries {
+		if strings.HasPrefix(fi.Name(), ".") {
+			t.Fatalf("temporary file %s left behind", fi.Name())
+		}
+	}
+}
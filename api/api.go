@@ -2,10 +2,16 @@
 package api
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/bbandix/cfssl/audit"
 	"github.com/bbandix/cfssl/errors"
 	"github.com/bbandix/cfssl/log"
 )
@@ -77,13 +83,80 @@ func (h HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			match = true
 		}
 	}
+	r = WithRequestID(w, r)
 	if match {
 		err = h.Handle(w, r)
 	} else {
 		err = errors.NewMethodNotAllowed(r.Method)
 	}
 	status := handleError(w, err)
-	log.Infof("%s - \"%s %s\" %d", r.RemoteAddr, r.Method, r.URL, status)
+	log.FromContext(r.Context()).Info("request served", "remote", r.RemoteAddr,
+		"method", r.Method, "url", r.URL.String(), "status", status)
+}
+
+// RequestIDHeader is the response header that carries the ID of the
+// request, under which its log messages are recorded.
+const RequestIDHeader = "X-Request-Id"
+
+// WithRequestID returns r with an ID for the request in its context, as
+// read by log.RequestID, and sets the ID in the response header. A
+// request that already has an ID, because an outer handler has given
+// it one, keeps it.
+func WithRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	if log.RequestID(r.Context()) != "" {
+		return r
+	}
+
+	id := newRequestID()
+	w.Header().Set(RequestIDHeader, id)
+	return r.WithContext(log.WithRequestID(r.Context(), id))
+}
+
+// RequestIDHandler wraps h to give each request an ID, for handlers
+// that aren't HTTPHandlers or that pass requests on to several of them.
+func RequestIDHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, WithRequestID(w, r))
+	})
+}
+
+// Requester returns the identity of the client making a request, for
+// the audit log: its IP address, preceded by the common name of its
+// certificate and "@" if it presented one.
+func Requester(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0].Subject.CommonName + "@" + host
+	}
+	return host
+}
+
+// AuditDenied records in the audit log that a request for an operation
+// was refused before it reached the signer, and why.
+func AuditDenied(r *http.Request, operation, profile, reason string) {
+	rec := &audit.Record{
+		Operation: operation,
+		RequestID: log.RequestID(r.Context()),
+		Requester: Requester(r),
+		Profile:   profile,
+		Outcome:   audit.OutcomeDenied,
+		Error:     reason,
+	}
+	if err := audit.Write(rec); err != nil {
+		log.FromContext(r.Context()).Error("failed to write audit record", "error", err)
+	}
+}
+
+// newRequestID returns a random request ID.
+func newRequestID() string {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(id[:])
 }
 
 // readRequestBlob takes a JSON-blob-encoded response body in the form
@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/bbandix/cfssl/log"
 )
 
 const (
@@ -218,3 +220,30 @@ func TestCleverHandle(t *testing.T) {
 		t.Errorf("Test expected 405, have %d", resp.StatusCode)
 	}
 }
+
+func TestRequestID(t *testing.T) {
+	var id string
+	h := HTTPHandler{
+		Handler: HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			id = log.RequestID(r.Context())
+			return SendResponse(w, ty)
+		}),
+		Methods: []string{"GET"},
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if id == "" {
+		t.Fatal("handler was not given a request ID")
+	}
+	if header := w.Header().Get(RequestIDHeader); header != id {
+		t.Fatalf("expected request ID %s in the response header, have %q", id, header)
+	}
+
+	// A request that already has an ID keeps it.
+	w = httptest.NewRecorder()
+	RequestIDHandler(h).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if header := w.Header()[RequestIDHeader]; len(header) != 1 || header[0] != id {
+		t.Fatalf("expected the request ID %s given by the outer handler, have %v", id, header)
+	}
+}
@@ -3,6 +3,7 @@ package client
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	stderr "errors"
 	"fmt"
@@ -22,8 +23,11 @@ import (
 
 // A server points to a single remote CFSSL instance.
 type server struct {
-	Address string
-	Port    int
+	Address   string
+	Port      int
+	timeout   time.Duration
+	tlsConfig *tls.Config
+	transport *http.Transport
 }
 
 // A Remote points to at least one (but possibly multiple) remote
@@ -34,10 +38,22 @@ type server struct {
 type Remote interface {
 	AuthSign(req, id []byte, provider auth.Provider) ([]byte, error)
 	Sign(jsonData []byte) ([]byte, error)
+	Renew(jsonData []byte) ([]byte, error)
 	Info(jsonData []byte) (*info.Resp, error)
 	Hosts() []string
 }
 
+// Stop ends any background work of r, such as the health checks of a
+// health-checked group, once r is no longer needed. Requests can still
+// be made through r afterwards.
+func Stop(r Remote) {
+	if s, ok := r.(interface {
+		Stop()
+	}); ok {
+		s.Stop()
+	}
+}
+
 // NewServer sets up a new server target. The address should be the
 // DNS name (or "name:port") of the remote CFSSL instance. If no port
 // is specified, the CFSSL default port (8888) is used. If the name is
@@ -82,17 +98,59 @@ func newServer(addr string) *server {
 		}
 	}
 
-	return &server{host, portno}
+	return &server{Address: host, Port: portno}
+}
+
+// configure applies the per-server parts of opts: the request timeout
+// and the TLS configuration. A server with a TLS configuration is
+// reached over HTTPS.
+func (srv *server) configure(opts Options) {
+	srv.timeout = opts.Timeout
+	srv.tlsConfig = opts.TLSConfig
+	if srv.tlsConfig != nil {
+		srv.transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: srv.tlsConfig,
+		}
+	}
+}
+
+func (srv *server) httpClient() *http.Client {
+	client := &http.Client{Timeout: srv.timeout}
+	if srv.transport != nil {
+		client.Transport = srv.transport
+	}
+	return client
 }
 
 func (srv *server) getURL(endpoint string) string {
-	return fmt.Sprintf("http://%s:%d/api/v1/cfssl/%s", srv.Address, srv.Port, endpoint)
+	scheme := "http"
+	if srv.tlsConfig != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/api/v1/cfssl/%s", scheme, net.JoinHostPort(srv.Address, strconv.Itoa(srv.Port)), endpoint)
+}
+
+// localIP returns the local address used to reach the server, or nil
+// if it cannot be determined. No packets are sent.
+func (srv *server) localIP() []byte {
+	conn, err := net.Dial("udp", net.JoinHostPort(srv.Address, strconv.Itoa(srv.Port)))
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+	return addr.IP
 }
 
 // post connects to the remote server and returns a Response struct
 func (srv *server) post(url string, jsonData []byte) (*api.Response, error) {
 	buf := bytes.NewBuffer(jsonData)
-	resp, err := http.Post(url, "application/json", buf)
+	resp, err := srv.httpClient().Post(url, "application/json", buf)
 	if err != nil {
 		return nil, errors.Wrap(errors.APIClientError, errors.ClientHTTPError, err)
 	}
@@ -145,18 +203,29 @@ func (srv *server) AuthInfo(req, id []byte, provider auth.Provider) ([]byte, err
 func (srv *server) authReq(req, ID []byte, provider auth.Provider, target string) ([]byte, error) {
 	url := srv.getURL("auth" + target)
 
-	token, err := provider.Token(req)
-	if err != nil {
-		return nil, errors.Wrap(errors.APIClientError, errors.AuthenticationFailure, err)
+	// An IP-bound token needs the address the server will see the
+	// request coming from; without one, use the local address of
+	// the route to the server.
+	if ID == nil && auth.NeedsRemoteAddress(provider) {
+		ID = srv.localIP()
 	}
 
 	aReq := &auth.AuthenticatedRequest{
 		Timestamp:     time.Now().Unix(),
 		RemoteAddress: ID,
-		Token:         token,
 		Request:       req,
 	}
 
+	var err error
+	if rp, ok := provider.(auth.RequestProvider); ok {
+		err = rp.Authenticate(aReq)
+	} else {
+		aReq.Token, err = provider.Token(req)
+	}
+	if err != nil {
+		return nil, errors.Wrap(errors.APIClientError, errors.AuthenticationFailure, err)
+	}
+
 	jsonData, err := json.Marshal(aReq)
 	if err != nil {
 		return nil, errors.Wrap(errors.APIClientError, errors.JSONError, err)
@@ -187,6 +256,13 @@ func (srv *server) Sign(jsonData []byte) ([]byte, error) {
 	return srv.request(jsonData, "sign")
 }
 
+// Renew sends a renewal request to the remote CFSSL server,
+// receiving a renewed certificate or an error in response.
+// It takes the serialized JSON request to send.
+func (srv *server) Renew(jsonData []byte) ([]byte, error) {
+	return srv.request(jsonData, "renew")
+}
+
 // Info sends an info request to the remote CFSSL server, receiving a
 // response or an error in response.
 // It takes the serialized JSON request to send.
@@ -217,6 +293,19 @@ func (srv *server) Info(jsonData []byte) (*info.Resp, error) {
 	return info, nil
 }
 
+// probe checks that the server is up by posting an empty request to
+// its info endpoint. Any response short of a server error counts: a
+// multi-root CA rejects the request for want of a label, but is still
+// able to serve requests that have one.
+func (srv *server) probe() bool {
+	resp, err := srv.httpClient().Post(srv.getURL("info"), "application/json", strings.NewReader("{}"))
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < http.StatusInternalServerError
+}
+
 func (srv *server) getResultMap(jsonData []byte, target string) (result map[string]interface{}, err error) {
 	url := srv.getURL(target)
 	response, err := srv.post(url, jsonData)
@@ -231,7 +320,7 @@ func (srv *server) getResultMap(jsonData []byte, target string) (result map[stri
 	return
 }
 
-// request performs the common logic for Sign and Info, performing the actual
+// request performs the common logic for Sign, Renew and Info, performing the actual
 // request and returning the resultant certificate.
 func (srv *server) request(jsonData []byte, target string) ([]byte, error) {
 	result, err := srv.getResultMap(jsonData, target)
@@ -1,11 +1,17 @@
 package client
 
 import (
+	"crypto/tls"
 	"errors"
+	"math/rand"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/bbandix/cfssl/auth"
 	"github.com/bbandix/cfssl/info"
+	"github.com/bbandix/cfssl/log"
 )
 
 // Strategy is the means by which the server to use as a remote should
@@ -22,13 +28,34 @@ const (
 	// client will proceed in this manner until the list of
 	// servers is exhausted, and then an error is returned.
 	StrategyOrderedList
+
+	// StrategyRoundRobin spreads requests over the servers by
+	// starting each request at the server after the one the
+	// previous request started at, failing over through the rest
+	// of the list in order.
+	StrategyRoundRobin
+
+	// StrategyRandom starts each request at a random server,
+	// failing over through the others in random order.
+	StrategyRandom
+
+	// StrategyHealthChecked probes each server's info endpoint
+	// periodically, ejecting servers that fail the probe and
+	// readmitting them once they pass it again. Requests are
+	// spread round-robin over the healthy servers; ejected servers
+	// are only tried once every healthy server has failed.
+	StrategyHealthChecked
 )
 
 var strategyStrings = map[string]Strategy{
-	"ordered_list": StrategyOrderedList,
+	"ordered_list":   StrategyOrderedList,
+	"round_robin":    StrategyRoundRobin,
+	"random":         StrategyRandom,
+	"health_checked": StrategyHealthChecked,
 }
 
-// StrategyFromString takes a string describing a
+// StrategyFromString takes a string describing a strategy and
+// returns it, or StrategyInvalid if the name is not recognised.
 func StrategyFromString(s string) Strategy {
 	s = strings.TrimSpace(strings.ToLower(s))
 	strategy, ok := strategyStrings[s]
@@ -38,27 +65,129 @@ func StrategyFromString(s string) Strategy {
 	return strategy
 }
 
+// DefaultHealthCheckInterval is the interval between probes of a
+// health-checked group when none is given.
+const DefaultHealthCheckInterval = 30 * time.Second
+
+// Options tune how a group of remotes is used.
+type Options struct {
+	// Strategy selects the order in which servers are tried.
+	Strategy Strategy
+
+	// Timeout bounds each request to a single server,
+	// including health probes. Zero means no timeout.
+	Timeout time.Duration
+
+	// MaxAttempts is the retry budget of a request: the number
+	// of servers tried before giving up. Zero means every
+	// server is tried once.
+	MaxAttempts int
+
+	// HealthCheckInterval is the interval between probes for
+	// StrategyHealthChecked; it defaults to
+	// DefaultHealthCheckInterval.
+	HealthCheckInterval time.Duration
+
+	// TLSConfig, if set, is used to reach the servers over
+	// HTTPS: it holds the CAs used to verify the servers and the
+	// client certificate to present to them.
+	TLSConfig *tls.Config
+}
+
 // NewGroup will use the collection of remotes specified with the
 // given strategy.
 func NewGroup(remotes []string, strategy Strategy) (Remote, error) {
-	var servers = make([]*server, len(remotes))
+	return NewGroupWithOptions(remotes, Options{Strategy: strategy})
+}
+
+// NewGroupWithOptions will use the collection of remotes specified
+// with the given options.
+func NewGroupWithOptions(remotes []string, opts Options) (Remote, error) {
+	var servers = make([]*server, 0, len(remotes))
 	for i := range remotes {
-		servers[i] = newServer(remotes[i])
+		srv := newServer(remotes[i])
+		if srv == nil {
+			return nil, errors.New("invalid remote " + remotes[i])
+		}
+		srv.configure(opts)
+		servers = append(servers, srv)
 	}
 
-	switch strategy {
+	g := group{remotes: servers, maxAttempts: opts.MaxAttempts}
+
+	switch opts.Strategy {
 	case StrategyOrderedList:
-		return newOrdererdListGroup(servers)
+		return newOrdererdListGroup(g)
+	case StrategyRoundRobin:
+		return newRoundRobinGroup(g)
+	case StrategyRandom:
+		return newRandomGroup(g)
+	case StrategyHealthChecked:
+		interval := opts.HealthCheckInterval
+		if interval == 0 {
+			interval = DefaultHealthCheckInterval
+		}
+		return newHealthCheckedGroup(g, interval)
 	default:
 		return nil, errors.New("unrecognised strategy")
 	}
 }
 
-type orderedListGroup struct {
-	remotes []*server
+// NewServerWithOptions sets up a new server target as NewServer does,
+// using opts for the strategy, timeouts, retry budget and TLS. The
+// strategy is only used if addr is a comma-separated list of hosts.
+func NewServerWithOptions(addr string, opts Options) Remote {
+	addrs := strings.Split(addr, ",")
+	if len(addrs) == 1 {
+		srv := newServer(addr)
+		if srv == nil {
+			return nil
+		}
+		srv.configure(opts)
+		return srv
+	}
+
+	if opts.Strategy == StrategyInvalid {
+		opts.Strategy = StrategyOrderedList
+	}
+	remote, err := NewGroupWithOptions(addrs, opts)
+	if err != nil {
+		return nil
+	}
+	return remote
+}
+
+// A group holds the servers of a remote and the logic common to every
+// strategy. order returns the servers in the order they should be
+// tried for the next request.
+type group struct {
+	remotes     []*server
+	maxAttempts int
+	order       func() []*server
+}
+
+// errNoServers is returned when a group has no server to try.
+var errNoServers = errors.New("no remote servers available")
+
+// try calls f with each server in turn, in the order chosen by the
+// strategy and within the retry budget, until it succeeds. It returns
+// the last error if every attempt failed.
+func (g *group) try(f func(srv *server) error) error {
+	servers := g.order()
+	if g.maxAttempts > 0 && g.maxAttempts < len(servers) {
+		servers = servers[:g.maxAttempts]
+	}
+
+	err := errNoServers
+	for _, srv := range servers {
+		if err = f(srv); err == nil {
+			return nil
+		}
+	}
+	return err
 }
 
-func (g *orderedListGroup) Hosts() []string {
+func (g *group) Hosts() []string {
 	var hosts = make([]string, 0, len(g.remotes))
 	for _, srv := range g.remotes {
 		srvHosts := srv.Hosts()
@@ -67,41 +196,192 @@ func (g *orderedListGroup) Hosts() []string {
 	return hosts
 }
 
-func newOrdererdListGroup(remotes []*server) (Remote, error) {
-	return &orderedListGroup{
-		remotes: remotes,
-	}, nil
+func (g *group) AuthSign(req, id []byte, provider auth.Provider) (resp []byte, err error) {
+	err = g.try(func(srv *server) (err error) {
+		resp, err = srv.AuthSign(req, id, provider)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (g *group) Sign(jsonData []byte) (resp []byte, err error) {
+	err = g.try(func(srv *server) (err error) {
+		resp, err = srv.Sign(jsonData)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (g *group) Renew(jsonData []byte) (resp []byte, err error) {
+	err = g.try(func(srv *server) (err error) {
+		resp, err = srv.Renew(jsonData)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (g *group) Info(jsonData []byte) (resp *info.Resp, err error) {
+	err = g.try(func(srv *server) (err error) {
+		resp, err = srv.Info(jsonData)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// rotate returns servers starting at index start, wrapping around.
+func rotate(servers []*server, start int) []*server {
+	rotated := make([]*server, 0, len(servers))
+	rotated = append(rotated, servers[start:]...)
+	return append(rotated, servers[:start]...)
+}
+
+type orderedListGroup struct {
+	group
+}
+
+func newOrdererdListGroup(g group) (Remote, error) {
+	ogl := &orderedListGroup{group: g}
+	ogl.order = func() []*server {
+		return ogl.remotes
+	}
+	return ogl, nil
+}
+
+type roundRobinGroup struct {
+	group
+	next uint32
+}
+
+func newRoundRobinGroup(g group) (Remote, error) {
+	rr := &roundRobinGroup{group: g}
+	rr.order = func() []*server {
+		if len(rr.remotes) == 0 {
+			return nil
+		}
+		start := atomic.AddUint32(&rr.next, 1) - 1
+		return rotate(rr.remotes, int(start%uint32(len(rr.remotes))))
+	}
+	return rr, nil
 }
 
-func (g *orderedListGroup) AuthSign(req, id []byte, provider auth.Provider) (resp []byte, err error) {
-	for i := range g.remotes {
-		resp, err = g.remotes[i].AuthSign(req, id, provider)
-		if err == nil {
-			return resp, nil
+type randomGroup struct {
+	group
+}
+
+func newRandomGroup(g group) (Remote, error) {
+	rg := &randomGroup{group: g}
+	rg.order = func() []*server {
+		servers := make([]*server, len(rg.remotes))
+		for i, j := range rand.Perm(len(rg.remotes)) {
+			servers[i] = rg.remotes[j]
 		}
+		return servers
 	}
+	return rg, nil
+}
 
-	return nil, err
+type healthCheckedGroup struct {
+	group
+	next uint32
+
+	lock     sync.RWMutex
+	healthy  map[*server]bool
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
-func (g *orderedListGroup) Sign(jsonData []byte) (resp []byte, err error) {
-	for i := range g.remotes {
-		resp, err = g.remotes[i].Sign(jsonData)
-		if err == nil {
-			return resp, nil
+func newHealthCheckedGroup(g group, interval time.Duration) (Remote, error) {
+	hc := &healthCheckedGroup{
+		group:   g,
+		healthy: make(map[*server]bool, len(g.remotes)),
+		stop:    make(chan struct{}),
+	}
+
+	// Servers start out healthy, so that requests made before the
+	// first probe completes are not held up.
+	for _, srv := range hc.remotes {
+		hc.healthy[srv] = true
+	}
+
+	hc.order = func() []*server {
+		var healthy, ejected []*server
+		hc.lock.RLock()
+		for _, srv := range hc.remotes {
+			if hc.healthy[srv] {
+				healthy = append(healthy, srv)
+			} else {
+				ejected = append(ejected, srv)
+			}
+		}
+		hc.lock.RUnlock()
+
+		if len(healthy) > 0 {
+			start := atomic.AddUint32(&hc.next, 1) - 1
+			healthy = rotate(healthy, int(start%uint32(len(healthy))))
 		}
+		return append(healthy, ejected...)
 	}
 
-	return nil, err
+	go hc.run(interval)
+	return hc, nil
 }
 
-func (g *orderedListGroup) Info(jsonData []byte) (resp *info.Resp, err error) {
-	for i := range g.remotes {
-		resp, err = g.remotes[i].Info(jsonData)
-		if err == nil {
-			return resp, nil
+// check probes every server, ejecting those that fail and readmitting
+// those that pass.
+func (hc *healthCheckedGroup) check() {
+	results := make([]bool, len(hc.remotes))
+	var wg sync.WaitGroup
+	for i, srv := range hc.remotes {
+		wg.Add(1)
+		go func(i int, srv *server) {
+			defer wg.Done()
+			results[i] = srv.probe()
+		}(i, srv)
+	}
+	wg.Wait()
+
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+	for i, srv := range hc.remotes {
+		host := srv.Hosts()[0]
+		if hc.healthy[srv] && !results[i] {
+			log.Warningf("remote %s failed its health check, ejecting it", host)
+		} else if !hc.healthy[srv] && results[i] {
+			log.Infof("remote %s passed its health check, readmitting it", host)
 		}
+		hc.healthy[srv] = results[i]
 	}
+}
+
+func (hc *healthCheckedGroup) run(interval time.Duration) {
+	hc.check()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hc.stop:
+			return
+		case <-ticker.C:
+			hc.check()
+		}
+	}
+}
 
-	return nil, err
+// Stop ends the periodic health checks of the group. The group keeps
+// serving requests, using the servers' last known health.
+func (hc *healthCheckedGroup) Stop() {
+	hc.stopOnce.Do(func() { close(hc.stop) })
 }
@@ -0,0 +1,296 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bbandix/cfssl/api"
+)
+
+// testServer is a remote that counts its requests and answers them
+// with a certificate, or fails them while down is set.
+type testServer struct {
+	*httptest.Server
+	hits  int32
+	down  int32
+	delay time.Duration
+}
+
+func newTestServer() *testServer {
+	ts := &testServer{}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(ts.delay)
+		if atomic.LoadInt32(&ts.down) != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/sign") {
+			atomic.AddInt32(&ts.hits, 1)
+		}
+		api.SendResponse(w, map[string]string{"certificate": "cert"})
+	}))
+	return ts
+}
+
+func (ts *testServer) setDown(down bool) {
+	var v int32
+	if down {
+		v = 1
+	}
+	atomic.StoreInt32(&ts.down, v)
+}
+
+func (ts *testServer) count() int32 {
+	return atomic.LoadInt32(&ts.hits)
+}
+
+func (ts *testServer) addr() string {
+	return strings.TrimPrefix(ts.URL, "http://")
+}
+
+func newTestServers(n int) ([]*testServer, []string) {
+	var servers []*testServer
+	var addrs []string
+	for i := 0; i < n; i++ {
+		ts := newTestServer()
+		servers = append(servers, ts)
+		addrs = append(addrs, ts.addr())
+	}
+	return servers, addrs
+}
+
+func closeTestServers(servers []*testServer) {
+	for _, ts := range servers {
+		ts.Close()
+	}
+}
+
+func TestStrategyFromString(t *testing.T) {
+	for name, strategy := range map[string]Strategy{
+		"ordered_list":   StrategyOrderedList,
+		"Round_Robin":    StrategyRoundRobin,
+		" random ":       StrategyRandom,
+		"health_checked": StrategyHealthChecked,
+		"fastest":        StrategyInvalid,
+	} {
+		if StrategyFromString(name) != strategy {
+			t.Fatalf("StrategyFromString(%q) != %d", name, strategy)
+		}
+	}
+}
+
+func TestRoundRobinGroup(t *testing.T) {
+	servers, addrs := newTestServers(3)
+	defer closeTestServers(servers)
+
+	g, err := NewGroup(addrs, StrategyRoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 9; i++ {
+		if _, err = g.Sign([]byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, ts := range servers {
+		if ts.count() != 3 {
+			t.Fatalf("server %d got %d requests, expected 3", i, ts.count())
+		}
+	}
+
+	// A failing server's requests go to the next one.
+	servers[1].setDown(true)
+	for i := 0; i < 3; i++ {
+		if _, err = g.Sign([]byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if servers[1].count() != 3 || servers[0].count()+servers[2].count() != 9 {
+		t.Fatalf("bad failover: %d, %d, %d", servers[0].count(), servers[1].count(), servers[2].count())
+	}
+}
+
+func TestRandomGroup(t *testing.T) {
+	servers, addrs := newTestServers(2)
+	defer closeTestServers(servers)
+
+	g, err := NewGroup(addrs, StrategyRandom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 64; i++ {
+		if _, err = g.Sign([]byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if servers[0].count() == 0 || servers[1].count() == 0 {
+		t.Fatalf("requests not spread: %d, %d", servers[0].count(), servers[1].count())
+	}
+}
+
+func TestMaxAttempts(t *testing.T) {
+	servers, addrs := newTestServers(3)
+	defer closeTestServers(servers)
+	servers[0].setDown(true)
+	servers[1].setDown(true)
+
+	g, err := NewGroupWithOptions(addrs, Options{Strategy: StrategyOrderedList, MaxAttempts: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Sign([]byte("{}")); err == nil {
+		t.Fatal("expected the retry budget to run out")
+	}
+
+	g, err = NewGroupWithOptions(addrs, Options{Strategy: StrategyOrderedList})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Sign([]byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	servers, addrs := newTestServers(2)
+	defer closeTestServers(servers)
+	servers[0].delay = time.Second
+
+	g, err := NewGroupWithOptions(addrs, Options{Strategy: StrategyOrderedList, Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err = g.Sign([]byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 500*time.Millisecond || servers[1].count() != 1 {
+		t.Fatal("slow server was not timed out")
+	}
+}
+
+func TestHealthCheckedGroup(t *testing.T) {
+	servers, addrs := newTestServers(2)
+	defer closeTestServers(servers)
+	servers[0].setDown(true)
+
+	g, err := NewGroupWithOptions(addrs, Options{
+		Strategy:            StrategyHealthChecked,
+		HealthCheckInterval: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc := g.(*healthCheckedGroup)
+	defer hc.Stop()
+
+	waitFor := func(srv *server, healthy bool) {
+		for i := 0; i < 100; i++ {
+			hc.lock.RLock()
+			ok := hc.healthy[srv] == healthy
+			hc.lock.RUnlock()
+			if ok {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("server %s never became healthy=%v", srv.Hosts()[0], healthy)
+	}
+
+	waitFor(hc.remotes[0], false)
+	order := hc.order()
+	if order[0] != hc.remotes[1] || order[1] != hc.remotes[0] {
+		t.Fatal("ejected server not tried last")
+	}
+
+	servers[0].setDown(false)
+	waitFor(hc.remotes[0], true)
+	for i := 0; i < 4; i++ {
+		if _, err = g.Sign([]byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if servers[0].count() != 2 || servers[1].count() != 2 {
+		t.Fatalf("readmitted server not used: %d, %d", servers[0].count(), servers[1].count())
+	}
+}
+
+func TestStopHealthCheckedGroup(t *testing.T) {
+	var probes int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&probes, 1)
+		api.SendResponse(w, map[string]string{"certificate": "cert"})
+	}))
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+
+	g, err := NewGroupWithOptions([]string{addr, addr}, Options{
+		Strategy:            StrategyHealthChecked,
+		HealthCheckInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	Stop(g)
+	Stop(g)
+
+	// A probe may have been under way when the group was stopped.
+	time.Sleep(20 * time.Millisecond)
+	stopped := atomic.LoadInt32(&probes)
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&probes); n != stopped {
+		t.Fatalf("health checks continued after the group was stopped: %d probes, then %d", stopped, n)
+	}
+
+	if _, err = g.Sign([]byte("{}")); err != nil {
+		t.Fatalf("a stopped group should still serve requests: %v", err)
+	}
+}
+
+func TestNewServerWithOptions(t *testing.T) {
+	s := NewServerWithOptions("cfssl1.local:8888,cfssl2.local:8888", Options{Strategy: StrategyRoundRobin})
+	if _, ok := s.(*roundRobinGroup); !ok {
+		t.Fatalf("expected a round robin group, got %T", s)
+	}
+
+	s = NewServerWithOptions("cfssl1.local:8888,cfssl2.local:8888", Options{})
+	if _, ok := s.(*orderedListGroup); !ok {
+		t.Fatalf("expected an ordered list group, got %T", s)
+	}
+
+	s = NewServerWithOptions("cfssl1.local:8888", Options{Timeout: time.Second})
+	if srv, ok := s.(*server); !ok || srv.timeout != time.Second {
+		t.Fatalf("expected a single server with a timeout, got %+v", s)
+	}
+}
+
+func TestTLSServer(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.SendResponse(w, map[string]string{"certificate": "cert"})
+	}))
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "https://")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+	s := NewServerWithOptions(addr, Options{TLSConfig: &tls.Config{RootCAs: roots}})
+	if _, err := s.Sign([]byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without the server's CA, the server cannot be verified.
+	s = NewServerWithOptions(addr, Options{TLSConfig: &tls.Config{}})
+	if _, err := s.Sign([]byte("{}")); err == nil {
+		t.Fatal("unverified server was trusted")
+	}
+}
@@ -0,0 +1,97 @@
+// Package crl implements the HTTP handler for serving CRLs.
+package crl
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bbandix/cfssl/api"
+	"github.com/bbandix/cfssl/crl"
+	"github.com/bbandix/cfssl/log"
+)
+
+// A Handler serves a CRL for an issuer, listing the serial numbers in a
+// file. The file is reread for every request, so that certificates can
+// be revoked without restarting the server, but a new CRL is only
+// signed when the file has changed or half of the current CRL's
+// validity has elapsed.
+type Handler struct {
+	issuer     *x509.Certificate
+	key        crypto.Signer
+	serialFile string
+	expiry     time.Duration
+
+	lock       sync.Mutex
+	serialList []byte
+	der        []byte
+	refreshAt  time.Time
+	number     int64
+}
+
+// NewHandler returns a new http.Handler that serves a DER-encoded CRL
+// for the issuer, signed with its key and valid for expiry, listing the
+// serial numbers in serialFile in the form read by crl.ParseSerials.
+func NewHandler(issuer *x509.Certificate, key crypto.Signer, serialFile string, expiry time.Duration) http.Handler {
+	return &api.HTTPHandler{
+		Handler: &Handler{
+			issuer:     issuer,
+			key:        key,
+			serialFile: serialFile,
+			expiry:     expiry,
+		},
+		Methods: []string{"GET"},
+	}
+}
+
+// current returns the CRL for serialList, signing a new one if the
+// list has changed or the cached CRL is due to be refreshed. CRLs are
+// numbered by the time they are created, in seconds, so that the
+// numbers keep increasing across restarts.
+func (h *Handler) current(serialList []byte) ([]byte, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	now := time.Now()
+	if h.der != nil && bytes.Equal(serialList, h.serialList) && now.Before(h.refreshAt) {
+		return h.der, nil
+	}
+
+	number := now.Unix()
+	if number <= h.number {
+		number = h.number + 1
+	}
+
+	der, err := crl.NewCRL(serialList, big.NewInt(number), h.issuer, h.key, h.expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	h.serialList, h.der, h.number = serialList, der, number
+	h.refreshAt = now.Add(h.expiry / 2)
+	return der, nil
+}
+
+// Handle responds with the current CRL.
+func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) error {
+	serialList, err := ioutil.ReadFile(h.serialFile)
+	if err != nil {
+		log.Errorf("failed to read the revoked serial list: %v", err)
+		return err
+	}
+
+	der, err := h.current(serialList)
+	if err != nil {
+		log.Errorf("failed to create CRL: %v", err)
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	_, err = w.Write(der)
+	return err
+}
@@ -0,0 +1,154 @@
+package crl
+
+import (
+	"bytes"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bbandix/cfssl/helpers"
+)
+
+const (
+	testCaFile    = "../../crl/testdata/ca.pem"
+	testCaKeyFile = "../../crl/testdata/ca_key.pem"
+)
+
+func newTestHandler(t *testing.T, serialFile string) http.Handler {
+	certPEM, err := ioutil.ReadFile(testCaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := ioutil.ReadFile(testCaKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := helpers.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewHandler(issuer, key, serialFile, time.Hour)
+}
+
+func TestCRLHandler(t *testing.T) {
+	serialFile, err := ioutil.TempFile("", "crl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(serialFile.Name())
+	serialFile.WriteString("1 2015-05-01T00:00:00Z\n2 2015-06-01T00:00:00Z\n3 2015-07-01T00:00:00Z\n")
+	serialFile.Close()
+
+	ts := httptest.NewServer(newTestHandler(t, serialFile.Name()))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, have %d: %s", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/pkix-crl" {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+
+	crl, err := x509.ParseDERCRL(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(crl.TBSCertList.RevokedCertificates); n != 3 {
+		t.Fatalf("expected 3 revoked certificates, have %d", n)
+	}
+
+	resp, err = http.Post(ts.URL, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for POST, have %d", resp.StatusCode)
+	}
+}
+
+// getCRL fetches and parses the CRL served by ts.
+func getCRL(t *testing.T, ts *httptest.Server) ([]byte, *x509.RevocationList) {
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, have %d: %s", resp.StatusCode, body)
+	}
+
+	crl, err := x509.ParseRevocationList(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body, crl
+}
+
+func TestCRLHandlerCache(t *testing.T) {
+	serialFile, err := ioutil.TempFile("", "crl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(serialFile.Name())
+	serialFile.WriteString("1 2015-05-01T00:00:00Z\n")
+	serialFile.Close()
+
+	ts := httptest.NewServer(newTestHandler(t, serialFile.Name()))
+	defer ts.Close()
+
+	first, crl := getCRL(t, ts)
+	if crl.Number == nil {
+		t.Fatal("CRL has no CRL number")
+	}
+
+	// The CRL isn't signed afresh while the serial list is unchanged.
+	again, _ := getCRL(t, ts)
+	if !bytes.Equal(first, again) {
+		t.Fatal("expected the cached CRL to be served")
+	}
+
+	err = ioutil.WriteFile(serialFile.Name(), []byte("1 2015-05-01T00:00:00Z\n2 2015-06-01T00:00:00Z\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, updated := getCRL(t, ts)
+	if n := len(updated.RevokedCertificateEntries); n != 2 {
+		t.Fatalf("expected 2 revoked certificates after the list changed, have %d", n)
+	}
+	if updated.Number.Cmp(crl.Number) <= 0 {
+		t.Fatalf("expected the CRL number to increase, have %v then %v", crl.Number, updated.Number)
+	}
+	if !updated.RevokedCertificateEntries[0].RevocationTime.Equal(crl.RevokedCertificateEntries[0].RevocationTime) {
+		t.Fatal("revocation time changed between CRLs")
+	}
+}
+
+func TestCRLHandlerMissingFile(t *testing.T) {
+	ts := httptest.NewServer(newTestHandler(t, "testdata/missing"))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected 500 for a missing serial list, have %d", resp.StatusCode)
+	}
+}
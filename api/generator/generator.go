@@ -239,6 +239,9 @@ func (cg *CertGeneratorHandler) Handle(w http.ResponseWriter, r *http.Request) e
 		Request: string(csr),
 		Profile: req.Profile,
 		Label:   req.Label,
+
+		RequestID: log.RequestID(r.Context()),
+		Requester: api.Requester(r),
 	}
 
 	certBytes, err := cg.signer.Sign(signReq)
@@ -2,6 +2,8 @@
 package initca
 
 import (
+	"crypto"
+	"crypto/x509"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
@@ -9,6 +11,7 @@ import (
 	"github.com/bbandix/cfssl/api"
 	"github.com/bbandix/cfssl/csr"
 	"github.com/bbandix/cfssl/errors"
+	"github.com/bbandix/cfssl/helpers"
 	"github.com/bbandix/cfssl/initca"
 	"github.com/bbandix/cfssl/log"
 )
@@ -58,3 +61,120 @@ func initialCAHandler(w http.ResponseWriter, r *http.Request) error {
 func NewHandler() http.Handler {
 	return api.HTTPHandler{Handler: api.HandlerFunc(initialCAHandler), Methods: []string{"POST"}}
 }
+
+// A RenewRequest contains an existing CA certificate and its private
+// key, and for rekeying, an optional request for the new key.
+type RenewRequest struct {
+	Cert       string               `json:"certificate"`
+	Key        string               `json:"private_key"`
+	KeyRequest *csr.BasicKeyRequest `json:"key,omitempty"`
+}
+
+// A RekeyedCA contains a new private key and root certificate for an
+// existing CA, and a cross-certificate for the new key issued by the
+// existing CA key.
+type RekeyedCA struct {
+	Key       string `json:"private_key"`
+	Cert      string `json:"certificate"`
+	CrossCert string `json:"cross_certificate"`
+}
+
+// readRenewRequest reads a RenewRequest from the request body and
+// parses the CA certificate and key in it.
+func readRenewRequest(r *http.Request) (*RenewRequest, *x509.Certificate, crypto.Signer, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Warningf("failed to read request body: %v", err)
+		return nil, nil, nil, errors.NewBadRequest(err)
+	}
+
+	req := new(RenewRequest)
+	err = json.Unmarshal(body, req)
+	if err != nil {
+		log.Warningf("failed to unmarshal request: %v", err)
+		return nil, nil, nil, errors.NewBadRequest(err)
+	}
+
+	if req.Cert == "" || req.Key == "" {
+		return nil, nil, nil, errors.NewBadRequestString("missing certificate or private_key parameter")
+	}
+
+	ca, err := helpers.ParseCertificatePEM([]byte(req.Cert))
+	if err != nil {
+		log.Warningf("failed to parse CA certificate: %v", err)
+		return nil, nil, nil, err
+	}
+
+	priv, err := helpers.ParsePrivateKeyPEM([]byte(req.Key))
+	if err != nil {
+		log.Warningf("failed to parse CA private key: %v", err)
+		return nil, nil, nil, err
+	}
+
+	return req, ca, priv, nil
+}
+
+// renewCAHandler is an HTTP handler that accepts a JSON blob with an
+// existing root certificate and its private key, and returns the
+// root certificate renewed with the same key.
+func renewCAHandler(w http.ResponseWriter, r *http.Request) error {
+	log.Info("setting up CA renewal handler")
+	_, ca, priv, err := readRenewRequest(r)
+	if err != nil {
+		return err
+	}
+
+	cert, err := initca.RenewFromSigner(ca, priv)
+	if err != nil {
+		log.Warningf("failed to renew CA: %v", err)
+		return err
+	}
+
+	response := api.NewSuccessResponse(map[string]string{"certificate": string(cert)})
+
+	enc := json.NewEncoder(w)
+	err = enc.Encode(response)
+	return err
+}
+
+// rekeyCAHandler is an HTTP handler that accepts a JSON blob with an
+// existing root certificate and its private key, and returns a new
+// private key, the root certificate re-issued for it, and a
+// cross-certificate for the new key signed by the existing key.
+func rekeyCAHandler(w http.ResponseWriter, r *http.Request) error {
+	log.Info("setting up CA rekey handler")
+	req, ca, priv, err := readRenewRequest(r)
+	if err != nil {
+		return err
+	}
+
+	// A nil *BasicKeyRequest must not become a non-nil KeyRequest.
+	var kr csr.KeyRequest
+	if req.KeyRequest != nil {
+		kr = req.KeyRequest
+	}
+
+	cert, crossCert, key, err := initca.Rekey(ca, priv, kr)
+	if err != nil {
+		log.Warningf("failed to rekey CA: %v", err)
+		return err
+	}
+
+	response := api.NewSuccessResponse(&RekeyedCA{string(key), string(cert), string(crossCert)})
+
+	enc := json.NewEncoder(w)
+	err = enc.Encode(response)
+	return err
+}
+
+// NewRenewHandler returns a new http.Handler that handles requests to
+// renew a CA certificate with its existing key.
+func NewRenewHandler() http.Handler {
+	return api.HTTPHandler{Handler: api.HandlerFunc(renewCAHandler), Methods: []string{"POST"}}
+}
+
+// NewRekeyHandler returns a new http.Handler that handles requests to
+// rekey a CA and cross-sign the new key with the existing one.
+func NewRekeyHandler() http.Handler {
+	return api.HTTPHandler{Handler: api.HandlerFunc(rekeyCAHandler), Methods: []string{"POST"}}
+}
@@ -7,7 +7,10 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/bbandix/cfssl/api"
 	"github.com/bbandix/cfssl/csr"
+	"github.com/bbandix/cfssl/helpers"
+	"github.com/bbandix/cfssl/initca"
 )
 
 func csrData(t *testing.T) *bytes.Reader {
@@ -64,3 +67,82 @@ func TestInitCARESTfulVerbs(t *testing.T) {
 		t.Fatal(resp.Status)
 	}
 }
+
+func renewData(t *testing.T) *bytes.Reader {
+	cert, _, key, err := initca.New(&csr.CertificateRequest{
+		CN:         "cloudflare.com",
+		KeyRequest: csr.NewBasicKeyRequest(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reqBytes, err := json.Marshal(&RenewRequest{Cert: string(cert), Key: string(key)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(reqBytes)
+}
+
+func TestRenewCA(t *testing.T) {
+	ts := httptest.NewServer(NewRenewHandler())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL, "application/json", renewData(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal(resp.Status)
+	}
+
+	var response api.Response
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	result := response.Result.(map[string]interface{})
+	if _, err = helpers.ParseCertificatePEM([]byte(result["certificate"].(string))); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err = http.Post(ts.URL, "application/json", bytes.NewReader([]byte(`{}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatal(resp.Status)
+	}
+}
+
+func TestRekeyCA(t *testing.T) {
+	ts := httptest.NewServer(NewRekeyHandler())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL, "application/json", renewData(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal(resp.Status)
+	}
+
+	var response api.Response
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	result := response.Result.(map[string]interface{})
+	cert, err := helpers.ParseCertificatePEM([]byte(result["certificate"].(string)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cross, err := helpers.ParseCertificatePEM([]byte(result["cross_certificate"].(string)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = helpers.ParsePrivateKeyPEM([]byte(result["private_key"].(string))); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cert.RawSubjectPublicKeyInfo, cross.RawSubjectPublicKeyInfo) {
+		t.Fatal("cross-certificate public key mismatch")
+	}
+}
@@ -0,0 +1,190 @@
+// Package metrics implements the HTTP handler for the Prometheus metrics
+// endpoint, and instruments the handlers of the other endpoints.
+package metrics
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bbandix/cfssl/log"
+	"github.com/bbandix/cfssl/whitelist"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// UnknownProfile is the profile recorded for requests naming a profile
+// that isn't known, so that such requests don't each create new
+// metrics.
+const UnknownProfile = "unknown"
+
+var (
+	requests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "cfssl",
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests, by endpoint, signing profile and status code.",
+		},
+		[]string{"endpoint", "profile", "code"},
+	)
+
+	requestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "cfssl",
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests, by endpoint, signing profile and status code.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"endpoint", "profile", "code"},
+	)
+
+	certificateExpiry = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "cfssl",
+			Name:      "certificate_expiry_timestamp_seconds",
+			Help:      "Expiry time of the loaded CA and OCSP responder certificates, in seconds since the epoch.",
+		},
+		[]string{"type", "label"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(requests, requestDuration, certificateExpiry)
+}
+
+// NewHandler returns a handler that serves all registered metrics in
+// the Prometheus text format.
+func NewHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+// NewWhitelistedHandler returns the metrics handler, restricted to
+// localhost and the networks in nets, a comma-separated list in CIDR
+// notation. Other clients are answered as if there were no metrics.
+func NewWhitelistedHandler(nets string) (http.Handler, error) {
+	acl := whitelist.NewBasicNet()
+	for _, cidr := range []string{"127.0.0.0/8", "::1/128"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		acl.Add(n)
+	}
+	for _, cidr := range strings.Split(nets, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("metrics: invalid network %s: %v", cidr, err)
+		}
+		acl.Add(n)
+	}
+
+	return whitelist.NewHandler(NewHandler(), http.HandlerFunc(metricsDisallowed), acl)
+}
+
+func metricsDisallowed(w http.ResponseWriter, req *http.Request) {
+	log.Warning("attempt to access metrics endpoint from external address ", req.RemoteAddr)
+	http.NotFound(w, req)
+}
+
+// A ProfileFilter reports whether a signing profile is known.
+type ProfileFilter func(profile string) bool
+
+// Instrument wraps h to record the number and latency of requests to
+// the endpoint. If known is not nil, requests are also recorded by the
+// signing profile they name, or UnknownProfile if known doesn't
+// recognise it; requests that don't name a profile are recorded with an
+// empty profile.
+func Instrument(endpoint string, h http.Handler, known ProfileFilter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		var profile string
+		if known != nil {
+			profile = requestProfile(r)
+			if profile != "" && !known(profile) {
+				profile = UnknownProfile
+			}
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+
+		code := strconv.Itoa(sw.status)
+		requests.WithLabelValues(endpoint, profile, code).Inc()
+		requestDuration.WithLabelValues(endpoint, profile, code).Observe(time.Since(start).Seconds())
+	})
+}
+
+// requestProfile returns the signing profile named in a JSON request
+// body, looking inside authenticated requests. The body is restored
+// for the handler.
+func requestProfile(r *http.Request) string {
+	if r.Body == nil || r.Method != "POST" {
+		return ""
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	var req struct {
+		Profile string          `json:"profile"`
+		Request json.RawMessage `json:"request"`
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return ""
+	}
+	if req.Profile != "" || len(req.Request) == 0 {
+		return req.Profile
+	}
+
+	// An authenticated request carries the sign request as
+	// base64-encoded JSON.
+	var inner []byte
+	if json.Unmarshal(req.Request, &inner) != nil || json.Unmarshal(inner, &req) != nil {
+		return ""
+	}
+	return req.Profile
+}
+
+// A statusWriter records the status code of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes on flushes, so that streamed responses aren't held up.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// SetCertificateExpiry records the expiry time of a loaded certificate
+// of the given type, such as "ca" or "responder", for a label; servers
+// with a single CA use an empty label.
+func SetCertificateExpiry(certType, label string, cert *x509.Certificate) {
+	certificateExpiry.WithLabelValues(certType, label).Set(float64(cert.NotAfter.Unix()))
+}
+
+// ResetCertificateExpiry forgets the expiry times of all certificates,
+// for when the certificates a server has loaded are replaced.
+func ResetCertificateExpiry() {
+	certificateExpiry.Reset()
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bbandix/cfssl/helpers"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const testCaFile = "../testdata/ca.pem"
+
+func knownProfile(profile string) bool {
+	return profile == "server"
+}
+
+func serve(t *testing.T, h http.Handler, body string) {
+	req, err := http.NewRequest("POST", "/", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestInstrument(t *testing.T) {
+	var seen string
+	h := Instrument("test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		seen = string(body)
+		w.WriteHeader(http.StatusBadRequest)
+	}), knownProfile)
+
+	body := `{"profile":"server"}`
+	serve(t, h, body)
+	if seen != body {
+		t.Fatalf("handler read %q instead of the request body", seen)
+	}
+	if n := testutil.ToFloat64(requests.WithLabelValues("test", "server", "400")); n != 1 {
+		t.Fatalf("expected 1 request for the server profile, have %v", n)
+	}
+
+	serve(t, h, `{"profile":"nonexistent"}`)
+	if n := testutil.ToFloat64(requests.WithLabelValues("test", UnknownProfile, "400")); n != 1 {
+		t.Fatalf("expected 1 request for an unknown profile, have %v", n)
+	}
+
+	inner := base64.StdEncoding.EncodeToString([]byte(`{"profile":"server"}`))
+	serve(t, h, `{"token":"","request":"`+inner+`"}`)
+	if n := testutil.ToFloat64(requests.WithLabelValues("test", "server", "400")); n != 2 {
+		t.Fatalf("expected 2 requests for the server profile, have %v", n)
+	}
+}
+
+func TestInstrumentWithoutProfiles(t *testing.T) {
+	h := Instrument("noprofile", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), nil)
+	serve(t, h, `{"profile":"server"}`)
+	if n := testutil.ToFloat64(requests.WithLabelValues("noprofile", "", "200")); n != 1 {
+		t.Fatalf("expected 1 request without a profile, have %v", n)
+	}
+}
+
+func TestCertificateExpiry(t *testing.T) {
+	in, err := ioutil.ReadFile(testCaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := helpers.ParseCertificatePEM(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	SetCertificateExpiry("ca", "primary", cert)
+	rec := httptest.NewRecorder()
+	NewHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `cfssl_certificate_expiry_timestamp_seconds{label="primary",type="ca"}`) {
+		t.Fatal("expiry of the CA certificate is missing from the metrics")
+	}
+
+	ResetCertificateExpiry()
+	if n := testutil.CollectAndCount(certificateExpiry); n != 0 {
+		t.Fatalf("expected no expiry metrics after a reset, have %d", n)
+	}
+}
+
+func TestNewWhitelistedHandler(t *testing.T) {
+	if _, err := NewWhitelistedHandler("10.0.0.0/8,bogus"); err == nil {
+		t.Fatal("expected an error with an invalid network")
+	}
+
+	h, err := NewWhitelistedHandler("10.0.0.0/8, 192.168.1.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, status := range map[string]int{
+		"127.0.0.1:1234":   http.StatusOK,
+		"[::1]:1234":       http.StatusOK,
+		"10.1.2.3:1234":    http.StatusOK,
+		"192.168.1.5:1234": http.StatusOK,
+		"192.0.2.1:1234":   http.StatusNotFound,
+	} {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != status {
+			t.Fatalf("request from %s: expected status %d, got %d", addr, status, rec.Code)
+		}
+	}
+}
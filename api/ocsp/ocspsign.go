@@ -69,6 +69,8 @@ func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) error {
 	signReq := ocsp.SignRequest{
 		Certificate: cert,
 		Status:      req.Status,
+		RequestID:   log.RequestID(r.Context()),
+		Requester:   api.Requester(r),
 	}
 	// We need to convert the time from being a string to a time.Time
 	if req.Status == "revoked" {
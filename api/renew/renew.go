@@ -0,0 +1,105 @@
+// Package renew implements the HTTP handler for the certificate renewal command.
+package renew
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/bbandix/cfssl/api"
+	"github.com/bbandix/cfssl/audit"
+	"github.com/bbandix/cfssl/config"
+	"github.com/bbandix/cfssl/errors"
+	"github.com/bbandix/cfssl/log"
+	"github.com/bbandix/cfssl/renew"
+	"github.com/bbandix/cfssl/signer"
+)
+
+// A Handler accepts requests with an existing certificate, and
+// optionally a new CSR, and returns a renewed certificate with the
+// same subject and SANs.
+type Handler struct {
+	signer signer.Signer
+}
+
+// NewHandlerFromSigner generates a new Handler directly from an
+// existing signer.
+func NewHandlerFromSigner(s signer.Signer) (http.Handler, error) {
+	if s.Policy() == nil {
+		return nil, errors.New(errors.PolicyError, errors.InvalidPolicy)
+	}
+
+	return &api.HTTPHandler{
+		Handler: &Handler{
+			signer: s,
+		},
+		Methods: []string{"POST"},
+	}, nil
+}
+
+// authorizeClient checks the client certificate of the request against
+// the client certificate rules of the profile, if it has any, for the
+// names of the certificate being renewed.
+func authorizeClient(r *http.Request, profile *config.SigningProfile, req *renew.Request) error {
+	if profile.ClientCertAuth == nil {
+		return nil
+	}
+
+	names, err := signer.RequestedNames(signer.SignRequest{Request: req.Certificate})
+	if err != nil {
+		return err
+	}
+
+	if err = profile.ClientCertAuth.Authorize(r.TLS, names); err != nil {
+		log.FromContext(r.Context()).Warning("client certificate authorisation failed", "error", err)
+		api.AuditDenied(r, audit.OpSign, req.Profile, "client certificate not authorised: "+err.Error())
+		return errors.NewForbidden(err)
+	}
+	return nil
+}
+
+// Handle responds to requests for the CA to renew the certificate
+// present in the "certificate" parameter, issuing it for the key in
+// the "certificate_request" parameter if one is present.
+func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) error {
+	log.Info("renewal request received")
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	var req renew.Request
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errors.NewBadRequestString("Unable to parse renewal request")
+	}
+
+	if req.Certificate == "" {
+		return errors.NewBadRequestString("missing parameter 'certificate'")
+	}
+
+	// The request is checked against and renewed by the same signer,
+	// even if the server reloads it meanwhile.
+	s := signer.Snapshot(h.signer)
+
+	profile, err := signer.Profile(s, req.Profile)
+	if err != nil {
+		return err
+	}
+
+	if err = authorizeClient(r, profile, &req); err != nil {
+		return err
+	}
+
+	cert, err := renew.Renew(s, &req)
+	if err != nil {
+		log.Warningf("failed to renew certificate: %v", err)
+		return err
+	}
+
+	result := map[string]string{"certificate": string(cert)}
+	log.Info("wrote response")
+	return api.SendResponse(w, result)
+}
@@ -0,0 +1,171 @@
+package renew
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bbandix/cfssl/api"
+	"github.com/bbandix/cfssl/config"
+	"github.com/bbandix/cfssl/renew"
+	"github.com/bbandix/cfssl/signer"
+	"github.com/bbandix/cfssl/signer/local"
+)
+
+const (
+	testCaFile    = "../testdata/ca.pem"
+	testCaKeyFile = "../testdata/ca_key.pem"
+	testCSRFile   = "../testdata/csr.pem"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, signer.Signer) {
+	s, err := local.NewSignerFromFile(testCaFile, testCaKeyFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := NewHandlerFromSigner(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(h), s
+}
+
+func postRenew(t *testing.T, ts *httptest.Server, req *renew.Request) (*http.Response, *api.Response) {
+	blob, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	var message api.Response
+	if err = json.Unmarshal(body, &message); err != nil {
+		t.Fatal(err)
+	}
+	return resp, &message
+}
+
+func TestRenew(t *testing.T) {
+	ts, s := newTestServer(t)
+	defer ts.Close()
+
+	csrPEM, err := ioutil.ReadFile(testCSRFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, err := s.Sign(signer.SignRequest{Request: string(csrPEM)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, message := postRenew(t, ts, &renew.Request{Certificate: string(certPEM)})
+	if resp.StatusCode != http.StatusOK || !message.Success {
+		t.Fatalf("renewal failed: %v", message.Errors)
+	}
+
+	result := message.Result.(map[string]interface{})
+	if cert, ok := result["certificate"].(string); !ok || cert == "" {
+		t.Fatal("renewal returned no certificate")
+	}
+}
+
+func TestRenewBadRequest(t *testing.T) {
+	ts, _ := newTestServer(t)
+	defer ts.Close()
+
+	resp, message := postRenew(t, ts, &renew.Request{})
+	if resp.StatusCode == http.StatusOK || message.Success {
+		t.Fatal("renewal without a certificate should fail")
+	}
+
+	// A CA certificate can't be renewed through this endpoint.
+	caPEM, err := ioutil.ReadFile(testCaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, message = postRenew(t, ts, &renew.Request{Certificate: string(caPEM)})
+	if resp.StatusCode == http.StatusOK || message.Success {
+		t.Fatal("renewal of a CA certificate should fail")
+	}
+}
+
+var validClientCertConfig = `
+{
+	"signing": {
+		"default": {
+			"usages": ["digital signature", "server auth"],
+			"expiry": "1h",
+			"client_cert_auth": {
+				"rules": [
+					{"identity": "client.internal", "names": ["*.cloudflare-inter.com", "cloudflare-inter.com", "wwwcloudflare-inter.com"]},
+					{"identity": "other.internal", "names": ["*.example.com"]}
+				]
+			}
+		}
+	}
+}`
+
+func TestRenewClientCertAuth(t *testing.T) {
+	conf, err := config.LoadConfig([]byte(validClientCertConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := local.NewSignerFromFile(testCaFile, testCaKeyFile, conf.Signing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHandlerFromSigner(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csrPEM, err := ioutil.ReadFile(testCSRFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, err := s.Sign(signer.SignRequest{Request: string(csrPEM)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified := func(identity string) *tls.ConnectionState {
+		client := &x509.Certificate{Subject: pkix.Name{CommonName: identity}}
+		return &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{client},
+			VerifiedChains:   [][]*x509.Certificate{{client}},
+		}
+	}
+
+	for i, test := range []struct {
+		state  *tls.ConnectionState
+		status int
+	}{
+		{nil, http.StatusForbidden},
+		{verified("other.internal"), http.StatusForbidden},
+		{verified("client.internal"), http.StatusOK},
+	} {
+		blob, _ := json.Marshal(&renew.Request{Certificate: string(certPEM)})
+		req, _ := http.NewRequest("POST", "/", bytes.NewReader(blob))
+		req.TLS = test.state
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Fatalf("Test %d: expected %d, have %d: %s", i, test.status, w.Code, w.Body.String())
+		}
+	}
+}
@@ -1,17 +1,21 @@
 package scan
 
 import (
+	"context"
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/bbandix/cfssl/api"
 	"github.com/bbandix/cfssl/errors"
 	"github.com/bbandix/cfssl/log"
 	"github.com/bbandix/cfssl/scan"
+	"github.com/bbandix/cfssl/starttls"
 )
 
 // scanHandler is an HTTP handler that accepts GET parameters for host (required)
-// family and scanner, and uses these to perform scans, returning a JSON blob result.
+// family, scanner and starttls, and uses these to perform scans, returning a JSON blob result.
 func scanHandler(w http.ResponseWriter, r *http.Request) error {
 	if err := r.ParseForm(); err != nil {
 		log.Warningf("failed to parse body: %v", err)
@@ -27,11 +31,19 @@ func scanHandler(w http.ResponseWriter, r *http.Request) error {
 		return errors.NewBadRequestString("no host given")
 	}
 
-	results, err := scan.Default.RunScans(host, ip, family, scanner, 0)
+	ctx := r.Context()
+	if protocol := r.Form.Get("starttls"); protocol != "" {
+		ctx = scan.WithStartTLS(ctx, protocol)
+	}
+
+	results, err := scan.Default.RunScans(ctx, host, ip, family, scanner, 0)
 	if err != nil {
 		log.Warningf("%v", err)
 		return errors.NewBadRequest(err)
 	}
+	if err = scan.SaveHistory(host, results); err != nil {
+		log.Warningf("failed to save scan history: %v", err)
+	}
 
 	response := api.NewSuccessResponse(results)
 	enc := json.NewEncoder(w)
@@ -46,6 +58,166 @@ func NewHandler(caBundleFile string) (http.Handler, error) {
 	}, scan.LoadRootCAs(caBundleFile)
 }
 
+// MaxBatchHosts is the most hosts, after CIDR ranges are expanded, that
+// a single batch request may scan.
+var MaxBatchHosts = 4096
+
+// MaxBatchTimeout bounds the scan of each host in a batch request, and
+// MaxBatchRate limits the connections made to each host to this many a
+// second. A request may ask for a shorter timeout or a lower rate, but
+// not for more.
+var (
+	MaxBatchTimeout = time.Minute
+	MaxBatchRate    = 10.0
+)
+
+// batchRequest is the body of a batch scan request.
+type batchRequest struct {
+	Hosts    []string `json:"hosts"`
+	Family   string   `json:"family"`
+	Scanner  string   `json:"scanner"`
+	StartTLS string   `json:"starttls"`
+	Timeout  string   `json:"timeout"`
+	Rate     float64  `json:"rate"`
+}
+
+// limits returns the per-host timeout and rate a batch request asks
+// for, capped at MaxBatchTimeout and MaxBatchRate.
+func (req *batchRequest) limits() (timeout time.Duration, rate float64, err error) {
+	timeout = MaxBatchTimeout
+	if req.Timeout != "" {
+		var d time.Duration
+		if d, err = time.ParseDuration(req.Timeout); err != nil {
+			return 0, 0, errors.NewBadRequest(err)
+		}
+		if d > 0 && d < timeout {
+			timeout = d
+		}
+	}
+
+	rate = MaxBatchRate
+	if req.Rate > 0 && req.Rate < rate {
+		rate = req.Rate
+	}
+	return
+}
+
+// batchHandler is an HTTP handler that accepts a JSON list of hosts and
+// CIDR ranges to scan, and streams the result of each host as a line of
+// JSON as soon as it has been scanned.
+func batchHandler(w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Warningf("failed to read request body: %v", err)
+		return errors.NewBadRequest(err)
+	}
+	r.Body.Close()
+
+	var req batchRequest
+	if err = json.Unmarshal(body, &req); err != nil {
+		return errors.NewBadRequestString("Unable to parse batch scan request")
+	}
+
+	if req.StartTLS != "" {
+		if _, err = starttls.Port(req.StartTLS); err != nil {
+			return errors.NewBadRequest(err)
+		}
+	}
+
+	timeout, rate, err := req.limits()
+	if err != nil {
+		return err
+	}
+
+	hosts, err := expandHosts(r.Context(), req.Hosts)
+	if err != nil {
+		return err
+	}
+
+	batch := &scan.Batch{
+		Families: scan.Default,
+		Family:   req.Family,
+		Scanner:  req.Scanner,
+		StartTLS: req.StartTLS,
+		Timeout:  timeout,
+		Rate:     rate,
+	}
+
+	queue := make(chan string, len(hosts))
+	for _, host := range hosts {
+		queue <- host
+	}
+	close(queue)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	err = batch.Run(r.Context(), queue, func(result *scan.HostResult) error {
+		if err := scan.WriteResult(w, result); err != nil {
+			return err
+		}
+		if err := scan.SaveResult(result); err != nil {
+			log.Warningf("failed to save scan history: %v", err)
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// The response has already been started, so the error can
+		// only be logged.
+		log.Warningf("batch scan stopped: %v", err)
+	}
+	return nil
+}
+
+// expandHosts expands the CIDR ranges in hosts, failing if a range is
+// too wide or there are more than MaxBatchHosts hosts.
+func expandHosts(ctx context.Context, hosts []string) ([]string, error) {
+	if len(hosts) == 0 {
+		return nil, errors.NewBadRequestString("no hosts given")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	expanded := make(chan string)
+	expandErr := make(chan error, 1)
+	go func() {
+		defer close(expanded)
+		for _, host := range hosts {
+			if err := scan.ExpandHost(ctx, host, expanded); err != nil {
+				expandErr <- err
+				return
+			}
+		}
+	}()
+
+	var all []string
+	for host := range expanded {
+		if len(all) == MaxBatchHosts {
+			return nil, errors.NewBadRequestString("too many hosts given")
+		}
+		all = append(all, host)
+	}
+
+	select {
+	case err := <-expandErr:
+		return nil, errors.NewBadRequest(err)
+	default:
+	}
+	return all, nil
+}
+
+// NewBatchHandler returns a new http.Handler that handles a batch scan
+// request.
+func NewBatchHandler(caBundleFile string) (http.Handler, error) {
+	return api.HTTPHandler{
+		Handler: api.HandlerFunc(batchHandler),
+		Methods: []string{"POST"},
+	}, scan.LoadRootCAs(caBundleFile)
+}
+
 // scanInfoHandler is an HTTP handler that returns a JSON blob result describing
 // the possible families and scans to be run.
 func scanInfoHandler(w http.ResponseWriter, r *http.Request) error {
@@ -1 +1,55 @@
 package scan
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBatchLimits(t *testing.T) {
+	tests := []struct {
+		req     batchRequest
+		timeout time.Duration
+		rate    float64
+	}{
+		{batchRequest{}, MaxBatchTimeout, MaxBatchRate},
+		{batchRequest{Timeout: "5s", Rate: 2}, 5 * time.Second, 2},
+		{batchRequest{Timeout: "1h", Rate: 1000}, MaxBatchTimeout, MaxBatchRate},
+		{batchRequest{Timeout: "-5s", Rate: -1}, MaxBatchTimeout, MaxBatchRate},
+	}
+	for _, test := range tests {
+		timeout, rate, err := test.req.limits()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if timeout != test.timeout || rate != test.rate {
+			t.Fatalf("%+v: expected %v and %v, have %v and %v", test.req, test.timeout, test.rate, timeout, rate)
+		}
+	}
+
+	req := batchRequest{Timeout: "soon"}
+	if _, _, err := req.limits(); err == nil {
+		t.Fatal("expected an invalid timeout to be rejected")
+	}
+}
+
+func TestExpandHosts(t *testing.T) {
+	hosts, err := expandHosts(context.Background(), []string{"example.com", "192.0.2.0/30:443"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 5 {
+		t.Fatalf("expected 5 hosts, have %v", hosts)
+	}
+
+	bad := [][]string{
+		nil,
+		{"example.com", "10.0.0.0/8"},
+		{"192.0.0.0/20", "192.0.16.0/20"},
+	}
+	for _, hosts := range bad {
+		if _, err = expandHosts(context.Background(), hosts); err == nil {
+			t.Fatalf("expected an error expanding %v", hosts)
+		}
+	}
+}
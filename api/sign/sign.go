@@ -8,12 +8,14 @@ import (
 	"net/http"
 
 	"github.com/bbandix/cfssl/api"
+	"github.com/bbandix/cfssl/audit"
 	"github.com/bbandix/cfssl/auth"
 	"github.com/bbandix/cfssl/config"
 	"github.com/bbandix/cfssl/errors"
 	"github.com/bbandix/cfssl/log"
 	"github.com/bbandix/cfssl/signer"
 	"github.com/bbandix/cfssl/signer/universal"
+	"github.com/bbandix/cfssl/whitelist"
 )
 
 // A Handler accepts requests with a hostname and certficate
@@ -55,7 +57,9 @@ func NewHandlerFromSigner(signer signer.Signer) (h *api.HTTPHandler, err error)
 
 	// Sign will only respond for profiles that have no auth provider.
 	// So if all of the profiles require authentication, we return an error.
-	haveUnauth := (policy.Default.Provider == nil)
+	// The default profile supplied for a configuration without one
+	// doesn't count, as it was never configured for signing.
+	haveUnauth := (policy.Default.Provider == nil && !policy.Default.Implicit)
 	for _, profile := range policy.Profiles {
 		haveUnauth = haveUnauth || (profile.Provider == nil)
 	}
@@ -116,13 +120,34 @@ func jsonReqToTrue(js jsonSignRequest) signer.SignRequest {
 	}
 }
 
+// authorizeClient checks the client certificate of the request against
+// the client certificate rules of the profile, if it has any.
+func authorizeClient(r *http.Request, profile *config.SigningProfile, req signer.SignRequest) error {
+	if profile.ClientCertAuth == nil {
+		return nil
+	}
+
+	names, err := signer.RequestedNames(req)
+	if err != nil {
+		return err
+	}
+
+	if err = profile.ClientCertAuth.Authorize(r.TLS, names); err != nil {
+		log.FromContext(r.Context()).Warning("client certificate authorisation failed", "error", err)
+		api.AuditDenied(r, audit.OpSign, req.Profile, "client certificate not authorised: "+err.Error())
+		return errors.NewForbidden(err)
+	}
+	return nil
+}
+
 // Handle responds to requests for the CA to sign the certificate request
 // present in the "certificate_request" parameter for the host named
 // in the "hostname" parameter. The certificate should be PEM-encoded. If
 // provided, subject information from the "subject" parameter will be used
 // in place of the subject information from the CSR.
 func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) error {
-	log.Info("signature request received")
+	logger := log.FromContext(r.Context())
+	logger.Info("signature request received")
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -138,30 +163,44 @@ func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	signReq := jsonReqToTrue(req)
+	signReq.RequestID = log.RequestID(r.Context())
+	signReq.Requester = api.Requester(r)
 
 	if req.Request == "" {
 		return errors.NewBadRequestString("missing parameter 'certificate_request'")
 	}
 
+	// The request is checked against and signed by the same
+	// signer, even if the server reloads it meanwhile.
+	s := signer.Snapshot(h.signer)
+
 	var cert []byte
-	profile, err := signer.Profile(h.signer, req.Profile)
+	profile, err := signer.Profile(s, req.Profile)
 	if err != nil {
 		return err
 	}
 
 	if profile.Provider != nil {
-		log.Error("profile requires authentication")
+		logger.Error("profile requires authentication", "profile", req.Profile)
 		return errors.NewBadRequestString("authentication required")
 	}
+	if profile.Implicit {
+		logger.Error("profile is not configured", "profile", req.Profile)
+		return errors.NewBadRequestString("unknown profile")
+	}
 
-	cert, err = h.signer.Sign(signReq)
+	if err = authorizeClient(r, profile, signReq); err != nil {
+		return err
+	}
+
+	cert, err = s.Sign(signReq)
 	if err != nil {
-		log.Warningf("failed to sign request: %v", err)
+		logger.Warning("failed to sign request", "error", err)
 		return err
 	}
 
 	result := map[string]string{"certificate": string(cert)}
-	log.Info("wrote response")
+	logger.Info("wrote response")
 	return api.SendResponse(w, result)
 }
 
@@ -227,11 +266,12 @@ func NewAuthHandlerFromSigner(signer signer.Signer) (http.Handler, error) {
 
 // Handle receives the incoming request, validates it, and processes it.
 func (h *AuthHandler) Handle(w http.ResponseWriter, r *http.Request) error {
-	log.Info("signature request received")
+	logger := log.FromContext(r.Context())
+	logger.Info("signature request received")
 
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Errorf("failed to read response body: %v", err)
+		logger.Error("failed to read request body", "error", err)
 		return err
 	}
 	r.Body.Close()
@@ -239,53 +279,68 @@ func (h *AuthHandler) Handle(w http.ResponseWriter, r *http.Request) error {
 	var aReq auth.AuthenticatedRequest
 	err = json.Unmarshal(body, &aReq)
 	if err != nil {
-		log.Errorf("failed to unmarshal authenticated request: %v", err)
+		logger.Error("failed to unmarshal authenticated request", "error", err)
 		return errors.NewBadRequest(err)
 	}
 
 	var req jsonSignRequest
 	err = json.Unmarshal(aReq.Request, &req)
 	if err != nil {
-		log.Errorf("failed to unmarshal request from authenticated request: %v", err)
+		logger.Error("failed to unmarshal request from authenticated request", "error", err)
 		return errors.NewBadRequestString("Unable to parse authenticated sign request")
 	}
 
+	// The request is verified against and signed by the same
+	// signer, even if the server reloads it meanwhile.
+	s := signer.Snapshot(h.signer)
+
 	// Sanity checks to ensure that we have a valid policy. This
 	// should have been checked in NewAuthHandler.
-	policy := h.signer.Policy()
+	policy := s.Policy()
 	if policy == nil {
-		log.Critical("signer was initialised without a signing policy")
+		logger.Critical("signer was initialised without a signing policy")
 		return errors.NewBadRequestString("invalid policy")
 	}
 
-	profile, err := signer.Profile(h.signer, req.Profile)
+	profile, err := signer.Profile(s, req.Profile)
 	if err != nil {
 		return err
 	}
 
 	if profile.Provider == nil {
-		log.Error("profile has no authentication provider")
+		logger.Error("profile has no authentication provider", "profile", req.Profile)
 		return errors.NewBadRequestString("no authentication provider")
 	}
 
+	// The remote address is taken from the connection, so that
+	// IP-bound tokens only verify for the address they were made for.
+	aReq.RemoteAddress, _ = whitelist.HTTPRequestLookup(r)
+
 	if !profile.Provider.Verify(&aReq) {
-		log.Warning("received authenticated request with invalid token")
+		logger.Warning("received authenticated request with invalid token", "profile", req.Profile)
+		api.AuditDenied(r, audit.OpSign, req.Profile, "invalid token")
 		return errors.NewBadRequestString("invalid token")
 	}
 
 	signReq := jsonReqToTrue(req)
+	signReq.RequestID = log.RequestID(r.Context())
+	signReq.Requester = api.Requester(r)
 
 	if signReq.Request == "" {
 		return errors.NewBadRequestString("missing parameter 'certificate_request'")
 	}
 
-	cert, err := h.signer.Sign(signReq)
+	if err = authorizeClient(r, profile, signReq); err != nil {
+		return err
+	}
+
+	cert, err := s.Sign(signReq)
 	if err != nil {
-		log.Errorf("signature failed: %v", err)
+		logger.Error("signature failed", "error", err)
 		return err
 	}
 
 	result := map[string]string{"certificate": string(cert)}
-	log.Info("wrote response")
+	logger.Info("wrote response")
 	return api.SendResponse(w, result)
 }
@@ -2,6 +2,9 @@ package sign
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
@@ -10,6 +13,7 @@ import (
 	"testing"
 
 	"github.com/bbandix/cfssl/api"
+	"github.com/bbandix/cfssl/api/client"
 	"github.com/bbandix/cfssl/auth"
 	"github.com/bbandix/cfssl/config"
 	"github.com/bbandix/cfssl/signer"
@@ -96,6 +100,20 @@ var alsoValidMixedLocalConfig = `
 	}
 }`
 
+// noDefaultConfig has no default profile, so that LoadConfig supplies
+// one.
+var noDefaultConfig = `
+{
+	"signing": {
+		"profiles": {
+			"www": {
+				"usages": ["digital signature", "server auth"],
+				"expiry": "1m"
+			}
+		}
+	}
+}`
+
 func newTestHandler(t *testing.T) (h http.Handler) {
 	h, err := NewHandler(testCaFile, testCaKeyFile, nil)
 	if err != nil {
@@ -529,3 +547,199 @@ func TestAuthSign(t *testing.T) {
 
 	}
 }
+
+var validClientCertConfig = `
+{
+	"signing": {
+		"default": {
+			"usages": ["digital signature", "email protection"],
+			"expiry": "1m",
+			"client_cert_auth": {
+				"rules": [
+					{"identity": "client.internal", "names": ["*.cloudflare-inter.com"]}
+				]
+			}
+		}
+	}
+}`
+
+func TestSignImplicitDefault(t *testing.T) {
+	conf, err := config.LoadConfig([]byte(noDefaultConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The supplied default profile doesn't enable signing by itself.
+	delete(conf.Signing.Profiles, "www")
+	if _, err = NewHandler(testCaFile, testCaKeyFile, conf.Signing); err == nil {
+		t.Fatal("expected the supplied default profile not to enable signing")
+	}
+
+	conf, err = config.LoadConfig([]byte(noDefaultConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHandler(testCaFile, testCaKeyFile, conf.Signing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csrPEM, err := ioutil.ReadFile(testCSRFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		profile string
+		status  int
+	}{
+		{"www", http.StatusOK},
+		{"", http.StatusBadRequest},
+		{"unknown", http.StatusBadRequest},
+	} {
+		blob, _ := json.Marshal(map[string]interface{}{
+			"hosts":               []string{"www.cloudflare-inter.com"},
+			"certificate_request": string(csrPEM),
+			"profile":             test.profile,
+		})
+		req, _ := http.NewRequest("POST", "/", bytes.NewReader(blob))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Fatalf("profile %q: expected %d, have %d: %s", test.profile, test.status, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestSignClientCertAuth(t *testing.T) {
+	conf, err := config.LoadConfig([]byte(validClientCertConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHandler(testCaFile, testCaKeyFile, conf.Signing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csrPEM, err := ioutil.ReadFile(testCSRFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &x509.Certificate{Subject: pkix.Name{CommonName: "client.internal"}}
+	verified := &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{client},
+		VerifiedChains:   [][]*x509.Certificate{{client}},
+	}
+
+	for i, test := range []struct {
+		state  *tls.ConnectionState
+		hosts  []string
+		status int
+	}{
+		{nil, []string{"www.cloudflare-inter.com"}, http.StatusForbidden},
+		{verified, []string{"www.cloudflare-inter.com"}, http.StatusOK},
+		{verified, []string{"www.cloudflare-inter.com", "cloudflare.com"}, http.StatusForbidden},
+	} {
+		blob, _ := json.Marshal(map[string]interface{}{
+			"hosts":               test.hosts,
+			"certificate_request": string(csrPEM),
+			"subject":             map[string]string{"CN": "www.cloudflare-inter.com"},
+		})
+		req, _ := http.NewRequest("POST", "/", bytes.NewReader(blob))
+		req.TLS = test.state
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Fatalf("Test %d: expected %d, have %d: %s", i, test.status, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestAuthSignReplayProtection(t *testing.T) {
+	for _, keyType := range []string{"standard-ts", "standard-ip"} {
+		conf, err := config.LoadConfig([]byte(strings.Replace(validAuthLocalConfig, `"standard"`, `"`+keyType+`"`, 1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := NewAuthHandler(testCaFile, testCaKeyFile, conf.Signing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts := httptest.NewServer(h)
+		defer ts.Close()
+
+		csrPEM, err := ioutil.ReadFile(testCSRFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqBlob, _ := json.Marshal(map[string]string{"certificate_request": string(csrPEM)})
+
+		// The client fills in the fields the provider needs.
+		remote := client.NewServer(strings.TrimPrefix(ts.URL, "http://"))
+		if _, err = remote.AuthSign(reqBlob, nil, conf.Signing.Default.Provider); err != nil {
+			t.Fatalf("%s: %v", keyType, err)
+		}
+
+		// A request authenticated for another address or
+		// captured and replayed is rejected.
+		aReq := auth.AuthenticatedRequest{Request: reqBlob, RemoteAddress: []byte{10, 0, 0, 1}}
+		if err = conf.Signing.Default.Provider.(auth.RequestProvider).Authenticate(&aReq); err != nil {
+			t.Fatal(err)
+		}
+		blob, _ := json.Marshal(aReq)
+		for i := 0; i < 2; i++ {
+			resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(blob))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			expected := http.StatusOK
+			if keyType == "standard-ip" || i > 0 {
+				expected = http.StatusBadRequest
+			}
+			if resp.StatusCode != expected {
+				t.Fatalf("%s: request %d: expected %d, have %d", keyType, i, expected, resp.StatusCode)
+			}
+		}
+	}
+}
+
+func TestAuthSignAsymmetric(t *testing.T) {
+	serverConf, err := config.LoadConfig([]byte(`{
+		"signing": {"default": {"usages": ["digital signature"], "expiry": "1m", "auth_key": "clients"}},
+		"auth_keys": {"clients": {"type": "asymmetric", "public_keys": {"ec": "../../auth/testdata/ecdsa_client_pub.pem"}}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewAuthHandler(testCaFile, testCaKeyFile, serverConf.Signing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	csrPEM, err := ioutil.ReadFile(testCSRFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqBlob, _ := json.Marshal(map[string]string{"certificate_request": string(csrPEM)})
+	remote := client.NewServer(strings.TrimPrefix(ts.URL, "http://"))
+
+	for keyID, ok := range map[string]bool{"ec": true, "other": false} {
+		provider, err := config.LoadConfig([]byte(`{
+			"signing": {"default": {"auth_remote": {"remote": "ca", "auth_key": "client"}}},
+			"auth_keys": {"client": {"type": "asymmetric", "key_id": "` + keyID + `", "private_key": "../../auth/testdata/ecdsa_client_key.pem"}},
+			"remotes": {"ca": "127.0.0.1:8888"}
+		}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = remote.AuthSign(reqBlob, nil, provider.Signing.Default.RemoteProvider)
+		if (err == nil) != ok {
+			t.Fatalf("key ID %s: unexpected result %v", keyID, err)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+// Package audit implements a tamper-evident record of CA operations.
+// Each record carries a sequence number, the hash of the record before
+// it and its own hash, an HMAC keyed with a secret audit key, so that a
+// record that is edited, removed or inserted breaks the chain and is
+// detected by Verify. A checkpoint of the last record, kept alongside
+// the records and also keyed, reveals records removed from the end.
+//
+// Operations are recorded with Write, which does nothing until a Log
+// has been set with SetLog.
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The following constants are the operations that are recorded.
+const (
+	OpSign     = "sign"
+	OpInitCA   = "init_ca"
+	OpRenewCA  = "renew_ca"
+	OpRekeyCA  = "rekey_ca"
+	OpOCSPSign = "ocsp_sign"
+	OpRevoke   = "revoke"
+)
+
+// MinKeySize is the minimum size of an audit key, in bytes.
+const MinKeySize = 16
+
+// The following constants are the outcomes of an operation.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied"
+)
+
+// A Record describes a single CA operation.
+type Record struct {
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	RequestID string    `json:"request_id,omitempty"`
+	Requester string    `json:"requester,omitempty"`
+	Profile   string    `json:"profile,omitempty"`
+	Serial    string    `json:"serial,omitempty"`
+	SANs      []string  `json:"sans,omitempty"`
+	Status    string    `json:"status,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+	PrevHash  string    `json:"prev_hash"`
+	Hash      string    `json:"hash"`
+}
+
+// SetCertificate records the serial number and subject alternative
+// names of the certificate an operation was for.
+func (r *Record) SetCertificate(cert *x509.Certificate) {
+	r.Serial = cert.SerialNumber.String()
+	r.SANs = append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		r.SANs = append(r.SANs, ip.String())
+	}
+	r.SANs = append(r.SANs, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		r.SANs = append(r.SANs, uri.String())
+	}
+}
+
+// SetOutcome records the outcome of an operation from the error it
+// returned.
+func (r *Record) SetOutcome(err error) {
+	if err != nil {
+		r.Outcome = OutcomeFailure
+		r.Error = err.Error()
+	} else {
+		r.Outcome = OutcomeSuccess
+	}
+}
+
+// LoadKey reads an audit key from a file holding it hex-encoded.
+func LoadKey(path string) ([]byte, error) {
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(in)))
+	if err != nil {
+		return nil, fmt.Errorf("audit: invalid key in %s: %v", path, err)
+	}
+	if len(key) < MinKeySize {
+		return nil, fmt.Errorf("audit: the key in %s is shorter than %d bytes", path, MinKeySize)
+	}
+	return key, nil
+}
+
+// mac returns the hex-encoded HMAC-SHA256 of msg keyed with key.
+func mac(key, msg []byte) string {
+	h := hmac.New(sha256.New, key)
+	h.Write(msg)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ComputeHash returns the hash of a record: the HMAC-SHA256 of its JSON
+// encoding with the Hash field empty, keyed with key and hex-encoded.
+// As the encoding includes PrevHash, the hash covers every earlier
+// record.
+func ComputeHash(r *Record, key []byte) (string, error) {
+	rec := *r
+	rec.Hash = ""
+	out, err := json.Marshal(&rec)
+	if err != nil {
+		return "", err
+	}
+	return mac(key, out), nil
+}
+
+// A Checkpoint records the sequence number and hash of the last record
+// in a log, with a MAC over both, so that records removed from the end
+// of the log are detected. Only the latest checkpoint is kept, so
+// removals can still be hidden by restoring an old copy of it along
+// with the records it covers; keeping copies elsewhere guards
+// against this.
+type Checkpoint struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+	MAC  string `json:"mac"`
+}
+
+// checkpointMAC returns the MAC of a checkpoint for the given record.
+// The message is distinct from any record's JSON encoding, so that a
+// record's hash can't stand in for a checkpoint's MAC.
+func checkpointMAC(key []byte, seq uint64, hash string) string {
+	return mac(key, []byte(fmt.Sprintf("checkpoint %d %s", seq, hash)))
+}
+
+// NewCheckpoint returns a checkpoint for last, the last record in a log.
+func NewCheckpoint(last *Record, key []byte) *Checkpoint {
+	return &Checkpoint{
+		Seq:  last.Seq,
+		Hash: last.Hash,
+		MAC:  checkpointMAC(key, last.Seq, last.Hash),
+	}
+}
+
+// Check checks that c is a valid checkpoint for last, the last record
+// in a log, which is nil for an empty log. A nil checkpoint is only
+// valid for an empty log.
+func (c *Checkpoint) Check(last *Record, key []byte) error {
+	if c == nil {
+		if last != nil {
+			return errors.New("audit: the checkpoint is missing")
+		}
+		return nil
+	}
+
+	if !hmac.Equal([]byte(c.MAC), []byte(checkpointMAC(key, c.Seq, c.Hash))) {
+		return errors.New("audit: the checkpoint has been modified")
+	}
+	if last == nil || last.Seq != c.Seq || last.Hash != c.Hash {
+		var seq uint64
+		if last != nil {
+			seq = last.Seq
+		}
+		return fmt.Errorf("audit: the log ends at record %d, but its checkpoint is for record %d", seq, c.Seq)
+	}
+	return nil
+}
+
+// A Store holds audit records and the checkpoint of the last one.
+// Stores are only written by a single Log, which serialises its calls.
+type Store interface {
+	// Append adds a record after the last one.
+	Append(r *Record) error
+
+	// Last returns the last record, or nil if there are none.
+	Last() (*Record, error)
+
+	// SetCheckpoint replaces the checkpoint.
+	SetCheckpoint(c *Checkpoint) error
+
+	// Checkpoint returns the checkpoint, or nil if there is none.
+	Checkpoint() (*Checkpoint, error)
+}
+
+// A Log appends hash-chained records to a Store.
+type Log struct {
+	lock  sync.Mutex
+	store Store
+	key   []byte
+	last  *Record
+}
+
+// NewLog returns a Log that continues the chain of records in store,
+// keyed with key. The last record must match the store's checkpoint,
+// so that a log whose end has been removed isn't silently continued.
+func NewLog(store Store, key []byte) (*Log, error) {
+	if len(key) < MinKeySize {
+		return nil, fmt.Errorf("audit: the key must be at least %d bytes", MinKeySize)
+	}
+
+	last, err := store.Last()
+	if err != nil {
+		return nil, err
+	}
+	cp, err := store.Checkpoint()
+	if err != nil {
+		return nil, err
+	}
+	if err = cp.Check(last, key); err != nil {
+		return nil, err
+	}
+	return &Log{store: store, key: key, last: last}, nil
+}
+
+// Write fills in the sequence number, time and hashes of a record and
+// appends it to the store.
+func (l *Log) Write(r *Record) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	rec := *r
+	rec.Seq = 1
+	rec.PrevHash = ""
+	if l.last != nil {
+		rec.Seq = l.last.Seq + 1
+		rec.PrevHash = l.last.Hash
+	}
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	// Times are recorded in UTC, so that they encode the same way
+	// when the record is read back and its hash checked.
+	rec.Time = rec.Time.UTC()
+
+	var err error
+	if rec.Hash, err = ComputeHash(&rec, l.key); err != nil {
+		return err
+	}
+	if err = l.store.Append(&rec); err != nil {
+		return err
+	}
+	l.last = &rec
+	return l.store.SetCheckpoint(NewCheckpoint(&rec, l.key))
+}
+
+var (
+	stdLock sync.RWMutex
+	std     *Log
+)
+
+// SetLog sets the Log that Write records operations in.
+func SetLog(l *Log) {
+	stdLock.Lock()
+	defer stdLock.Unlock()
+	std = l
+}
+
+// Write records an operation in the Log set with SetLog, if any.
+// Callers should fail the operation if the record can't be written, so
+// that no operation goes unrecorded.
+func Write(r *Record) error {
+	stdLock.RLock()
+	defer stdLock.RUnlock()
+	if std == nil {
+		return nil
+	}
+	return std.Write(r)
+}
@@ -0,0 +1,218 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/x509"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testKey = []byte("0123456789abcdef")
+
+// writeLog writes n records to a new audit log file and returns its
+// path.
+func writeLog(t *testing.T, dir string, n int) string {
+	path := filepath.Join(dir, "audit.log")
+	store, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	l, err := NewLog(store, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err = l.Write(&Record{Operation: OpSign, Profile: "server", Outcome: OutcomeSuccess}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func verifyFile(t *testing.T, path string, key []byte) (*Record, error) {
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp, err := ReadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Verify(bytes.NewReader(in), key, cp)
+}
+
+func TestChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeLog(t, dir, 3)
+
+	// Reopening the file continues the chain.
+	store, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLog(store, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Write(&Record{Operation: OpRevoke, Outcome: OutcomeSuccess}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	last, err := verifyFile(t, path, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last.Seq != 4 || last.Operation != OpRevoke {
+		t.Fatalf("unexpected last record %+v", last)
+	}
+}
+
+func TestVerifyTampering(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeLog(t, dir, 3)
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(in), "\n")
+	cp, err := ReadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := map[string]string{
+		"edited":   lines[0] + strings.Replace(lines[1], `"server"`, `"client"`, 1) + lines[2],
+		"removed":  lines[0] + lines[2],
+		"reversed": lines[0] + lines[2] + lines[1],
+		"unknown":  lines[0] + strings.Replace(lines[1], `{`, `{"extra":1,`, 1) + lines[2],
+	}
+	for name, log := range tampered {
+		last, err := Verify(strings.NewReader(log), testKey, cp)
+		if err == nil {
+			t.Fatalf("%s record was not detected", name)
+		}
+		if last == nil || last.Seq != 1 {
+			t.Fatalf("%s record: expected verification to stop after record 1, have %+v", name, last)
+		}
+	}
+}
+
+func TestVerifyTruncation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeLog(t, dir, 3)
+	in, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(in), "\n")
+	if err = ioutil.WriteFile(path, []byte(lines[0]+lines[1]), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	last, err := verifyFile(t, path, testKey)
+	if err == nil {
+		t.Fatal("truncated log was not detected")
+	}
+	if last == nil || last.Seq != 2 {
+		t.Fatalf("expected every remaining record to verify, have %+v", last)
+	}
+
+	store, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, err = NewLog(store, testKey); err == nil {
+		t.Fatal("expected a truncated log not to be continued")
+	}
+}
+
+func TestVerifyWrongKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeLog(t, dir, 2)
+	if _, err = verifyFile(t, path, []byte("fedcba9876543210")); err == nil {
+		t.Fatal("expected verification with the wrong key to fail")
+	}
+	if err = os.Remove(path + ".checkpoint"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = verifyFile(t, path, testKey); err == nil {
+		t.Fatal("expected verification without the checkpoint to fail")
+	}
+}
+
+func TestShortKey(t *testing.T) {
+	if _, err := NewLog(nil, []byte("short")); err == nil {
+		t.Fatal("expected a short key to be rejected")
+	}
+}
+
+func TestEmptyLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := OpenFile(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	last, err := store.Last()
+	if err != nil || last != nil {
+		t.Fatalf("expected no records, have %+v, %v", last, err)
+	}
+}
+
+func TestSetCertificate(t *testing.T) {
+	cert := &x509.Certificate{
+		SerialNumber:   big.NewInt(42),
+		DNSNames:       []string{"example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("127.0.0.1")},
+		EmailAddresses: []string{"admin@example.com"},
+		URIs:           []*url.URL{{Scheme: "spiffe", Host: "example.com", Path: "/server"}},
+	}
+
+	var r Record
+	r.SetCertificate(cert)
+	if r.Serial != "42" || strings.Join(r.SANs, ",") != "example.com,127.0.0.1,admin@example.com,spiffe://example.com/server" {
+		t.Fatalf("unexpected record %+v", r)
+	}
+}
+
+func TestWriteWithoutLog(t *testing.T) {
+	if err := Write(&Record{Operation: OpSign}); err != nil {
+		t.Fatalf("expected records to be dropped without a log, have %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FileStore stores records in a file, one JSON object per line. The file
+// is only ever appended to, and is synced after every record. The
+// checkpoint is kept in a file of its own, named after the log with
+// ".checkpoint" appended, which is replaced after every record.
+type FileStore struct {
+	f    *os.File
+	path string
+}
+
+// OpenFile opens, or creates, an audit log file.
+func OpenFile(path string) (*FileStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStore{f: f, path: path}, nil
+}
+
+// checkpointPath returns the path of the checkpoint of the audit log
+// at path.
+func checkpointPath(path string) string {
+	return path + ".checkpoint"
+}
+
+// SetCheckpoint replaces the checkpoint file, writing the new
+// checkpoint to a temporary file and renaming it into place.
+func (s *FileStore) SetCheckpoint(c *Checkpoint) error {
+	out, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	path := checkpointPath(s.path)
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(append(out, '\n')); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Checkpoint reads the checkpoint file.
+func (s *FileStore) Checkpoint() (*Checkpoint, error) {
+	return ReadCheckpoint(s.path)
+}
+
+// ReadCheckpoint reads the checkpoint of the audit log file at path.
+// It returns nil if the log has no checkpoint.
+func ReadCheckpoint(path string) (*Checkpoint, error) {
+	in, err := ioutil.ReadFile(checkpointPath(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var c Checkpoint
+	if err = json.Unmarshal(in, &c); err != nil {
+		return nil, fmt.Errorf("audit: invalid checkpoint: %v", err)
+	}
+	return &c, nil
+}
+
+// Append writes a record as a line at the end of the file.
+func (s *FileStore) Append(r *Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err = s.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+// Last reads the last record in the file, reading backwards from its
+// end so that the whole file needn't be read.
+func (s *FileStore) Last() (*Record, error) {
+	size, err := s.f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	var tail []byte
+	const chunk = 4096
+	for off := size; off > 0; {
+		n := int64(chunk)
+		if off < n {
+			n = off
+		}
+		off -= n
+
+		buf := make([]byte, n)
+		if _, err = s.f.ReadAt(buf, off); err != nil {
+			return nil, err
+		}
+		tail = append(buf, tail...)
+
+		line := bytes.TrimRight(tail, "\n")
+		if i := bytes.LastIndexByte(line, '\n'); i >= 0 || off == 0 {
+			line = line[i+1:]
+			if len(line) == 0 {
+				return nil, nil
+			}
+
+			var r Record
+			if err = json.Unmarshal(line, &r); err != nil {
+				return nil, fmt.Errorf("audit: invalid last record: %v", err)
+			}
+			return &r, nil
+		}
+	}
+	return nil, nil
+}
+
+// Close closes the file.
+func (s *FileStore) Close() error {
+	return s.f.Close()
+}
+
+// Verify reads records, one JSON object per line, and checks that they
+// form an unbroken chain keyed with key, starting at the first record,
+// and that the last record matches the checkpoint cp. It returns the
+// last record that was verified. Records with unknown fields are
+// rejected.
+func Verify(r io.Reader, key []byte, cp *Checkpoint) (*Record, error) {
+	var last *Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var rec Record
+		dec := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rec); err != nil {
+			return last, fmt.Errorf("audit: line %d: invalid record: %v", line, err)
+		}
+
+		var wantSeq uint64 = 1
+		var wantPrev string
+		if last != nil {
+			wantSeq = last.Seq + 1
+			wantPrev = last.Hash
+		}
+		if rec.Seq != wantSeq {
+			return last, fmt.Errorf("audit: line %d: expected record %d, found %d", line, wantSeq, rec.Seq)
+		}
+		if rec.PrevHash != wantPrev {
+			return last, fmt.Errorf("audit: line %d: record %d doesn't follow on from the one before it", line, rec.Seq)
+		}
+
+		hash, err := ComputeHash(&rec, key)
+		if err != nil {
+			return last, err
+		}
+		if hash != rec.Hash {
+			return last, fmt.Errorf("audit: line %d: record %d has been modified", line, rec.Seq)
+		}
+
+		last = &rec
+	}
+	if err := scanner.Err(); err != nil {
+		return last, err
+	}
+	return last, cp.Check(last, key)
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"math/big"
+)
+
+// Asymmetric implements an authentication provider in which clients
+// sign requests with their own ECDSA or Ed25519 private keys, and
+// servers verify them against a set of public keys identified by key
+// ID, so that no secret is shared between clients and the server.
+// Signatures cover the key ID, timestamp and a random nonce as well as
+// the request; stale and replayed requests are rejected as they are
+// by StandardTS.
+type Asymmetric struct {
+	keyID  string
+	priv   crypto.Signer
+	pubs   map[string]crypto.PublicKey
+	replay *replayCache
+}
+
+// NewAsymmetric creates an asymmetric authentication provider. A
+// client needs a key ID and private key to sign requests; a server
+// needs the public keys of its clients, indexed by key ID, to verify
+// them. A provider may have both.
+func NewAsymmetric(keyID string, priv crypto.Signer, pubs map[string]crypto.PublicKey) (*Asymmetric, error) {
+	if priv == nil && len(pubs) == 0 {
+		return nil, errors.New("auth: an asymmetric provider needs a private key or public keys")
+	}
+	if priv != nil {
+		if keyID == "" {
+			return nil, errors.New("auth: a private key needs a key ID")
+		}
+		if err := checkKeyType(priv.Public()); err != nil {
+			return nil, err
+		}
+	}
+	for _, pub := range pubs {
+		if err := checkKeyType(pub); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Asymmetric{
+		keyID:  keyID,
+		priv:   priv,
+		pubs:   pubs,
+		replay: newReplayCache(DefaultSkew, DefaultReplayCacheSize),
+	}, nil
+}
+
+func checkKeyType(pub crypto.PublicKey) error {
+	switch pub.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+		return nil
+	default:
+		return errors.New("auth: only ECDSA and Ed25519 keys are supported")
+	}
+}
+
+// ParsePrivateKeyPEM parses a PEM-encoded ECDSA or Ed25519 private key,
+// in SEC 1 or PKCS #8 form, for signing requests.
+func ParsePrivateKeyPEM(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("auth: no PEM-encoded private key found")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.New("auth: failed to parse private key")
+		}
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("auth: only ECDSA and Ed25519 keys are supported")
+	}
+	if err = checkKeyType(signer.Public()); err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+// ParsePublicKeyPEM parses a PEM-encoded ECDSA or Ed25519 public key
+// for verifying requests.
+func ParsePublicKeyPEM(keyPEM []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("auth: no PEM-encoded public key found")
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkKeyType(pub); err != nil {
+		return nil, err
+	}
+	return pub, nil
+}
+
+// Token always fails, as requests are signed along with their key ID,
+// timestamp and nonce; use Authenticate instead.
+func (p *Asymmetric) Token(req []byte) ([]byte, error) {
+	return nil, errNeedsAuthenticate
+}
+
+// message returns the data signed for a request. Every field but the
+// request has a fixed size or a length prefix, so the message is
+// unambiguous.
+func message(aReq *AuthenticatedRequest) []byte {
+	var buf []byte
+	var n [8]byte
+
+	binary.BigEndian.PutUint64(n[:], uint64(aReq.Timestamp))
+	buf = append(buf, n[:]...)
+	buf = append(buf, aReq.Nonce...)
+
+	binary.BigEndian.PutUint16(n[:2], uint16(len(aReq.KeyID)))
+	buf = append(buf, n[:2]...)
+	buf = append(buf, aReq.KeyID...)
+
+	return append(buf, aReq.Request...)
+}
+
+// Authenticate sets the key ID, timestamp and nonce of a request and
+// signs it with the provider's private key.
+func (p *Asymmetric) Authenticate(aReq *AuthenticatedRequest) (err error) {
+	if p.priv == nil {
+		return errors.New("auth: no private key to sign the request with")
+	}
+
+	aReq.KeyID = p.keyID
+	if err = p.replay.stamp(aReq); err != nil {
+		return
+	}
+
+	msg := message(aReq)
+	if _, ok := p.priv.Public().(ed25519.PublicKey); ok {
+		aReq.Token, err = p.priv.Sign(rand.Reader, msg, crypto.Hash(0))
+		return
+	}
+
+	digest := sha256.Sum256(msg)
+	aReq.Token, err = p.priv.Sign(rand.Reader, digest[:], crypto.SHA256)
+	return
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// Verify determines whether a request was signed by the key with its
+// key ID, is fresh and has not been seen before.
+func (p *Asymmetric) Verify(aReq *AuthenticatedRequest) bool {
+	if !p.replay.fresh(aReq) {
+		return false
+	}
+
+	pub, ok := p.pubs[aReq.KeyID]
+	if !ok {
+		return false
+	}
+
+	msg := message(aReq)
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, msg, aReq.Token) {
+			return false
+		}
+	case *ecdsa.PublicKey:
+		var sig ecdsaSignature
+		rest, err := asn1.Unmarshal(aReq.Token, &sig)
+		if err != nil || len(rest) != 0 || sig.R == nil || sig.S == nil {
+			return false
+		}
+		digest := sha256.Sum256(msg)
+		if !ecdsa.Verify(pub, digest[:], sig.R, sig.S) {
+			return false
+		}
+	default:
+		return false
+	}
+
+	return p.replay.remember(aReq)
+}
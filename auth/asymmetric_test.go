@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"crypto"
+	"io/ioutil"
+	"testing"
+)
+
+func loadAsymmetric(t *testing.T, name string) (crypto.Signer, crypto.PublicKey) {
+	keyPEM, err := ioutil.ReadFile("testdata/" + name + "_client_key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pubPEM, err := ioutil.ReadFile("testdata/" + name + "_client_pub.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := ParsePublicKeyPEM(pubPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, pub
+}
+
+func TestAsymmetric(t *testing.T) {
+	ecPriv, ecPub := loadAsymmetric(t, "ecdsa")
+	edPriv, edPub := loadAsymmetric(t, "ed25519")
+
+	server, err := NewAsymmetric("", nil, map[string]crypto.PublicKey{"ec": ecPub, "ed": edPub})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for keyID, priv := range map[string]crypto.Signer{"ec": ecPriv, "ed": edPriv} {
+		client, err := NewAsymmetric(keyID, priv, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = client.Token([]byte(`testing 1 2 3`)); err == nil {
+			t.Fatal("Token should not be usable with an asymmetric provider")
+		}
+
+		aReq := &AuthenticatedRequest{Request: []byte(`testing 1 2 3`)}
+		if err = client.Authenticate(aReq); err != nil {
+			t.Fatal(err)
+		}
+		if aReq.KeyID != keyID {
+			t.Fatalf("request has key ID %q, expected %q", aReq.KeyID, keyID)
+		}
+		if !server.Verify(aReq) {
+			t.Fatalf("failed to verify a request signed with %s", keyID)
+		}
+		if server.Verify(aReq) {
+			t.Fatal("replayed request was verified")
+		}
+
+		for _, tamper := range []func(*AuthenticatedRequest){
+			func(r *AuthenticatedRequest) { r.Request = []byte(`testing 3 2 1`) },
+			func(r *AuthenticatedRequest) { r.Timestamp++ },
+			func(r *AuthenticatedRequest) { r.Token[len(r.Token)-1] ^= 1 },
+			func(r *AuthenticatedRequest) { r.KeyID = "unknown" },
+		} {
+			aReq = &AuthenticatedRequest{Request: []byte(`testing 1 2 3`)}
+			client.Authenticate(aReq)
+			tamper(aReq)
+			if server.Verify(aReq) {
+				t.Fatal("tampered request was verified")
+			}
+		}
+	}
+
+	// A request signed with one key does not verify under another
+	// key's ID.
+	client, _ := NewAsymmetric("ed", ecPriv, nil)
+	aReq := &AuthenticatedRequest{Request: []byte(`testing 1 2 3`)}
+	client.Authenticate(aReq)
+	if server.Verify(aReq) {
+		t.Fatal("request signed with the wrong key was verified")
+	}
+
+	// A server has no private key to sign with.
+	if server.Authenticate(aReq) == nil {
+		t.Fatal("provider without a private key signed a request")
+	}
+}
+
+func TestNewAsymmetricErrors(t *testing.T) {
+	ecPriv, _ := loadAsymmetric(t, "ecdsa")
+
+	if _, err := NewAsymmetric("", nil, nil); err == nil {
+		t.Fatal("expected an error without keys")
+	}
+	if _, err := NewAsymmetric("", ecPriv, nil); err == nil {
+		t.Fatal("expected an error for a private key without a key ID")
+	}
+
+	rsaPEM, err := ioutil.ReadFile("../helpers/testdata/priv_rsa_key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParsePrivateKeyPEM(rsaPEM); err == nil {
+		t.Fatal("expected an error for an RSA key")
+	}
+}
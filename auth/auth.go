@@ -9,6 +9,8 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"net"
 )
 
 // An AuthenticatedRequest contains a request and authentication
@@ -18,6 +20,8 @@ type AuthenticatedRequest struct {
 	// An Authenticator decides whether to use this field.
 	Timestamp     int64  `json:"timestamp,omitempty"`
 	RemoteAddress []byte `json:"remote_address,omitempty"`
+	Nonce         []byte `json:"nonce,omitempty"`
+	KeyID         string `json:"key_id,omitempty"`
 	Token         []byte `json:"token"`
 	Request       []byte `json:"request"`
 }
@@ -31,6 +35,16 @@ type Provider interface {
 	Verify(aReq *AuthenticatedRequest) bool
 }
 
+// A RequestProvider is a Provider whose tokens also cover fields of
+// the authenticated request other than the request itself, such as
+// the timestamp or the client's address. Clients should use
+// Authenticate, which fills in the token and any fields it needs,
+// rather than Token.
+type RequestProvider interface {
+	Provider
+	Authenticate(aReq *AuthenticatedRequest) error
+}
+
 // Standard implements an HMAC-SHA-256 authentication provider. It may
 // be supplied additional data at creation time that will be used as
 // request || additional-data with the HMAC.
@@ -73,3 +87,83 @@ func (p Standard) Verify(ad *AuthenticatedRequest) bool {
 
 	return hmac.Equal(token, ad.Token)
 }
+
+// StandardIP implements an HMAC-SHA-256 authentication provider that
+// binds tokens to the client's IP address, which is carried in the
+// remote address of the authenticated request. Servers must set the
+// remote address to the address the request came from before
+// verifying it, so that a token is only valid from that address.
+type StandardIP struct {
+	key []byte
+}
+
+// NewIP generates a new IP-bound authentication provider from the
+// hex-encoded key.
+func NewIP(key string) (*StandardIP, error) {
+	keyBytes, err := hex.DecodeString(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StandardIP{keyBytes}, nil
+}
+
+// NeedsRemoteAddress reports whether requests authenticated by p must
+// carry the client's IP address in their remote address.
+func NeedsRemoteAddress(p Provider) bool {
+	switch p := p.(type) {
+	case *StandardIP:
+		return true
+	case *KeySet:
+		key, err := p.current()
+		return err == nil && NeedsRemoteAddress(key.Provider)
+	default:
+		return false
+	}
+}
+
+// errNeedsAuthenticate is returned by Token for providers whose tokens
+// cover more than the request.
+var errNeedsAuthenticate = errors.New("auth: this provider authenticates the whole request; use Authenticate")
+
+// Token always fails, as the token depends on the client's address;
+// use Authenticate instead.
+func (p StandardIP) Token(req []byte) ([]byte, error) {
+	return nil, errNeedsAuthenticate
+}
+
+// token computes the HMAC of the request and the 16-byte form of
+// the address.
+func (p StandardIP) token(req, addr []byte) ([]byte, error) {
+	ip := net.IP(addr).To16()
+	if ip == nil {
+		return nil, errors.New("auth: invalid remote address")
+	}
+
+	h := hmac.New(sha256.New, p.key)
+	h.Write(req)
+	h.Write(ip)
+	return h.Sum(nil), nil
+}
+
+// Authenticate sets the token of an authenticated request whose remote
+// address holds the client's IP address.
+func (p StandardIP) Authenticate(aReq *AuthenticatedRequest) (err error) {
+	aReq.Token, err = p.token(aReq.Request, aReq.RemoteAddress)
+	return
+}
+
+// Verify determines whether an authenticated request is valid for its
+// remote address.
+func (p StandardIP) Verify(aReq *AuthenticatedRequest) bool {
+	if aReq == nil {
+		return false
+	}
+
+	token, err := p.token(aReq.Request, aReq.RemoteAddress)
+	if err != nil || len(aReq.Token) != len(token) {
+		return false
+	}
+
+	return hmac.Equal(token, aReq.Token)
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A ClientCertRule binds a client identity to the names it may
+// request. The identity is matched against the common name, DNS names,
+// email addresses and URIs (such as SPIFFE IDs) of the client
+// certificate; a leading "*." matches any subdomain. The names follow
+// the same rules, and "*" matches any name.
+type ClientCertRule struct {
+	Identity string   `json:"identity"`
+	Names    []string `json:"names"`
+}
+
+// A ClientCertPolicy requires that callers present a verified TLS
+// client certificate. If it has rules, the caller's certificate must
+// match at least one of them, and each requested name must be allowed
+// by a rule the caller matches.
+type ClientCertPolicy struct {
+	Rules []ClientCertRule `json:"rules"`
+}
+
+// Validate checks that every rule has an identity and names.
+func (p *ClientCertPolicy) Validate() error {
+	for i, rule := range p.Rules {
+		if rule.Identity == "" {
+			return fmt.Errorf("client certificate rule %d has no identity", i)
+		}
+		if len(rule.Names) == 0 {
+			return fmt.Errorf("client certificate rule %d has no names", i)
+		}
+	}
+	return nil
+}
+
+// ClientIdentities returns the identities of a client certificate:
+// its common name, DNS names, email addresses and URIs.
+func ClientIdentities(cert *x509.Certificate) []string {
+	var ids []string
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+	ids = append(ids, cert.DNSNames...)
+	ids = append(ids, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		ids = append(ids, uri.String())
+	}
+	return ids
+}
+
+// matchName reports whether name matches pattern. URIs are compared
+// exactly; other names are compared case-insensitively, and a pattern
+// starting with "*." matches any subdomain of the rest of the pattern.
+func matchName(pattern, name string) bool {
+	if strings.Contains(pattern, "://") {
+		return pattern == name
+	}
+
+	pattern, name = strings.ToLower(pattern), strings.ToLower(name)
+	if strings.HasPrefix(pattern, "*.") {
+		suffix := pattern[1:]
+		return len(name) > len(suffix) && strings.HasSuffix(name, suffix)
+	}
+	return pattern == name
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if pattern == "*" || matchName(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize checks that the connection state carries a verified client
+// certificate whose identity is allowed to request names.
+func (p *ClientCertPolicy) Authorize(state *tls.ConnectionState, names []string) error {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return errors.New("a verified client certificate is required")
+	}
+	if len(p.Rules) == 0 {
+		return nil
+	}
+
+	ids := ClientIdentities(state.PeerCertificates[0])
+	var allowed []string
+	for _, rule := range p.Rules {
+		for _, id := range ids {
+			if matchName(rule.Identity, id) {
+				allowed = append(allowed, rule.Names...)
+				break
+			}
+		}
+	}
+	if allowed == nil {
+		return fmt.Errorf("client %s is not authorised by any rule", strings.Join(ids, ", "))
+	}
+
+	for _, name := range names {
+		if !matchAny(allowed, name) {
+			return fmt.Errorf("client %s is not authorised to request %s", strings.Join(ids, ", "), name)
+		}
+	}
+	return nil
+}
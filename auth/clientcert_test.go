@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+)
+
+func clientState(cert *x509.Certificate) *tls.ConnectionState {
+	return &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}
+}
+
+func TestClientCertPolicyValidate(t *testing.T) {
+	for _, rule := range []ClientCertRule{
+		{Names: []string{"*.x.internal"}},
+		{Identity: "x"},
+	} {
+		p := &ClientCertPolicy{Rules: []ClientCertRule{rule}}
+		if p.Validate() == nil {
+			t.Fatalf("invalid rule %+v accepted", rule)
+		}
+	}
+}
+
+func TestClientCertPolicyAuthorize(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/service/x")
+	x := &x509.Certificate{URIs: []*url.URL{spiffe}}
+	y := &x509.Certificate{Subject: pkix.Name{CommonName: "y"}, DNSNames: []string{"y.svc.internal"}}
+
+	p := &ClientCertPolicy{Rules: []ClientCertRule{
+		{Identity: "spiffe://example.org/service/x", Names: []string{"*.x.internal"}},
+		{Identity: "*.svc.internal", Names: []string{"y.internal", "10.0.0.1"}},
+	}}
+
+	for _, tc := range []struct {
+		cert  *x509.Certificate
+		names []string
+		ok    bool
+	}{
+		{x, []string{"a.x.internal", "B.A.X.Internal"}, true},
+		{x, []string{"x.internal"}, false},
+		{x, []string{"a.x.internal", "y.internal"}, false},
+		{y, []string{"y.internal", "10.0.0.1"}, true},
+		{y, []string{"a.x.internal"}, false},
+		{&x509.Certificate{Subject: pkix.Name{CommonName: "z"}}, nil, false},
+	} {
+		err := p.Authorize(clientState(tc.cert), tc.names)
+		if (err == nil) != tc.ok {
+			t.Fatalf("Authorize(%v, %v) = %v", ClientIdentities(tc.cert), tc.names, err)
+		}
+	}
+
+	// Without rules, any verified client is allowed.
+	open := &ClientCertPolicy{}
+	if err := open.Authorize(clientState(y), []string{"anything"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unverified or missing client certificates are rejected.
+	if open.Authorize(nil, nil) == nil {
+		t.Fatal("request without TLS was authorised")
+	}
+	if open.Authorize(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{y}}, nil) == nil {
+		t.Fatal("unverified client certificate was authorised")
+	}
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bbandix/cfssl/log"
+)
+
+// A Key is an authentication provider with a key ID and an optional
+// validity window, for use in a KeySet.
+type Key struct {
+	ID        string
+	Provider  Provider
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// valid reports whether the key is within its validity window at t.
+func (k *Key) valid(t time.Time) bool {
+	if !k.NotBefore.IsZero() && t.Before(k.NotBefore) {
+		return false
+	}
+	if !k.NotAfter.IsZero() && t.After(k.NotAfter) {
+		return false
+	}
+	return true
+}
+
+// A KeySet is a Provider made up of several keys, so that keys can be
+// rotated without every client switching at once. A request is
+// accepted if any key within its validity window verifies it; the key
+// named by the request's key ID, if any, is tried first. Requests are
+// authenticated with the last key in the set that is within its
+// window, so keys should be listed oldest first.
+type KeySet struct {
+	keys []Key
+
+	// now is the clock used to check validity windows; it is
+	// replaced in tests.
+	now func() time.Time
+}
+
+// NewKeySet creates a key set from keys, which must have distinct IDs.
+func NewKeySet(keys []Key) (*KeySet, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("auth: a key set needs at least one key")
+	}
+
+	ids := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if key.Provider == nil {
+			return nil, errors.New("auth: key " + key.ID + " has no provider")
+		}
+		if ids[key.ID] {
+			return nil, errors.New("auth: duplicate key " + key.ID)
+		}
+		ids[key.ID] = true
+	}
+
+	return &KeySet{keys: keys, now: time.Now}, nil
+}
+
+// current returns the key used to authenticate requests: the last key
+// within its validity window.
+func (ks *KeySet) current() (*Key, error) {
+	now := ks.now()
+	for i := len(ks.keys) - 1; i >= 0; i-- {
+		if ks.keys[i].valid(now) {
+			return &ks.keys[i], nil
+		}
+	}
+	return nil, errors.New("auth: no key is currently valid")
+}
+
+// Token generates a token for the request with the current key.
+func (ks *KeySet) Token(req []byte) ([]byte, error) {
+	key, err := ks.current()
+	if err != nil {
+		return nil, err
+	}
+	return key.Provider.Token(req)
+}
+
+// Authenticate authenticates the request with the current key. Unless
+// the key's provider sets a key ID of its own, the request's key ID is
+// set to the key's ID, as a hint for the server.
+func (ks *KeySet) Authenticate(aReq *AuthenticatedRequest) (err error) {
+	key, err := ks.current()
+	if err != nil {
+		return err
+	}
+
+	if rp, ok := key.Provider.(RequestProvider); ok {
+		err = rp.Authenticate(aReq)
+	} else {
+		aReq.Token, err = key.Provider.Token(aReq.Request)
+	}
+	if err != nil {
+		return err
+	}
+
+	if aReq.KeyID == "" {
+		aReq.KeyID = key.ID
+	}
+	return nil
+}
+
+// Verify determines whether any key within its validity window
+// verifies the request, trying the key named by the request's key ID
+// first, and logs the key that did.
+func (ks *KeySet) Verify(aReq *AuthenticatedRequest) bool {
+	if aReq == nil {
+		return false
+	}
+
+	now := ks.now()
+	var keys []*Key
+	for i := range ks.keys {
+		key := &ks.keys[i]
+		if !key.valid(now) {
+			continue
+		}
+		if key.ID == aReq.KeyID {
+			keys = append([]*Key{key}, keys...)
+		} else {
+			keys = append(keys, key)
+		}
+	}
+
+	for _, key := range keys {
+		if key.Provider.Verify(aReq) {
+			log.Infof("request authenticated with key %s", key.ID)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewKeySetErrors(t *testing.T) {
+	p, _ := New(testKey, nil)
+	for _, keys := range [][]Key{
+		nil,
+		{{ID: "a"}},
+		{{ID: "a", Provider: p}, {ID: "a", Provider: p}},
+	} {
+		if _, err := NewKeySet(keys); err == nil {
+			t.Fatalf("expected an error for key set %+v", keys)
+		}
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	oldKey, _ := New("00112233445566778899AABBCCDDEEFF", nil)
+	newKey, _ := New(testKey, nil)
+
+	now := time.Now()
+	rotation := now.Add(time.Hour)
+
+	server, err := NewKeySet([]Key{
+		{ID: "old", Provider: oldKey, NotAfter: rotation.Add(time.Hour)},
+		{ID: "new", Provider: newKey, NotBefore: now},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.now = func() time.Time { return now }
+
+	client, _ := NewKeySet([]Key{
+		{ID: "old", Provider: oldKey},
+		{ID: "new", Provider: newKey, NotBefore: rotation},
+	})
+
+	authenticate := func(at time.Time) *AuthenticatedRequest {
+		client.now = func() time.Time { return at }
+		aReq := &AuthenticatedRequest{Request: []byte(`testing 1 2 3`)}
+		if err := client.Authenticate(aReq); err != nil {
+			t.Fatal(err)
+		}
+		return aReq
+	}
+
+	// Before the rotation, the client uses the old key, which the
+	// server still accepts.
+	aReq := authenticate(now)
+	if aReq.KeyID != "old" || !server.Verify(aReq) {
+		t.Fatalf("request with key %s not verified before the rotation", aReq.KeyID)
+	}
+
+	// After it, the client switches to the new key.
+	aReq = authenticate(rotation)
+	if aReq.KeyID != "new" || !server.Verify(aReq) {
+		t.Fatalf("request with key %s not verified after the rotation", aReq.KeyID)
+	}
+
+	// The key ID is only a hint: a wrong one does not stop the right
+	// key from being found.
+	aReq.KeyID = "old"
+	if !server.Verify(aReq) {
+		t.Fatal("request with a wrong key ID hint not verified")
+	}
+
+	// Once the old key's window closes, it is no longer accepted.
+	aReq = authenticate(now)
+	server.now = func() time.Time { return rotation.Add(2 * time.Hour) }
+	if server.Verify(aReq) {
+		t.Fatal("request with an expired key was verified")
+	}
+
+	// Without a valid key, nothing can be authenticated.
+	expired, _ := NewKeySet([]Key{{ID: "old", Provider: oldKey, NotAfter: now.Add(-time.Hour)}})
+	if _, err = expired.Token([]byte(`testing 1 2 3`)); err == nil {
+		t.Fatal("expected an error without a valid key")
+	}
+}
+
+func TestNeedsRemoteAddress(t *testing.T) {
+	standard, _ := New(testKey, nil)
+	ip, _ := NewIP(testKey)
+	ks, _ := NewKeySet([]Key{{ID: "ip", Provider: ip}})
+
+	if NeedsRemoteAddress(standard) || !NeedsRemoteAddress(ip) || !NeedsRemoteAddress(ks) {
+		t.Fatal("wrong remote address requirements")
+	}
+}
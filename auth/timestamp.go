@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSkew is the default window, on either side of the
+	// server's clock, within which a timestamped request must fall.
+	DefaultSkew = 5 * time.Minute
+
+	// DefaultReplayCacheSize is the default number of nonces
+	// remembered to reject replayed requests. Once that many
+	// requests have been verified within the skew window, further
+	// requests are rejected until the oldest expire.
+	DefaultReplayCacheSize = 65536
+
+	// nonceSize is the size of the random nonce of a timestamped
+	// request.
+	nonceSize = 16
+)
+
+// StandardTS implements an HMAC-SHA-256 authentication provider whose
+// tokens cover the timestamp of the request and a random nonce as
+// well as the request. Requests whose timestamps are further than the
+// skew from the server's clock are rejected, as are requests already
+// seen within the skew window, so that a captured request cannot be
+// replayed.
+type StandardTS struct {
+	key    []byte
+	replay *replayCache
+}
+
+// NewTS generates a new timestamped authentication provider from the
+// hex-encoded key. Requests must be timestamped within skew of the
+// server's clock, and up to cacheSize nonces are remembered to reject
+// replays, so at most cacheSize requests are verified within a skew
+// window; zero values select DefaultSkew and DefaultReplayCacheSize.
+func NewTS(key string, skew time.Duration, cacheSize int) (*StandardTS, error) {
+	keyBytes, err := hex.DecodeString(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StandardTS{
+		key:    keyBytes,
+		replay: newReplayCache(skew, cacheSize),
+	}, nil
+}
+
+// Token always fails, as the token depends on the timestamp and nonce
+// of the request; use Authenticate instead.
+func (p *StandardTS) Token(req []byte) ([]byte, error) {
+	return nil, errNeedsAuthenticate
+}
+
+// token computes the HMAC of the timestamp, nonce and request. The
+// timestamp and nonce have fixed sizes, so the message is unambiguous.
+func (p *StandardTS) token(aReq *AuthenticatedRequest) []byte {
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(aReq.Timestamp))
+
+	h := hmac.New(sha256.New, p.key)
+	h.Write(ts[:])
+	h.Write(aReq.Nonce)
+	h.Write(aReq.Request)
+	return h.Sum(nil)
+}
+
+// Authenticate timestamps the request with the current time, gives it
+// a random nonce and sets its token.
+func (p *StandardTS) Authenticate(aReq *AuthenticatedRequest) error {
+	if err := p.replay.stamp(aReq); err != nil {
+		return err
+	}
+
+	aReq.Token = p.token(aReq)
+	return nil
+}
+
+// Verify determines whether an authenticated request is valid, was
+// timestamped within the skew window, and has not been seen before.
+func (p *StandardTS) Verify(aReq *AuthenticatedRequest) bool {
+	if !p.replay.fresh(aReq) {
+		return false
+	}
+
+	token := p.token(aReq)
+	if len(aReq.Token) != len(token) || !hmac.Equal(token, aReq.Token) {
+		return false
+	}
+
+	return p.replay.remember(aReq)
+}
+
+// A replayCache rejects requests that are stale or have been seen
+// before. Requests carry a timestamp, which must be within the skew of
+// the clock, and a random nonce; the nonces of requests seen within
+// the skew window are remembered.
+type replayCache struct {
+	skew time.Duration
+
+	// now is the clock used to check timestamps; it is replaced in
+	// tests.
+	now func() time.Time
+
+	lock   sync.Mutex
+	seen   map[string]bool
+	queue  []seenNonce
+	maxLen int
+}
+
+// A seenNonce records when a verified request was timestamped.
+type seenNonce struct {
+	nonce     string
+	timestamp int64
+}
+
+// newReplayCache creates a replay cache; zero values select
+// DefaultSkew and DefaultReplayCacheSize.
+func newReplayCache(skew time.Duration, size int) *replayCache {
+	if skew <= 0 {
+		skew = DefaultSkew
+	}
+	if size <= 0 {
+		size = DefaultReplayCacheSize
+	}
+
+	return &replayCache{
+		skew:   skew,
+		now:    time.Now,
+		seen:   make(map[string]bool),
+		maxLen: size,
+	}
+}
+
+// stamp sets the timestamp of a request to the current time and gives
+// it a random nonce.
+func (c *replayCache) stamp(aReq *AuthenticatedRequest) error {
+	aReq.Timestamp = c.now().Unix()
+	aReq.Nonce = make([]byte, nonceSize)
+	_, err := rand.Read(aReq.Nonce)
+	return err
+}
+
+// fresh reports whether a request has a nonce and was timestamped
+// within the skew window.
+func (c *replayCache) fresh(aReq *AuthenticatedRequest) bool {
+	if aReq == nil || len(aReq.Nonce) != nonceSize {
+		return false
+	}
+
+	now := c.now()
+	ts := time.Unix(aReq.Timestamp, 0)
+	return !ts.Before(now.Add(-c.skew)) && !ts.After(now.Add(c.skew))
+}
+
+// remember records the nonce of a verified request, returning false
+// if it has been seen already. The nonce is used rather than the
+// token, as some signatures can be altered without invalidating them.
+// Nonces are forgotten once their timestamps fall out of the skew
+// window, as they are rejected from then on anyway. Nonces still
+// inside the window are never forgotten, as the request could then be
+// replayed; if the cache is full of them, new requests are rejected
+// until some expire.
+func (c *replayCache) remember(aReq *AuthenticatedRequest) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	expired := c.now().Add(-c.skew).Unix()
+	for len(c.queue) > 0 && c.queue[0].timestamp < expired {
+		delete(c.seen, c.queue[0].nonce)
+		c.queue = c.queue[1:]
+	}
+
+	// Only the nonce is used: it is covered by every provider's
+	// token, whereas the key ID is not.
+	nonce := string(aReq.Nonce)
+	if c.seen[nonce] {
+		return false
+	}
+
+	if len(c.queue) >= c.maxLen {
+		// The queue is in the order requests were verified,
+		// which may differ from the order of their timestamps,
+		// so expired nonces may remain behind the first.
+		c.compact(expired)
+		if len(c.queue) >= c.maxLen {
+			return false
+		}
+	}
+
+	c.seen[nonce] = true
+	c.queue = append(c.queue, seenNonce{nonce, aReq.Timestamp})
+	return true
+}
+
+// compact forgets every nonce timestamped before expired.
+func (c *replayCache) compact(expired int64) {
+	queue := make([]seenNonce, 0, len(c.queue))
+	for _, n := range c.queue {
+		if n.timestamp < expired {
+			delete(c.seen, n.nonce)
+			continue
+		}
+		queue = append(queue, n)
+	}
+	c.queue = queue
+}
+
+// ShareReplayCache makes to use the replay cache of from, so that a
+// provider rebuilt when its configuration is reloaded still rejects
+// the requests its predecessor accepted. Within key sets, the caches
+// of keys with the same ID are shared. Providers of differing types or
+// replay windows, and those without replay protection, are left alone.
+func ShareReplayCache(from, to Provider) {
+	switch to := to.(type) {
+	case *StandardTS:
+		if from, ok := from.(*StandardTS); ok {
+			to.replay = to.replay.share(from.replay)
+		}
+	case *Asymmetric:
+		if from, ok := from.(*Asymmetric); ok {
+			to.replay = to.replay.share(from.replay)
+		}
+	case *KeySet:
+		from, ok := from.(*KeySet)
+		if !ok {
+			return
+		}
+		for i := range to.keys {
+			for _, key := range from.keys {
+				if key.ID == to.keys[i].ID {
+					ShareReplayCache(key.Provider, to.keys[i].Provider)
+				}
+			}
+		}
+	}
+}
+
+// share returns prev if it has the same skew and size as c, and c
+// otherwise.
+func (c *replayCache) share(prev *replayCache) *replayCache {
+	if prev == nil || prev.skew != c.skew || prev.maxLen != c.maxLen {
+		return c
+	}
+	return prev
+}
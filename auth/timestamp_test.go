@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStandardTS(t *testing.T) {
+	if _, err := NewTS("ABC", 0, 0); err == nil {
+		t.Fatal("expected failure with improperly-hex-encoded key")
+	}
+
+	p, err := NewTS(testKey, time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = p.Token([]byte(`testing 1 2 3`)); err == nil {
+		t.Fatal("Token should not be usable with a timestamped provider")
+	}
+
+	aReq := &AuthenticatedRequest{Request: []byte(`testing 1 2 3`)}
+	if err = p.Authenticate(aReq); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Verify(aReq) {
+		t.Fatal("failed to verify a timestamped request")
+	}
+	if p.Verify(aReq) {
+		t.Fatal("replayed request was verified")
+	}
+	aReq.KeyID = "other"
+	if p.Verify(aReq) {
+		t.Fatal("replayed request with another key ID was verified")
+	}
+
+	// The timestamp and nonce are covered by the token.
+	for _, tamper := range []func(*AuthenticatedRequest){
+		func(r *AuthenticatedRequest) { r.Timestamp++ },
+		func(r *AuthenticatedRequest) { r.Nonce[0] ^= 1 },
+		func(r *AuthenticatedRequest) { r.Request = []byte(`testing 3 2 1`) },
+		func(r *AuthenticatedRequest) { r.Nonce = nil },
+	} {
+		aReq = &AuthenticatedRequest{Request: []byte(`testing 1 2 3`)}
+		p.Authenticate(aReq)
+		tamper(aReq)
+		if p.Verify(aReq) {
+			t.Fatal("tampered request was verified")
+		}
+	}
+}
+
+func TestStandardTSSkew(t *testing.T) {
+	p, err := NewTS(testKey, time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for offset, ok := range map[time.Duration]bool{
+		-2 * time.Minute:  false,
+		-30 * time.Second: true,
+		30 * time.Second:  true,
+		2 * time.Minute:   false,
+	} {
+		p.replay.now = func() time.Time { return now.Add(offset) }
+		aReq := &AuthenticatedRequest{Request: []byte(`testing 1 2 3`)}
+		p.Authenticate(aReq)
+
+		p.replay.now = func() time.Time { return now }
+		if p.Verify(aReq) != ok {
+			t.Fatalf("request timestamped %v from now: expected verification to be %v", offset, ok)
+		}
+	}
+}
+
+func TestStandardTSReplayCache(t *testing.T) {
+	p, err := NewTS(testKey, time.Minute, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	p.replay.now = func() time.Time { return now }
+
+	var reqs []*AuthenticatedRequest
+	for i := 0; i < 2; i++ {
+		aReq := &AuthenticatedRequest{Request: []byte(`testing 1 2 3`)}
+		p.Authenticate(aReq)
+		if !p.Verify(aReq) {
+			t.Fatal("failed to verify a timestamped request")
+		}
+		reqs = append(reqs, aReq)
+	}
+
+	// A full cache rejects new requests rather than forgetting
+	// nonces that could still be replayed.
+	aReq := &AuthenticatedRequest{Request: []byte(`testing 1 2 3`)}
+	p.Authenticate(aReq)
+	if p.Verify(aReq) {
+		t.Fatal("request was verified with a full replay cache")
+	}
+	if len(p.replay.queue) != 2 || len(p.replay.seen) != 2 {
+		t.Fatalf("replay cache grew past its size: %d", len(p.replay.queue))
+	}
+	for _, aReq := range reqs {
+		if p.Verify(aReq) {
+			t.Fatal("replayed request was verified")
+		}
+	}
+
+	// Tokens are forgotten once they fall out of the skew window.
+	p.replay.now = func() time.Time { return now.Add(2 * time.Minute) }
+	aReq = &AuthenticatedRequest{Request: []byte(`testing 1 2 3`)}
+	p.Authenticate(aReq)
+	if !p.Verify(aReq) || len(p.replay.queue) != 1 {
+		t.Fatalf("expired tokens were not forgotten: %d remain", len(p.replay.queue))
+	}
+
+	// Expired tokens behind a newer one are forgotten when the
+	// cache fills.
+	p.replay = newReplayCache(time.Minute, 2)
+	for _, offset := range []time.Duration{time.Minute, -30 * time.Second} {
+		p.replay.now = func() time.Time { return now.Add(offset) }
+		aReq = &AuthenticatedRequest{Request: []byte(`testing 1 2 3`)}
+		p.Authenticate(aReq)
+		p.replay.now = func() time.Time { return now }
+		if !p.Verify(aReq) {
+			t.Fatal("failed to verify a timestamped request")
+		}
+	}
+	p.replay.now = func() time.Time { return now.Add(45 * time.Second) }
+	aReq = &AuthenticatedRequest{Request: []byte(`testing 1 2 3`)}
+	p.Authenticate(aReq)
+	if !p.Verify(aReq) || len(p.replay.queue) != 2 {
+		t.Fatalf("expired token kept a full cache from verifying a request: %d remain", len(p.replay.queue))
+	}
+}
+
+func TestShareReplayCache(t *testing.T) {
+	prev, _ := NewTS(testKey, 0, 0)
+	aReq := &AuthenticatedRequest{Request: []byte(`testing 1 2 3`)}
+	prev.Authenticate(aReq)
+	if !prev.Verify(aReq) {
+		t.Fatal("failed to verify a timestamped request")
+	}
+
+	p, _ := NewTS(testKey, 0, 0)
+	ShareReplayCache(prev, p)
+	if p.Verify(aReq) {
+		t.Fatal("replayed request was verified by a provider sharing the cache")
+	}
+
+	// Caches with another replay window are not shared.
+	p, _ = NewTS(testKey, time.Minute, 0)
+	ShareReplayCache(prev, p)
+	if !p.Verify(aReq) || p.replay == prev.replay {
+		t.Fatal("replay cache with another skew was shared")
+	}
+}
+
+func TestStandardIP(t *testing.T) {
+	if _, err := NewIP("ABC"); err == nil {
+		t.Fatal("expected failure with improperly-hex-encoded key")
+	}
+
+	p, err := NewIP(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = p.Token([]byte(`testing 1 2 3`)); err == nil {
+		t.Fatal("Token should not be usable with an IP-bound provider")
+	}
+
+	aReq := &AuthenticatedRequest{Request: []byte(`testing 1 2 3`)}
+	if err = p.Authenticate(aReq); err == nil {
+		t.Fatal("authenticated a request without a remote address")
+	}
+
+	aReq.RemoteAddress = net.ParseIP("1.2.3.4").To4()
+	if err = p.Authenticate(aReq); err != nil {
+		t.Fatal(err)
+	}
+
+	// The 4- and 16-byte forms of an address are equivalent.
+	aReq.RemoteAddress = net.ParseIP("1.2.3.4")
+	if !p.Verify(aReq) {
+		t.Fatal("failed to verify an IP-bound request")
+	}
+
+	aReq.RemoteAddress = net.ParseIP("1.2.3.5")
+	if p.Verify(aReq) {
+		t.Fatal("request from another address was verified")
+	}
+}
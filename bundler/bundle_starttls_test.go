@@ -0,0 +1,105 @@
+package bundler
+
+// This test file contains tests on bundling from remote servers that
+// need STARTTLS, using a local stub server.
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// newPOP3Server starts a POP3 server that accepts STLS, serving a
+// certificate for 127.0.0.1, and returns it with the PEM-encoded CA that
+// issued the certificate.
+func newPOP3Server(t *testing.T) (net.Listener, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "STARTTLS Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				io.WriteString(conn, "+OK POP3 ready\r\n")
+				if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "STLS\r\n" {
+					return
+				}
+				io.WriteString(conn, "+OK begin TLS\r\n")
+				tls.Server(conn, config).Handshake()
+			}()
+		}
+	}()
+	return l, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+}
+
+func TestBundleFromRemoteStartTLS(t *testing.T) {
+	l, caPEM := newPOP3Server(t)
+	defer l.Close()
+
+	b := newBundlerFromPEM(t, caPEM, caPEM)
+	bundle, err := b.BundleFromRemoteStartTLS(l.Addr().String(), "", "pop3", Force)
+	if err != nil {
+		t.Fatalf("expected no error. but an error occurred: %v", err)
+	}
+	if bundle.Cert.Subject.CommonName != "127.0.0.1" {
+		t.Errorf("expected the stub server's certificate. Got %s", bundle.Cert.Subject.CommonName)
+	}
+
+	// Without STARTTLS, the handshake fails.
+	if _, err = b.BundleFromRemote(l.Addr().String(), "", Force); err == nil {
+		t.Error("expected an error bundling without STARTTLS")
+	}
+
+	if _, err = b.BundleFromRemoteStartTLS(l.Addr().String(), "", "gopher", Force); err == nil {
+		t.Error("expected an error for an unknown protocol")
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/bbandix/cfssl/errors"
 	"github.com/bbandix/cfssl/helpers"
 	"github.com/bbandix/cfssl/log"
+	"github.com/bbandix/cfssl/starttls"
 	"github.com/bbandix/cfssl/ubiquity"
 )
 
@@ -241,6 +242,26 @@ func (b *Bundler) BundleFromPEMorDER(certsRaw, keyPEM []byte, flavor BundleFlavo
 // port 443. The certificate used by the server in this connection is
 // used to build the bundle, which will necessarily be keyless.
 func (b *Bundler) BundleFromRemote(serverName, ip string, flavor BundleFlavor) (*Bundle, error) {
+	return b.BundleFromRemoteStartTLS(serverName, ip, "", flavor)
+}
+
+// BundleFromRemoteStartTLS is like BundleFromRemote, but if protocol
+// is not empty it negotiates STARTTLS with the server using protocol,
+// one of those supported by the starttls package, and connects to the
+// protocol's usual port instead of 443. In either case, serverName may
+// be followed by a port to connect to.
+func (b *Bundler) BundleFromRemoteStartTLS(serverName, ip, protocol string, flavor BundleFlavor) (*Bundle, error) {
+	port := "443"
+	if protocol != "" {
+		var err error
+		if port, err = starttls.Port(protocol); err != nil {
+			return nil, errors.Wrap(errors.DialError, errors.Unknown, err)
+		}
+	}
+	if host, p, err := net.SplitHostPort(serverName); err == nil {
+		serverName, port = host, p
+	}
+
 	config := &tls.Config{
 		RootCAs:    b.RootPool,
 		ServerName: serverName,
@@ -249,15 +270,15 @@ func (b *Bundler) BundleFromRemote(serverName, ip string, flavor BundleFlavor) (
 	// Dial by IP if present
 	var dialName string
 	if ip != "" {
-		dialName = ip + ":443"
+		dialName = net.JoinHostPort(ip, port)
 	} else {
-		dialName = serverName + ":443"
+		dialName = net.JoinHostPort(serverName, port)
 	}
 
 	log.Debugf("bundling from remote %s", dialName)
 
 	dialer := &net.Dialer{Timeout: time.Duration(5) * time.Second}
-	conn, err := tls.DialWithDialer(dialer, "tcp", dialName, config)
+	conn, err := dialRemote(dialer, dialName, protocol, config)
 	var dialError string
 	// If there's an error in tls.Dial, try again with
 	// InsecureSkipVerify to fetch the remote bundle to (re-)bundle
@@ -271,7 +292,7 @@ func (b *Bundler) BundleFromRemote(serverName, ip string, flavor BundleFlavor) (
 		// dial again with InsecureSkipVerify
 		log.Debugf("try again with InsecureSkipVerify.")
 		config.InsecureSkipVerify = true
-		conn, err = tls.DialWithDialer(dialer, "tcp", dialName, config)
+		conn, err = dialRemote(dialer, dialName, protocol, config)
 		if err != nil {
 			log.Debugf("dial with InsecureSkipVerify failed: %v", err)
 			return nil, errors.Wrap(errors.DialError, errors.Unknown, err)
@@ -298,6 +319,33 @@ func (b *Bundler) BundleFromRemote(serverName, ip string, flavor BundleFlavor) (
 	return bundle, err
 }
 
+// dialRemote connects to addr, negotiates STARTTLS using protocol if it
+// is not empty, and performs a TLS handshake, all within the dialer's
+// timeout.
+func dialRemote(dialer *net.Dialer, addr, protocol string, config *tls.Config) (*tls.Conn, error) {
+	if protocol == "" {
+		return tls.DialWithDialer(dialer, "tcp", addr, config)
+	}
+
+	rawConn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	rawConn.SetDeadline(time.Now().Add(dialer.Timeout))
+	if err = starttls.Negotiate(rawConn, protocol, config.ServerName); err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+
+	conn := tls.Client(rawConn, config)
+	if err = conn.Handshake(); err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+	rawConn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
 type fetchedIntermediate struct {
 	Cert *x509.Certificate
 	Name string
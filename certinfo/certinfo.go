@@ -0,0 +1,303 @@
+// Package certinfo extracts a summary of the fields of certificates
+// that matter for inventory and expiry monitoring, from files,
+// directory trees and TLS endpoints.
+package certinfo
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	cferr "github.com/bbandix/cfssl/errors"
+	"github.com/bbandix/cfssl/helpers"
+	"github.com/bbandix/cfssl/log"
+)
+
+// A Name is the subject or issuer of a certificate.
+type Name struct {
+	CommonName         string `json:"common_name,omitempty"`
+	SerialNumber       string `json:"serial_number,omitempty"`
+	Country            string `json:"country,omitempty"`
+	Organization       string `json:"organization,omitempty"`
+	OrganizationalUnit string `json:"organizational_unit,omitempty"`
+	Locality           string `json:"locality,omitempty"`
+	Province           string `json:"province,omitempty"`
+}
+
+func first(s []string) string {
+	if len(s) == 0 {
+		return ""
+	}
+	return s[0]
+}
+
+// ParseName summarises a pkix.Name.
+func ParseName(name pkix.Name) Name {
+	return Name{
+		CommonName:         name.CommonName,
+		SerialNumber:       name.SerialNumber,
+		Country:            first(name.Country),
+		Organization:       first(name.Organization),
+		OrganizationalUnit: first(name.OrganizationalUnit),
+		Locality:           first(name.Locality),
+		Province:           first(name.Province),
+	}
+}
+
+// String returns the name in the "/CN=.../O=..." form used by
+// OpenSSL.
+func (n Name) String() string {
+	var buf bytes.Buffer
+	for _, attr := range []struct{ key, value string }{
+		{"C", n.Country},
+		{"ST", n.Province},
+		{"L", n.Locality},
+		{"O", n.Organization},
+		{"OU", n.OrganizationalUnit},
+		{"CN", n.CommonName},
+		{"serialNumber", n.SerialNumber},
+	} {
+		if attr.value != "" {
+			fmt.Fprintf(&buf, "/%s=%s", attr.key, attr.value)
+		}
+	}
+	return buf.String()
+}
+
+// A Certificate is the summary of a certificate. Source records where
+// the certificate was found: a file path or a TLS endpoint.
+type Certificate struct {
+	Source             string    `json:"source"`
+	Subject            Name      `json:"subject"`
+	Issuer             Name      `json:"issuer"`
+	SerialNumber       string    `json:"serial_number"`
+	SANs               []string  `json:"sans"`
+	NotBefore          time.Time `json:"not_before"`
+	NotAfter           time.Time `json:"not_after"`
+	DaysRemaining      int       `json:"days_remaining"`
+	KeyAlgorithm       string    `json:"key_algorithm"`
+	SignatureAlgorithm string    `json:"sigalg"`
+	SubjectKeyID       string    `json:"subject_key_id,omitempty"`
+	AuthorityKeyID     string    `json:"authority_key_id,omitempty"`
+	IsCA               bool      `json:"is_ca"`
+}
+
+// keyAlgorithm describes the public key of cert in the form used by
+// the bundler, e.g. "2048-bit RSA".
+func keyAlgorithm(cert *x509.Certificate) string {
+	keyLength := helpers.KeyLength(cert.PublicKey)
+	switch cert.PublicKeyAlgorithm {
+	case x509.ECDSA:
+		return fmt.Sprintf("%d-bit ECDSA", keyLength)
+	case x509.RSA:
+		return fmt.Sprintf("%d-bit RSA", keyLength)
+	case x509.DSA:
+		return "DSA"
+	default:
+		return "Unknown"
+	}
+}
+
+// daysRemaining returns the number of whole days until notAfter,
+// which is negative once notAfter has passed.
+func daysRemaining(notAfter, now time.Time) int {
+	d := notAfter.Sub(now)
+	days := int(d / helpers.OneDay)
+	if d < 0 && d%helpers.OneDay != 0 {
+		days--
+	}
+	return days
+}
+
+// ParseCertificate summarises cert.
+func ParseCertificate(cert *x509.Certificate) *Certificate {
+	sans := make([]string, 0, len(cert.DNSNames)+len(cert.IPAddresses)+len(cert.EmailAddresses))
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+
+	c := &Certificate{
+		Subject:            ParseName(cert.Subject),
+		Issuer:             ParseName(cert.Issuer),
+		SerialNumber:       cert.SerialNumber.String(),
+		SANs:               sans,
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		DaysRemaining:      daysRemaining(cert.NotAfter, time.Now()),
+		KeyAlgorithm:       keyAlgorithm(cert),
+		SignatureAlgorithm: helpers.SignatureString(cert.SignatureAlgorithm),
+		IsCA:               cert.IsCA,
+	}
+	if len(cert.SubjectKeyId) > 0 {
+		c.SubjectKeyID = fmt.Sprintf("%X", cert.SubjectKeyId)
+	}
+	if len(cert.AuthorityKeyId) > 0 {
+		c.AuthorityKeyID = fmt.Sprintf("%X", cert.AuthorityKeyId)
+	}
+	return c
+}
+
+// parseCertificates parses the certificates in data, which may hold
+// PEM-encoded certificates and PKCS #7 structures, or a single DER
+// certificate, PKCS #7 or PKCS #12 structure. Other PEM blocks, such
+// as private keys, are skipped.
+func parseCertificates(data []byte, password string) ([]*x509.Certificate, error) {
+	data = bytes.TrimSpace(data)
+	if !bytes.HasPrefix(data, []byte("-----BEGIN")) {
+		certs, _, err := helpers.ParseCertificatesDER(data, password)
+		return certs, err
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case "CERTIFICATE", "TRUSTED CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, err)
+			}
+			certs = append(certs, cert)
+		case "PKCS7", "CERTIFICATE CHAIN":
+			p7certs, _, err := helpers.ParseCertificatesDER(block.Bytes, "")
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, p7certs...)
+		}
+	}
+	return certs, nil
+}
+
+// Parse summarises the certificates in data, recording source as
+// their origin.
+func Parse(source string, data []byte, password string) ([]*Certificate, error) {
+	certs, err := parseCertificates(data, password)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*Certificate, 0, len(certs))
+	for _, cert := range certs {
+		info := ParseCertificate(cert)
+		info.Source = source
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// ParseFile summarises the certificates in the file at path; see
+// Parse.
+func ParseFile(path, password string) ([]*Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(path, data, password)
+}
+
+// certExtensions are the file extensions that ParseDirectory looks
+// at.
+var certExtensions = map[string]bool{
+	".pem": true,
+	".crt": true,
+	".cer": true,
+	".der": true,
+	".p7b": true,
+	".p7c": true,
+	".p12": true,
+	".pfx": true,
+}
+
+// ParseDirectory summarises the certificates in the files under dir
+// that have a certificate file extension. Files that cannot be parsed
+// are logged and skipped.
+func ParseDirectory(dir, password string) ([]*Certificate, error) {
+	var infos []*Certificate
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || !certExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+
+		certs, err := ParseFile(path, password)
+		if err != nil {
+			log.Warningf("skipping %s: %v", path, err)
+			return nil
+		}
+		infos = append(infos, certs...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// DefaultEndpointTimeout is the time ParseEndpoint allows for
+// connecting and completing the TLS handshake when given no timeout.
+var DefaultEndpointTimeout = 10 * time.Second
+
+// ParseEndpoint connects to the TLS server at addr ("host:port", with
+// the port defaulting to 443) and summarises the certificates it
+// presents. The connection and the handshake must complete within
+// timeout, or DefaultEndpointTimeout if it is zero. The chain is not
+// verified: expired and untrusted certificates are exactly what an
+// inventory needs to find.
+func ParseEndpoint(addr string, timeout time.Duration) ([]*Certificate, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, "443"
+	}
+	addr = net.JoinHostPort(host, port)
+
+	if timeout <= 0 {
+		timeout = DefaultEndpointTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	rawConn, err := (&net.Dialer{Deadline: deadline}).Dial("tcp", addr)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.Unknown, err)
+	}
+	conn := tls.Client(rawConn, &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: true,
+	})
+	defer conn.Close()
+
+	// A server that accepts the connection but never answers the
+	// handshake would otherwise hang the inventory.
+	if err = conn.SetDeadline(deadline); err == nil {
+		err = conn.Handshake()
+	}
+	if err != nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.Unknown, err)
+	}
+
+	var infos []*Certificate
+	for _, cert := range conn.ConnectionState().PeerCertificates {
+		info := ParseCertificate(cert)
+		info.Source = addr
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
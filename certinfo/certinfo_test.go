@@ -0,0 +1,187 @@
+package certinfo
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bbandix/cfssl/helpers"
+)
+
+const (
+	testCertFile        = "../api/testdata/ca.pem"
+	testBundleFile      = "../helpers/testdata/bundle.pem"
+	testBundlePKCS7File = "../helpers/testdata/bundle_pkcs7.pem"
+	testPKCS12File      = "../helpers/testdata/emptypasswordpkcs12.p12"
+	testKeyFile         = "../helpers/testdata/priv_rsa_key.pem"
+)
+
+func TestParseFile(t *testing.T) {
+	certs, err := ParseFile(testCertFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 {
+		t.Fatalf("expected 1 certificate, got %d", len(certs))
+	}
+
+	data, _ := ioutil.ReadFile(testCertFile)
+	cert, err := helpers.ParseCertificatePEM(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := certs[0]
+	if info.Source != testCertFile {
+		t.Fatalf("bad source %s", info.Source)
+	}
+	if info.Subject.CommonName != cert.Subject.CommonName || info.SerialNumber != cert.SerialNumber.String() {
+		t.Fatalf("certificate summary mismatch: %+v", info)
+	}
+	if !info.NotAfter.Equal(cert.NotAfter) || info.KeyAlgorithm == "" {
+		t.Fatalf("certificate summary mismatch: %+v", info)
+	}
+
+	derFile, err := ioutil.TempFile("", "cfssl-certinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(derFile.Name())
+	derFile.Write(cert.Raw)
+	derFile.Close()
+
+	der, err := ParseFile(derFile.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(der) != 1 || der[0].SerialNumber != info.SerialNumber {
+		t.Fatal("DER certificate summary mismatch")
+	}
+}
+
+func TestParseFileFormats(t *testing.T) {
+	bundle, err := ParseFile(testBundleFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bundle) < 2 {
+		t.Fatalf("expected a chain, got %d certificates", len(bundle))
+	}
+
+	pkcs7, err := ParseFile(testBundlePKCS7File, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkcs7) != len(bundle) {
+		t.Fatalf("expected %d certificates from PKCS #7, got %d", len(bundle), len(pkcs7))
+	}
+
+	pkcs12, err := ParseFile(testPKCS12File, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkcs12) == 0 {
+		t.Fatal("expected certificates from PKCS #12")
+	}
+
+	// Files with only a key hold no certificates.
+	keys, err := ParseFile(testKeyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Fatal("found certificates in a key file")
+	}
+}
+
+func TestParseDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cfssl-certinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data, err := ioutil.ReadFile(testCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "sub", "a.crt"), data, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "b.pem"), data, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "broken.pem"), []byte("-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt"), data, 0644)
+
+	certs, err := ParseDirectory(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 {
+		t.Fatalf("expected 2 certificates, got %d", len(certs))
+	}
+}
+
+func TestParseEndpoint(t *testing.T) {
+	ts := httptest.NewTLSServer(nil)
+	defer ts.Close()
+
+	certs, err := ParseEndpoint(strings.TrimPrefix(ts.URL, "https://"), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) == 0 || certs[0].DaysRemaining <= 0 {
+		t.Fatalf("bad endpoint certificates: %+v", certs)
+	}
+}
+
+func TestParseEndpointTimeout(t *testing.T) {
+	// A server that accepts connections but never completes a
+	// handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	defer func(d time.Duration) { DefaultEndpointTimeout = d }(DefaultEndpointTimeout)
+	DefaultEndpointTimeout = 100 * time.Millisecond
+	for _, timeout := range []time.Duration{0, 100 * time.Millisecond} {
+		start := time.Now()
+		if _, err = ParseEndpoint(l.Addr().String(), timeout); err == nil {
+			t.Fatal("expected a stalled handshake to fail")
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("stalled handshake with timeout %v took %v", timeout, elapsed)
+		}
+	}
+}
+
+func TestDaysRemaining(t *testing.T) {
+	now := time.Now()
+	for d, days := range map[time.Duration]int{
+		36 * time.Hour:         1,
+		48 * time.Hour:         2,
+		time.Hour:              0,
+		-time.Hour:             -1,
+		-48 * time.Hour:        -2,
+		-48*time.Hour - 1:      -3,
+		helpers.OneYear + 3600: 365,
+	} {
+		if got := daysRemaining(now.Add(d), now); got != days {
+			t.Fatalf("daysRemaining(%v) = %d, want %d", d, got, days)
+		}
+	}
+}
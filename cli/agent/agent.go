@@ -0,0 +1,66 @@
+// Package agent implements the agent command.
+package agent
+
+import (
+	"errors"
+
+	"github.com/bbandix/cfssl/agent"
+	"github.com/bbandix/cfssl/api/client"
+	"github.com/bbandix/cfssl/cli"
+	"github.com/bbandix/cfssl/log"
+)
+
+var agentUsageText = `cfssl agent -- watch certificates and renew them through a remote CFSSL server
+
+Usage of agent:
+        cfssl agent [-remote remote_host] [-tls-remote-ca ca] [-mutual-tls-client-cert cert -mutual-tls-client-key key] AGENT_CONFIG
+
+Arguments:
+        AGENT_CONFIG: JSON file listing the certificates to watch; see doc/cmd/agent.txt.
+
+The remote given with -remote takes precedence over the one in AGENT_CONFIG.
+
+Flags:
+`
+
+var agentFlags = []string{"remote", "tls-remote-ca", "mutual-tls-client-cert", "mutual-tls-client-key"}
+
+func agentMain(args []string, c cli.Config) (err error) {
+	configFile, args, err := cli.PopFirstArgument(args)
+	if err != nil {
+		return
+	}
+
+	if len(args) > 0 {
+		return errors.New("only one argument is accepted, please check with usage")
+	}
+
+	cfg, err := agent.LoadFile(configFile)
+	if err != nil {
+		return
+	}
+
+	if c.Remote != "" {
+		cfg.Remote = c.Remote
+	}
+	if cfg.Remote == "" {
+		return errors.New("no remote given; set one with -remote or in the agent configuration")
+	}
+
+	tlsConfig, err := cli.RemoteTLSConfig(&c)
+	if err != nil {
+		return
+	}
+
+	remote := client.NewServerWithOptions(cfg.Remote, client.Options{TLSConfig: tlsConfig})
+	if remote == nil {
+		return errors.New("invalid remote " + cfg.Remote)
+	}
+
+	log.Infof("watching %d certificates, renewing through %s", len(cfg.Certificates), cfg.Remote)
+	agent.New(cfg, remote).Run(nil)
+	return nil
+}
+
+// Command assembles the definition of Command 'agent'
+var Command = &cli.Command{UsageText: agentUsageText, Flags: agentFlags, Main: agentMain}
@@ -0,0 +1,76 @@
+// Package audit implements the audit command.
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/bbandix/cfssl/audit"
+	"github.com/bbandix/cfssl/cli"
+)
+
+var auditUsageText = `cfssl audit -- check the audit log of CA operations
+
+Usage of audit:
+        cfssl audit verify -audit-key key FILE
+
+Arguments:
+        FILE:       audit log, as written with the -audit-log flag
+
+The verify subcommand checks that the records in the file form an unbroken
+hash chain keyed with the audit key, and exits with a non-zero status at the
+first record that has been modified, removed or inserted. It then checks the
+last record against the log's checkpoint, FILE.checkpoint, to detect records
+removed from the end of the file, and prints its sequence number and hash.
+
+Flags:
+`
+
+func auditMain(args []string, c cli.Config) error {
+	sub, args, err := cli.PopFirstArgument(args)
+	if err != nil {
+		return err
+	}
+	if sub != "verify" {
+		return errors.New("unknown subcommand " + sub)
+	}
+
+	path, _, err := cli.PopFirstArgument(args)
+	if err != nil {
+		return err
+	}
+
+	if c.AuditKey == "" {
+		return errors.New("need the audit key (provide one with -audit-key)")
+	}
+	key, err := audit.LoadKey(c.AuditKey)
+	if err != nil {
+		return err
+	}
+
+	cp, err := audit.ReadCheckpoint(path)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	last, err := audit.Verify(f, key, cp)
+	if err != nil {
+		return err
+	}
+	if last == nil {
+		fmt.Println("audit log is empty")
+		return nil
+	}
+	fmt.Printf("verified %d records; last hash %s\n", last.Seq, last.Hash)
+	return nil
+}
+
+// Command assembles the definition of Command 'audit'
+var Command = &cli.Command{UsageText: auditUsageText, Flags: []string{"audit-key"}, Main: auditMain}
@@ -17,13 +17,18 @@ Usage of bundle:
 	- Bundle local certificate files
         cfssl bundle -cert file [-ca-bundle file] [-int-bundle file] [-int-dir dir] [-metadata file] [-key keyfile] [-flavor optimal|ubiquitous|force] [-password password]
 	- Bundle certificate from remote server.
-        cfssl bundle -domain domain_name [-ip ip_address] [-ca-bundle file] [-int-bundle file] [-int-dir dir] [-metadata file]
+        cfssl bundle -domain domain_name [-ip ip_address] [-starttls protocol] [-ca-bundle file] [-int-bundle file] [-int-dir dir] [-metadata file]
+
+The domain name may be followed by a port. With -starttls, STARTTLS is
+negotiated with the given protocol (smtp, imap, pop3, ldap, xmpp, ftp or
+postgres) before the TLS handshake, on the protocol's usual port unless
+another is given.
 
 Flags:
 `
 
 // flags used by 'cfssl bundle'
-var bundlerFlags = []string{"cert", "key", "ca-bundle", "int-bundle", "flavor", "int-dir", "metadata", "domain", "ip", "password"}
+var bundlerFlags = []string{"cert", "key", "ca-bundle", "int-bundle", "flavor", "int-dir", "metadata", "domain", "ip", "starttls", "password"}
 
 // bundlerMain is the main CLI of bundler functionality.
 func bundlerMain(args []string, c cli.Config) (err error) {
@@ -68,7 +73,7 @@ func bundlerMain(args []string, c cli.Config) (err error) {
 			}
 		}
 	} else if c.Domain != "" {
-		bundle, err = b.BundleFromRemote(c.Domain, c.IP, flavor)
+		bundle, err = b.BundleFromRemoteStartTLS(c.Domain, c.IP, c.StartTLS, flavor)
 		if err != nil {
 			return
 		}
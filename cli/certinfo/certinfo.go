@@ -0,0 +1,130 @@
+// Package certinfo implements the certinfo command.
+package certinfo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bbandix/cfssl/certinfo"
+	"github.com/bbandix/cfssl/cli"
+)
+
+var certinfoUsageText = `cfssl certinfo -- report certificate details and expiry for files, directories and TLS endpoints
+
+Usage of certinfo:
+        cfssl certinfo [-domain host[:port],...] [-timeout duration] [-password password] [-threshold days] [-format json|table] [PATH...]
+
+Arguments:
+        PATH:       certificate file (PEM, DER, PKCS #7 or PKCS #12) or directory to search, use '-' for reading from stdin.
+
+Each -domain endpoint must accept the connection and complete the TLS handshake within
+-timeout, which defaults to 10s.
+
+Certificates are listed by increasing days remaining. If -threshold is given, the
+command exits with a non-zero status when any certificate expires within that many days.
+
+Flags:
+`
+
+var certinfoFlags = []string{"domain", "password", "timeout", "threshold", "format"}
+
+func load(args []string, c cli.Config) ([]*certinfo.Certificate, error) {
+	var certs []*certinfo.Certificate
+	for _, path := range args {
+		var found []*certinfo.Certificate
+		var err error
+
+		if path == "-" {
+			var data []byte
+			data, err = cli.ReadStdin(path)
+			if err == nil {
+				found, err = certinfo.Parse("stdin", data, c.Password)
+			}
+		} else if fi, statErr := os.Stat(path); statErr == nil && fi.IsDir() {
+			found, err = certinfo.ParseDirectory(path, c.Password)
+		} else {
+			found, err = certinfo.ParseFile(path, c.Password)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		certs = append(certs, found...)
+	}
+
+	if c.Domain != "" {
+		for _, addr := range strings.Split(c.Domain, ",") {
+			found, err := certinfo.ParseEndpoint(strings.TrimSpace(addr), c.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", addr, err)
+			}
+			certs = append(certs, found...)
+		}
+	}
+	return certs, nil
+}
+
+type byExpiry []*certinfo.Certificate
+
+func (s byExpiry) Len() int           { return len(s) }
+func (s byExpiry) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byExpiry) Less(i, j int) bool { return s[i].NotAfter.Before(s[j].NotAfter) }
+
+func printTable(certs []*certinfo.Certificate) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "DAYS\tNOT AFTER\tSUBJECT\tSANS\tISSUER\tSERIAL\tKEY\tSOURCE")
+	for _, cert := range certs {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			cert.DaysRemaining, cert.NotAfter.Format("2006-01-02"), cert.Subject,
+			strings.Join(cert.SANs, ","), cert.Issuer, cert.SerialNumber,
+			cert.KeyAlgorithm, cert.Source)
+	}
+	w.Flush()
+}
+
+func certinfoMain(args []string, c cli.Config) error {
+	if len(args) == 0 && c.Domain == "" {
+		return errors.New("no certificates given; provide paths or -domain, please check with usage")
+	}
+
+	if c.Format != "json" && c.Format != "table" {
+		return errors.New("unknown format " + c.Format)
+	}
+
+	certs, err := load(args, c)
+	if err != nil {
+		return err
+	}
+
+	sort.Stable(byExpiry(certs))
+
+	if c.Format == "table" {
+		printTable(certs)
+	} else {
+		out, err := json.MarshalIndent(certs, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", out)
+	}
+
+	if c.Threshold > 0 {
+		var expiring int
+		for _, cert := range certs {
+			if cert.DaysRemaining < c.Threshold {
+				expiring++
+			}
+		}
+		if expiring > 0 {
+			return fmt.Errorf("%d certificate(s) expire within %d days", expiring, c.Threshold)
+		}
+	}
+	return nil
+}
+
+// Command assembles the definition of Command 'certinfo'
+var Command = &cli.Command{UsageText: certinfoUsageText, Flags: certinfoFlags, Main: certinfoMain}
@@ -30,9 +30,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/user"
 
 	"encoding/base64"
+	"github.com/bbandix/cfssl/audit"
 	"github.com/bbandix/cfssl/config"
+	"github.com/bbandix/cfssl/log"
 )
 
 // Command holds the implementation details of a cfssl command.
@@ -128,6 +131,16 @@ func Start(cmds map[string]*Command) error {
 	cfsslFlagSet.Parse(args)
 	args = cfsslFlagSet.Args()
 
+	if err := setupLogging(c); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return err
+	}
+
+	if err := setupAudit(c); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return err
+	}
+
 	var err error
 	c.CFG, err = config.LoadFile(c.ConfigFile)
 	if c.ConfigFile != "" && err != nil {
@@ -143,6 +156,63 @@ func Start(cmds map[string]*Command) error {
 	return nil
 }
 
+// setupLogging sets the log format and sink given by the -log-format
+// and -syslog flags.
+func setupLogging(c Config) error {
+	format, err := log.ParseFormat(c.LogFormat)
+	if err != nil {
+		return err
+	}
+	log.Format = format
+
+	if c.Syslog {
+		sink, err := log.NewSyslogSink("cfssl")
+		if err != nil {
+			return err
+		}
+		log.SetSink(sink)
+	}
+	return nil
+}
+
+// setupAudit opens the audit log given by the -audit-log flag, if any,
+// keyed with the key in the -audit-key file, so that CA operations are
+// recorded in it.
+func setupAudit(c Config) error {
+	if c.AuditLog == "" {
+		return nil
+	}
+	if c.AuditKey == "" {
+		return errors.New("-audit-log requires an -audit-key")
+	}
+
+	key, err := audit.LoadKey(c.AuditKey)
+	if err != nil {
+		return err
+	}
+	store, err := audit.OpenFile(c.AuditLog)
+	if err != nil {
+		return err
+	}
+	l, err := audit.NewLog(store, key)
+	if err != nil {
+		store.Close()
+		return err
+	}
+	audit.SetLog(l)
+	return nil
+}
+
+// Requester returns the identity of the local user running a command,
+// for the audit log.
+func Requester() string {
+	u, err := user.Current()
+	if err != nil {
+		return "local"
+	}
+	return "local:" + u.Username
+}
+
 // ReadStdin reads from stdin if the file is "-"
 func ReadStdin(filename string) ([]byte, error) {
 	if filename == "-" {
@@ -173,6 +243,26 @@ func PrintCert(key, csrBytes, cert []byte) {
 	fmt.Printf("%s\n", jsonOut)
 }
 
+// PrintCrossCert outputs a rekeyed CA cert, its new key and the
+// cross-certificate issued for the new key by the previous CA key to
+// stdout
+func PrintCrossCert(key, cert, crossCert []byte) {
+	out := map[string]string{
+		"cert":       string(cert),
+		"cross_cert": string(crossCert),
+	}
+
+	if key != nil {
+		out["key"] = string(key)
+	}
+
+	jsonOut, err := json.Marshal(out)
+	if err != nil {
+		return
+	}
+	fmt.Printf("%s\n", jsonOut)
+}
+
 // PrintOCSPResponse outputs an OCSP response to stdout
 // ocspResponse is base64 encoded
 func PrintOCSPResponse(resp []byte) {
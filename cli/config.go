@@ -1,6 +1,8 @@
 package cli
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"os"
 	"time"
@@ -29,6 +31,8 @@ type Config struct {
 	CFG               *config.Config
 	Profile           string
 	IsCA              bool
+	RenewCA           bool
+	Rekey             bool
 	IntDir            string
 	Flavor            string
 	Metadata          string
@@ -51,9 +55,33 @@ type Config struct {
 	Family            string
 	Timeout           time.Duration
 	Scanner           string
+	Workers           int
+	Batch             bool
+	HostsFile         string
+	ResultsFile       string
+	Concurrency       int
+	Rate              float64
+	CTLogListFile     string
+	CTMinSCTs         int
+	ScanHistoryFile   string
+	ScanPolicyFile    string
+	StartTLS          string
 	Responses         string
+	MetricsNets       string
 	Path              string
 	Usage             string
+	Threshold         int
+	Format            string
+	TLSCertFile       string
+	TLSKeyFile        string
+	MutualTLSCAFile   string
+	TLSRemoteCAs      string
+	MutualTLSCertFile string
+	MutualTLSKeyFile  string
+	LogFormat         string
+	Syslog            bool
+	AuditLog          string
+	AuditKey          string
 }
 
 // registerFlags defines all cfssl command flags and associates their values with variables.
@@ -72,6 +100,8 @@ func registerFlags(c *Config, f *flag.FlagSet) {
 	f.StringVar(&c.ConfigFile, "config", "", "path to configuration file")
 	f.StringVar(&c.Profile, "profile", "", "signing profile to use")
 	f.BoolVar(&c.IsCA, "initca", false, "initialise new CA")
+	f.BoolVar(&c.RenewCA, "renewca", false, "re-generate a CA certificate from existing CA certificate/key")
+	f.BoolVar(&c.Rekey, "rekey", false, "with -renewca, generate a new CA key and a cross-certificate signed by the existing key")
 	f.StringVar(&c.IntDir, "int-dir", "", "specify intermediates directory")
 	f.StringVar(&c.Flavor, "flavor", "ubiquitous", "Bundle Flavor: ubiquitous, optimal and force.")
 	f.StringVar(&c.Metadata, "metadata", "", "Metadata file for root certificate presence. The content of the file is a json dictionary (k,v): each key k is SHA-1 digest of a root certificate while value v is a list of key store filenames.")
@@ -89,11 +119,35 @@ func registerFlags(c *Config, f *flag.FlagSet) {
 	f.BoolVar(&c.List, "list", false, "list possible scanners")
 	f.StringVar(&c.Family, "family", "", "scanner family regular expression")
 	f.StringVar(&c.Scanner, "scanner", "", "scanner regular expression")
+	f.IntVar(&c.Workers, "workers", 0, "number of scanners to run at once (default: 8)")
+	f.BoolVar(&c.Batch, "batch", false, "scan hosts in batch mode, writing a line of JSON for each host")
+	f.StringVar(&c.HostsFile, "hosts", "", "file to read hosts to scan from, one a line ('-' for stdin)")
+	f.StringVar(&c.ResultsFile, "results", "", "file to append batch results to; hosts already in it are skipped")
+	f.IntVar(&c.Concurrency, "concurrency", 0, "number of hosts to scan at once in batch mode (default: 16)")
+	f.Float64Var(&c.Rate, "rate", 0, "maximum connections a second to each host in batch mode (default: unlimited)")
+	f.StringVar(&c.CTLogListFile, "ct-log-list", "", "JSON list of Certificate Transparency logs to verify SCTs against")
+	f.IntVar(&c.CTMinSCTs, "ct-min-scts", 0, "number of SCTs from distinct logs a certificate needs to meet CT policy (default: 2)")
+	f.StringVar(&c.StartTLS, "starttls", "", "protocol to negotiate STARTTLS with before the TLS handshake: smtp, imap, pop3, ldap, xmpp, ftp or postgres")
+	f.StringVar(&c.ScanPolicyFile, "scan-policy", "", "JSON policy file of rules to grade scan results against, merged over the default policy")
+	f.StringVar(&c.ScanHistoryFile, "scan-history", "", "file to store the results of each scan in, to compare later scans with")
 	f.DurationVar(&c.Timeout, "timeout", 0, "duration (ns, us, ms, s, m, h) to scan each host before timing out")
 	f.StringVar(&c.Responses, "responses", "", "file to load OCSP responses from")
+	f.StringVar(&c.MetricsNets, "metrics-nets", "", "comma-separated networks, besides localhost, allowed to read /metrics")
 	f.StringVar(&c.Path, "path", "/", "Path on which the server will listen")
 	f.StringVar(&c.Password, "password", "0", "Password for accessing PKCS #12 data passed to bundler")
 	f.StringVar(&c.Usage, "usage", "dev", "usage of private key")
+	f.IntVar(&c.Threshold, "threshold", 0, "fail if any certificate expires within this many days")
+	f.StringVar(&c.Format, "format", "json", "output format: json or table")
+	f.StringVar(&c.TLSCertFile, "tls-cert", "", "Server certificate for serving over TLS")
+	f.StringVar(&c.TLSKeyFile, "tls-key", "", "Server private key for serving over TLS")
+	f.StringVar(&c.MutualTLSCAFile, "mutual-tls-ca", "", "CAs that client certificates must be issued by; enables mutual TLS")
+	f.StringVar(&c.TLSRemoteCAs, "tls-remote-ca", "", "CAs to verify the remote server against; enables TLS to the remote")
+	f.StringVar(&c.MutualTLSCertFile, "mutual-tls-client-cert", "", "Client certificate to present to the remote server")
+	f.StringVar(&c.MutualTLSKeyFile, "mutual-tls-client-key", "", "Client private key for the certificate presented to the remote server")
+	f.StringVar(&c.LogFormat, "log-format", "text", "Log format: text or json")
+	f.BoolVar(&c.Syslog, "syslog", false, "Log to the system log instead of standard error")
+	f.StringVar(&c.AuditLog, "audit-log", "", "File to append a hash-chained audit record of each CA operation to")
+	f.StringVar(&c.AuditKey, "audit-key", "", "File holding the hex-encoded key for the audit log")
 
 	if pkcs11.Enabled {
 		f.StringVar(&c.Module, "pkcs11-module", "", "PKCS #11 module")
@@ -118,3 +172,27 @@ func RootFromConfig(c *Config) universal.Root {
 		ForceRemote: c.Remote != "",
 	}
 }
+
+// RemoteTLSConfig returns the TLS configuration for reaching remote
+// servers given by the -tls-remote-ca and -mutual-tls-client-* flags,
+// or nil if none of them are set.
+func RemoteTLSConfig(c *Config) (*tls.Config, error) {
+	if c.TLSRemoteCAs == "" && c.MutualTLSCertFile == "" && c.MutualTLSKeyFile == "" {
+		return nil, nil
+	}
+
+	var remoteCAs *x509.CertPool
+	if c.TLSRemoteCAs != "" {
+		var err error
+		remoteCAs, err = helpers.LoadPEMCertPool(c.TLSRemoteCAs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cert, err := helpers.LoadClientCertificate(c.MutualTLSCertFile, c.MutualTLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return helpers.CreateTLSConfig(remoteCAs, cert), nil
+}
@@ -25,15 +25,23 @@ Usage of gencert:
 	Re-generate a existing CA cert with the CA key and CSR:
         cfssl gencert -initca -ca-key key CSRJSON
 
+	Renew an existing CA cert with the same key, or with a new key and a cross-certificate:
+        cfssl gencert -renewca -ca cert -ca-key key
+        cfssl gencert -renewca -rekey -ca cert -ca-key key
+
 Arguments:
-        CSRJSON:    JSON file containing the request, use '-' for reading JSON from stdin
+        CSRJSON:    JSON file containing the request, use '-' for reading JSON from stdin (not used with -renewca)
 
 Flags:
 `
 
-var gencertFlags = []string{"initca", "remote", "ca", "ca-key", "config", "hostname", "profile", "label"}
+var gencertFlags = []string{"initca", "renewca", "rekey", "remote", "ca", "ca-key", "config", "hostname", "profile", "label",
+	"tls-remote-ca", "mutual-tls-client-cert", "mutual-tls-client-key", "audit-log", "audit-key"}
 
 func gencertMain(args []string, c cli.Config) (err error) {
+	if c.RenewCA {
+		return renewCA(c)
+	}
 
 	csrJSONFile, args, err := cli.PopFirstArgument(args)
 	if err != nil {
@@ -105,6 +113,8 @@ func gencertMain(args []string, c cli.Config) (err error) {
 			Hosts:   signer.SplitHosts(c.Hostname),
 			Profile: c.Profile,
 			Label:   c.Label,
+
+			Requester: cli.Requester(),
 		}
 
 		cert, err = s.Sign(req)
@@ -117,6 +127,31 @@ func gencertMain(args []string, c cli.Config) (err error) {
 	return nil
 }
 
+// renewCA renews, or with -rekey rekeys, the CA given by -ca and -ca-key.
+func renewCA(c cli.Config) error {
+	if c.IsCA {
+		return errors.New("-initca and -renewca cannot be used together")
+	}
+
+	if c.Rekey {
+		log.Infof("re-key the CA certificate with a new key")
+		cert, crossCert, key, err := initca.RekeyFromPEM(c.CAFile, c.CAKeyFile, nil)
+		if err != nil {
+			return err
+		}
+		cli.PrintCrossCert(key, cert, crossCert)
+		return nil
+	}
+
+	log.Infof("re-generate a CA certificate from CA cert and key")
+	cert, err := initca.RenewFromPEM(c.CAFile, c.CAKeyFile)
+	if err != nil {
+		return err
+	}
+	cli.PrintCert(nil, nil, cert)
+	return nil
+}
+
 // CLIGenCert is a subcommand that generates a new certificate from a
 // JSON CSR request file.
 var Command = &cli.Command{UsageText: gencertUsageText, Flags: gencertFlags, Main: gencertMain}
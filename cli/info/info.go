@@ -25,14 +25,22 @@ cfssl info -remote remote_host [-label label] [-profile profile] [-label label]
 Flags:
 `
 
-var infoFlags = []string{"remote", "label", "profile", "config"}
+var infoFlags = []string{"remote", "label", "profile", "config", "tls-remote-ca", "mutual-tls-client-cert", "mutual-tls-client-key"}
 
 func getInfoFromRemote(c cli.Config) (resp *info.Resp, err error) {
 	req := new(info.Req)
 	req.Label = c.Label
 	req.Profile = c.Profile
 
-	serv := client.NewServer(c.Remote)
+	tlsConfig, err := cli.RemoteTLSConfig(&c)
+	if err != nil {
+		return
+	}
+
+	serv := client.NewServerWithOptions(c.Remote, client.Options{TLSConfig: tlsConfig})
+	if serv == nil {
+		return nil, goerr.New("invalid remote " + c.Remote)
+	}
 
 	reqJSON, _ := json.Marshal(req)
 	resp, err = serv.Info(reqJSON)
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/bbandix/cfssl/api/metrics"
 	"github.com/bbandix/cfssl/cli"
 	"github.com/bbandix/cfssl/log"
 	"github.com/bbandix/cfssl/ocsp"
@@ -15,13 +16,14 @@ import (
 var ocspServerUsageText = `cfssl ocspserve -- set up an HTTP server that handles OCSP requests from a file (see RFC 5019)
 
   Usage of ocspserve:
-          cfssl ocspserve [-address address] [-port port] [-responses file]
+          cfssl ocspserve [-address address] [-port port] [-responses file] \
+                          [-log-format format] [-syslog] [-metrics-nets networks]
 
   Flags:
   `
 
 // Flags used by 'cfssl serve'
-var ocspServerFlags = []string{"address", "port", "responses"}
+var ocspServerFlags = []string{"address", "port", "responses", "log-format", "syslog", "metrics-nets"}
 
 // ocspServerMain is the command line entry point to the OCSP responder.
 // It sets up a new HTTP server that responds to OCSP requests.
@@ -41,7 +43,12 @@ func ocspServerMain(args []string, c cli.Config) error {
 	}
 
 	log.Info("Registering OCSP responder handler")
-	http.Handle(c.Path, ocsp.Responder{Source: src})
+	http.Handle(c.Path, metrics.Instrument("ocsp", ocsp.Responder{Source: src}, nil))
+	metricsHandler, err := metrics.NewWhitelistedHandler(c.MetricsNets)
+	if err != nil {
+		return err
+	}
+	http.Handle("/metrics", metricsHandler)
 
 	addr := fmt.Sprintf("%s:%d", c.Address, c.Port)
 	log.Info("Now listening on ", addr)
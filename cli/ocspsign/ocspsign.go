@@ -22,7 +22,7 @@ Flags:
 `
 
 // Flags of 'cfssl ocspsign'
-var ocspSignerFlags = []string{"ca", "responder", "responder-key", "reason", "status", "revoked-at", "interval"}
+var ocspSignerFlags = []string{"ca", "responder", "responder-key", "reason", "status", "revoked-at", "interval", "audit-log", "audit-key"}
 
 // ocspSignerMain is the main CLI of OCSP signer functionality.
 func ocspSignerMain(args []string, c cli.Config) (err error) {
@@ -41,6 +41,7 @@ func ocspSignerMain(args []string, c cli.Config) (err error) {
 	req := ocsp.SignRequest{
 		Certificate: cert,
 		Status:      c.Status,
+		Requester:   cli.Requester(),
 	}
 
 	if c.Status == "revoked" {
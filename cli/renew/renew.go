@@ -0,0 +1,141 @@
+// Package renew implements the renew command.
+package renew
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+
+	"github.com/bbandix/cfssl/api/client"
+	"github.com/bbandix/cfssl/cli"
+	"github.com/bbandix/cfssl/cli/sign"
+	"github.com/bbandix/cfssl/helpers"
+	"github.com/bbandix/cfssl/log"
+	"github.com/bbandix/cfssl/renew"
+)
+
+var renewUsageText = `cfssl renew -- renew a certificate issued by a CA, keeping its subject and SANs
+
+Usage of renew:
+        cfssl renew -ca cert -ca-key key [-config config] [-profile profile] [-csr csr] [-key key] CERT
+        cfssl renew -remote remote_host [-config config] [-profile profile] [-label label] [-csr csr] [-key key] CERT
+
+Arguments:
+        CERT:       PEM file for the certificate to renew, use '-' for reading PEM from stdin.
+
+Note: CERT can also be supplied via the -cert flag; the flag value will take precedence over the argument.
+
+If -csr is given, the renewed certificate is issued for the key in the CSR; otherwise it is issued
+for the key of CERT. If -key is given, the request carries a proof of possession made with the
+private key of CERT, which is required with -csr and by profiles with renewal_proof set or with
+authentication.
+
+Flags:
+`
+
+var renewFlags = []string{"cert", "csr", "key", "ca", "ca-key", "config", "profile", "label", "remote",
+	"tls-remote-ca", "mutual-tls-client-cert", "mutual-tls-client-key"}
+
+func renewMain(args []string, c cli.Config) (err error) {
+	if c.CertFile == "" {
+		c.CertFile, args, err = cli.PopFirstArgument(args)
+		if err != nil {
+			return
+		}
+	}
+
+	certPEM, err := cli.ReadStdin(c.CertFile)
+	if err != nil {
+		return
+	}
+
+	req := &renew.Request{
+		Certificate: string(certPEM),
+		Profile:     c.Profile,
+		Label:       c.Label,
+	}
+
+	if c.CSRFile != "" {
+		var csrPEM []byte
+		csrPEM, err = ioutil.ReadFile(c.CSRFile)
+		if err != nil {
+			return
+		}
+		req.Request = string(csrPEM)
+	}
+
+	if c.KeyFile != "" {
+		var keyPEM []byte
+		keyPEM, err = ioutil.ReadFile(c.KeyFile)
+		if err != nil {
+			return
+		}
+
+		priv, err := helpers.ParsePrivateKeyPEM(keyPEM)
+		if err != nil {
+			return err
+		}
+
+		if err = req.Sign(priv); err != nil {
+			return err
+		}
+	}
+
+	var cert []byte
+	if c.Remote != "" {
+		var reqJSON []byte
+		reqJSON, err = json.Marshal(req)
+		if err != nil {
+			return
+		}
+
+		var tlsConfig *tls.Config
+		tlsConfig, err = cli.RemoteTLSConfig(&c)
+		if err != nil {
+			return
+		}
+
+		remote := client.NewServerWithOptions(c.Remote, client.Options{TLSConfig: tlsConfig})
+		if remote == nil {
+			return errors.New("invalid remote " + c.Remote)
+		}
+
+		cert, err = remote.Renew(reqJSON)
+		if err != nil {
+			return
+		}
+	} else {
+		if c.CFG == nil {
+			if c.CAFile == "" {
+				log.Error("need CA certificate (provide one with -ca)")
+				return
+			}
+
+			if c.CAKeyFile == "" {
+				log.Error("need CA key (provide one with -ca-key)")
+				return
+			}
+		}
+
+		s, err := sign.SignerFromConfig(c)
+		if err != nil {
+			return err
+		}
+
+		cert, err = renew.Renew(s, req)
+		if err != nil {
+			return err
+		}
+	}
+
+	var csrPEM []byte
+	if req.Request != "" {
+		csrPEM = []byte(req.Request)
+	}
+	cli.PrintCert(nil, csrPEM, cert)
+	return
+}
+
+// Command assembles the definition of Command 'renew'
+var Command = &cli.Command{UsageText: renewUsageText, Flags: renewFlags, Main: renewMain}
@@ -1,23 +1,57 @@
 package scan
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"os/signal"
 
 	"github.com/bbandix/cfssl/cli"
 	"github.com/bbandix/cfssl/scan"
+	"github.com/bbandix/cfssl/starttls"
 )
 
 var scanUsageText = `cfssl scan -- scan a host for issues
 Usage of scan:
-        cfssl scan [-family regexp] [-scanner regexp] [-timeout duration] [-ip IPAddr] HOST+
+        cfssl scan [-family regexp] [-scanner regexp] [-timeout duration] [-workers n] [-ip IPAddr]
+                   [-starttls protocol] [-ct-log-list file] [-ct-min-scts n] [-scan-policy file]
+                   [-scan-history file] HOST+
+        cfssl scan -batch [-hosts file] [-results file] [-concurrency n] [-rate n]
+                   [-family regexp] [-scanner regexp] [-timeout duration] [-workers n]
+                   [-starttls protocol] [-scan-policy file] [-scan-history file] [HOST|CIDR]...
         cfssl scan -list
 
 Arguments:
         HOST:    Host(s) to scan (including port)
+        CIDR:    In batch mode, an IP range to scan each address of (optionally including port),
+                 no wider than /16 for IPv4 or /112 for IPv6
+
+With -starttls, STARTTLS is negotiated with the given protocol before each
+TLS handshake, and hosts without a port are scanned on the protocol's usual
+port, such as 25 for smtp.
+
+When the scan of a host runs out of -timeout, the results of the scanners
+that completed are printed, followed by the error.
+
+In batch mode, a line of JSON is written for each host as soon as it has
+been scanned, and -timeout bounds the scan of each host. Hosts already in
+the -results file are skipped, so an interrupted batch is resumed by
+running it again.
+
+Results are graded against the default policy, merged with the rules in the
+-scan-policy file if one is given, on top of the grade each scanner gives;
+see "SCAN POLICY" in doc/cmd/cfssl.txt.
+
+With -scan-history, the results of each host are also stored in the given
+file with the time of the scan; "cfssl scandiff" compares the latest two
+scans of a host.
 Flags:
 `
-var scanFlags = []string{"list", "family", "scanner", "timeout", "ip", "ca-bundle"}
+var scanFlags = []string{"list", "family", "scanner", "timeout", "workers", "ip", "starttls", "ca-bundle",
+	"batch", "hosts", "results", "concurrency", "rate", "ct-log-list", "ct-min-scts",
+	"scan-policy", "scan-history"}
 
 func printJSON(v interface{}) {
 	b, err := json.MarshalIndent(v, "", "  ")
@@ -27,11 +61,42 @@ func printJSON(v interface{}) {
 	fmt.Printf("%s\n\n", b)
 }
 
+// loadScanConfig loads the root CAs, CT logs and policy the scanners
+// check against, and opens the file results are stored in, if any.
+func loadScanConfig(c cli.Config) error {
+	if err := scan.LoadRootCAs(c.CABundleFile); err != nil {
+		return err
+	}
+	if c.CTMinSCTs > 0 {
+		scan.MinSCTs = c.CTMinSCTs
+	}
+	if err := scan.LoadCTLogs(c.CTLogListFile); err != nil {
+		return err
+	}
+	if err := scan.LoadPolicy(c.ScanPolicyFile); err != nil {
+		return err
+	}
+
+	if c.ScanHistoryFile != "" {
+		history, err := scan.OpenHistory(c.ScanHistoryFile)
+		if err != nil {
+			return err
+		}
+		scan.SetHistory(history)
+	}
+	return nil
+}
+
 func scanMain(args []string, c cli.Config) (err error) {
 	if c.List {
 		printJSON(scan.Default)
+	} else if c.Batch {
+		if err = loadScanConfig(c); err != nil {
+			return
+		}
+		err = batchMain(args, c)
 	} else {
-		if err = scan.LoadRootCAs(c.CABundleFile); err != nil {
+		if err = loadScanConfig(c); err != nil {
 			return
 		}
 		// Execute for each HOST argument given
@@ -44,18 +109,138 @@ func scanMain(args []string, c cli.Config) (err error) {
 
 			fmt.Printf("Scanning %s...\n", host)
 
+			ctx, cancel := context.Background(), context.CancelFunc(func() {})
+			if c.StartTLS != "" {
+				ctx = scan.WithStartTLS(ctx, c.StartTLS)
+			}
+			if c.Timeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+			}
+
 			var results map[string]scan.FamilyResult
-			results, err = scan.Default.RunScans(host, c.IP, c.Family, c.Scanner, c.Timeout)
+			results, err = scan.Default.RunScans(ctx, host, c.IP, c.Family, c.Scanner, c.Workers)
+			cancel()
+			// A scan that timed out still prints the results of the
+			// scanners that completed.
+			if results != nil {
+				printJSON(results)
+			}
 			if err != nil {
 				return
 			}
-			if results != nil {
-				printJSON(results)
+			if err = scan.SaveHistory(host, results); err != nil {
+				return
 			}
 		}
 	}
 	return
 }
 
+// batchMain scans the hosts given as arguments and read from the hosts
+// file, writing the result of each host as a line of JSON.
+func batchMain(args []string, c cli.Config) error {
+	if len(args) == 0 && c.HostsFile == "" {
+		return fmt.Errorf("no hosts given")
+	}
+	if c.StartTLS != "" {
+		if _, err := starttls.Port(c.StartTLS); err != nil {
+			return err
+		}
+	}
+
+	batch := &scan.Batch{
+		Families:    scan.Default,
+		Family:      c.Family,
+		Scanner:     c.Scanner,
+		Concurrency: c.Concurrency,
+		Workers:     c.Workers,
+		Timeout:     c.Timeout,
+		Rate:        c.Rate,
+		StartTLS:    c.StartTLS,
+	}
+
+	var out io.Writer = os.Stdout
+	if c.ResultsFile != "" {
+		f, err := os.OpenFile(c.ResultsFile, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if batch.Done, err = scan.ReadDone(f); err != nil {
+			return err
+		}
+		// End a line left partly written by an interrupted batch, so
+		// that it doesn't run into the next result.
+		if fi, err := f.Stat(); err == nil && fi.Size() > 0 {
+			last := make([]byte, 1)
+			if _, err = f.ReadAt(last, fi.Size()-1); err == nil && last[0] != '\n' {
+				f.Write([]byte("\n"))
+			}
+		}
+		out = f
+	}
+
+	// Stop cleanly when interrupted, so that the batch can be resumed.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	go func() {
+		select {
+		case <-interrupt:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	hosts := make(chan string)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(hosts)
+		for _, arg := range args {
+			if err := scan.ExpandHost(ctx, arg, hosts); err != nil {
+				readErr <- err
+				return
+			}
+		}
+		if c.HostsFile != "" {
+			in := os.Stdin
+			if c.HostsFile != "-" {
+				f, err := os.Open(c.HostsFile)
+				if err != nil {
+					readErr <- err
+					cancel()
+					return
+				}
+				defer f.Close()
+				in = f
+			}
+			if err := scan.ReadHosts(ctx, in, hosts); err != nil {
+				readErr <- err
+				cancel()
+				return
+			}
+		}
+		readErr <- nil
+	}()
+
+	err := batch.Run(ctx, hosts, func(result *scan.HostResult) error {
+		if err := scan.WriteResult(out, result); err != nil {
+			return err
+		}
+		return scan.SaveResult(result)
+	})
+	cancel()
+	if rerr := <-readErr; rerr != nil && rerr != context.Canceled {
+		return rerr
+	}
+	if err == context.Canceled {
+		return fmt.Errorf("batch interrupted")
+	}
+	return err
+}
+
 // Command assembles the definition of Command 'scan'
 var Command = &cli.Command{UsageText: scanUsageText, Flags: scanFlags, Main: scanMain}
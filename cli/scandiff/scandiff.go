@@ -0,0 +1,63 @@
+// Package scandiff implements the scandiff command.
+package scandiff
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/bbandix/cfssl/cli"
+	"github.com/bbandix/cfssl/scan"
+)
+
+var scandiffUsageText = `cfssl scandiff -- compare the latest two scans of a host
+
+Usage of scandiff:
+        cfssl scandiff -scan-history file HOST
+
+Arguments:
+        HOST:       host, as given to cfssl scan or the scan API
+
+The latest two scans of the host in the history file, as written with the
+-scan-history flag of cfssl scan and cfssl serve, are compared. Each scanner
+whose grade changed, or whose output gained or lost items, is reported;
+grades that became worse are marked as regressions.
+Flags:
+`
+
+var scandiffFlags = []string{"scan-history"}
+
+func scandiffMain(args []string, c cli.Config) error {
+	host, _, err := cli.PopFirstArgument(args)
+	if err != nil {
+		return err
+	}
+	if c.ScanHistoryFile == "" {
+		return errors.New("no scan history file given")
+	}
+
+	f, err := os.Open(c.ScanHistoryFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	records, err := scan.ReadHistory(f, host, 2)
+	if err != nil {
+		return err
+	}
+	if len(records) < 2 {
+		return fmt.Errorf("fewer than two scans of %s in the scan history", host)
+	}
+
+	out, err := json.MarshalIndent(scan.DiffHistory(records[0], records[1]), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", out)
+	return nil
+}
+
+// Command assembles the definition of Command 'scandiff'
+var Command = &cli.Command{UsageText: scandiffUsageText, Flags: scandiffFlags, Main: scandiffMain}
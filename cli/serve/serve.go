@@ -3,25 +3,34 @@ package serve
 
 import (
 	"errors"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/bbandix/cfssl/api/bundle"
 	"github.com/bbandix/cfssl/api/generator"
 	"github.com/bbandix/cfssl/api/info"
 	"github.com/bbandix/cfssl/api/initca"
+	"github.com/bbandix/cfssl/api/metrics"
 	apiocsp "github.com/bbandix/cfssl/api/ocsp"
-	"github.com/bbandix/cfssl/api/scan"
+	apirenew "github.com/bbandix/cfssl/api/renew"
+	apiscan "github.com/bbandix/cfssl/api/scan"
 	apisign "github.com/bbandix/cfssl/api/sign"
 	"github.com/bbandix/cfssl/bundler"
 	"github.com/bbandix/cfssl/cli"
 	ocspsign "github.com/bbandix/cfssl/cli/ocspsign"
 	"github.com/bbandix/cfssl/cli/sign"
+	"github.com/bbandix/cfssl/config"
+	"github.com/bbandix/cfssl/helpers"
 	"github.com/bbandix/cfssl/log"
 	"github.com/bbandix/cfssl/ocsp"
+	"github.com/bbandix/cfssl/scan"
 	"github.com/bbandix/cfssl/signer"
 	"github.com/bbandix/cfssl/ubiquity"
 
@@ -35,17 +44,25 @@ Usage of serve:
         cfssl serve [-address address] [-ca cert] [-ca-bundle bundle] \
                     [-ca-key key] [-int-bundle bundle] [-int-dir dir] [-port port] \
                     [-metadata file] [-remote remote_host] [-config config] \
-                    [-responder cert] [-responder-key key]
+                    [-responder cert] [-responder-key key] \
+                    [-tls-cert cert -tls-key key] [-mutual-tls-ca ca] \
+                    [-tls-remote-ca ca] [-mutual-tls-client-cert cert -mutual-tls-client-key key] \
+                    [-log-format format] [-syslog] [-audit-log file -audit-key key] \
+                    [-ct-log-list file] [-ct-min-scts n] [-scan-policy file] [-scan-history file] \
+                    [-metrics-nets networks]
 
 Flags:
 `
 
 // Flags used by 'cfssl serve'
-var serverFlags = []string{"address", "port", "ca", "ca-key", "ca-bundle", "int-bundle", "int-dir", "metadata", "remote", "config", "responder", "responder-key"}
+var serverFlags = []string{"address", "port", "ca", "ca-key", "ca-bundle", "int-bundle", "int-dir", "metadata", "remote", "config", "responder", "responder-key",
+	"tls-cert", "tls-key", "mutual-tls-ca", "tls-remote-ca", "mutual-tls-client-cert", "mutual-tls-client-key",
+	"log-format", "syslog", "audit-log", "audit-key", "ct-log-list", "ct-min-scts", "scan-policy", "scan-history", "metrics-nets"}
 
 var (
 	conf       cli.Config
 	s          signer.Signer
+	reloadable *signer.Reloadable
 	ocspSigner ocsp.Signer
 	staticDir  = "static"
 )
@@ -67,6 +84,13 @@ var v1Endpoints = map[string]func() (http.Handler, error){
 		return apisign.NewAuthHandlerFromSigner(s)
 	},
 
+	"renew": func() (http.Handler, error) {
+		if s == nil {
+			return nil, errBadSigner
+		}
+		return apirenew.NewHandlerFromSigner(s)
+	},
+
 	"info": func() (http.Handler, error) {
 		if s == nil {
 			return nil, errBadSigner
@@ -93,12 +117,24 @@ var v1Endpoints = map[string]func() (http.Handler, error){
 		return initca.NewHandler(), nil
 	},
 
+	"renew_ca": func() (http.Handler, error) {
+		return initca.NewRenewHandler(), nil
+	},
+
+	"rekey_ca": func() (http.Handler, error) {
+		return initca.NewRekeyHandler(), nil
+	},
+
 	"scan": func() (http.Handler, error) {
-		return scan.NewHandler(conf.CABundleFile)
+		return apiscan.NewHandler(conf.CABundleFile)
+	},
+
+	"scan_batch": func() (http.Handler, error) {
+		return apiscan.NewBatchHandler(conf.CABundleFile)
 	},
 
 	"scaninfo": func() (http.Handler, error) {
-		return scan.NewInfoHandler(), nil
+		return apiscan.NewInfoHandler(), nil
 	},
 
 	"ocspsign": func() (http.Handler, error) {
@@ -137,16 +173,22 @@ func v1APIPath(endpoint string) string {
 }
 
 // registerHandlers instantiates various handlers and associate them to corresponding endpoints.
-func registerHandlers() {
-	for path, getHandler := range v1Endpoints {
-		path = "/api/v1/cfssl/" + path
+func registerHandlers() error {
+	for endpoint, getHandler := range v1Endpoints {
+		path := "/api/v1/cfssl/" + endpoint
 		log.Infof("Setting up '%s' endpoint", path)
 		if handler, err := getHandler(); err != nil {
 			log.Warningf("endpoint '%s' is disabled: %v", path, err)
 		} else {
-			http.Handle(path, handler)
+			http.Handle(path, metrics.Instrument(endpoint, handler, knownProfile))
 		}
 	}
+	log.Info("Setting up '/metrics' endpoint")
+	metricsHandler, err := metrics.NewWhitelistedHandler(conf.MetricsNets)
+	if err != nil {
+		return err
+	}
+	http.Handle("/metrics", metricsHandler)
 	for path, getHandler := range staticEndpoints {
 		log.Infof("Setting up '%s' endpoint", path)
 		if handler, err := getHandler(); err != nil {
@@ -157,6 +199,87 @@ func registerHandlers() {
 	}
 
 	log.Info("Handler set up complete.")
+	return nil
+}
+
+// knownProfile reports whether the signer's policy has a profile, for
+// recording requests by profile.
+func knownProfile(profile string) bool {
+	if s == nil {
+		return false
+	}
+	policy := s.Policy()
+	return policy != nil && policy.Profiles[profile] != nil
+}
+
+// recordCertificateExpiry records the expiry times of the CA and OCSP
+// responder certificates for the metrics endpoint.
+func recordCertificateExpiry(c cli.Config) {
+	for certType, file := range map[string]string{"ca": c.CAFile, "responder": c.ResponderFile} {
+		if file == "" {
+			continue
+		}
+
+		in, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Warningf("couldn't read the %s certificate for metrics: %v", certType, err)
+			continue
+		}
+		cert, err := helpers.ParseCertificatePEM(in)
+		if err != nil {
+			log.Warningf("couldn't parse the %s certificate for metrics: %v", certType, err)
+			continue
+		}
+		metrics.SetCertificateExpiry(certType, "", cert)
+	}
+}
+
+// reloadOnSignal reloads the signer whenever the process receives SIGHUP.
+func reloadOnSignal(c cli.Config) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	for range sigs {
+		log.Info("received SIGHUP, reloading the signer")
+		reloadSigner(c)
+	}
+}
+
+// reloadSigner rereads the configuration file and CA material and
+// swaps the new signer in for the current one. If the new
+// configuration is invalid or the signer can't be set up, the error is
+// logged and the current signer is kept. The set of endpoints and the
+// OCSP signer are fixed at startup, and aren't reloaded.
+func reloadSigner(c cli.Config) {
+	if reloadable == nil {
+		log.Error("the signer failed to initialize at startup; restart the server to enable signing")
+		return
+	}
+
+	if c.ConfigFile != "" {
+		cfg, err := config.LoadFile(c.ConfigFile)
+		if err != nil {
+			log.Errorf("keeping the current signer, failed to reload the configuration: %v", err)
+			return
+		}
+		c.CFG = cfg
+	}
+
+	newSigner, err := sign.SignerFromConfig(c)
+	if err != nil {
+		log.Errorf("keeping the current signer, failed to reload the signer: %v", err)
+		return
+	}
+
+	// The replay caches of authenticated profiles are carried over,
+	// so that requests accepted before the reload can't be replayed
+	// after it.
+	if policy := newSigner.Policy(); policy != nil {
+		policy.ShareReplayCaches(reloadable.Policy())
+	}
+
+	reloadable.Swap(newSigner)
+	recordCertificateExpiry(c)
+	log.Info("signer reloaded")
 }
 
 // serverMain is the command line entry point to the API server. It sets up a
@@ -175,20 +298,58 @@ func serverMain(args []string, c cli.Config) error {
 		return err
 	}
 
+	if err = scan.LoadCTLogs(conf.CTLogListFile); err != nil {
+		return err
+	}
+	if conf.CTMinSCTs > 0 {
+		scan.MinSCTs = conf.CTMinSCTs
+	}
+	if err = scan.LoadPolicy(conf.ScanPolicyFile); err != nil {
+		return err
+	}
+	if conf.ScanHistoryFile != "" {
+		history, err := scan.OpenHistory(conf.ScanHistoryFile)
+		if err != nil {
+			return err
+		}
+		scan.SetHistory(history)
+	}
+
 	log.Info("Initializing signer")
 	if s, err = sign.SignerFromConfig(c); err != nil {
 		log.Warningf("couldn't initialize signer: %v", err)
+	} else {
+		reloadable = signer.NewReloadable(s)
+		s = reloadable
 	}
 
 	if ocspSigner, err = ocspsign.SignerFromConfig(c); err != nil {
 		log.Warningf("couldn't initialize ocsp signer: %v", err)
 	}
 
-	registerHandlers()
+	recordCertificateExpiry(c)
+	if err = registerHandlers(); err != nil {
+		return err
+	}
+	go reloadOnSignal(c)
 
 	addr := net.JoinHostPort(conf.Address, strconv.Itoa(conf.Port))
-	log.Info("Now listening on ", addr)
-	return http.ListenAndServe(addr, nil)
+
+	if conf.TLSCertFile == "" && conf.TLSKeyFile == "" {
+		if conf.MutualTLSCAFile != "" {
+			return errors.New("mutual TLS requires a TLS certificate and key")
+		}
+		log.Info("Now listening on ", addr)
+		return http.ListenAndServe(addr, nil)
+	}
+
+	tlsConfig, err := helpers.ServerTLSConfig(conf.TLSCertFile, conf.TLSKeyFile, conf.MutualTLSCAFile)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Addr: addr, TLSConfig: tlsConfig}
+	log.Info("Now listening on https://", addr)
+	return server.ListenAndServeTLS("", "")
 }
 
 // CLIServer assembles the definition of Command 'serve'
@@ -6,11 +6,17 @@ import (
 	"os"
 	"testing"
 
+	"github.com/bbandix/cfssl/cli"
+	"github.com/bbandix/cfssl/cli/sign"
+	"github.com/bbandix/cfssl/signer"
+
 	rice "github.com/GeertJohan/go.rice"
 )
 
 func TestServe(t *testing.T) {
-	registerHandlers()
+	if err := registerHandlers(); err != nil {
+		t.Fatal(err)
+	}
 	ts := httptest.NewServer(http.DefaultServeMux)
 	defer ts.Close()
 	expected := make(map[string]int)
@@ -35,14 +41,18 @@ func TestServe(t *testing.T) {
 	// Disabled endpoints should return '404 Not Found'
 	expected[v1APIPath("sign")] = http.StatusNotFound
 	expected[v1APIPath("authsign")] = http.StatusNotFound
+	expected[v1APIPath("renew")] = http.StatusNotFound
 	expected[v1APIPath("newcert")] = http.StatusNotFound
 	expected[v1APIPath("info")] = http.StatusNotFound
 	expected[v1APIPath("ocspsign")] = http.StatusNotFound
 
 	// Enabled endpoints should return '405 Method Not Allowed'
 	expected[v1APIPath("init_ca")] = http.StatusMethodNotAllowed
+	expected[v1APIPath("renew_ca")] = http.StatusMethodNotAllowed
+	expected[v1APIPath("rekey_ca")] = http.StatusMethodNotAllowed
 	expected[v1APIPath("newkey")] = http.StatusMethodNotAllowed
 	expected[v1APIPath("bundle")] = http.StatusMethodNotAllowed
+	expected[v1APIPath("scan_batch")] = http.StatusMethodNotAllowed
 
 	// POST-only endpoints should return '400 Bad Request'
 	expected[v1APIPath("scan")] = http.StatusBadRequest
@@ -60,3 +70,37 @@ func TestServe(t *testing.T) {
 		}
 	}
 }
+
+func TestReloadSigner(t *testing.T) {
+	c := cli.Config{
+		CAFile:     "../../testdata/server.crt",
+		CAKeyFile:  "../../testdata/server.key",
+		ConfigFile: "../../config/testdata/valid_config.json",
+	}
+	initial, err := sign.SignerFromConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloadable = signer.NewReloadable(initial)
+	defer func() { reloadable = nil }()
+
+	reloadSigner(c)
+	if reloadable.Current() == initial {
+		t.Fatal("signer was not reloaded")
+	}
+	if _, ok := reloadable.Policy().Profiles["email"]; !ok {
+		t.Fatal("reloaded signer does not use the configuration file")
+	}
+
+	// An invalid configuration or missing CA keeps the current signer.
+	current := reloadable.Current()
+	for _, bad := range []cli.Config{
+		{CAFile: c.CAFile, CAKeyFile: c.CAKeyFile, ConfigFile: "../../config/testdata/invalid_profile.json"},
+		{CAFile: c.CAFile, CAKeyFile: "../../testdata/missing.key", ConfigFile: c.ConfigFile},
+	} {
+		reloadSigner(bad)
+		if reloadable.Current() != current {
+			t.Fatalf("signer was replaced by an invalid one: %+v", bad)
+		}
+	}
+}
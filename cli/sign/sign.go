@@ -3,6 +3,7 @@ package sign
 
 import (
 	"encoding/json"
+	"encoding/pem"
 	"io/ioutil"
 
 	"github.com/bbandix/cfssl/cli"
@@ -24,13 +25,16 @@ Arguments:
 
 Note: CSR can also be supplied via flag values; flag value will take precedence over the argument.
 
+CSR may also be an existing CA certificate, which is cross-signed using a CA profile: its subject, key and name constraints are kept.
+
 SUBJECT is an optional file containing subject information to use for the certificate instead of the subject information in the CSR.
 
 Flags:
 `
 
 // Flags of 'cfssl sign'
-var signerFlags = []string{"hostname", "csr", "ca", "ca-key", "config", "profile", "label", "remote"}
+var signerFlags = []string{"hostname", "csr", "ca", "ca-key", "config", "profile", "label", "remote",
+	"tls-remote-ca", "mutual-tls-client-cert", "mutual-tls-client-key", "audit-log", "audit-key"}
 
 // SignerFromConfig takes the Config and creates the appropriate
 // signer.Signer object
@@ -55,6 +59,14 @@ func SignerFromConfig(c cli.Config) (signer.Signer, error) {
 		}
 	}
 
+	tlsConfig, err := cli.RemoteTLSConfig(&c)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		policy.SetRemoteTLSConfig(tlsConfig)
+	}
+
 	s, err := universal.NewSigner(cli.RootFromConfig(&c), policy)
 	if err != nil {
 		return nil, err
@@ -123,11 +135,18 @@ func signerMain(args []string, c cli.Config) (err error) {
 		Subject: subjectData,
 		Profile: c.Profile,
 		Label:   c.Label,
+
+		Requester: cli.Requester(),
 	}
 	cert, err := s.Sign(req)
 	if err != nil {
 		return
 	}
+
+	// A cross-signed certificate has no CSR to echo back.
+	if block, _ := pem.Decode(csr); block != nil && block.Type == "CERTIFICATE" {
+		csr = nil
+	}
 	cli.PrintCert(nil, csr, cert)
 	return
 }
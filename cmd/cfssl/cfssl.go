@@ -10,11 +10,15 @@ Usage:
 
 	bundle	 create a certificate bundle
 	sign	 signs a certificate signing request (CSR)
+	renew	 renews a certificate, keeping its subject and SANs
+	agent	 watches certificates and renews them through a remote
 	serve	 starts a HTTP server handling sign and bundle requests
 	version	 prints the current cfssl version
 	genkey   generates a key and an associated CSR
 	gencert  generates a key and a signed certificate
 	selfsign generates a self-signed certificate
+	certinfo reports certificate details and days until expiry
+	scandiff compares the latest two scans of a host
 
 Use "cfssl [command] -help" to find out more about a command.
 */
@@ -25,14 +29,19 @@ import (
 	"os"
 
 	"github.com/bbandix/cfssl/cli"
+	"github.com/bbandix/cfssl/cli/agent"
+	"github.com/bbandix/cfssl/cli/audit"
 	"github.com/bbandix/cfssl/cli/bundle"
+	"github.com/bbandix/cfssl/cli/certinfo"
 	"github.com/bbandix/cfssl/cli/gencert"
 	"github.com/bbandix/cfssl/cli/genkey"
 	"github.com/bbandix/cfssl/cli/info"
 	"github.com/bbandix/cfssl/cli/ocspserve"
 	"github.com/bbandix/cfssl/cli/ocspsign"
 	"github.com/bbandix/cfssl/cli/printdefault"
+	"github.com/bbandix/cfssl/cli/renew"
 	"github.com/bbandix/cfssl/cli/scan"
+	"github.com/bbandix/cfssl/cli/scandiff"
 	"github.com/bbandix/cfssl/cli/selfsign"
 	"github.com/bbandix/cfssl/cli/serve"
 	"github.com/bbandix/cfssl/cli/sign"
@@ -49,6 +58,9 @@ func main() {
 	cmds := map[string]*cli.Command{
 		"bundle":         bundle.Command,
 		"sign":           sign.Command,
+		"renew":          renew.Command,
+		"agent":          agent.Command,
+		"audit":          audit.Command,
 		"serve":          serve.Command,
 		"version":        version.Command,
 		"genkey":         genkey.Command,
@@ -57,7 +69,9 @@ func main() {
 		"ocspserve":      ocspserve.Command,
 		"selfsign":       selfsign.Command,
 		"scan":           scan.Command,
+		"scandiff":       scandiff.Command,
 		"info":           info.Command,
+		"certinfo":       certinfo.Command,
 		"print-defaults": printdefaults.Command,
 	}
 
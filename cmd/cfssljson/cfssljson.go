@@ -65,6 +65,7 @@ func main() {
 	var cert string
 	var key string
 	var csr string
+	var crossCert string
 
 	fileData, err := readFile(*inFile)
 	if err != nil {
@@ -136,6 +137,19 @@ func main() {
 		})
 	}
 
+	if contents, ok := input["cross_cert"]; ok {
+		crossCert = contents.(string)
+	} else if contents, ok = input["cross_certificate"]; ok {
+		crossCert = contents.(string)
+	}
+	if crossCert != "" {
+		outs = append(outs, outputFile{
+			Filename: baseName + "-cross.pem",
+			Contents: crossCert,
+			Perms:    0664,
+		})
+	}
+
 	if contents, ok := input["bundle"]; ok {
 		outs = append(outs, outputFile{
 			Filename: baseName + "-bundle.pem",
@@ -2,12 +2,12 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"net/http"
-	"net/http/httputil"
+	"sync"
 
 	"github.com/bbandix/cfssl/api"
+	"github.com/bbandix/cfssl/audit"
 	"github.com/bbandix/cfssl/auth"
 	"github.com/bbandix/cfssl/helpers"
 	"github.com/bbandix/cfssl/log"
@@ -34,6 +34,7 @@ type signerStats struct {
 var stats struct {
 	Registry         metrics.Registry
 	Requests         map[string]signerStats
+	RequestsLock     sync.Mutex
 	TotalRequestRate metrics.Meter
 	ErrorPercent     metrics.GaugeFloat64
 	ErrorRate        metrics.Meter
@@ -44,19 +45,29 @@ func initStats() {
 
 	stats.Requests = map[string]signerStats{}
 
-	// signers is defined in ca.go
-	for k := range signers {
-		stats.Requests[k] = signerStats{
-			Counter: metrics.NewRegisteredCounter("requests:"+k, stats.Registry),
-			Rate:    metrics.NewRegisteredMeter("request-rate:"+k, stats.Registry),
-		}
-	}
-
 	stats.TotalRequestRate = metrics.NewRegisteredMeter("total-request-rate", stats.Registry)
 	stats.ErrorPercent = metrics.NewRegisteredGaugeFloat64("error-percent", stats.Registry)
 	stats.ErrorRate = metrics.NewRegisteredMeter("error-rate", stats.Registry)
 }
 
+// requestStats returns the request statistics for a label, registering
+// them on first use, as signers may be added when the roots are
+// reloaded.
+func requestStats(label string) signerStats {
+	stats.RequestsLock.Lock()
+	defer stats.RequestsLock.Unlock()
+
+	st, ok := stats.Requests[label]
+	if !ok {
+		st = signerStats{
+			Counter: metrics.GetOrRegisterCounter("requests:"+label, stats.Registry),
+			Rate:    metrics.GetOrRegisterMeter("request-rate:"+label, stats.Registry),
+		}
+		stats.Requests[label] = st
+	}
+	return st
+}
+
 // incError increments the error count and updates the error percentage.
 func incErrors() {
 	stats.ErrorRate.Mark(1)
@@ -76,17 +87,10 @@ func incRequests() {
 func fail(w http.ResponseWriter, req *http.Request, status, code int, msg, ad string) {
 	incErrors()
 
-	if ad != "" {
-		ad = " (" + ad + ")"
-	}
-	log.Errorf("[HTTP %d] %d - %s%s", status, code, msg, ad)
-
-	dumpReq, err := httputil.DumpRequest(req, true)
-	if err != nil {
-		fmt.Printf("%v#v\n", req)
-	} else {
-		fmt.Printf("%s\n", dumpReq)
-	}
+	// The request body isn't logged, as it may carry an
+	// authentication token.
+	log.FromContext(req.Context()).Error(msg, "status", status, "code", code, "detail", ad,
+		"remote", req.RemoteAddr, "method", req.Method, "url", req.URL.String())
 
 	res := api.NewErrorResponse(msg, code)
 	w.WriteHeader(status)
@@ -126,8 +130,10 @@ func dispatchRequest(w http.ResponseWriter, req *http.Request) {
 	if sigRequest.Label == "" {
 		sigRequest.Label = defaultLabel
 	}
+	sigRequest.RequestID = log.RequestID(req.Context())
+	sigRequest.Requester = api.Requester(req)
 
-	acl := whitelists[sigRequest.Label]
+	s, acl, ok := lookupSigner(sigRequest.Label)
 	if acl != nil {
 		ip, err := whitelist.HTTPRequestLookup(req)
 		if err != nil {
@@ -141,14 +147,14 @@ func dispatchRequest(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
-	s, ok := signers[sigRequest.Label]
 	if !ok {
 		fail(w, req, http.StatusBadRequest, 1, "bad request", "request is for non-existent label "+sigRequest.Label)
 		return
 	}
 
-	stats.Requests[sigRequest.Label].Counter.Inc(1)
-	stats.Requests[sigRequest.Label].Rate.Mark(1)
+	st := requestStats(sigRequest.Label)
+	st.Counter.Inc(1)
+	st.Rate.Mark(1)
 
 	// Sanity checks to ensure that we have a valid policy. This
 	// should have been checked in NewAuthSignHandler.
@@ -177,7 +183,12 @@ func dispatchRequest(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// The remote address is taken from the connection, so that
+	// IP-bound tokens only verify for the address they were made for.
+	authReq.RemoteAddress, _ = whitelist.HTTPRequestLookup(req)
+
 	if !profile.Provider.Verify(&authReq) {
+		api.AuditDenied(req, audit.OpSign, sigRequest.Profile, "invalid token")
 		fail(w, req, http.StatusBadRequest, 1, "invalid token", "received authenticated request with invalid token")
 		return
 	}
@@ -187,6 +198,20 @@ func dispatchRequest(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if profile.ClientCertAuth != nil {
+		names, err := signer.RequestedNames(sigRequest)
+		if err != nil {
+			fail(w, req, http.StatusBadRequest, 1, "invalid request", err.Error())
+			return
+		}
+
+		if err = profile.ClientCertAuth.Authorize(req.TLS, names); err != nil {
+			api.AuditDenied(req, audit.OpSign, sigRequest.Profile, "client certificate not authorised: "+err.Error())
+			fail(w, req, http.StatusForbidden, 1, "not authorised", err.Error())
+			return
+		}
+	}
+
 	cert, err := s.Sign(sigRequest)
 	if err != nil {
 		fail(w, req, http.StatusBadRequest, 1, "bad request", "signature failed: "+err.Error())
@@ -198,14 +223,15 @@ func dispatchRequest(w http.ResponseWriter, req *http.Request) {
 		fail(w, req, http.StatusInternalServerError, 1, "bad certificate", err.Error())
 	}
 
-	log.Infof("signature: requester=%s, label=%s, profile=%s, serialno=%s",
-		req.RemoteAddr, sigRequest.Label, sigRequest.Profile, x509Cert.SerialNumber)
+	logger := log.FromContext(req.Context())
+	logger.Info("signature", "requester", req.RemoteAddr, "label", sigRequest.Label,
+		"profile", sigRequest.Profile, "serial", x509Cert.SerialNumber)
 
 	res := api.NewSuccessResponse(&SignatureResponse{Certificate: string(cert)})
 	jenc := json.NewEncoder(w)
 	err = jenc.Encode(res)
 	if err != nil {
-		log.Errorf("error writing response: %v", err)
+		logger.Error("error writing response", "error", err)
 	}
 }
 
@@ -219,11 +245,13 @@ func dumpMetrics(w http.ResponseWriter, req *http.Request) {
 	var statsOut = struct {
 		Metrics metrics.Registry `json:"metrics"`
 		Signers []string         `json:"signers"`
-	}{stats.Registry, make([]string, 0, len(signers))}
+	}{stats.Registry, []string{}}
 
+	rootsLock.RLock()
 	for signer := range signers {
 		statsOut.Signers = append(statsOut.Signers, signer)
 	}
+	rootsLock.RUnlock()
 
 	out, err := json.Marshal(statsOut)
 	if err != nil {
@@ -7,9 +7,17 @@ import (
 	"flag"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
+	"github.com/bbandix/cfssl/api"
 	"github.com/bbandix/cfssl/api/info"
+	apimetrics "github.com/bbandix/cfssl/api/metrics"
+	"github.com/bbandix/cfssl/audit"
 	"github.com/bbandix/cfssl/cmd/multirootca/config"
+	"github.com/bbandix/cfssl/helpers"
 	"github.com/bbandix/cfssl/log"
 	"github.com/bbandix/cfssl/signer"
 	"github.com/bbandix/cfssl/signer/local"
@@ -33,45 +41,181 @@ func parseSigner(root *config.Root) (signer.Signer, error) {
 
 var (
 	defaultLabel string
-	signers      = map[string]signer.Signer{}
-	whitelists   = map[string]whitelist.NetACL{}
+
+	// rootsLock guards signers, whitelists, endpoints and
+	// infoHandler, which are replaced as a whole when the roots file
+	// is reloaded.
+	rootsLock   sync.RWMutex
+	signers     = map[string]signer.Signer{}
+	whitelists  = map[string]whitelist.NetACL{}
+	endpoints   = map[string]map[string]http.Handler{}
+	infoHandler http.Handler
 )
 
+// loadRoots parses the roots file and sets up a signer for each root.
+// The current signers are only replaced if all of the new ones could
+// be set up.
+func loadRoots(rootFile string) error {
+	roots, err := config.Parse(rootFile)
+	if err != nil {
+		return err
+	}
+
+	newSigners := map[string]signer.Signer{}
+	newWhitelists := map[string]whitelist.NetACL{}
+	newEndpoints := map[string]map[string]http.Handler{}
+	for label, root := range roots {
+		s, err := parseSigner(root)
+		if err != nil {
+			return errors.New("signer " + label + ": " + err.Error())
+		}
+		// The replay caches of authenticated profiles are
+		// carried over, so that requests accepted before a
+		// reload can't be replayed after it.
+		rootsLock.RLock()
+		prev := signers[label]
+		rootsLock.RUnlock()
+		if prev != nil {
+			root.Config.ShareReplayCaches(prev.Policy())
+		}
+
+		newSigners[label] = s
+		if root.ACL != nil {
+			newWhitelists[label] = root.ACL
+		}
+
+		newEndpoints[label], err = labelEndpoints(label, root, s)
+		if err != nil {
+			return errors.New("signer " + label + ": " + err.Error())
+		}
+		log.Info("loaded signer ", label)
+	}
+
+	newInfoHandler, err := info.NewMultiHandler(newSigners, defaultLabel)
+	if err != nil {
+		return err
+	}
+
+	rootsLock.Lock()
+	signers, whitelists, endpoints, infoHandler = newSigners, newWhitelists, newEndpoints, newInfoHandler
+	rootsLock.Unlock()
+
+	apimetrics.ResetCertificateExpiry()
+	for label, root := range roots {
+		apimetrics.SetCertificateExpiry("ca", label, root.Certificate)
+		if root.OCSPResponder != nil {
+			apimetrics.SetCertificateExpiry("responder", label, root.OCSPResponder)
+		}
+	}
+	return nil
+}
+
+// knownProfile reports whether any signer's policy has a profile, for
+// recording requests by profile.
+func knownProfile(profile string) bool {
+	rootsLock.RLock()
+	defer rootsLock.RUnlock()
+	for _, s := range signers {
+		if policy := s.Policy(); policy != nil && policy.Profiles[profile] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupSigner returns the signer for a label and its whitelist, which
+// is nil if all networks are permitted.
+func lookupSigner(label string) (signer.Signer, whitelist.NetACL, bool) {
+	rootsLock.RLock()
+	defer rootsLock.RUnlock()
+	s, ok := signers[label]
+	return s, whitelists[label], ok
+}
+
+// serveInfo passes info requests to the handler for the current signers.
+func serveInfo(w http.ResponseWriter, req *http.Request) {
+	rootsLock.RLock()
+	h := infoHandler
+	rootsLock.RUnlock()
+	h.ServeHTTP(w, req)
+}
+
+// reloadOnSignal reloads the roots file whenever the process receives
+// SIGHUP, keeping the current signers if it can't be loaded.
+func reloadOnSignal(rootFile string) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	for range sigs {
+		log.Info("received SIGHUP, reloading ", rootFile)
+		if err := loadRoots(rootFile); err != nil {
+			log.Errorf("keeping the current signers, failed to reload the roots: %v", err)
+			continue
+		}
+		log.Info("signers reloaded")
+	}
+}
+
+// handle registers the handler for an endpoint, giving each request an
+// ID for its log messages and recording metrics for it.
+func handle(pattern, endpoint string, h http.Handler, known apimetrics.ProfileFilter) {
+	http.Handle(pattern, api.RequestIDHandler(apimetrics.Instrument(endpoint, h, known)))
+}
+
 func main() {
 	flagAddr := flag.String("a", ":8888", "listening address")
 	flagRootFile := flag.String("roots", "", "configuration file specifying root keys")
 	flagDefaultLabel := flag.String("l", "", "specify a default label")
+	flagTLSCertFile := flag.String("tls-cert", "", "certificate for serving over TLS")
+	flagTLSKeyFile := flag.String("tls-key", "", "private key for serving over TLS")
+	flagMutualTLSCAFile := flag.String("mutual-tls-ca", "", "require clients to present a certificate issued by a CA in this file")
 	flag.IntVar(&log.Level, "loglevel", log.LevelInfo, "log level (0 = DEBUG, 4 = ERROR)")
+	flagLogFormat := flag.String("log-format", "text", "log format (text or json)")
+	flagSyslog := flag.Bool("syslog", false, "log to the system log instead of standard error")
+	flagAuditLog := flag.String("audit-log", "", "file to append a hash-chained audit record of each CA operation to")
+	flagAuditKey := flag.String("audit-key", "", "file holding the hex-encoded key for the audit log")
 	flag.Parse()
 
-	if *flagRootFile == "" {
-		log.Fatal("no root file specified")
-	}
-
-	roots, err := config.Parse(*flagRootFile)
-	if err != nil {
+	var err error
+	if log.Format, err = log.ParseFormat(*flagLogFormat); err != nil {
 		log.Fatalf("%v", err)
 	}
+	if *flagSyslog {
+		sink, err := log.NewSyslogSink("multirootca")
+		if err != nil {
+			log.Fatalf("failed to open the system log: %v", err)
+		}
+		log.SetSink(sink)
+	}
 
-	for label, root := range roots {
-		s, err := parseSigner(root)
+	if *flagAuditLog != "" {
+		if *flagAuditKey == "" {
+			log.Fatal("-audit-log requires an -audit-key")
+		}
+		key, err := audit.LoadKey(*flagAuditKey)
 		if err != nil {
-			log.Criticalf("%v", err)
+			log.Fatalf("failed to load the audit key: %v", err)
 		}
-		signers[label] = s
-		if root.ACL != nil {
-			whitelists[label] = root.ACL
+		store, err := audit.OpenFile(*flagAuditLog)
+		if err != nil {
+			log.Fatalf("failed to open the audit log: %v", err)
 		}
-		log.Info("loaded signer ", label)
+		l, err := audit.NewLog(store, key)
+		if err != nil {
+			log.Fatalf("failed to read the audit log: %v", err)
+		}
+		audit.SetLog(l)
 	}
 
-	defaultLabel = *flagDefaultLabel
-	initStats()
+	if *flagRootFile == "" {
+		log.Fatal("no root file specified")
+	}
 
-	infoHandler, err := info.NewMultiHandler(signers, defaultLabel)
-	if err != nil {
-		log.Criticalf("%v", err)
+	defaultLabel = *flagDefaultLabel
+	if err := loadRoots(*flagRootFile); err != nil {
+		log.Fatalf("%v", err)
 	}
+	initStats()
+	go reloadOnSignal(*flagRootFile)
 
 	var localhost = whitelist.NewBasic()
 	localhost.Add(net.ParseIP("127.0.0.1"))
@@ -80,10 +224,36 @@ func main() {
 	if err != nil {
 		log.Criticalf("failed to set up the metrics whitelist: %v", err)
 	}
+	promMetrics, err := apimetrics.NewWhitelistedHandler("")
+	if err != nil {
+		log.Criticalf("failed to set up the metrics whitelist: %v", err)
+	}
 
-	http.HandleFunc("/api/v1/cfssl/authsign", dispatchRequest)
-	http.Handle("/api/v1/cfssl/info", infoHandler)
+	crl := dispatchPath("crl", "/api/v1/cfssl/crl")
+	handle("/api/v1/cfssl/authsign", "authsign", http.HandlerFunc(dispatchRequest), knownProfile)
+	handle("/api/v1/cfssl/info", "info", http.HandlerFunc(serveInfo), knownProfile)
+	handle("/api/v1/cfssl/sign", "sign", dispatchJSON("sign"), knownProfile)
+	handle("/api/v1/cfssl/bundle", "bundle", dispatchJSON("bundle"), nil)
+	handle("/api/v1/cfssl/ocspsign", "ocspsign", dispatchJSON("ocspsign"), nil)
+	handle("/api/v1/cfssl/crl", "crl", crl, nil)
+	handle("/api/v1/cfssl/crl/", "crl", crl, nil)
+	handle("/ocsp/", "ocsp", dispatchPath("ocsp", "/ocsp"), nil)
 	http.Handle("/api/v1/cfssl/metrics", metrics)
-	log.Info("listening on ", *flagAddr)
-	log.Error(http.ListenAndServe(*flagAddr, nil))
+	http.Handle("/metrics", promMetrics)
+	if *flagTLSCertFile == "" && *flagTLSKeyFile == "" {
+		if *flagMutualTLSCAFile != "" {
+			log.Fatal("mutual TLS requires a TLS certificate and key")
+		}
+		log.Info("listening on ", *flagAddr)
+		log.Error(http.ListenAndServe(*flagAddr, nil))
+		return
+	}
+
+	tlsConfig, err := helpers.ServerTLSConfig(*flagTLSCertFile, *flagTLSKeyFile, *flagMutualTLSCAFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	server := &http.Server{Addr: *flagAddr, TLSConfig: tlsConfig}
+	log.Info("listening on https://", *flagAddr)
+	log.Error(server.ListenAndServeTLS("", ""))
 }
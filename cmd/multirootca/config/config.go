@@ -13,12 +13,15 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bbandix/cfssl/config"
 	"github.com/bbandix/cfssl/helpers"
 	"github.com/bbandix/cfssl/helpers/derhelpers"
 	"github.com/bbandix/cfssl/log"
+	"github.com/bbandix/cfssl/ocsp"
 	"github.com/bbandix/cfssl/whitelist"
 
 	"github.com/cloudflare/redoctober/client"
@@ -102,6 +105,165 @@ type Root struct {
 	Certificate *x509.Certificate
 	Config      *config.Signing
 	ACL         whitelist.NetACL
+
+	// Sign enables the unauthenticated sign endpoint for the root,
+	// and OCSPSign the ocspsign endpoint. Both sign without
+	// authentication, so they are only enabled when asked for.
+	Sign     bool
+	OCSPSign bool
+
+	// The remaining fields are optional; each one enables the
+	// corresponding endpoint for the root.
+	CABundleFile  string
+	IntBundleFile string
+	OCSPSigner    ocsp.Signer
+	OCSPResponder *x509.Certificate
+	OCSPResponses ocsp.Source
+	CRLSerialFile string
+	CRLExpiry     time.Duration
+}
+
+const (
+	// DefaultOCSPInterval is the default interval between OCSP
+	// updates, used to set the next update time of OCSP responses.
+	DefaultOCSPInterval = 4 * helpers.OneDay
+
+	// DefaultCRLExpiry is the default time for which a CRL is valid.
+	DefaultCRLExpiry = 7 * helpers.OneDay
+)
+
+// An endpointSpec gives the settings for the optional endpoints of a
+// root.
+type endpointSpec struct {
+	Sign      bool     `json:"sign"`
+	CABundle  string   `json:"ca_bundle"`
+	IntBundle string   `json:"int_bundle"`
+	OCSP      ocspSpec `json:"ocsp"`
+	CRL       crlSpec  `json:"crl"`
+}
+
+// An ocspSpec gives the OCSP responder settings of a root.
+type ocspSpec struct {
+	Sign         bool   `json:"sign"`
+	Responder    string `json:"responder"`
+	ResponderKey string `json:"responder_key"`
+	Interval     string `json:"interval"`
+	Responses    string `json:"responses"`
+}
+
+// A crlSpec gives the CRL settings of a root.
+type crlSpec struct {
+	Serials string `json:"serials"`
+	Expiry  string `json:"expiry"`
+}
+
+// iniEndpoints reads the optional endpoint entries of an INI section.
+func iniEndpoints(entries map[string]string) (spec endpointSpec, err error) {
+	if spec.Sign, err = parseBool("sign", entries["sign"]); err != nil {
+		return
+	}
+	if spec.OCSP.Sign, err = parseBool("ocsp_sign", entries["ocsp_sign"]); err != nil {
+		return
+	}
+
+	spec.CABundle = entries["ca_bundle"]
+	spec.IntBundle = entries["int_bundle"]
+	spec.OCSP.Responder = entries["ocsp_responder"]
+	spec.OCSP.ResponderKey = entries["ocsp_responder_key"]
+	spec.OCSP.Interval = entries["ocsp_interval"]
+	spec.OCSP.Responses = entries["ocsp_responses"]
+	spec.CRL.Serials = entries["crl_serials"]
+	spec.CRL.Expiry = entries["crl_expiry"]
+	return
+}
+
+// parseBool parses an optional boolean entry, which is false if it is
+// empty.
+func parseBool(name, val string) (bool, error) {
+	if val == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, errors.New("config: invalid " + name + ": " + val)
+	}
+	return b, nil
+}
+
+// parseDuration parses an optional duration, returning def if it is
+// empty.
+func parseDuration(name, val string, def time.Duration) (time.Duration, error) {
+	if val == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, errors.New("config: invalid " + name + ": " + err.Error())
+	}
+	return d, nil
+}
+
+// parseEndpoints sets up the sign, bundle, OCSP and CRL endpoints of a
+// root.
+func parseEndpoints(root *Root, spec endpointSpec) (err error) {
+	root.Sign = spec.Sign
+	root.CABundleFile = spec.CABundle
+	root.IntBundleFile = spec.IntBundle
+	if root.IntBundleFile != "" && root.CABundleFile == "" {
+		return ErrMissingCABundle
+	}
+
+	if spec.OCSP.Responder != "" || spec.OCSP.ResponderKey != "" {
+		if spec.OCSP.Responder == "" || spec.OCSP.ResponderKey == "" {
+			return ErrIncompleteOCSPResponder
+		}
+
+		interval, err := parseDuration("OCSP interval", spec.OCSP.Interval, DefaultOCSPInterval)
+		if err != nil {
+			return err
+		}
+
+		in, err := ioutil.ReadFile(spec.OCSP.Responder)
+		if err != nil {
+			return err
+		}
+		responderCert, err := helpers.ParseCertificatePEM(in)
+		if err != nil {
+			return err
+		}
+
+		in, err = ioutil.ReadFile(spec.OCSP.ResponderKey)
+		if err != nil {
+			return err
+		}
+		key, err := helpers.ParsePrivateKeyPEM(in)
+		if err != nil {
+			return err
+		}
+
+		root.OCSPSigner, err = ocsp.NewSigner(root.Certificate, responderCert, key, interval)
+		if err != nil {
+			return err
+		}
+		root.OCSPResponder = responderCert
+	}
+	if spec.OCSP.Sign && root.OCSPSigner == nil {
+		return ErrMissingOCSPResponder
+	}
+	root.OCSPSign = spec.OCSP.Sign
+
+	if spec.OCSP.Responses != "" {
+		root.OCSPResponses, err = ocsp.NewSourceFromFile(spec.OCSP.Responses)
+		if err != nil {
+			return err
+		}
+	}
+
+	root.CRLSerialFile = spec.CRL.Serials
+	root.CRLExpiry, err = parseDuration("CRL expiry", spec.CRL.Expiry, DefaultCRLExpiry)
+	return err
 }
 
 // ErrUnsupportedScheme indicates a private key scheme that is not currently supported.
@@ -223,10 +385,36 @@ var (
 
 	// ErrInvalidConfig indicates the configuration is invalid.
 	ErrInvalidConfig = errors.New("config: invalid configuration")
+
+	// ErrMissingCABundle indicates that an intermediate bundle was
+	// given without a CA bundle to verify it against.
+	ErrMissingCABundle = errors.New("config: root has an intermediate bundle but no CA bundle")
+
+	// ErrIncompleteOCSPResponder indicates that only one of the OCSP
+	// responder certificate and key was given.
+	ErrIncompleteOCSPResponder = errors.New("config: OCSP responder needs both a certificate and a key")
+
+	// ErrMissingOCSPResponder indicates that OCSP signing was
+	// enabled without an OCSP responder to sign with.
+	ErrMissingOCSPResponder = errors.New("config: OCSP signing needs an OCSP responder")
 )
 
-// Parse loads a RootList from a file.
+// Parse loads a RootList from a file. Files with a .json, .yaml or
+// .yml extension are read in the structured format of rootsFile; any
+// other file is read as an INI-style file.
 func Parse(filename string) (RootList, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return parseStructured(filename, false)
+	case ".yaml", ".yml":
+		return parseStructured(filename, true)
+	default:
+		return parseINI(filename)
+	}
+}
+
+// parseINI loads a RootList from an INI-style file.
+func parseINI(filename string) (RootList, error) {
 	cfgMap, err := parseFile(filename)
 	if err != nil {
 		return nil, err
@@ -282,6 +470,14 @@ func Parse(filename string) (RootList, error) {
 			}
 		}
 
+		endpoints, err := iniEndpoints(entries)
+		if err != nil {
+			return nil, err
+		}
+		if err = parseEndpoints(&root, endpoints); err != nil {
+			return nil, err
+		}
+
 		rootList[label] = &root
 	}
 
@@ -2,8 +2,12 @@ package config
 
 import (
 	"crypto/rsa"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/bbandix/cfssl/log"
 )
@@ -148,6 +152,11 @@ func TestLoadBadRootConfs(t *testing.T) {
 		"testdata/roots_missing_certificate_entry.conf",
 		"testdata/roots_missing_private_key.conf",
 		"testdata/roots_missing_private_key_entry.conf",
+		"testdata/roots_bad_ocsp_responder.conf",
+		"testdata/roots_bad_crl_expiry.conf",
+		"testdata/roots_missing_ca_bundle.conf",
+		"testdata/roots_bad_sign.conf",
+		"testdata/roots_ocsp_sign_no_responder.conf",
 	}
 
 	for _, cf := range confs {
@@ -159,6 +168,38 @@ func TestLoadBadRootConfs(t *testing.T) {
 	}
 }
 
+func TestLoadEndpoints(t *testing.T) {
+	roots, err := Parse("testdata/roots_endpoints.conf")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	primary := roots["primary"]
+	if primary.CABundleFile != "testdata/server.crt" || primary.IntBundleFile != "" {
+		t.Fatal("bundle files were not loaded")
+	}
+	if primary.OCSPSigner == nil {
+		t.Fatal("expected an OCSP signer for the primary root")
+	}
+	if !primary.Sign || !primary.OCSPSign {
+		t.Fatal("expected the sign and ocspsign endpoints to be enabled for the primary root")
+	}
+	if primary.OCSPResponses == nil {
+		t.Fatal("expected OCSP responses for the primary root")
+	}
+	if primary.CRLSerialFile != "testdata/crl_serials.txt" || primary.CRLExpiry != 48*time.Hour {
+		t.Fatal("CRL settings were not loaded")
+	}
+
+	backup := roots["backup"]
+	if backup.Sign || backup.OCSPSign || backup.CABundleFile != "" || backup.OCSPSigner != nil || backup.OCSPResponses != nil || backup.CRLSerialFile != "" {
+		t.Fatal("expected no optional endpoints for the backup root")
+	}
+	if backup.CRLExpiry != DefaultCRLExpiry {
+		t.Fatalf("expected the default CRL expiry, have %v", backup.CRLExpiry)
+	}
+}
+
 const confWhitelist = "testdata/roots_whitelist.conf"
 
 func TestLoadWhitelist(t *testing.T) {
@@ -252,3 +293,79 @@ func TestLoadIPv6Whitelist(t *testing.T) {
 		}
 	}
 }
+
+func TestLoadStructuredRoots(t *testing.T) {
+	for _, file := range []string{"testdata/roots.json", "testdata/roots.yaml"} {
+		roots, err := Parse(file)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+
+		if len(roots) != 2 {
+			t.Fatalf("%s: expected 2 roots, have %d", file, len(roots))
+		}
+
+		primary := roots["primary"]
+		if _, ok := primary.PrivateKey.(*rsa.PrivateKey); !ok {
+			t.Fatalf("%s: expected an RSA private key", file)
+		}
+		if primary.ACL == nil || !primary.ACL.Permitted([]byte{192, 168, 3, 15}) || primary.ACL.Permitted([]byte{192, 168, 3, 16}) {
+			t.Fatalf("%s: the primary root has the wrong ACL", file)
+		}
+		if primary.Config.Profiles["client_auth"] == nil {
+			t.Fatalf("%s: the primary root's configuration was not loaded", file)
+		}
+		if primary.Sign || !primary.OCSPSign || primary.OCSPSigner == nil || primary.CRLExpiry != 48*time.Hour {
+			t.Fatalf("%s: the primary root's endpoints were not loaded", file)
+		}
+
+		backup := roots["backup"]
+		if backup.ACL != nil {
+			t.Fatalf("%s: expected a nil ACL for the backup root", file)
+		}
+		if !backup.Sign || backup.Config == nil || backup.Config.Default.Expiry != 168*time.Hour {
+			t.Fatalf("%s: the backup root's inline configuration was not loaded", file)
+		}
+	}
+}
+
+func TestLoadBadStructuredRoots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "multirootca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := `"private_key": "file://testdata/server.key", "certificate": "testdata/server.crt"`
+	tests := []struct {
+		file, content, msg string
+	}{
+		{"empty.json", `{}`, "config: no roots are defined"},
+		{"unknown.json", `{"roots": {"primary": {"private_key": "x", "certficate": "y"}}}`, `unknown field "certficate"`},
+		{"nokey.json", `{"roots": {"primary": {"certificate": "testdata/server.crt"}}}`, "config: root primary: private_key: required field is missing"},
+		{"nocert.json", `{"roots": {"primary": {"private_key": "file://testdata/server.key"}}}`, "config: root primary: certificate: required field is missing"},
+		{"noconfig.json", `{"roots": {"primary": {` + root + `}}}`, "config: root primary: config: required field is missing"},
+		{"badconfig.json", `{"roots": {"primary": {` + root + `, "config": 3}}}`, "config: root primary: config: must be a path or a configuration object"},
+		{"nosigning.json", `{"roots": {"primary": {` + root + `, "config": {}}}}`, "no signing policy given"},
+		{"badnets.json", `{"roots": {"primary": {` + root + `, "config": "testdata/config.json", "nets": ["10.0.0.0/8", "10.0.0.1"]}}}`, "config: root primary: nets[1]: invalid CIDR address: 10.0.0.1"},
+		{"badocsp.json", `{"roots": {"primary": {` + root + `, "config": "testdata/config.json", "ocsp": {"responder": "testdata/server.crt"}}}}`, "config: root primary: OCSP responder needs both a certificate and a key"},
+		{"ocspsign.json", `{"roots": {"primary": {` + root + `, "config": "testdata/config.json", "ocsp": {"sign": true}}}}`, "config: root primary: OCSP signing needs an OCSP responder"},
+		{"badcrl.yaml", "roots:\n  primary:\n    private_key: file://testdata/server.key\n    certificate: testdata/server.crt\n    config: testdata/config.json\n    crl:\n      expiry: a week\n", "config: root primary: invalid CRL expiry"},
+		{"bad.yaml", "roots: [", "config: invalid YAML"},
+	}
+
+	for _, test := range tests {
+		file := filepath.Join(dir, test.file)
+		if err = ioutil.WriteFile(file, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = Parse(file)
+		if err == nil {
+			t.Fatalf("expected %s to fail", test.file)
+		}
+		if !strings.Contains(err.Error(), test.msg) {
+			t.Fatalf("%s: expected an error containing %q, have %q", test.file, test.msg, err)
+		}
+	}
+}
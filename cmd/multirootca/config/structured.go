@@ -0,0 +1,257 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/bbandix/cfssl/config"
+	"github.com/bbandix/cfssl/helpers"
+
+	"gopkg.in/yaml.v2"
+)
+
+// A rootsFile is the structured form of a roots file, in JSON or YAML.
+// For example, in JSON:
+//
+//	{
+//	    "roots": {
+//	        "primary": {
+//	            "private_key": "file://testdata/server.key",
+//	            "certificate": "testdata/server.crt",
+//	            "config": "testdata/config.json",
+//	            "nets": ["10.0.2.1/24", "192.168.3.15/32"],
+//	            "ocsp": {
+//	                "responder": "testdata/responder.crt",
+//	                "responder_key": "testdata/responder.key"
+//	            }
+//	        }
+//	    }
+//	}
+//
+// A root's config is either the path to a cfssl configuration file or
+// the configuration itself, inline.
+type rootsFile struct {
+	Roots map[string]*rootSpec `json:"roots"`
+}
+
+// A rootSpec is the structured form of a root.
+type rootSpec struct {
+	PrivateKey  string          `json:"private_key"`
+	RedOctober  *redOctoberSpec `json:"red_october"`
+	Certificate string          `json:"certificate"`
+	Config      json.RawMessage `json:"config"`
+	Nets        []string        `json:"nets"`
+	endpointSpec
+}
+
+// A redOctoberSpec gives the Red October server used to decrypt a
+// private key with the "rofile" scheme.
+type redOctoberSpec struct {
+	Server   string `json:"server"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	CA       string `json:"ca"`
+}
+
+// errRequired indicates that a required field of a root is missing.
+var errRequired = errors.New("required field is missing")
+
+// rootError describes an error in a field of a root in a structured
+// roots file.
+func rootError(label, field string, err error) error {
+	msg := strings.TrimPrefix(err.Error(), "config: ")
+	if field == "" {
+		return fmt.Errorf("config: root %s: %s", label, msg)
+	}
+	return fmt.Errorf("config: root %s: %s: %s", label, field, msg)
+}
+
+// parseStructured loads a RootList from a JSON file, or a YAML file if
+// isYAML is set. Unknown fields are rejected, so that misspelt
+// settings aren't silently ignored.
+func parseStructured(filename string, isYAML bool) (RootList, error) {
+	in, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if isYAML {
+		in, err = yamlToJSON(in)
+		if err != nil {
+			return nil, errors.New("config: invalid YAML: " + err.Error())
+		}
+	}
+
+	var file rootsFile
+	dec := json.NewDecoder(bytes.NewReader(in))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&file); err != nil {
+		return nil, errors.New("config: invalid roots file: " + err.Error())
+	}
+
+	if len(file.Roots) == 0 {
+		return nil, errors.New("config: no roots are defined")
+	}
+
+	// Roots are loaded in order of their labels, so that the same
+	// error is reported for a file every time.
+	labels := make([]string, 0, len(file.Roots))
+	for label := range file.Roots {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	var rootList = RootList{}
+	for _, label := range labels {
+		spec := file.Roots[label]
+		if spec == nil {
+			return nil, rootError(label, "", errors.New("root is empty"))
+		}
+
+		rootList[label], err = spec.root(label)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rootList, nil
+}
+
+// root loads the keys, certificates and configuration of a root.
+func (spec *rootSpec) root(label string) (*Root, error) {
+	var root Root
+	var err error
+
+	if spec.PrivateKey == "" {
+		return nil, rootError(label, "private_key", errRequired)
+	}
+	var roEntries map[string]string
+	if spec.RedOctober != nil {
+		roEntries = map[string]string{
+			"ro_server": spec.RedOctober.Server,
+			"ro_user":   spec.RedOctober.User,
+			"ro_pass":   spec.RedOctober.Password,
+			"ro_ca":     spec.RedOctober.CA,
+		}
+	}
+	root.PrivateKey, err = parsePrivateKeySpec(spec.PrivateKey, roEntries)
+	if err != nil {
+		return nil, rootError(label, "private_key", err)
+	}
+
+	if spec.Certificate == "" {
+		return nil, rootError(label, "certificate", errRequired)
+	}
+	in, err := ioutil.ReadFile(spec.Certificate)
+	if err != nil {
+		return nil, rootError(label, "certificate", err)
+	}
+	root.Certificate, err = helpers.ParseCertificatePEM(in)
+	if err != nil {
+		return nil, rootError(label, "certificate", err)
+	}
+
+	conf, err := spec.loadConfig()
+	if err != nil {
+		return nil, rootError(label, "config", err)
+	}
+	root.Config = conf.Signing
+
+	for i, n := range spec.Nets {
+		if _, _, err = net.ParseCIDR(strings.TrimSpace(n)); err != nil {
+			return nil, rootError(label, fmt.Sprintf("nets[%d]", i), err)
+		}
+	}
+	if len(spec.Nets) > 0 {
+		root.ACL, err = parseACL(strings.Join(spec.Nets, ","))
+		if err != nil {
+			return nil, rootError(label, "nets", err)
+		}
+	}
+
+	if err = parseEndpoints(&root, spec.endpointSpec); err != nil {
+		return nil, rootError(label, "", err)
+	}
+
+	return &root, nil
+}
+
+// loadConfig loads the root's cfssl configuration, which is given
+// either as a path or inline.
+func (spec *rootSpec) loadConfig() (*config.Config, error) {
+	raw := bytes.TrimSpace(spec.Config)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, errRequired
+	}
+
+	var conf *config.Config
+	var err error
+	switch raw[0] {
+	case '"':
+		var path string
+		if err = json.Unmarshal(raw, &path); err != nil {
+			return nil, err
+		}
+		conf, err = config.LoadFile(path)
+	case '{':
+		conf, err = config.LoadConfig(raw)
+	default:
+		return nil, errors.New("must be a path or a configuration object")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// yamlToJSON converts a YAML document to JSON, so that it can be
+// decoded like a JSON roots file.
+func yamlToJSON(in []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(in, &v); err != nil {
+		return nil, err
+	}
+
+	v, err := jsonValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// jsonValue converts a value decoded from YAML, whose maps may have
+// keys of any type, to one that can be encoded as JSON.
+func jsonValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("key %v is not a string", k)
+			}
+
+			var err error
+			if m[key], err = jsonValue(val); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []interface{}:
+		for i := range v {
+			var err error
+			if v[i], err = jsonValue(v[i]); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
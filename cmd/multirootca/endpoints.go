@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/bbandix/cfssl/api/bundle"
+	apicrl "github.com/bbandix/cfssl/api/crl"
+	apiocsp "github.com/bbandix/cfssl/api/ocsp"
+	apisign "github.com/bbandix/cfssl/api/sign"
+	"github.com/bbandix/cfssl/cmd/multirootca/config"
+	"github.com/bbandix/cfssl/crl"
+	"github.com/bbandix/cfssl/ocsp"
+	"github.com/bbandix/cfssl/signer"
+	"github.com/bbandix/cfssl/whitelist"
+)
+
+// whitelistedEndpoints are the endpoints that issue signatures, and so
+// are restricted to the networks whitelisted for a label. The others
+// serve public information.
+var whitelistedEndpoints = map[string]bool{
+	"sign":     true,
+	"ocspsign": true,
+}
+
+// labelEndpoints sets up the handlers for the endpoints a root enables,
+// keyed by endpoint name.
+func labelEndpoints(label string, root *config.Root, s signer.Signer) (map[string]http.Handler, error) {
+	handlers := map[string]http.Handler{}
+
+	// Unauthenticated signing is only available if the root asks
+	// for it, and its policy configures a profile without an
+	// authentication provider.
+	if root.Sign {
+		h, err := apisign.NewHandlerFromSigner(s)
+		if err != nil {
+			return nil, fmt.Errorf("sign endpoint for %s needs a profile without an auth_key: %v", label, err)
+		}
+		handlers["sign"] = h
+	}
+
+	if root.CABundleFile != "" {
+		h, err := bundle.NewHandler(root.CABundleFile, root.IntBundleFile)
+		if err != nil {
+			return nil, err
+		}
+		handlers["bundle"] = h
+	}
+
+	if root.OCSPSign {
+		handlers["ocspsign"] = apiocsp.NewHandler(root.OCSPSigner)
+	}
+
+	if root.OCSPResponses != nil {
+		handlers["ocsp"] = ocsp.Responder{Source: root.OCSPResponses}
+	}
+
+	if root.CRLSerialFile != "" {
+		if err := crl.CheckIssuer(root.Certificate); err != nil {
+			return nil, fmt.Errorf("crl endpoint for %s: %v", label, err)
+		}
+		handlers["crl"] = apicrl.NewHandler(root.Certificate, root.PrivateKey, root.CRLSerialFile, root.CRLExpiry)
+	}
+
+	return handlers, nil
+}
+
+// lookupEndpoint returns the handler of an endpoint for a label, and
+// the label's whitelist.
+func lookupEndpoint(endpoint, label string) (http.Handler, whitelist.NetACL, bool) {
+	rootsLock.RLock()
+	defer rootsLock.RUnlock()
+	h, ok := endpoints[label][endpoint]
+	return h, whitelists[label], ok
+}
+
+// serveLabel passes a request to an endpoint's handler for the label,
+// or for the default label if it is empty.
+func serveLabel(w http.ResponseWriter, req *http.Request, endpoint, label string) {
+	incRequests()
+
+	if label == "" {
+		label = defaultLabel
+	}
+
+	h, acl, ok := lookupEndpoint(endpoint, label)
+	if !ok {
+		fail(w, req, http.StatusNotFound, 1, "not found", endpoint+" is not enabled for label "+label)
+		return
+	}
+
+	if acl != nil && whitelistedEndpoints[endpoint] {
+		ip, err := whitelist.HTTPRequestLookup(req)
+		if err != nil {
+			fail(w, req, http.StatusInternalServerError, 1, err.Error(), "while getting request IP")
+			return
+		}
+
+		if !acl.Permitted(ip) {
+			fail(w, req, http.StatusForbidden, 1, "not authorised", "because IP is not whitelisted")
+			return
+		}
+	}
+
+	st := requestStats(label)
+	st.Counter.Inc(1)
+	st.Rate.Mark(1)
+
+	h.ServeHTTP(w, req)
+}
+
+// dispatchJSON returns a handler for an endpoint that takes JSON
+// requests, which name their label in the "label" field.
+func dispatchJSON(endpoint string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			fail(w, req, http.StatusInternalServerError, 1, err.Error(), "while reading request body")
+			return
+		}
+		req.Body.Close()
+
+		// Malformed requests are left for the endpoint's handler
+		// to reject.
+		var labelReq struct {
+			Label string `json:"label"`
+		}
+		json.Unmarshal(body, &labelReq)
+
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		serveLabel(w, req, endpoint, labelReq.Label)
+	}
+}
+
+// dispatchPath returns a handler for an endpoint served under prefix,
+// where the label is the first element of the path after the prefix.
+// The rest of the path is passed on to the endpoint's handler.
+func dispatchPath(endpoint, prefix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		label := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, prefix), "/")
+		rest := ""
+		if i := strings.Index(label, "/"); i >= 0 {
+			label, rest = label[:i], label[i+1:]
+		}
+
+		r := *req
+		u := *req.URL
+		u.Path = rest
+		r.URL = &u
+		serveLabel(w, &r, endpoint, label)
+	}
+}
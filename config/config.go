@@ -2,6 +2,8 @@
 package config
 
 import (
+	"crypto"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/asn1"
 	"encoding/json"
@@ -13,6 +15,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/bbandix/cfssl/api/client"
 	"github.com/bbandix/cfssl/auth"
 	cferr "github.com/bbandix/cfssl/errors"
 	"github.com/bbandix/cfssl/helpers"
@@ -59,6 +62,88 @@ type AuthRemote struct {
 	AuthKeyName string `json:"auth_key"`
 }
 
+// RemoteOptions tune how a remote is used: the strategy used to pick
+// one of its servers, the timeout of each request to a server, the
+// number of servers tried per request and, for the health_checked
+// strategy, the interval between health probes. If any of the TLS
+// files are given, the remote is reached over HTTPS, verifying it
+// against the CAs in TLSRemoteCAFile (or the system roots) and
+// presenting the client certificate in TLSCertFile and TLSKeyFile.
+type RemoteOptions struct {
+	Strategy                  string `json:"strategy"`
+	TimeoutString             string `json:"timeout"`
+	MaxAttempts               int    `json:"max_attempts"`
+	HealthCheckIntervalString string `json:"health_check_interval"`
+	TLSRemoteCAFile           string `json:"tls_remote_ca"`
+	TLSCertFile               string `json:"tls_client_cert"`
+	TLSKeyFile                string `json:"tls_client_key"`
+
+	Timeout             time.Duration `json:"-"`
+	HealthCheckInterval time.Duration `json:"-"`
+	TLSConfig           *tls.Config   `json:"-"`
+}
+
+// populate parses the durations in the options and checks the
+// strategy.
+func (o *RemoteOptions) populate() error {
+	if o.Strategy != "" && client.StrategyFromString(o.Strategy) == client.StrategyInvalid {
+		return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
+			errors.New("unknown remote strategy "+o.Strategy))
+	}
+
+	if o.MaxAttempts < 0 {
+		return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
+			errors.New("max_attempts can't be negative"))
+	}
+
+	var err error
+	if o.TimeoutString != "" {
+		o.Timeout, err = time.ParseDuration(o.TimeoutString)
+		if err != nil {
+			return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy, err)
+		}
+	}
+
+	if o.HealthCheckIntervalString != "" {
+		o.HealthCheckInterval, err = time.ParseDuration(o.HealthCheckIntervalString)
+		if err != nil {
+			return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy, err)
+		}
+	}
+
+	if o.TLSRemoteCAFile != "" || o.TLSCertFile != "" || o.TLSKeyFile != "" {
+		var remoteCAs *x509.CertPool
+		if o.TLSRemoteCAFile != "" {
+			remoteCAs, err = helpers.LoadPEMCertPool(o.TLSRemoteCAFile)
+			if err != nil {
+				return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy, err)
+			}
+		}
+
+		cert, err := helpers.LoadClientCertificate(o.TLSCertFile, o.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		o.TLSConfig = helpers.CreateTLSConfig(remoteCAs, cert)
+	}
+	return nil
+}
+
+// ClientOptions returns the options in the form used by the API
+// client. Nil options give the client defaults.
+func (o *RemoteOptions) ClientOptions() client.Options {
+	if o == nil {
+		return client.Options{}
+	}
+	return client.Options{
+		Strategy:            client.StrategyFromString(o.Strategy),
+		Timeout:             o.Timeout,
+		MaxAttempts:         o.MaxAttempts,
+		HealthCheckInterval: o.HealthCheckInterval,
+		TLSConfig:           o.TLSConfig,
+	}
+}
+
 // A SigningProfile stores information that the CA needs to store
 // signature policy.
 type SigningProfile struct {
@@ -76,6 +161,10 @@ type SigningProfile struct {
 	NotAfter            time.Time  `json:"not_after"`
 	NameWhitelistString string     `json:"name_whitelist"`
 	AuthRemote          AuthRemote `json:"auth_remote"`
+	RenewalProof        bool       `json:"renewal_proof"`
+
+	ClientCertAuth *auth.ClientCertPolicy `json:"client_cert_auth"`
+	AuthKeyNames   []string               `json:"auth_keys"`
 
 	Policies                    []CertificatePolicy
 	Expiry                      time.Duration
@@ -83,9 +172,14 @@ type SigningProfile struct {
 	Provider                    auth.Provider
 	RemoteProvider              auth.Provider
 	RemoteServer                string
+	RemoteOptions               *RemoteOptions
 	CSRWhitelist                *CSRWhitelist
 	NameWhitelist               *regexp.Regexp
 	ClientProvidesSerialNumbers bool
+
+	// Implicit is set on the default profile that LoadConfig
+	// supplies when a configuration doesn't give one.
+	Implicit bool `json:"-"`
 }
 
 // UnmarshalJSON unmarshals a JSON string into an OID.
@@ -196,6 +290,7 @@ func (p *SigningProfile) populate(cfg *Config) error {
 			if err := p.updateRemote(remote); err != nil {
 				return err
 			}
+			p.RemoteOptions = cfg.RemoteOptions[p.RemoteName]
 		} else {
 			return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
 				errors.New("failed to find remote in remotes section"))
@@ -206,51 +301,37 @@ func (p *SigningProfile) populate(cfg *Config) error {
 			if err := p.updateRemote(remote); err != nil {
 				return err
 			}
+			p.RemoteOptions = cfg.RemoteOptions[p.AuthRemote.RemoteName]
 		} else {
 			return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
 				errors.New("failed to find remote in remotes section"))
 		}
 	}
 
-	if p.AuthKeyName != "" {
-		log.Debug("match auth key in profile to auth_keys section")
-		if key, ok := cfg.AuthKeys[p.AuthKeyName]; ok == true {
-			if key.Type == "standard" {
-				p.Provider, err = auth.New(key.Key, nil)
-				if err != nil {
-					log.Debugf("failed to create new standard auth provider: %v", err)
-					return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
-						errors.New("failed to create new standard auth provider"))
-				}
-			} else {
-				log.Debugf("unknown authentication type %v", key.Type)
-				return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
-					errors.New("unknown authentication type"))
-			}
-		} else {
-			return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
-				errors.New("failed to find auth_key in auth_keys section"))
+	if p.AuthKeyName != "" || len(p.AuthKeyNames) > 0 {
+		log.Debug("match auth keys in profile to auth_keys section")
+		names := p.AuthKeyNames
+		if p.AuthKeyName != "" {
+			names = append([]string{p.AuthKeyName}, names...)
+		}
+		p.Provider, err = newProfileAuthProvider(cfg, names, "auth_key")
+		if err != nil {
+			return err
 		}
 	}
 
 	if p.AuthRemote.AuthKeyName != "" {
 		log.Debug("match auth remote key in profile to auth_keys section")
-		if key, ok := cfg.AuthKeys[p.AuthRemote.AuthKeyName]; ok == true {
-			if key.Type == "standard" {
-				p.RemoteProvider, err = auth.New(key.Key, nil)
-				if err != nil {
-					log.Debugf("failed to create new standard auth provider: %v", err)
-					return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
-						errors.New("failed to create new standard auth provider"))
-				}
-			} else {
-				log.Debugf("unknown authentication type %v", key.Type)
-				return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
-					errors.New("unknown authentication type"))
-			}
-		} else {
-			return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
-				errors.New("failed to find auth_remote's auth_key in auth_keys section"))
+		p.RemoteProvider, err = newProfileAuthProvider(cfg, []string{p.AuthRemote.AuthKeyName}, "auth_remote's auth_key")
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.ClientCertAuth != nil {
+		log.Debug("validate client certificate rules")
+		if err := p.ClientCertAuth.Validate(); err != nil {
+			return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy, err)
 		}
 	}
 
@@ -295,6 +376,41 @@ func (p *Signing) OverrideRemotes(remote string) error {
 	return nil
 }
 
+// SetRemoteTLSConfig sets the TLS configuration used to reach the
+// remotes of every profile, overriding any given in remote_options.
+func (p *Signing) SetRemoteTLSConfig(tlsConfig *tls.Config) {
+	for _, profile := range p.Profiles {
+		profile.setRemoteTLSConfig(tlsConfig)
+	}
+	p.Default.setRemoteTLSConfig(tlsConfig)
+}
+
+func (p *SigningProfile) setRemoteTLSConfig(tlsConfig *tls.Config) {
+	if p.RemoteOptions == nil {
+		p.RemoteOptions = &RemoteOptions{}
+	}
+	p.RemoteOptions.TLSConfig = tlsConfig
+}
+
+// ShareReplayCaches makes the authentication providers of the
+// profiles use the replay caches of the profiles with the same names
+// in prev, so that requests accepted under prev can't be replayed once
+// this configuration replaces it.
+func (p *Signing) ShareReplayCaches(prev *Signing) {
+	if p == nil || prev == nil {
+		return
+	}
+
+	for name, profile := range p.Profiles {
+		if prevProfile := prev.Profiles[name]; prevProfile != nil && profile != nil {
+			auth.ShareReplayCache(prevProfile.Provider, profile.Provider)
+		}
+	}
+	if p.Default != nil && prev.Default != nil {
+		auth.ShareReplayCache(prev.Default.Provider, p.Default.Provider)
+	}
+}
+
 // NeedsRemoteSigner returns true if one of the profiles has a remote set
 func (p *Signing) NeedsRemoteSigner() bool {
 	for _, profile := range p.Profiles {
@@ -360,7 +476,7 @@ func (p *SigningProfile) validProfile(isDefault bool) bool {
 			return false
 		}
 
-		if p.AuthKeyName != "" && p.Provider == nil {
+		if (p.AuthKeyName != "" || len(p.AuthKeyNames) > 0) && p.Provider == nil {
 			log.Debugf("invalid remote profile: auth key name is defined but no auth provider is set")
 			return false
 		}
@@ -413,6 +529,8 @@ type Config struct {
 	OCSP     *ocspConfig.Config `json:"ocsp"`
 	AuthKeys map[string]AuthKey `json:"auth_keys,omitempty"`
 	Remotes  map[string]string  `json:"remotes,omitempty"`
+
+	RemoteOptions map[string]*RemoteOptions `json:"remote_options,omitempty"`
 }
 
 // Valid ensures that Config is a valid configuration. It should be
@@ -480,13 +598,125 @@ var ExtKeyUsage = map[string]x509.ExtKeyUsage{
 // An AuthKey contains an entry for a key used for authentication.
 type AuthKey struct {
 	// Type contains information needed to select the appropriate
-	// constructor. For example, "standard" for HMAC-SHA-256,
-	// "standard-ip" for HMAC-SHA-256 incorporating the client's
-	// IP.
+	// constructor: "standard" for HMAC-SHA-256, "standard-ts" for
+	// HMAC-SHA-256 incorporating a timestamp and nonce, with replay
+	// protection, and "standard-ip" for HMAC-SHA-256 incorporating
+	// the client's IP, and "asymmetric" for requests signed with
+	// the client's own ECDSA or Ed25519 key.
 	Type string `json:"type"`
 	// Key contains the key information, such as a hex-encoded
 	// HMAC key.
 	Key string `json:"key"`
+	// KeyID, PrivateKeyFile and PublicKeys are used by the
+	// "asymmetric" type: a client signs requests with the private
+	// key in PrivateKeyFile, identified by KeyID, and a server
+	// verifies them against PublicKeys, which maps key IDs to public
+	// key files.
+	KeyID          string            `json:"key_id"`
+	PrivateKeyFile string            `json:"private_key"`
+	PublicKeys     map[string]string `json:"public_keys"`
+	// NotBefore and NotAfter, if set, bound the window in which
+	// the key is accepted, and used by clients, for rotation.
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+}
+
+// newAuthProvider creates the authentication provider described by an
+// auth key.
+func newAuthProvider(key AuthKey) (provider auth.Provider, err error) {
+	switch key.Type {
+	case "standard":
+		provider, err = auth.New(key.Key, nil)
+	case "standard-ts":
+		provider, err = auth.NewTS(key.Key, auth.DefaultSkew, auth.DefaultReplayCacheSize)
+	case "standard-ip":
+		provider, err = auth.NewIP(key.Key)
+	case "asymmetric":
+		provider, err = newAsymmetricProvider(key)
+	default:
+		log.Debugf("unknown authentication type %v", key.Type)
+		return nil, cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
+			errors.New("unknown authentication type"))
+	}
+
+	if err != nil {
+		log.Debugf("failed to create new %s auth provider: %v", key.Type, err)
+		return nil, cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
+			errors.New("failed to create new "+key.Type+" auth provider"))
+	}
+	return provider, nil
+}
+
+// newProfileAuthProvider creates the authentication provider for the
+// named auth keys; what names the profile field for errors. A single
+// key without a validity window is used as it is; otherwise the keys
+// are combined into a key set, so that they can be rotated.
+func newProfileAuthProvider(cfg *Config, names []string, what string) (auth.Provider, error) {
+	var keys []auth.Key
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		key, ok := cfg.AuthKeys[name]
+		if !ok {
+			return nil, cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
+				errors.New("failed to find "+what+" in auth_keys section"))
+		}
+
+		provider, err := newAuthProvider(key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, auth.Key{
+			ID:        name,
+			Provider:  provider,
+			NotBefore: key.NotBefore,
+			NotAfter:  key.NotAfter,
+		})
+	}
+
+	if len(keys) == 1 && keys[0].NotBefore.IsZero() && keys[0].NotAfter.IsZero() {
+		return keys[0].Provider, nil
+	}
+
+	ks, err := auth.NewKeySet(keys)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy, err)
+	}
+	return ks, nil
+}
+
+// newAsymmetricProvider loads the private key and public keys of an
+// asymmetric auth key.
+func newAsymmetricProvider(key AuthKey) (auth.Provider, error) {
+	var priv crypto.Signer
+	if key.PrivateKeyFile != "" {
+		keyPEM, err := ioutil.ReadFile(key.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		priv, err = auth.ParsePrivateKeyPEM(keyPEM)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pubs := make(map[string]crypto.PublicKey, len(key.PublicKeys))
+	for keyID, file := range key.PublicKeys {
+		keyPEM, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		pubs[keyID], err = auth.ParsePublicKeyPEM(keyPEM)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return auth.NewAsymmetric(key.KeyID, priv, pubs)
 }
 
 // DefaultConfig returns a default configuration specifying basic key
@@ -527,9 +757,28 @@ func LoadConfig(config []byte) (*Config, error) {
 			errors.New("failed to unmarshal configuration: "+err.Error()))
 	}
 
+	for name, opts := range cfg.RemoteOptions {
+		if _, ok := cfg.Remotes[name]; !ok {
+			return nil, cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
+				errors.New("remote_options given for unknown remote "+name))
+		}
+		if opts == nil {
+			continue
+		}
+		if err := opts.populate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Signing == nil {
+		return nil, cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
+			errors.New("no signing policy given"))
+	}
+
 	if cfg.Signing.Default == nil {
 		log.Debugf("no default given: using default config")
 		cfg.Signing.Default = DefaultConfig()
+		cfg.Signing.Default.Implicit = true
 	} else {
 		if err := cfg.Signing.Default.populate(cfg); err != nil {
 			return nil, err
@@ -3,8 +3,12 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/bbandix/cfssl/api/client"
+	"github.com/bbandix/cfssl/auth"
 )
 
 var expiry = 1 * time.Minute
@@ -267,6 +271,7 @@ func TestLoadInvalidConfigFile(t *testing.T) {
 		"testdata/invalid_no_auth_keys.json",
 		"testdata/invalid_remote.json",
 		"testdata/invalid_no_remotes.json",
+		"testdata/invalid_no_signing.json",
 	}
 	for _, configFile := range invalidConfigFiles {
 		_, err := LoadFile(configFile)
@@ -335,3 +340,176 @@ func TestOverrideRemotes(t *testing.T) {
 	}
 
 }
+
+var validRemoteOptionsConfig = `
+{
+	"signing": {
+		"default": {
+			"remote": "cas"
+		}
+	},
+	"remotes": {
+		"cas": "ca1.example.org:8888,ca2.example.org:8888"
+	},
+	"remote_options": {
+		"cas": {
+			"strategy": "health_checked",
+			"timeout": "5s",
+			"max_attempts": 1,
+			"health_check_interval": "10s"
+		}
+	}
+}`
+
+func TestRemoteOptions(t *testing.T) {
+	c, err := LoadConfig([]byte(validRemoteOptionsConfig))
+	if err != nil {
+		t.Fatal("load valid config failed:", err)
+	}
+
+	opts := c.Signing.Default.RemoteOptions.ClientOptions()
+	if opts.Strategy != client.StrategyHealthChecked || opts.Timeout != 5*time.Second ||
+		opts.MaxAttempts != 1 || opts.HealthCheckInterval != 10*time.Second {
+		t.Fatalf("remote options not populated: %+v", opts)
+	}
+
+	for _, bad := range []string{
+		`"cas": {"strategy": "fastest"}`,
+		`"cas": {"timeout": "soon"}`,
+		`"cas": {"max_attempts": -1}`,
+		`"other": {"strategy": "random"}`,
+		`"cas": {"tls_remote_ca": "testdata/nonexistent.pem"}`,
+		`"cas": {"tls_client_cert": "../api/testdata/ca.pem"}`,
+	} {
+		config := strings.Replace(validRemoteOptionsConfig, `"cas": {
+			"strategy": "health_checked",
+			"timeout": "5s",
+			"max_attempts": 1,
+			"health_check_interval": "10s"
+		}`, bad, 1)
+		if _, err = LoadConfig([]byte(config)); err == nil {
+			t.Fatalf("expected an error with remote options %s", bad)
+		}
+	}
+
+	config := strings.Replace(validRemoteOptionsConfig, `"max_attempts": 1,`,
+		`"max_attempts": 1, "tls_remote_ca": "../api/testdata/ca.pem",`, 1)
+	if c, err = LoadConfig([]byte(config)); err != nil {
+		t.Fatal("load config with TLS remote options failed:", err)
+	}
+	opts = c.Signing.Default.RemoteOptions.ClientOptions()
+	if opts.TLSConfig == nil || opts.TLSConfig.RootCAs == nil {
+		t.Fatal("TLS remote options not populated")
+	}
+}
+
+var validAsymmetricAuthConfig = `
+{
+	"signing": {
+		"default": {
+			"usages": ["digital signature", "email protection"],
+			"expiry": "8000h",
+			"auth_key": "clients"
+		}
+	},
+	"auth_keys": {
+		"clients": {
+			"type": "asymmetric",
+			"key_id": "ec",
+			"private_key": "../auth/testdata/ecdsa_client_key.pem",
+			"public_keys": {
+				"ec": "../auth/testdata/ecdsa_client_pub.pem",
+				"ed": "../auth/testdata/ed25519_client_pub.pem"
+			}
+		}
+	}
+}`
+
+func TestAsymmetricAuthKey(t *testing.T) {
+	c, err := LoadConfig([]byte(validAsymmetricAuthConfig))
+	if err != nil {
+		t.Fatal("load valid config failed:", err)
+	}
+	if _, ok := c.Signing.Default.Provider.(*auth.Asymmetric); !ok {
+		t.Fatalf("expected an asymmetric provider, got %T", c.Signing.Default.Provider)
+	}
+
+	for _, bad := range []string{
+		`"../auth/testdata/missing.pem"`,
+		`"../auth/testdata/ecdsa_client_key.pem"`,
+	} {
+		config := strings.Replace(validAsymmetricAuthConfig, `"../auth/testdata/ed25519_client_pub.pem"`, bad, 1)
+		if _, err = LoadConfig([]byte(config)); err == nil {
+			t.Fatalf("expected an error with public key %s", bad)
+		}
+	}
+}
+
+var validKeyRotationConfig = `
+{
+	"signing": {
+		"default": {
+			"usages": ["digital signature", "email protection"],
+			"expiry": "8000h",
+			"auth_key": "old",
+			"auth_keys": ["old", "new"]
+		}
+	},
+	"auth_keys": {
+		"old": {
+			"type": "standard",
+			"key": "00112233445566778899AABBCCDDEEFF",
+			"not_after": "2030-01-01T00:00:00Z"
+		},
+		"new": {
+			"type": "standard-ts",
+			"key": "0123456789ABCDEF0123456789ABCDEF",
+			"not_before": "2020-01-01T00:00:00Z"
+		}
+	}
+}`
+
+func TestAuthKeyRotation(t *testing.T) {
+	c, err := LoadConfig([]byte(validKeyRotationConfig))
+	if err != nil {
+		t.Fatal("load valid config failed:", err)
+	}
+	if _, ok := c.Signing.Default.Provider.(*auth.KeySet); !ok {
+		t.Fatalf("expected a key set, got %T", c.Signing.Default.Provider)
+	}
+
+	config := strings.Replace(validKeyRotationConfig, `["old", "new"]`, `["old", "newer"]`, 1)
+	if _, err = LoadConfig([]byte(config)); err == nil {
+		t.Fatal("expected an error with an unknown auth key")
+	}
+}
+
+func TestShareReplayCaches(t *testing.T) {
+	load := func() *Config {
+		c, err := LoadConfig([]byte(validKeyRotationConfig))
+		if err != nil {
+			t.Fatal("load valid config failed:", err)
+		}
+		return c
+	}
+
+	prev := load()
+	aReq := &auth.AuthenticatedRequest{Request: []byte(`testing 1 2 3`)}
+	if err := prev.Signing.Default.Provider.(auth.RequestProvider).Authenticate(aReq); err != nil {
+		t.Fatal(err)
+	}
+	if !prev.Signing.Default.Provider.Verify(aReq) {
+		t.Fatal("failed to verify a timestamped request")
+	}
+
+	// A reloaded configuration would accept the request again,
+	// unless it takes over the previous replay caches.
+	if !load().Signing.Default.Provider.Verify(aReq) {
+		t.Fatal("failed to verify a timestamped request")
+	}
+	c := load()
+	c.Signing.ShareReplayCaches(prev.Signing)
+	if c.Signing.Default.Provider.Verify(aReq) {
+		t.Fatal("request replayed after a reload was verified")
+	}
+}
@@ -0,0 +1,99 @@
+package crl
+
+import (
+	"bytes"
+	"crypto/x509"
+	"io/ioutil"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/bbandix/cfssl/helpers"
+)
+
+// The test CA has a subject key identifier and may sign CRLs, as
+// NewCRL requires.
+const (
+	testCaFile    = "testdata/ca.pem"
+	testCaKeyFile = "testdata/ca_key.pem"
+)
+
+func TestParseSerials(t *testing.T) {
+	revoked, err := ParseSerials([]byte("# revoked\n1 2015-05-01T00:00:00Z\n\n  22 2015-06-01T02:00:00+02:00\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 2 {
+		t.Fatalf("expected 2 revoked certificates, have %d", len(revoked))
+	}
+	if revoked[0].SerialNumber.Int64() != 1 || revoked[0].RevocationTime.Month() != time.May {
+		t.Fatalf("bad first entry: %+v", revoked[0])
+	}
+	if revoked[1].SerialNumber.Int64() != 22 || !revoked[1].RevocationTime.Equal(time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("bad second entry: %+v", revoked[1])
+	}
+
+	// Without a revocation time, the CRL would give a different one
+	// each time it is generated.
+	for _, bad := range []string{"abc 2015-06-01T00:00:00Z", "1", "1 yesterday", "1 2015-06-01T00:00:00Z extra"} {
+		if _, err = ParseSerials([]byte(bad)); err == nil {
+			t.Fatalf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestNewCRL(t *testing.T) {
+	certPEM, err := ioutil.ReadFile(testCaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := ioutil.ReadFile(testCaKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := helpers.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := NewCRL([]byte("1 2015-05-01T00:00:00Z\n2 2015-06-01T00:00:00Z\n"), big.NewInt(7), issuer, key, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = crl.CheckSignatureFrom(issuer); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(crl.RevokedCertificateEntries); n != 2 {
+		t.Fatalf("expected 2 revoked certificates, have %d", n)
+	}
+	if !crl.RevokedCertificateEntries[1].RevocationTime.Equal(time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("bad revocation time %v", crl.RevokedCertificateEntries[1].RevocationTime)
+	}
+	if crl.Number == nil || crl.Number.Int64() != 7 {
+		t.Fatalf("expected CRL number 7, have %v", crl.Number)
+	}
+	if time.Now().After(crl.NextUpdate) || time.Now().Add(2*time.Hour).Before(crl.NextUpdate) {
+		t.Fatal("CRL has the wrong expiry")
+	}
+	if !bytes.Equal(crl.AuthorityKeyId, issuer.SubjectKeyId) {
+		t.Fatal("CRL doesn't name the issuer's key")
+	}
+
+	// An issuer that may not sign CRLs is refused.
+	issuer.KeyUsage = x509.KeyUsageCertSign
+	if err = CheckIssuer(issuer); err == nil {
+		t.Fatal("expected an issuer without the CRL sign key usage to be refused")
+	}
+	if _, err = NewCRL(nil, big.NewInt(8), issuer, key, time.Hour); err == nil {
+		t.Fatal("expected a CRL from an issuer without the CRL sign key usage to fail")
+	}
+}
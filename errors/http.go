@@ -34,6 +34,11 @@ func NewBadRequestString(s string) *HTTPError {
 	return NewBadRequest(errors.New(s))
 }
 
+// NewForbidden creates a HttpError with the given error and error code 403.
+func NewForbidden(err error) *HTTPError {
+	return &HTTPError{http.StatusForbidden, err}
+}
+
 // NewBadRequestMissingParameter returns a 400 HttpError as a required
 // parameter is missing in the HTTP request.
 func NewBadRequestMissingParameter(s string) *HTTPError {
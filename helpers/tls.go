@@ -0,0 +1,160 @@
+package helpers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	cferr "github.com/bbandix/cfssl/errors"
+	"github.com/bbandix/cfssl/log"
+)
+
+// LoadClientCertificate loads a certificate and key pair to present
+// as a TLS client certificate. If both paths are empty, it returns a
+// nil certificate.
+func LoadClientCertificate(certFile, keyFile string) (*tls.Certificate, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.ReadFailed,
+			errors.New("a client certificate needs both a certificate and a key"))
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.ReadFailed, err)
+	}
+	return &cert, nil
+}
+
+// CreateTLSConfig creates a TLS client configuration that verifies
+// servers against remoteCAs, or the system roots if it is nil, and
+// presents cert, if any, as the client certificate.
+func CreateTLSConfig(remoteCAs *x509.CertPool, cert *tls.Certificate) *tls.Config {
+	var certs []tls.Certificate
+	if cert != nil {
+		certs = []tls.Certificate{*cert}
+	}
+	return &tls.Config{
+		Certificates: certs,
+		RootCAs:      remoteCAs,
+	}
+}
+
+// certificateReloadInterval is the minimum interval between checks
+// for a changed serving certificate.
+var certificateReloadInterval = 10 * time.Second
+
+// A CertificateReloader serves a TLS certificate and key from disk,
+// reloading them when either file changes so that a renewed
+// certificate is picked up without a restart.
+type CertificateReloader struct {
+	certFile, keyFile string
+
+	lock      sync.RWMutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// NewCertificateReloader loads the certificate and key in certFile
+// and keyFile.
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	r := &CertificateReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// latestModTime returns the latest modification time of the certificate
+// and key files.
+func (r *CertificateReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Reload loads the certificate and key from disk. On error, the
+// previously loaded certificate is kept.
+func (r *CertificateReloader) Reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return cferr.Wrap(cferr.CertificateError, cferr.ReadFailed, err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return cferr.Wrap(cferr.CertificateError, cferr.ReadFailed, err)
+	}
+
+	r.lock.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.lock.Unlock()
+	return nil
+}
+
+// GetCertificate returns the current certificate, first reloading it
+// if the files on disk have changed since it was loaded. It is meant
+// to be used as the GetCertificate callback of a tls.Config.
+func (r *CertificateReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.Lock()
+	check := time.Since(r.lastCheck) >= certificateReloadInterval
+	if check {
+		r.lastCheck = time.Now()
+	}
+	r.lock.Unlock()
+
+	if check {
+		modTime, err := r.latestModTime()
+		r.lock.RLock()
+		changed := err == nil && !modTime.Equal(r.modTime)
+		r.lock.RUnlock()
+
+		if changed {
+			if err = r.Reload(); err != nil {
+				log.Warningf("failed to reload TLS certificate, keeping the current one: %v", err)
+			} else {
+				log.Infof("reloaded TLS certificate from %s", r.certFile)
+			}
+		}
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cert, nil
+}
+
+// ServerTLSConfig creates a TLS server configuration serving the
+// certificate and key in certFile and keyFile, reloading them when
+// they change. If clientCAFile is not empty, clients must present a
+// certificate issued by one of the CAs in it.
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{GetCertificate: reloader.GetCertificate}
+	if clientCAFile != "" {
+		config.ClientCAs, err = LoadPEMCertPool(clientCAFile)
+		if err != nil {
+			return nil, cferr.Wrap(cferr.CertificateError, cferr.ReadFailed, err)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
@@ -0,0 +1,179 @@
+package helpers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1
+// and its key to dir, returning their paths.
+func writeTestCertificate(t *testing.T, dir, name string) (certFile, keyFile string) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, name+".pem")
+	keyFile = filepath.Join(dir, name+"-key.pem")
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestLoadClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cfssl-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCertificate(t, dir, "client")
+
+	cert, err := LoadClientCertificate("", "")
+	if err != nil || cert != nil {
+		t.Fatal("expected no certificate without files")
+	}
+	if _, err = LoadClientCertificate(certFile, ""); err == nil {
+		t.Fatal("expected an error for a certificate without a key")
+	}
+	if cert, err = LoadClientCertificate(certFile, keyFile); err != nil || cert == nil {
+		t.Fatalf("failed to load the client certificate: %v", err)
+	}
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cfssl-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(interval time.Duration) { certificateReloadInterval = interval }(certificateReloadInterval)
+	certificateReloadInterval = 0
+
+	certFile, keyFile := writeTestCertificate(t, dir, "server")
+	r, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := r.GetCertificate(nil)
+
+	// Replace the certificate and make sure the change is noticed
+	// even on filesystems with coarse modification times.
+	writeTestCertificate(t, dir, "server")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+
+	second, _ := r.GetCertificate(nil)
+	if first == second || string(first.Certificate[0]) == string(second.Certificate[0]) {
+		t.Fatal("certificate was not reloaded")
+	}
+
+	// A broken file keeps the current certificate.
+	ioutil.WriteFile(certFile, []byte("not a certificate"), 0644)
+	later = later.Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if third, _ := r.GetCertificate(nil); third != second {
+		t.Fatal("broken certificate replaced the current one")
+	}
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cfssl-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	serverCert, serverKey := writeTestCertificate(t, dir, "server")
+	clientCert, clientKey := writeTestCertificate(t, dir, "client")
+
+	config, err := ServerTLSConfig(serverCert, serverKey, clientCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatal("mutual TLS not required")
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Write([]byte("ok"))
+			conn.Close()
+		}
+	}()
+
+	roots, err := LoadPEMCertPool(serverCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dial := func(cert *tls.Certificate) error {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), CreateTLSConfig(roots, cert))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = conn.Read(make([]byte, 2))
+		return err
+	}
+
+	if err = dial(nil); err == nil {
+		t.Fatal("connection without a client certificate was accepted")
+	}
+
+	cert, err := LoadClientCertificate(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dial(cert); err != nil {
+		t.Fatalf("connection with a client certificate was rejected: %v", err)
+	}
+}
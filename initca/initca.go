@@ -3,6 +3,7 @@
 package initca
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
@@ -12,8 +13,11 @@ import (
 	"encoding/pem"
 	"errors"
 	"io/ioutil"
+	"math"
+	"math/big"
 	"time"
 
+	"github.com/bbandix/cfssl/audit"
 	"github.com/bbandix/cfssl/config"
 	"github.com/bbandix/cfssl/csr"
 	cferr "github.com/bbandix/cfssl/errors"
@@ -179,6 +183,279 @@ func NewFromSigner(req *csr.CertificateRequest, priv crypto.Signer) (cert, csrPE
 	return
 }
 
+// RenewFromPEM re-creates a root certificate from the CA certificate
+// and key files passed in.
+func RenewFromPEM(caFile, keyFile string) ([]byte, error) {
+	ca, priv, err := loadCA(caFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return RenewFromSigner(ca, priv)
+}
+
+// RenewFromSigner re-creates a root certificate from an existing CA
+// certificate and its crypto.Signer. The renewed certificate keeps the
+// subject, public key, SKI and extensions of the existing one, gets a
+// new serial number, and is valid from now for as long as the
+// existing certificate was valid. E.g. a CA valid from Jan 01 2015 to
+// Jan 01 2020 renewed today is valid for five years from today.
+func RenewFromSigner(ca *x509.Certificate, priv crypto.Signer) ([]byte, error) {
+	if err := checkRoot(ca); err != nil {
+		return nil, err
+	}
+
+	if err := checkKeyMatch(ca, priv); err != nil {
+		return nil, err
+	}
+
+	template, err := renewTemplate(ca)
+	if err != nil {
+		return nil, err
+	}
+	template.AuthorityKeyId = template.SubjectKeyId
+	template.SignatureAlgorithm = signer.DefaultSigAlgo(priv)
+
+	return createCertificate(audit.OpRenewCA, template, template, priv)
+}
+
+// RekeyFromPEM generates a new key for the CA certificate and key
+// files passed in; see Rekey.
+func RekeyFromPEM(caFile, keyFile string, kr csr.KeyRequest) (cert, crossCert, key []byte, err error) {
+	ca, priv, err := loadCA(caFile, keyFile)
+	if err != nil {
+		return
+	}
+
+	return Rekey(ca, priv, kr)
+}
+
+// Rekey generates a new key for the CA following kr and re-creates
+// the root certificate with it; if kr is nil, the new key has the
+// same algorithm and size as the existing one. It returns the new
+// root certificate, a cross-certificate for the new key signed by
+// priv, and the new private key.
+func Rekey(ca *x509.Certificate, priv crypto.Signer, kr csr.KeyRequest) (cert, crossCert, key []byte, err error) {
+	if kr == nil {
+		kr = &csr.BasicKeyRequest{
+			A: keyAlgo(ca.PublicKey),
+			S: helpers.KeyLength(ca.PublicKey),
+		}
+	}
+
+	// ParseRequest is only used for its key generation and
+	// encoding; the CSR it produces is not needed.
+	_, key, err = csr.ParseRequest(&csr.CertificateRequest{
+		CN:         ca.Subject.CommonName,
+		KeyRequest: kr,
+	})
+	if err != nil {
+		key = nil
+		return
+	}
+
+	newPriv, err := helpers.ParsePrivateKeyPEM(key)
+	if err != nil {
+		key = nil
+		return
+	}
+
+	cert, crossCert, err = RekeyFromSigner(ca, priv, newPriv)
+	if err != nil {
+		key = nil
+	}
+	return
+}
+
+// RekeyFromSigner re-creates a root certificate from an existing CA
+// certificate with a new key. The new root keeps the subject and
+// extensions of the existing one, but carries newPriv's public key
+// and SKI. The returned cross-certificate binds the same subject and
+// the new public key, and is issued by the existing CA using priv, so
+// that relying parties which only trust the existing root can still
+// validate chains issued under the new key. The cross-certificate
+// never outlives the existing CA certificate.
+func RekeyFromSigner(ca *x509.Certificate, priv, newPriv crypto.Signer) (cert, crossCert []byte, err error) {
+	if err = checkRoot(ca); err != nil {
+		return
+	}
+
+	if err = checkKeyMatch(ca, priv); err != nil {
+		return
+	}
+
+	template, err := renewTemplate(ca)
+	if err != nil {
+		return
+	}
+	template.PublicKey = newPriv.Public()
+	template.SignatureAlgorithm = signer.DefaultSigAlgo(newPriv)
+	template.SubjectKeyId, err = signer.ComputeSKI(template)
+	if err != nil {
+		err = cferr.Wrap(cferr.PrivateKeyError, cferr.Unknown, err)
+		return
+	}
+	template.AuthorityKeyId = template.SubjectKeyId
+
+	cert, err = createCertificate(audit.OpRekeyCA, template, template, newPriv)
+	if err != nil {
+		return
+	}
+
+	cross, err := renewTemplate(ca)
+	if err != nil {
+		return
+	}
+	cross.PublicKey = template.PublicKey
+	cross.SubjectKeyId = template.SubjectKeyId
+	cross.AuthorityKeyId = ca.SubjectKeyId
+	cross.SignatureAlgorithm = signer.DefaultSigAlgo(priv)
+	if cross.NotAfter.After(ca.NotAfter) {
+		cross.NotAfter = ca.NotAfter
+	}
+
+	crossCert, err = createCertificate(audit.OpRekeyCA, cross, ca, priv)
+	if err != nil {
+		cert = nil
+	}
+	return
+}
+
+// loadCA reads and parses a PEM-encoded CA certificate and key.
+func loadCA(caFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	certData, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, nil, cferr.Wrap(cferr.CertificateError, cferr.ReadFailed, err)
+	}
+
+	ca, err := helpers.ParseCertificatePEM(certData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyData, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, cferr.Wrap(cferr.PrivateKeyError, cferr.ReadFailed, err)
+	}
+
+	priv, err := helpers.ParsePrivateKeyPEM(keyData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ca, priv, nil
+}
+
+// checkRoot verifies that ca is a self-signed CA certificate; only
+// roots can be renewed or rekeyed without involving another issuer.
+func checkRoot(ca *x509.Certificate) error {
+	if !ca.IsCA {
+		return cferr.Wrap(cferr.PolicyError, cferr.InvalidRequest, errors.New("input certificate is not a CA certificate"))
+	}
+
+	if !bytes.Equal(ca.RawIssuer, ca.RawSubject) || ca.CheckSignatureFrom(ca) != nil {
+		return cferr.Wrap(cferr.PolicyError, cferr.InvalidRequest, errors.New("input certificate is not a self-signed root"))
+	}
+
+	return nil
+}
+
+// checkKeyMatch verifies that priv is the private key for cert.
+func checkKeyMatch(cert *x509.Certificate, priv crypto.Signer) error {
+	switch priv.Public().(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return cferr.New(cferr.PrivateKeyError, cferr.NotRSAOrECC)
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(priv.Public())
+	if err != nil {
+		return cferr.Wrap(cferr.PrivateKeyError, cferr.Unknown, err)
+	}
+
+	if !bytes.Equal(pub, cert.RawSubjectPublicKeyInfo) {
+		return cferr.New(cferr.PrivateKeyError, cferr.KeyMismatch)
+	}
+	return nil
+}
+
+// keyAlgo returns the csr.BasicKeyRequest algorithm name for pub.
+func keyAlgo(pub crypto.PublicKey) string {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return "rsa"
+	case *ecdsa.PublicKey:
+		return "ecdsa"
+	default:
+		return ""
+	}
+}
+
+// renewTemplate builds a certificate template from ca that keeps its
+// subject, key and extensions, with a fresh serial number and a
+// validity window of the same length starting now.
+func renewTemplate(ca *x509.Certificate) (*x509.Certificate, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+	if err != nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.Unknown, err)
+	}
+
+	notBefore := time.Now().Round(time.Minute).Add(-5 * time.Minute).UTC()
+	notAfter := notBefore.Add(ca.NotAfter.Sub(ca.NotBefore)).UTC()
+
+	return &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               ca.Subject,
+		RawSubject:            ca.RawSubject,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		PublicKey:             ca.PublicKey,
+		KeyUsage:              ca.KeyUsage,
+		ExtKeyUsage:           ca.ExtKeyUsage,
+		UnknownExtKeyUsage:    ca.UnknownExtKeyUsage,
+		BasicConstraintsValid: ca.BasicConstraintsValid,
+		IsCA:                  ca.IsCA,
+		MaxPathLen:            ca.MaxPathLen,
+		MaxPathLenZero:        ca.MaxPathLenZero,
+		SubjectKeyId:          ca.SubjectKeyId,
+		DNSNames:              ca.DNSNames,
+		EmailAddresses:        ca.EmailAddresses,
+		IPAddresses:           ca.IPAddresses,
+		PermittedDNSDomains:   ca.PermittedDNSDomains,
+		OCSPServer:            ca.OCSPServer,
+		IssuingCertificateURL: ca.IssuingCertificateURL,
+		CRLDistributionPoints: ca.CRLDistributionPoints,
+		PolicyIdentifiers:     ca.PolicyIdentifiers,
+	}, nil
+}
+
+// createCertificate signs template with priv as issued by parent and
+// returns the PEM-encoded result, recording it in the audit log as op.
+// The certificate is withheld if its issuance can't be recorded.
+func createCertificate(op string, template, parent *x509.Certificate, priv crypto.Signer) ([]byte, error) {
+	rec := &audit.Record{Operation: op, Serial: template.SerialNumber.String()}
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, parent, template.PublicKey, priv)
+	if err != nil {
+		err = cferr.Wrap(cferr.CertificateError, cferr.Unknown, err)
+	} else if cert, perr := x509.ParseCertificate(derBytes); perr == nil {
+		rec.SetCertificate(cert)
+	}
+	rec.SetOutcome(err)
+
+	if auditErr := audit.Write(rec); auditErr != nil {
+		log.Errorf("failed to write audit record: %v", auditErr)
+		if err == nil {
+			return nil, cferr.Wrap(cferr.CertificateError, cferr.Unknown, auditErr)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("signed certificate with serial number %d", template.SerialNumber)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes}), nil
+}
+
 // CAPolicy contains the CA issuing policy as default policy.
 var CAPolicy = &config.Signing{
 	Default: &config.SigningProfile{
@@ -1,13 +1,18 @@
 package initca
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/rsa"
+	"crypto/x509"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/bbandix/cfssl/audit"
 	"github.com/bbandix/cfssl/config"
 	"github.com/bbandix/cfssl/csr"
 	"github.com/bbandix/cfssl/helpers"
@@ -195,3 +200,203 @@ func TestValidations(t *testing.T) {
 		}
 	}
 }
+
+// newTestCA creates a root CA and writes it and its key to temporary
+// files, returning the parsed certificate and the file names.
+func newTestCA(t *testing.T) (ca *x509.Certificate, caFile, keyFile string) {
+	req := &csr.CertificateRequest{
+		CN:         "test CA",
+		KeyRequest: csr.NewBasicKeyRequest(),
+	}
+	certPEM, _, keyPEM, err := New(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err = helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range []struct {
+		name *string
+		data []byte
+	}{{&caFile, certPEM}, {&keyFile, keyPEM}} {
+		tmp, err := ioutil.TempFile("", "initca")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmp.Write(f.data)
+		tmp.Close()
+		*f.name = tmp.Name()
+	}
+	return
+}
+
+func TestRenew(t *testing.T) {
+	ca, caFile, keyFile := newTestCA(t)
+	defer os.Remove(caFile)
+	defer os.Remove(keyFile)
+
+	certPEM, err := RenewFromPEM(caFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(cert.RawSubject, ca.RawSubject) {
+		t.Fatal("renewed certificate subject mismatch")
+	}
+	if !bytes.Equal(cert.SubjectKeyId, ca.SubjectKeyId) {
+		t.Fatal("renewed certificate SKI mismatch")
+	}
+	if !bytes.Equal(cert.RawSubjectPublicKeyInfo, ca.RawSubjectPublicKeyInfo) {
+		t.Fatal("renewed certificate public key mismatch")
+	}
+	if cert.SerialNumber.Cmp(ca.SerialNumber) == 0 {
+		t.Fatal("renewed certificate has the same serial number")
+	}
+	if !cert.IsCA {
+		t.Fatal("renewed certificate is not a CA")
+	}
+	if cert.NotAfter.Sub(cert.NotBefore) != ca.NotAfter.Sub(ca.NotBefore) {
+		t.Fatal("renewed certificate validity length mismatch")
+	}
+	if err = cert.CheckSignatureFrom(ca); err != nil {
+		t.Fatal("renewed certificate is not verifiable with the CA key:", err)
+	}
+}
+
+func TestRenewMismatch(t *testing.T) {
+	ca, caFile, keyFile := newTestCA(t)
+	defer os.Remove(caFile)
+	defer os.Remove(keyFile)
+
+	_, otherFile, otherKeyFile := newTestCA(t)
+	defer os.Remove(otherFile)
+	defer os.Remove(otherKeyFile)
+
+	if _, err := RenewFromPEM(caFile, otherKeyFile); err == nil {
+		t.Fatal("renewal with a mismatched key should fail")
+	}
+
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := helpers.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca.IsCA = false
+	if _, err = RenewFromSigner(ca, priv); err == nil {
+		t.Fatal("renewal of a non-CA certificate should fail")
+	}
+}
+
+func TestRekey(t *testing.T) {
+	ca, caFile, keyFile := newTestCA(t)
+	defer os.Remove(caFile)
+	defer os.Remove(keyFile)
+
+	certPEM, crossPEM, keyPEM, err := RekeyFromPEM(caFile, keyFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cross, err := helpers.ParseCertificatePEM(crossPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := helpers.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := priv.(*ecdsa.PrivateKey); !ok {
+		t.Fatal("new key should have the same algorithm as the CA key")
+	}
+	if bytes.Equal(cert.RawSubjectPublicKeyInfo, ca.RawSubjectPublicKeyInfo) {
+		t.Fatal("rekeyed certificate kept the CA public key")
+	}
+	if bytes.Equal(cert.SubjectKeyId, ca.SubjectKeyId) {
+		t.Fatal("rekeyed certificate kept the CA SKI")
+	}
+	if !bytes.Equal(cert.RawSubject, ca.RawSubject) {
+		t.Fatal("rekeyed certificate subject mismatch")
+	}
+	if err = cert.CheckSignatureFrom(cert); err != nil {
+		t.Fatal("rekeyed certificate is not self-signed:", err)
+	}
+
+	if !bytes.Equal(cross.RawSubjectPublicKeyInfo, cert.RawSubjectPublicKeyInfo) {
+		t.Fatal("cross-certificate public key mismatch")
+	}
+	if !bytes.Equal(cross.AuthorityKeyId, ca.SubjectKeyId) {
+		t.Fatal("cross-certificate AKI mismatch")
+	}
+	if cross.NotAfter.After(ca.NotAfter) {
+		t.Fatal("cross-certificate outlives the CA certificate")
+	}
+	if err = cross.CheckSignatureFrom(ca); err != nil {
+		t.Fatal("cross-certificate is not signed by the CA:", err)
+	}
+}
+
+func TestRekeyAudit(t *testing.T) {
+	ca, caFile, keyFile := newTestCA(t)
+	defer os.Remove(caFile)
+	defer os.Remove(keyFile)
+
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := audit.OpenFile(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	l, err := audit.NewLog(store, []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.SetLog(l)
+	defer audit.SetLog(nil)
+
+	if _, err = RenewFromPEM(caFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	_, crossPEM, _, err := RekeyFromPEM(caFile, keyFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cross, err := helpers.ParseCertificatePEM(crossPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The renewal, the rekeyed root and its cross-certificate are
+	// each recorded.
+	last, err := store.Last()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last == nil || last.Seq != 3 || last.Operation != audit.OpRekeyCA ||
+		last.Outcome != audit.OutcomeSuccess || last.Serial != cross.SerialNumber.String() {
+		t.Fatalf("unexpected audit record %+v", last)
+	}
+	if last.Serial == ca.SerialNumber.String() {
+		t.Fatal("the cross-certificate was recorded with the CA's serial number")
+	}
+}
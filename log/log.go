@@ -1,13 +1,16 @@
-// Package log implements a wrapper around the Go standard library's
-// logging package. Clients should set the current log level; only
-// messages below that level will actually be logged. For example, if
-// Level is set to LevelWarning, only log messages at the Warning,
-// Error, and Critical levels will be logged.
+// Package log implements leveled logging for CFSSL. Clients should set
+// the current log level; only messages below that level will actually
+// be logged. For example, if Level is set to LevelWarning, only log
+// messages at the Warning, Error, and Critical levels will be logged.
+//
+// Besides the printf-style functions, a Logger logs messages with
+// key/value fields, such as the ID of the request being served. Entries
+// are written as text or JSON, according to Format, to a Sink, which
+// defaults to standard error.
 package log
 
 import (
 	"fmt"
-	golog "log"
 	"os"
 )
 
@@ -35,13 +38,13 @@ var Level = LevelDebug
 
 func outputf(l int, format string, v []interface{}) {
 	if l >= Level {
-		golog.Printf(fmt.Sprint(levelPrefix[l], format), v...)
+		emit(l, fmt.Sprintf(format, v...), nil)
 	}
 }
 
 func output(l int, v []interface{}) {
 	if l >= Level {
-		golog.Print(levelPrefix[l], fmt.Sprint(v...))
+		emit(l, fmt.Sprint(v...), nil)
 	}
 }
 
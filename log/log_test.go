@@ -1 +1,144 @@
 package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordSink records the entries written to it.
+type recordSink []*Entry
+
+func (s *recordSink) Write(e *Entry) error {
+	*s = append(*s, e)
+	return nil
+}
+
+// record sends entries to a recordSink until the returned function is
+// called.
+func record() (*recordSink, func()) {
+	s := &recordSink{}
+	oldLevel := Level
+	SetSink(s)
+	return s, func() {
+		Level = oldLevel
+		SetSink(NewWriterSink(os.Stderr))
+	}
+}
+
+func TestLevel(t *testing.T) {
+	s, restore := record()
+	defer restore()
+	Level = LevelWarning
+
+	Info("dropped")
+	Warningf("kept %d", 1)
+	New("key", "value").Debug("dropped")
+	New("key", "value").Error("kept")
+
+	if len(*s) != 2 {
+		t.Fatalf("expected 2 entries, have %d", len(*s))
+	}
+	if (*s)[0].Message != "kept 1" || (*s)[0].Level != LevelWarning {
+		t.Fatalf("unexpected entry %+v", (*s)[0])
+	}
+}
+
+func TestFields(t *testing.T) {
+	s, restore := record()
+	defer restore()
+	Level = LevelDebug
+
+	ForRequest("abc").With("profile", "server").Info("signed", "serial", 42, "odd")
+	ForRequest("").Info("no request")
+
+	fields := (*s)[0].Fields
+	want := []Field{{"request_id", "abc"}, {"profile", "server"}, {"serial", 42}, {"odd", "(MISSING)"}}
+	if len(fields) != len(want) {
+		t.Fatalf("expected fields %v, have %v", want, fields)
+	}
+	for i := range want {
+		if fields[i] != want[i] {
+			t.Fatalf("expected fields %v, have %v", want, fields)
+		}
+	}
+
+	if n := len((*s)[1].Fields); n != 0 {
+		t.Fatalf("expected no fields without a request ID, have %d", n)
+	}
+}
+
+func TestEncodeText(t *testing.T) {
+	e := &Entry{
+		Level:   LevelInfo,
+		Message: "signed certificate",
+		Fields:  []Field{{"serial", 42}, {"error", errors.New("bad thing")}, {"empty", ""}},
+	}
+
+	want := `[INFO] signed certificate serial=42 error="bad thing" empty=""`
+	if have := string(e.Encode(FormatText)); have != want {
+		t.Fatalf("expected %s, have %s", want, have)
+	}
+}
+
+func TestEncodeJSON(t *testing.T) {
+	e := &Entry{
+		Time:    time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC),
+		Level:   LevelError,
+		Message: "signature failed",
+		Fields:  []Field{{"error", errors.New("bad thing")}, {"msg", "clash"}, {"code", 400}},
+	}
+
+	want := `{"time":"2015-06-01T12:00:00Z","level":"error","msg":"signature failed","error":"bad thing","_msg":"clash","code":400}`
+	out := e.Encode(FormatJSON)
+	if string(out) != want {
+		t.Fatalf("expected %s, have %s", want, out)
+	}
+	if !json.Valid(out) {
+		t.Fatal("entry is not valid JSON")
+	}
+}
+
+func TestWriterSink(t *testing.T) {
+	defer func() { Format = FormatText }()
+
+	var buf bytes.Buffer
+	s := NewWriterSink(&buf)
+	e := &Entry{Time: time.Now(), Level: LevelInfo, Message: "hello"}
+
+	s.Write(e)
+	if line := buf.String(); !strings.HasSuffix(line, " [INFO] hello\n") {
+		t.Fatalf("unexpected text line %q", line)
+	}
+
+	buf.Reset()
+	Format = FormatJSON
+	s.Write(e)
+	if line := buf.String(); !strings.HasPrefix(line, `{"time":`) || !strings.HasSuffix(line, "}\n") {
+		t.Fatalf("unexpected JSON line %q", line)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if f, err := ParseFormat("json"); err != nil || f != FormatJSON {
+		t.Fatalf("failed to parse the JSON format: %v", err)
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	ctx := WithRequestID(context.Background(), "abc")
+	if id := RequestID(ctx); id != "abc" {
+		t.Fatalf("expected request ID abc, have %q", id)
+	}
+	if id := RequestID(context.Background()); id != "" {
+		t.Fatalf("expected no request ID, have %q", id)
+	}
+}
@@ -0,0 +1,38 @@
+package log
+
+import (
+	"io"
+)
+
+// A Sink writes log entries to their destination. Writes are
+// serialised, so a Sink need not be safe for concurrent use.
+type Sink interface {
+	Write(e *Entry) error
+}
+
+// WriterSink writes entries to an io.Writer, one per line, in the
+// current Format. Text entries are prefixed with the date and time in
+// the same form as the standard library's logger.
+type WriterSink struct {
+	w io.Writer
+}
+
+// NewWriterSink returns a Sink that writes entries to w.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// Write writes an entry as a single line.
+func (s *WriterSink) Write(e *Entry) error {
+	var line []byte
+	if Format == FormatText {
+		line = append(line, e.Time.Format("2006/01/02 15:04:05 ")...)
+	}
+	line = append(line, e.Encode(Format)...)
+	line = append(line, '\n')
+
+	_, err := s.w.Write(line)
+	return err
+}
+
+var _ Sink = (*WriterSink)(nil)
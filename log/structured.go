@@ -0,0 +1,261 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// The following constants are the formats in which entries are
+// written.
+const (
+	FormatText = iota
+	FormatJSON
+)
+
+// Format stores the format in which entries are written.
+var Format = FormatText
+
+// ParseFormat returns the format named "text" or "json".
+func ParseFormat(name string) (int, error) {
+	switch name {
+	case "text":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	default:
+		return 0, fmt.Errorf("log: unknown format %q", name)
+	}
+}
+
+var levelName = [...]string{
+	LevelDebug:    "debug",
+	LevelInfo:     "info",
+	LevelWarning:  "warning",
+	LevelError:    "error",
+	LevelCritical: "critical",
+	LevelFatal:    "fatal",
+}
+
+// A Field is a key/value pair attached to a log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// An Entry is a single logged message.
+type Entry struct {
+	Time    time.Time
+	Level   int
+	Message string
+	Fields  []Field
+}
+
+// Encode returns the entry in the given format, without a trailing
+// newline. Text entries don't include the time, which is left to the
+// sink.
+func (e *Entry) Encode(format int) []byte {
+	if format == FormatJSON {
+		return e.encodeJSON()
+	}
+	return e.encodeText()
+}
+
+// encodeText returns the entry as the level prefix, the message, and
+// the fields as key=value pairs; values are quoted if they contain
+// spaces or special characters.
+func (e *Entry) encodeText() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(levelPrefix[e.Level])
+	buf.WriteString(e.Message)
+	for _, f := range e.Fields {
+		buf.WriteByte(' ')
+		buf.WriteString(f.Key)
+		buf.WriteByte('=')
+		buf.WriteString(textValue(f.Value))
+	}
+	return buf.Bytes()
+}
+
+func textValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.IndexFunc(s, needsQuote) >= 0 {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func needsQuote(r rune) bool {
+	return r == '"' || r == '=' || unicode.IsSpace(r) || !unicode.IsPrint(r)
+}
+
+// reservedKeys are the keys of the standard members of a JSON entry;
+// fields with these keys are written with a leading underscore.
+var reservedKeys = map[string]bool{
+	"time":  true,
+	"level": true,
+	"msg":   true,
+}
+
+// encodeJSON returns the entry as a JSON object, with the fields in
+// the order they were given.
+func (e *Entry) encodeJSON() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`{"time":`)
+	writeJSON(&buf, e.Time.Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeJSON(&buf, levelName[e.Level])
+	buf.WriteString(`,"msg":`)
+	writeJSON(&buf, e.Message)
+	for _, f := range e.Fields {
+		key := f.Key
+		if reservedKeys[key] {
+			key = "_" + key
+		}
+		buf.WriteByte(',')
+		writeJSON(&buf, key)
+		buf.WriteByte(':')
+		writeJSON(&buf, f.Value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+// writeJSON writes v as JSON; errors are written as their message, and
+// values that can't be encoded as their default string form.
+func writeJSON(buf *bytes.Buffer, v interface{}) {
+	if err, ok := v.(error); ok {
+		v = err.Error()
+	}
+
+	out, err := json.Marshal(v)
+	if err != nil {
+		out, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(out)
+}
+
+var (
+	sinkLock sync.Mutex
+	sink     Sink = NewWriterSink(os.Stderr)
+)
+
+// SetSink sets the sink that entries are written to.
+func SetSink(s Sink) {
+	sinkLock.Lock()
+	defer sinkLock.Unlock()
+	sink = s
+}
+
+// emit writes an entry at level l, which has already been checked
+// against Level.
+func emit(l int, msg string, fields []Field) {
+	e := &Entry{
+		Time:    time.Now(),
+		Level:   l,
+		Message: msg,
+		Fields:  fields,
+	}
+
+	sinkLock.Lock()
+	defer sinkLock.Unlock()
+	sink.Write(e)
+}
+
+// A Logger logs messages with key/value fields. The key/value pairs
+// passed to its methods alternate between a key, which should be a
+// string, and its value; they are added to the fields the Logger
+// carries.
+type Logger interface {
+	Debug(msg string, kv ...interface{})
+	Info(msg string, kv ...interface{})
+	Warning(msg string, kv ...interface{})
+	Error(msg string, kv ...interface{})
+	Critical(msg string, kv ...interface{})
+
+	// With returns a Logger that adds the key/value pairs to
+	// every message.
+	With(kv ...interface{}) Logger
+}
+
+// New returns a Logger that adds the key/value pairs to every message.
+func New(kv ...interface{}) Logger {
+	return fieldLogger(fields(nil, kv))
+}
+
+// fieldLogger is a Logger carrying a list of fields.
+type fieldLogger []Field
+
+func (l fieldLogger) log(level int, msg string, kv []interface{}) {
+	if level >= Level {
+		emit(level, msg, fields(l, kv))
+	}
+}
+
+func (l fieldLogger) Debug(msg string, kv ...interface{})    { l.log(LevelDebug, msg, kv) }
+func (l fieldLogger) Info(msg string, kv ...interface{})     { l.log(LevelInfo, msg, kv) }
+func (l fieldLogger) Warning(msg string, kv ...interface{})  { l.log(LevelWarning, msg, kv) }
+func (l fieldLogger) Error(msg string, kv ...interface{})    { l.log(LevelError, msg, kv) }
+func (l fieldLogger) Critical(msg string, kv ...interface{}) { l.log(LevelCritical, msg, kv) }
+
+func (l fieldLogger) With(kv ...interface{}) Logger {
+	return fieldLogger(fields(l, kv))
+}
+
+// fields returns a copy of base with the key/value pairs appended. A
+// key without a value is given the value "(MISSING)", as fmt does.
+func fields(base []Field, kv []interface{}) []Field {
+	fs := make([]Field, len(base), len(base)+(len(kv)+1)/2)
+	copy(fs, base)
+	for i := 0; i < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			key = fmt.Sprint(kv[i])
+		}
+
+		var value interface{} = "(MISSING)"
+		if i+1 < len(kv) {
+			value = kv[i+1]
+		}
+		fs = append(fs, Field{Key: key, Value: value})
+	}
+	return fs
+}
+
+type contextKey int
+
+const requestIDKey contextKey = 0
+
+// WithRequestID returns a copy of ctx carrying the ID of the request
+// being served.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID carried by ctx, or an empty string.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// ForRequest returns a Logger that tags every message with a request
+// ID, unless it is empty.
+func ForRequest(id string) Logger {
+	if id == "" {
+		return New()
+	}
+	return New("request_id", id)
+}
+
+// FromContext returns a Logger that tags every message with the request
+// ID carried by ctx.
+func FromContext(ctx context.Context) Logger {
+	return ForRequest(RequestID(ctx))
+}
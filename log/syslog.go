@@ -0,0 +1,44 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package log
+
+import (
+	"log/syslog"
+)
+
+// SyslogSink writes entries to the system log, at the priority matching
+// their level, in the current Format. The system log records the time
+// itself.
+type SyslogSink struct {
+	w *syslog.Writer
+}
+
+// NewSyslogSink returns a Sink that writes entries to the local system
+// log, in the daemon facility, tagged with tag.
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{w: w}, nil
+}
+
+// Write writes an entry at the priority for its level.
+func (s *SyslogSink) Write(e *Entry) error {
+	msg := string(e.Encode(Format))
+	switch e.Level {
+	case LevelDebug:
+		return s.w.Debug(msg)
+	case LevelInfo:
+		return s.w.Info(msg)
+	case LevelWarning:
+		return s.w.Warning(msg)
+	case LevelError:
+		return s.w.Err(msg)
+	default:
+		return s.w.Crit(msg)
+	}
+}
+
+var _ Sink = (*SyslogSink)(nil)
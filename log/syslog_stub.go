@@ -0,0 +1,20 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package log
+
+import "errors"
+
+// SyslogSink is not available on this platform.
+type SyslogSink struct{}
+
+// NewSyslogSink always returns an error, as there is no system log on
+// this platform.
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	return nil, errors.New("log: syslog is not supported on this platform")
+}
+
+// Write discards the entry.
+func (s *SyslogSink) Write(e *Entry) error {
+	return nil
+}
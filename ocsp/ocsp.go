@@ -14,6 +14,7 @@ import (
 	"io/ioutil"
 	"time"
 
+	"github.com/bbandix/cfssl/audit"
 	cferr "github.com/bbandix/cfssl/errors"
 	"github.com/bbandix/cfssl/helpers"
 	"github.com/bbandix/cfssl/log"
@@ -35,6 +36,8 @@ type SignRequest struct {
 	Status      string
 	Reason      int
 	RevokedAt   time.Time
+	RequestID   string // ID of the API request being served, for logging
+	Requester   string // identity of the client, for the audit log
 }
 
 // Signer represents a general signer of OCSP responses.  It is
@@ -108,6 +111,31 @@ func NewSigner(issuer, responder *x509.Certificate, key crypto.Signer, interval
 // Sign is used with an OCSP signer to request the issuance of
 // an OCSP response.
 func (s StandardSigner) Sign(req SignRequest) ([]byte, error) {
+	rec := &audit.Record{
+		Operation: audit.OpOCSPSign,
+		RequestID: req.RequestID,
+		Requester: req.Requester,
+		Status:    req.Status,
+	}
+	if req.Status == "revoked" {
+		rec.Operation = audit.OpRevoke
+	}
+	if req.Certificate != nil {
+		rec.SetCertificate(req.Certificate)
+	}
+
+	resp, err := s.sign(req)
+	rec.SetOutcome(err)
+	if auditErr := audit.Write(rec); auditErr != nil {
+		log.ForRequest(req.RequestID).Error("failed to write audit record", "error", auditErr)
+		if err == nil {
+			return nil, cferr.Wrap(cferr.OCSPError, cferr.Unknown, auditErr)
+		}
+	}
+	return resp, err
+}
+
+func (s StandardSigner) sign(req SignRequest) ([]byte, error) {
 	if req.Certificate == nil {
 		return nil, cferr.New(cferr.OCSPError, cferr.ReadFailed)
 	}
@@ -8,9 +8,23 @@ import (
 	"regexp"
 
 	"github.com/bbandix/cfssl/log"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/crypto/ocsp"
 )
 
+var ocspLookups = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "cfssl",
+		Name:      "ocsp_lookups_total",
+		Help:      "Number of OCSP requests looked up, by whether a response was found.",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(ocspLookups)
+}
+
 var (
 	malformedRequestErrorResponse = []byte{0x30, 0x03, 0x0A, 0x01, 0x01}
 	internalErrorErrorResponse    = []byte{0x30, 0x03, 0x0A, 0x01, 0x02}
@@ -154,10 +168,12 @@ func (rs Responder) ServeHTTP(response http.ResponseWriter, request *http.Reques
 	// Look up OCSP response from source
 	ocspResponse, found := rs.Source.Response(ocspRequest)
 	if !found {
+		ocspLookups.WithLabelValues("miss").Inc()
 		log.Errorf("No response found for request: %s", b64Body)
 		response.Write(unauthorizedErrorResponse)
 		return
 	}
+	ocspLookups.WithLabelValues("hit").Inc()
 
 	// Write OCSP response to response
 	response.WriteHeader(http.StatusOK)
@@ -0,0 +1,164 @@
+// Package renew implements certificate renewal: re-issuing a
+// certificate previously issued by a CA with the same subject and
+// SANs, optionally for a new key.
+package renew
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"time"
+
+	cferr "github.com/bbandix/cfssl/errors"
+	"github.com/bbandix/cfssl/helpers"
+	"github.com/bbandix/cfssl/info"
+	"github.com/bbandix/cfssl/log"
+	"github.com/bbandix/cfssl/revoke"
+	"github.com/bbandix/cfssl/signer"
+)
+
+// A Request is a request to renew an existing certificate. If a new
+// CSR is given, the renewed certificate is issued for its key;
+// otherwise it is issued for the key of the existing certificate. The
+// signature, if present, is a proof of possession of the existing
+// certificate's private key; see Sign.
+type Request struct {
+	Certificate string `json:"certificate"`
+	Request     string `json:"certificate_request,omitempty"`
+	Profile     string `json:"profile"`
+	Label       string `json:"label"`
+	Signature   []byte `json:"signature,omitempty"`
+}
+
+// proofMessage returns the message covered by a proof of possession:
+// the existing certificate followed by the new CSR, if any, both in
+// DER.
+func proofMessage(cert *x509.Certificate, csrPEM string) []byte {
+	msg := append([]byte{}, cert.Raw...)
+	if block, _ := pem.Decode([]byte(csrPEM)); block != nil {
+		msg = append(msg, block.Bytes...)
+	}
+	return msg
+}
+
+// proofAlgo returns the signature algorithm used for a proof of
+// possession with the given public key.
+func proofAlgo(pub crypto.PublicKey) x509.SignatureAlgorithm {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return x509.SHA256WithRSA
+	case *ecdsa.PublicKey:
+		return x509.ECDSAWithSHA256
+	default:
+		return x509.UnknownSignatureAlgorithm
+	}
+}
+
+// Sign fills in the request's proof of possession, signing the
+// existing certificate and new CSR with priv, the private key of the
+// existing certificate.
+func (req *Request) Sign(priv crypto.Signer) error {
+	cert, err := helpers.ParseCertificatePEM([]byte(req.Certificate))
+	if err != nil {
+		return err
+	}
+
+	if proofAlgo(priv.Public()) == x509.UnknownSignatureAlgorithm {
+		return cferr.New(cferr.PrivateKeyError, cferr.NotRSAOrECC)
+	}
+
+	digest := sha256.Sum256(proofMessage(cert, req.Request))
+	req.Signature, err = priv.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return cferr.Wrap(cferr.PrivateKeyError, cferr.Unknown, err)
+	}
+	return nil
+}
+
+// Renew verifies that the certificate in req was issued by the CA
+// behind s, has not expired and has not been revoked, and has a valid
+// proof of possession if one is required. It then has s issue a
+// replacement with the same subject and SANs under the requested
+// profile. A proof is always required to renew for a new key, as
+// otherwise anyone holding the certificate could have its names issued
+// to a key of their own; it is required to renew for the same key by
+// profiles with renewal_proof set and by profiles that require
+// authentication, as the key holder is the only party that can renew
+// under them. s must hold the CA key: remote signers don't re-issue
+// end-entity certificates.
+func Renew(s signer.Signer, req *Request) ([]byte, error) {
+	cert, err := helpers.ParseCertificatePEM([]byte(req.Certificate))
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(cert.NotAfter) {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.VerifyFailed,
+			errors.New("certificate has expired and can no longer be renewed"))
+	}
+
+	if cert.IsCA {
+		return nil, cferr.Wrap(cferr.PolicyError, cferr.InvalidRequest,
+			errors.New("CA certificates cannot be renewed"))
+	}
+
+	resp, err := s.Info(info.Req{Label: req.Label, Profile: req.Profile})
+	if err != nil {
+		return nil, err
+	}
+
+	ca, err := helpers.ParseCertificatePEM([]byte(resp.Certificate))
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(cert.RawIssuer, ca.RawSubject) || cert.CheckSignatureFrom(ca) != nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.VerifyFailed,
+			errors.New("certificate was not issued by this CA"))
+	}
+
+	revoked, ok := revoke.VerifyCertificate(cert)
+	if revoked {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.VerifyFailed,
+			errors.New("certificate has been revoked"))
+	} else if !ok {
+		log.Warningf("could not check revocation status of certificate %d", cert.SerialNumber)
+		if revoke.HardFail {
+			return nil, cferr.Wrap(cferr.CertificateError, cferr.VerifyFailed,
+				errors.New("revocation status of certificate could not be checked"))
+		}
+	}
+
+	profile, err := signer.Profile(s, req.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Signature != nil {
+		err = cert.CheckSignature(proofAlgo(cert.PublicKey), proofMessage(cert, req.Request), req.Signature)
+		if err != nil {
+			return nil, cferr.Wrap(cferr.PrivateKeyError, cferr.KeyMismatch, err)
+		}
+	} else if req.Request != "" || profile.RenewalProof || profile.Provider != nil {
+		return nil, cferr.Wrap(cferr.PolicyError, cferr.InvalidRequest,
+			errors.New("renewal requires a proof of possession of the certificate key"))
+	}
+
+	// A new CSR only supplies the key: the subject and SANs are
+	// those of the existing certificate.
+	signReq := signer.SignRequest{
+		Request: req.Certificate,
+		Profile: req.Profile,
+		Label:   req.Label,
+		Renewal: &signer.Renewal{Request: req.Request},
+	}
+
+	log.Infof("renewing certificate with serial number %d", cert.SerialNumber)
+	return s.Sign(signReq)
+}
@@ -0,0 +1,324 @@
+package renew
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/bbandix/cfssl/config"
+	"github.com/bbandix/cfssl/csr"
+	"github.com/bbandix/cfssl/helpers"
+	"github.com/bbandix/cfssl/signer"
+	"github.com/bbandix/cfssl/signer/local"
+)
+
+const (
+	testCaFile         = "../signer/local/testdata/ca.pem"
+	testCaKeyFile      = "../signer/local/testdata/ca_key.pem"
+	testECDSACaFile    = "../signer/local/testdata/ecdsa256_ca.pem"
+	testECDSACaKeyFile = "../signer/local/testdata/ecdsa256_ca_key.pem"
+)
+
+func newTestSigner(t *testing.T, caFile, caKeyFile string, profile *config.SigningProfile) *local.Signer {
+	s, err := local.NewSignerFromFile(caFile, caKeyFile, &config.Signing{
+		Profiles: map[string]*config.SigningProfile{},
+		Default:  profile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func newTestProfile() *config.SigningProfile {
+	return &config.SigningProfile{
+		Usage:  []string{"digital signature", "server auth"},
+		Expiry: time.Hour,
+	}
+}
+
+// issue generates a key and has s sign a certificate for it.
+func issue(t *testing.T, s signer.Signer) (crypto.Signer, []byte) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csrPEM, err := csr.Generate(priv, &csr.CertificateRequest{
+		CN:    "renew.example.com",
+		Names: []csr.Name{{O: "Example", C: "US"}},
+		Hosts: []string{"renew.example.com", "127.0.0.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM, err := s.Sign(signer.SignRequest{Request: string(csrPEM)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, certPEM
+}
+
+func checkRenewed(t *testing.T, oldPEM, newPEM []byte, pub crypto.PublicKey) {
+	old, err := helpers.ParseCertificatePEM(oldPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := helpers.ParseCertificatePEM(newPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert.Subject.CommonName != old.Subject.CommonName || cert.Subject.Organization[0] != "Example" {
+		t.Fatalf("renewed certificate subject mismatch: %v", cert.Subject)
+	}
+	if len(cert.DNSNames) != 1 || cert.DNSNames[0] != "renew.example.com" {
+		t.Fatalf("renewed certificate DNS names mismatch: %v", cert.DNSNames)
+	}
+	if len(cert.IPAddresses) != 1 || !cert.IPAddresses[0].Equal(old.IPAddresses[0]) {
+		t.Fatalf("renewed certificate IP addresses mismatch: %v", cert.IPAddresses)
+	}
+	if cert.SerialNumber.Cmp(old.SerialNumber) == 0 {
+		t.Fatal("renewed certificate has the same serial number")
+	}
+
+	expected, _ := x509.MarshalPKIXPublicKey(pub)
+	if string(cert.RawSubjectPublicKeyInfo) != string(expected) {
+		t.Fatal("renewed certificate public key mismatch")
+	}
+}
+
+func TestRenewSameKey(t *testing.T) {
+	s := newTestSigner(t, testCaFile, testCaKeyFile, newTestProfile())
+	priv, certPEM := issue(t, s)
+
+	renewed, err := Renew(s, &Request{Certificate: string(certPEM)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRenewed(t, certPEM, renewed, priv.Public())
+}
+
+func TestRenewNewKey(t *testing.T) {
+	s := newTestSigner(t, testCaFile, testCaKeyFile, newTestProfile())
+	priv, certPEM := issue(t, s)
+
+	newPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrPEM, err := csr.Generate(newPriv, &csr.CertificateRequest{CN: "other.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Anyone can hold the certificate: only its key holder can have
+	// it renewed for another key.
+	req := &Request{Certificate: string(certPEM), Request: string(csrPEM)}
+	if _, err = Renew(s, req); err == nil {
+		t.Fatal("renewal for a new key without a proof should fail")
+	}
+	if err = req.Sign(newPriv); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Renew(s, req); err == nil {
+		t.Fatal("renewal for a new key with a proof from the new key should fail")
+	}
+
+	if err = req.Sign(priv); err != nil {
+		t.Fatal(err)
+	}
+	renewed, err := Renew(s, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRenewed(t, certPEM, renewed, newPriv.Public())
+}
+
+func TestRenewKeepsNames(t *testing.T) {
+	s := newTestSigner(t, testCaFile, testCaKeyFile, newTestProfile())
+	ca, caKey := loadTestCA(t)
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri, _ := url.Parse("spiffe://example.com/renew")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName:         "renew.example.com",
+			Organization:       []string{"Example"},
+			OrganizationalUnit: []string{"Ops", "Web"},
+			SerialNumber:       "42",
+		},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		DNSNames:       []string{"renew.example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("127.0.0.1")},
+		EmailAddresses: []string{"ops@example.com"},
+		URIs:           []*url.URL{uri},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, priv.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	newPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrPEM, err := csr.Generate(newPriv, &csr.CertificateRequest{CN: "other.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &Request{Certificate: string(certPEM), Request: string(csrPEM)}
+	if err = req.Sign(priv); err != nil {
+		t.Fatal(err)
+	}
+	renewedPEM, err := Renew(s, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRenewed(t, certPEM, renewedPEM, newPriv.Public())
+
+	renewed, err := helpers.ParseCertificatePEM(renewedPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, _ := x509.ParseCertificate(der)
+	if !bytes.Equal(renewed.RawSubject, old.RawSubject) {
+		t.Fatalf("renewed certificate subject mismatch: %v", renewed.Subject)
+	}
+	if len(renewed.EmailAddresses) != 1 || renewed.EmailAddresses[0] != "ops@example.com" {
+		t.Fatalf("renewed certificate email addresses mismatch: %v", renewed.EmailAddresses)
+	}
+	if len(renewed.URIs) != 1 || renewed.URIs[0].String() != uri.String() {
+		t.Fatalf("renewed certificate URIs mismatch: %v", renewed.URIs)
+	}
+}
+
+func TestRenewProof(t *testing.T) {
+	profile := newTestProfile()
+	profile.RenewalProof = true
+	s := newTestSigner(t, testCaFile, testCaKeyFile, profile)
+	priv, certPEM := issue(t, s)
+
+	req := &Request{Certificate: string(certPEM)}
+	if _, err := Renew(s, req); err == nil {
+		t.Fatal("renewal without a required proof should fail")
+	}
+
+	wrongPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = req.Sign(wrongPriv); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Renew(s, req); err == nil {
+		t.Fatal("renewal with a proof from the wrong key should fail")
+	}
+
+	if err = req.Sign(priv); err != nil {
+		t.Fatal(err)
+	}
+	renewed, err := Renew(s, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRenewed(t, certPEM, renewed, priv.Public())
+}
+
+func TestRenewOtherCA(t *testing.T) {
+	s := newTestSigner(t, testCaFile, testCaKeyFile, newTestProfile())
+	other := newTestSigner(t, testECDSACaFile, testECDSACaKeyFile, newTestProfile())
+	_, certPEM := issue(t, other)
+
+	if _, err := Renew(s, &Request{Certificate: string(certPEM)}); err == nil {
+		t.Fatal("renewal of a certificate from another CA should fail")
+	}
+
+	// The signer itself refuses to re-issue it as well.
+	req := signer.SignRequest{Request: string(certPEM), Renewal: &signer.Renewal{}}
+	if _, err := s.Sign(req); err == nil {
+		t.Fatal("re-issue of a certificate from another CA should fail")
+	}
+}
+
+func TestSignRefusesEndEntityCertificate(t *testing.T) {
+	s := newTestSigner(t, testCaFile, testCaKeyFile, newTestProfile())
+	_, certPEM := issue(t, s)
+
+	// Outside of renewal, as through the sign endpoint, an end-entity
+	// certificate is never re-issued.
+	if _, err := s.Sign(signer.SignRequest{Request: string(certPEM)}); err == nil {
+		t.Fatal("re-issue of a certificate outside of renewal should fail")
+	}
+}
+
+// loadTestCA loads the test CA certificate and key.
+func loadTestCA(t *testing.T) (*x509.Certificate, crypto.Signer) {
+	caPEM, err := ioutil.ReadFile(testCaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := helpers.ParseCertificatePEM(caPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := ioutil.ReadFile(testCaKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caKey, err := helpers.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, caKey
+}
+
+func TestRenewRevoked(t *testing.T) {
+	ca, caKey := loadTestCA(t)
+
+	var crl []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(crl)
+	}))
+	defer ts.Close()
+
+	profile := newTestProfile()
+	profile.CRL = ts.URL
+	s := newTestSigner(t, testCaFile, testCaKeyFile, profile)
+	_, certPEM := issue(t, s)
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crl, err = ca.CreateCRL(rand.Reader, caKey, []pkix.RevokedCertificate{
+		{SerialNumber: cert.SerialNumber, RevocationTime: time.Now()},
+	}, time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = Renew(s, &Request{Certificate: string(certPEM)}); err == nil {
+		t.Fatal("renewal of a revoked certificate should fail")
+	}
+}
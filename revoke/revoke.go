@@ -1,17 +1,21 @@
 // Package revoke provides functionality for checking the validity of
 // a cert. Specifically, the temporal validity of the certificate is
-// checked first, then any CRL in the cert is checked. OCSP is not
-// supported at this time.
+// checked first, then any CRL in the cert is checked. Given the
+// issuer, OCSPStatus and CRLStatus also verify the responses and CRLs
+// they fetch.
 package revoke
 
 import (
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/base64"
 	"encoding/pem"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	neturl "net/url"
@@ -259,23 +263,159 @@ func sendOCSPRequest(server string, req []byte, issuer *x509.Certificate) (ocspR
 		return
 	}
 
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return
+		return nil, fmt.Errorf("OCSP server returned %s", resp.Status)
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return
 	}
-	resp.Body.Close()
 
 	if bytes.Equal(body, ocspUnauthorised) {
-		return
+		return nil, errors.New("OCSP server is unauthorised for the certificate")
 	}
 
 	if bytes.Equal(body, ocspMalformed) {
-		return
+		return nil, errors.New("OCSP server rejected the request as malformed")
 	}
 
 	return ocsp.ParseResponse(body, issuer)
 }
+
+// MaxFetchSize is the largest CRL or OCSP response OCSPStatus and
+// CRLStatus will fetch.
+var MaxFetchSize int64 = 16 << 20
+
+// fetchClient is the HTTP client used by get. It doesn't follow
+// redirects, so that the URLs in a certificate being checked can't
+// send requests elsewhere.
+var fetchClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// get fetches url, giving up when ctx is done, and fails if the body is
+// larger than MaxFetchSize.
+func get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := fetchClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxFetchSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > MaxFetchSize {
+		return nil, fmt.Errorf("%s returned more than %d bytes", url, MaxFetchSize)
+	}
+	return body, nil
+}
+
+// ParseOCSPResponse parses a DER-encoded OCSP response for cert, such as
+// one stapled to a TLS handshake. It fails unless the response is for
+// cert, is signed by issuer or a responder it delegated to, and is
+// current.
+func ParseOCSPResponse(der []byte, cert, issuer *x509.Certificate) (*ocsp.Response, error) {
+	resp, err := ocsp.ParseResponseForCert(der, cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if resp.ThisUpdate.After(now) {
+		return nil, fmt.Errorf("OCSP response isn't valid until %s", resp.ThisUpdate)
+	}
+	if !resp.NextUpdate.IsZero() && resp.NextUpdate.Before(now) {
+		return nil, fmt.Errorf("OCSP response expired %s", resp.NextUpdate)
+	}
+	return resp, nil
+}
+
+// OCSPStatus requests the status of cert, issued by issuer, from each of
+// its OCSP servers in turn, returning the first valid response as
+// checked by ParseOCSPResponse.
+func OCSPStatus(ctx context.Context, cert, issuer *x509.Certificate) (*ocsp.Response, error) {
+	if len(cert.OCSPServer) == 0 {
+		return nil, errors.New("certificate has no OCSP server")
+	}
+
+	req, err := ocsp.CreateRequest(cert, issuer, &ocspOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, server := range cert.OCSPServer {
+		var der []byte
+		der, err = get(ctx, server+"/"+neturl.PathEscape(base64.StdEncoding.EncodeToString(req)))
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(der, ocspUnauthorised) {
+			err = fmt.Errorf("%s is unauthorised for the certificate", server)
+			continue
+		}
+		if bytes.Equal(der, ocspMalformed) {
+			err = fmt.Errorf("%s rejected the request as malformed", server)
+			continue
+		}
+
+		var resp *ocsp.Response
+		if resp, err = ParseOCSPResponse(der, cert, issuer); err == nil {
+			return resp, nil
+		}
+	}
+	return nil, err
+}
+
+// CRLStatus fetches each of cert's CRLs, other than those in LDAP, and
+// reports whether any of them revokes it. Unlike VerifyCertificate, it
+// fails unless every CRL is signed by issuer and is current, and doesn't
+// use or fill CRLSet.
+func CRLStatus(ctx context.Context, cert, issuer *x509.Certificate) (revoked bool, err error) {
+	var checked bool
+	for _, url := range cert.CRLDistributionPoints {
+		if ldapURL(url) {
+			continue
+		}
+
+		var der []byte
+		if der, err = get(ctx, url); err != nil {
+			return
+		}
+
+		var crl *pkix.CertificateList
+		if crl, err = x509.ParseCRL(der); err != nil {
+			return
+		}
+		if err = issuer.CheckCRLSignature(crl); err != nil {
+			return false, fmt.Errorf("CRL %s: %v", url, err)
+		}
+		if crl.HasExpired(time.Now()) {
+			return false, fmt.Errorf("CRL %s expired %s", url, crl.TBSCertList.NextUpdate)
+		}
+
+		for _, rc := range crl.TBSCertList.RevokedCertificates {
+			if cert.SerialNumber.Cmp(rc.SerialNumber) == 0 {
+				return true, nil
+			}
+		}
+		checked = true
+	}
+
+	if !checked {
+		err = errors.New("certificate has no CRL that can be fetched")
+	}
+	return
+}
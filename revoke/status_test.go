@@ -0,0 +1,192 @@
+package revoke
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// testPKI is a CA and a certificate it issued, whose OCSP server and CRL
+// are served by a test server.
+type testPKI struct {
+	ca, cert *x509.Certificate
+	key      crypto.Signer
+	revoked  bool
+	srv      *httptest.Server
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	p := new(testPKI)
+	p.srv = httptest.NewServer(http.HandlerFunc(p.serve))
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.key = key
+
+	now := time.Now()
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ca, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "example.com"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		OCSPServer:            []string{p.srv.URL + "/ocsp"},
+		CRLDistributionPoints: []string{p.srv.URL + "/crl"},
+	}
+	der, err = x509.CreateCertificate(rand.Reader, template, p.ca, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func (p *testPKI) ocspResponse(nextUpdate time.Time) ([]byte, error) {
+	status := ocsp.Good
+	if p.revoked {
+		status = ocsp.Revoked
+	}
+	return ocsp.CreateResponse(p.ca, p.ca, ocsp.Response{
+		Status:       status,
+		SerialNumber: p.cert.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   nextUpdate,
+		RevokedAt:    time.Now().Add(-time.Minute),
+	}, p.key)
+}
+
+func (p *testPKI) serve(w http.ResponseWriter, r *http.Request) {
+	var out []byte
+	var err error
+	if r.URL.Path == "/crl" {
+		var revoked []pkix.RevokedCertificate
+		if p.revoked {
+			revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: p.cert.SerialNumber, RevocationTime: time.Now()})
+		}
+		out, err = p.ca.CreateCRL(rand.Reader, p.key, revoked, time.Now(), time.Now().Add(time.Hour))
+	} else {
+		out, err = p.ocspResponse(time.Now().Add(time.Hour))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(out)
+}
+
+func TestOCSPStatus(t *testing.T) {
+	p := newTestPKI(t)
+	defer p.srv.Close()
+
+	resp, err := OCSPStatus(context.Background(), p.cert, p.ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != ocsp.Good {
+		t.Fatalf("expected a good status, have %d", resp.Status)
+	}
+
+	p.revoked = true
+	if resp, err = OCSPStatus(context.Background(), p.cert, p.ca); err != nil || resp.Status != ocsp.Revoked {
+		t.Fatalf("expected a revoked status, have %v", err)
+	}
+}
+
+func TestParseOCSPResponseExpired(t *testing.T) {
+	p := newTestPKI(t)
+	defer p.srv.Close()
+
+	der, err := p.ocspResponse(time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParseOCSPResponse(der, p.cert, p.ca); err == nil {
+		t.Fatal("expected an expired response to be rejected")
+	}
+	if _, err = ParseOCSPResponse(der, p.ca, p.ca); err == nil {
+		t.Fatal("expected a response for another certificate to be rejected")
+	}
+}
+
+func TestCRLStatus(t *testing.T) {
+	p := newTestPKI(t)
+	defer p.srv.Close()
+
+	revoked, err := CRLStatus(context.Background(), p.cert, p.ca)
+	if err != nil || revoked {
+		t.Fatalf("expected the certificate not to be revoked, have %v, %v", revoked, err)
+	}
+
+	p.revoked = true
+	if revoked, err = CRLStatus(context.Background(), p.cert, p.ca); err != nil || !revoked {
+		t.Fatalf("expected the certificate to be revoked, have %v, %v", revoked, err)
+	}
+
+	// A CRL signed by another CA is rejected.
+	other := newTestPKI(t)
+	defer other.srv.Close()
+	if _, err = CRLStatus(context.Background(), p.cert, other.ca); err == nil {
+		t.Fatal("expected a CRL with a bad signature to be rejected")
+	}
+
+	if _, err = CRLStatus(context.Background(), p.ca, p.ca); err == nil {
+		t.Fatal("expected a certificate without a CRL to fail")
+	}
+}
+
+func TestGet(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		w.Write(make([]byte, 100))
+	}))
+	defer srv.Close()
+
+	defer func(size int64) { MaxFetchSize = size }(MaxFetchSize)
+	MaxFetchSize = 100
+	if body, err := get(context.Background(), srv.URL); err != nil || len(body) != 100 {
+		t.Fatalf("failed to fetch a response of the maximum size: %v", err)
+	}
+
+	MaxFetchSize = 99
+	if _, err := get(context.Background(), srv.URL); err == nil {
+		t.Fatal("expected a response over the maximum size to fail")
+	}
+
+	if _, err := get(context.Background(), srv.URL+"/redirect"); err == nil {
+		t.Fatal("expected a redirect not to be followed")
+	}
+}
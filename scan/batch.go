@@ -0,0 +1,375 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bbandix/cfssl/log"
+)
+
+// DefaultConcurrency is the number of hosts a Batch scans at once unless
+// told otherwise.
+var DefaultConcurrency = 16
+
+// HostResult is the result of scanning a single host in a batch.
+type HostResult struct {
+	Host    string                  `json:"host"`
+	Results map[string]FamilyResult `json:"results,omitempty"`
+	Error   string                  `json:"error,omitempty"`
+	// Duration is the time the host took to scan, in seconds.
+	Duration float64 `json:"duration"`
+}
+
+// A Batch scans many hosts, running the same families and scanners
+// against each of them.
+type Batch struct {
+	// Families contains the families to run scans from.
+	Families FamilySet
+	// Family and Scanner are regular expressions selecting the scans
+	// to run, as for RunScans.
+	Family, Scanner string
+	// Concurrency is the number of hosts scanned at once, or
+	// DefaultConcurrency if less than one.
+	Concurrency int
+	// Workers is the number of scanners run at once on each host.
+	Workers int
+	// Timeout bounds the scan of each host, if positive.
+	Timeout time.Duration
+	// Rate limits the connections made to each host to this many a
+	// second, if positive.
+	Rate float64
+	// StartTLS is the protocol to negotiate STARTTLS with before each
+	// TLS handshake, if not empty.
+	StartTLS string
+	// Done holds hosts that have already been scanned, which are
+	// skipped. It is used to resume an interrupted batch. Hosts given
+	// more than once are only scanned once regardless.
+	Done map[string]bool
+}
+
+// Run scans each host received from hosts, calling emit with the result
+// of each host as soon as it has been scanned. Calls to emit are
+// serialised. Run returns when hosts is closed and every host has been
+// scanned, when ctx is done, or when emit returns an error.
+func (b *Batch) Run(ctx context.Context, hosts <-chan string, emit func(*HostResult) error) error {
+	concurrency := b.Concurrency
+	if concurrency < 1 {
+		concurrency = DefaultConcurrency
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var emitLock sync.Mutex
+	var emitErr error
+	var limiters limiterSet
+
+	var seenLock sync.Mutex
+	seen := make(map[string]bool)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var host string
+				var ok bool
+				select {
+				case host, ok = <-hosts:
+				case <-ctx.Done():
+				}
+				if !ok || ctx.Err() != nil {
+					return
+				}
+				seenLock.Lock()
+				skip := b.Done[host] || seen[host]
+				seen[host] = true
+				seenLock.Unlock()
+				if skip {
+					continue
+				}
+
+				result := b.scanHost(ctx, host, &limiters)
+				if ctx.Err() != nil {
+					// The batch was interrupted, and the host will
+					// be scanned again if it is resumed.
+					return
+				}
+
+				emitLock.Lock()
+				if emitErr == nil {
+					if emitErr = emit(result); emitErr != nil {
+						cancel()
+					}
+				}
+				emitLock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if emitErr != nil {
+		return emitErr
+	}
+	return ctx.Err()
+}
+
+func (b *Batch) scanHost(ctx context.Context, host string, limiters *limiterSet) *HostResult {
+	if b.Rate > 0 {
+		ctx = withLimiter(ctx, limiters.get(hostname(host), b.Rate))
+	}
+	if b.StartTLS != "" {
+		ctx = WithStartTLS(ctx, b.StartTLS)
+	}
+	if b.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	result := &HostResult{Host: host}
+	results, err := b.Families.RunScans(ctx, host, "", b.Family, b.Scanner, b.Workers)
+	if err != nil {
+		log.Warningf("scan: %s: %v", host, err)
+		result.Error = err.Error()
+	}
+	result.Results = results
+	result.Duration = time.Since(start).Seconds()
+	return result
+}
+
+// hostname returns the host part of a host with an optional port.
+func hostname(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		return name
+	}
+	return host
+}
+
+// ReadHosts reads hosts, one a line, and sends them to hosts until r is
+// exhausted or ctx is done. Blank lines and lines starting with # are
+// skipped. A line may also be a CIDR range with an optional port, such
+// as 192.0.2.0/24:443, in which case each address in the range is sent.
+func ReadHosts(ctx context.Context, r io.Reader, hosts chan<- string) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := ExpandHost(ctx, line, hosts); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// MinIPv4Prefix and MinIPv6Prefix are the shortest prefixes of the
+// CIDR ranges ExpandHost and the IntermediateCAs scanner accept, so that a mistyped range can't queue
+// scans of millions of addresses.
+const (
+	MinIPv4Prefix = 16
+	MinIPv6Prefix = 112
+)
+
+// ExpandHost sends host to hosts or, if host is a CIDR range with an
+// optional port, each address in the range. Ranges with prefixes
+// shorter than MinIPv4Prefix or MinIPv6Prefix are rejected before any
+// address is sent.
+func ExpandHost(ctx context.Context, host string, hosts chan<- string) error {
+	cidr, port, err := net.SplitHostPort(host)
+	if err != nil {
+		cidr, port = host, ""
+	}
+
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return send(ctx, host, hosts)
+	}
+
+	if err = checkRange(cidr, ipnet); err != nil {
+		return err
+	}
+
+	ip := make(net.IP, len(ipnet.IP))
+	copy(ip, ipnet.IP)
+	for ; ipnet.Contains(ip); incrementBytes(ip) {
+		addr := ip.String()
+		if port != "" {
+			addr = net.JoinHostPort(addr, port)
+		}
+		if err = send(ctx, addr, hosts); err != nil {
+			return err
+		}
+		if isMaxIP(ip) {
+			break
+		}
+	}
+	return nil
+}
+
+// checkRange returns an error if ipnet, parsed from cidr, has a prefix
+// shorter than MinIPv4Prefix or MinIPv6Prefix.
+func checkRange(cidr string, ipnet *net.IPNet) error {
+	ones, bits := ipnet.Mask.Size()
+	min := MinIPv4Prefix
+	if bits == 8*net.IPv6len {
+		min = MinIPv6Prefix
+	}
+	if ones < min {
+		return fmt.Errorf("%s is wider than /%d", cidr, min)
+	}
+	return nil
+}
+
+func send(ctx context.Context, host string, hosts chan<- string) error {
+	select {
+	case hosts <- host:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isMaxIP reports whether every bit of ip is set, so that incrementing
+// it would wrap around.
+func isMaxIP(ip net.IP) bool {
+	for _, b := range ip {
+		if b != 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadDone reads the results of an earlier batch, as written by
+// WriteResult, and returns the hosts it scanned. A truncated last line,
+// as left by an interrupted batch, is ignored.
+func ReadDone(r io.Reader) (map[string]bool, error) {
+	done := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var result HostResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			continue
+		}
+		done[result.Host] = true
+	}
+	return done, scanner.Err()
+}
+
+// WriteResult writes a result to w as a single line of JSON.
+func WriteResult(w io.Writer, result *HostResult) error {
+	out, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(out, '\n'))
+	return err
+}
+
+// A limiter spaces out connections so that no more than a given number
+// are made a second.
+type limiter struct {
+	lock     sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// wait blocks until a connection may be made, or until ctx is done.
+func (l *limiter) wait(ctx context.Context) error {
+	l.lock.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.lock.Unlock()
+
+	if d := at.Sub(now); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// limiterSet holds a limiter for each host.
+type limiterSet struct {
+	lock     sync.Mutex
+	limiters map[string]*limiter
+}
+
+func (s *limiterSet) get(host string, rate float64) *limiter {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.limiters == nil {
+		s.limiters = make(map[string]*limiter)
+	}
+	l := s.limiters[host]
+	if l == nil {
+		l = &limiter{interval: time.Duration(float64(time.Second) / rate)}
+		s.limiters[host] = l
+	}
+	return l
+}
+
+type contextKey int
+
+const (
+	limiterKey contextKey = iota
+	startTLSKey
+)
+
+func withLimiter(ctx context.Context, l *limiter) context.Context {
+	return context.WithValue(ctx, limiterKey, l)
+}
+
+// waitLimit blocks until the rate limit carried by ctx, if any, allows
+// a connection. It returns a context without the limit for making the
+// connection, so that a timeout set on it doesn't count the wait.
+func waitLimit(ctx context.Context) (context.Context, error) {
+	l, ok := ctx.Value(limiterKey).(*limiter)
+	if !ok || l == nil {
+		return ctx, nil
+	}
+	if err := l.wait(ctx); err != nil {
+		return nil, err
+	}
+	return withLimiter(ctx, nil), nil
+}
+
+// dial connects to addr once the rate limit carried by ctx, if any,
+// allows it, and negotiates STARTTLS if ctx asks for it.
+func dial(ctx context.Context, addr string) (net.Conn, error) {
+	ctx, err := waitLimit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := Dialer.DialContext(ctx, Network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if opts, ok := startTLSFrom(ctx); ok {
+		if err = negotiateStartTLS(ctx, conn, addr, opts); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
@@ -0,0 +1,142 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func collect(t *testing.T, fn func(hosts chan<- string) error) []string {
+	hosts := make(chan string)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- fn(hosts)
+		close(hosts)
+	}()
+
+	var all []string
+	for host := range hosts {
+		all = append(all, host)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	return all
+}
+
+func TestReadHosts(t *testing.T) {
+	in := "# hosts\nexample.com\n\n192.0.2.0/30:443\nexample.org:8443\n"
+	hosts := collect(t, func(hosts chan<- string) error {
+		return ReadHosts(context.Background(), strings.NewReader(in), hosts)
+	})
+
+	expected := "example.com 192.0.2.0:443 192.0.2.1:443 192.0.2.2:443 192.0.2.3:443 example.org:8443"
+	if strings.Join(hosts, " ") != expected {
+		t.Fatalf("expected %s, have %s", expected, strings.Join(hosts, " "))
+	}
+
+	hosts = collect(t, func(hosts chan<- string) error {
+		return ExpandHost(context.Background(), "255.255.255.254/31", hosts)
+	})
+	if strings.Join(hosts, " ") != "255.255.255.254 255.255.255.255" {
+		t.Fatalf("unexpected hosts at the end of the address space: %v", hosts)
+	}
+
+	for _, host := range []string{"10.0.0.0/15", "10.0.0.0/8:443", "2001:db8::/111", "[2001:db8::/64]:443"} {
+		if err := ExpandHost(context.Background(), host, make(chan string)); err == nil {
+			t.Fatalf("expected %s to be rejected as too wide", host)
+		}
+	}
+	hosts = collect(t, func(hosts chan<- string) error {
+		return ExpandHost(context.Background(), "2001:db8::fffe/127", hosts)
+	})
+	if strings.Join(hosts, " ") != "2001:db8::fffe 2001:db8::ffff" {
+		t.Fatalf("unexpected hosts in an IPv6 range: %v", hosts)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	b := &Batch{
+		Families: FamilySet{"Testing": TestingFamily},
+		Done:     map[string]bool{"bad.example.com": true},
+	}
+
+	hosts := make(chan string, 3)
+	hosts <- "bad.example.com"
+	hosts <- "good.example.com"
+	hosts <- "Warning.example.com"
+	close(hosts)
+
+	var out bytes.Buffer
+	err := b.Run(context.Background(), hosts, func(result *HostResult) error {
+		return WriteResult(&out, result)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A partly written line, as left by an interrupted batch.
+	out.WriteString(`{"host":"other.exam`)
+
+	done, err := ReadDone(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scanned []string
+	for host := range done {
+		scanned = append(scanned, host)
+	}
+	sort.Strings(scanned)
+	if strings.Join(scanned, " ") != "Warning.example.com good.example.com" {
+		t.Fatalf("unexpected hosts scanned: %v", scanned)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	var s limiterSet
+	l := s.get("example.com", 20)
+	if s.get("example.com", 20) != l {
+		t.Fatal("expected the same limiter for the same host")
+	}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Fatalf("3 connections at 20 a second took only %v", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l.next = time.Now().Add(time.Hour)
+	if err := l.wait(ctx); err == nil {
+		t.Fatal("expected waiting to stop when the context is done")
+	}
+
+	// Once the limit allows a connection, it is made without it.
+	l.next = time.Time{}
+	connCtx, err := waitLimit(withLimiter(context.Background(), l))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.next.IsZero() {
+		t.Fatal("waiting didn't take a connection from the limit")
+	}
+	next := l.next
+	if _, err = waitLimit(connCtx); err != nil || l.next != next {
+		t.Fatal("the connection's context still carries the limit")
+	}
+}
+
+func TestIntermediateCAScanRange(t *testing.T) {
+	grade, _, err := intermediateCAScan(context.Background(), "10.0.0.0/8:443", "")
+	if err == nil || grade == Good {
+		t.Fatal("expected a range wider than /16 to be rejected")
+	}
+}
@@ -1,6 +1,7 @@
 package scan
 
 import (
+	"context"
 	"crypto/x509"
 	"net"
 	"sync"
@@ -37,19 +38,21 @@ var (
 )
 
 // intermediateCAScan scans for new intermediate CAs not in the trust store.
-func intermediateCAScan(addr, hostname string) (grade Grade, output Output, err error) {
+func intermediateCAScan(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
 	cidr, port, _ := net.SplitHostPort(addr)
 	_, ipnet, err := net.ParseCIDR(cidr)
 	if err != nil {
 		return Skipped, nil, nil
 	}
+	if err = checkRange(cidr, ipnet); err != nil {
+		return
+	}
 	b, err := bundler.NewBundler(caBundleFile, intBundleFile)
 	if err != nil {
 		return
 	}
 	var wg sync.WaitGroup
 	wg.Add(numWorkers)
-	dialer := &net.Dialer{Timeout: timeout}
 	config := &tls.Config{InsecureSkipVerify: true}
 	addrs := make(chan string)
 	chains := make(chan []*x509.Certificate, numWorkers)
@@ -61,7 +64,16 @@ func intermediateCAScan(addr, hostname string) (grade Grade, output Output, err
 	for i := 0; i < numWorkers; i++ {
 		go func() {
 			for addr := range addrs {
-				conn, err := tls.DialWithDialer(dialer, Network, addr, config)
+				// Connections are subject to the batch's rate
+				// limit and STARTTLS negotiation; the timeout
+				// starts once the rate limit allows them.
+				connCtx, err := waitLimit(ctx)
+				if err != nil {
+					continue
+				}
+				connCtx, cancel := context.WithTimeout(connCtx, timeout)
+				conn, err := dialTLS(connCtx, addr, config)
+				cancel()
 				if err != nil {
 					continue
 				}
@@ -73,12 +85,20 @@ func intermediateCAScan(addr, hostname string) (grade Grade, output Output, err
 			wg.Done()
 		}()
 	}
+feed:
 	for ip := ipnet.IP.To16(); ipnet.Contains(ip); incrementBytes(ip) {
-		addrs <- net.JoinHostPort(ip.String(), port)
+		select {
+		case addrs <- net.JoinHostPort(ip.String(), port):
+		case <-ctx.Done():
+			err = ctx.Err()
+			break feed
+		}
 	}
 	close(addrs)
 	wg.Wait()
 	close(chains)
-	grade = Good
+	if err == nil {
+		grade = Good
+	}
 	return
 }
@@ -2,11 +2,13 @@ package scan
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strings"
+	"sync"
 
 	"github.com/cloudflare/cf-tls/tls"
 )
@@ -35,8 +37,8 @@ var Connectivity = &Family{
 }
 
 // dnsLookupScan tests that DNS resolution of the host returns at least one address
-func dnsLookupScan(addr, hostname string) (grade Grade, output Output, err error) {
-	addrs, err := net.LookupHost(hostname)
+func dnsLookupScan(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
+	addrs, err := net.DefaultResolver.LookupHost(ctx, hostname)
 	if err != nil {
 		return
 	}
@@ -50,11 +52,16 @@ func dnsLookupScan(addr, hostname string) (grade Grade, output Output, err error
 }
 
 var (
-	cfNets    []*net.IPNet
-	cfNetsErr error
+	cfNetsLock sync.Mutex
+	cfNets     []*net.IPNet
+	cfNetsErr  error
 )
 
 func initOnCloudFlareScan() ([]*net.IPNet, error) {
+	// Scanners run concurrently, so only one of them downloads the ranges.
+	cfNetsLock.Lock()
+	defer cfNetsLock.Unlock()
+
 	// Propogate previous errors and don't attempt to re-download.
 	if cfNetsErr != nil {
 		return nil, cfNetsErr
@@ -97,14 +104,14 @@ func initOnCloudFlareScan() ([]*net.IPNet, error) {
 	return cfNets, nil
 }
 
-func onCloudFlareScan(addr, hostname string) (grade Grade, output Output, err error) {
+func onCloudFlareScan(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
 	var cloudflareNets []*net.IPNet
 	if cloudflareNets, err = initOnCloudFlareScan(); err != nil {
 		grade = Skipped
 		return
 	}
 
-	_, addrs, err := dnsLookupScan(addr, hostname)
+	_, addrs, err := dnsLookupScan(ctx, addr, hostname)
 	if err != nil {
 		return
 	}
@@ -130,8 +137,8 @@ func onCloudFlareScan(addr, hostname string) (grade Grade, output Output, err er
 }
 
 // tcpDialScan tests that the host can be connected to through TCP.
-func tcpDialScan(addr, hostname string) (grade Grade, output Output, err error) {
-	conn, err := Dialer.Dial(Network, addr)
+func tcpDialScan(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
+	conn, err := dial(ctx, addr)
 	if err != nil {
 		return
 	}
@@ -142,17 +149,17 @@ func tcpDialScan(addr, hostname string) (grade Grade, output Output, err error)
 
 // tlsDialScan tests that the host can perform a TLS Handshake
 // and warns if the server's certificate can't be verified.
-func tlsDialScan(addr, hostname string) (grade Grade, output Output, err error) {
+func tlsDialScan(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
 	var conn *tls.Conn
 	config := defaultTLSConfig(hostname)
 
-	if conn, err = tls.DialWithDialer(Dialer, Network, addr, config); err != nil {
+	if conn, err = dialTLS(ctx, addr, config); err != nil {
 		return
 	}
 	conn.Close()
 
 	config.InsecureSkipVerify = false
-	if conn, err = tls.DialWithDialer(Dialer, Network, addr, config); err != nil {
+	if conn, err = dialTLS(ctx, addr, config); err != nil {
 		grade = Warning
 		return
 	}
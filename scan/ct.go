@@ -0,0 +1,464 @@
+package scan
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/bbandix/cfssl/log"
+	"golang.org/x/crypto/ocsp"
+)
+
+// CT contains scanners for Certificate Transparency.
+var CT = &Family{
+	Description: "Scans for the host's compliance with Certificate Transparency policy",
+	Scanners: map[string]*Scanner{
+		"SCTs": {
+			"Host's certificate has enough SCTs from known logs, in the certificate, the TLS handshake or the stapled OCSP response",
+			sctScan,
+		},
+	},
+}
+
+// MinSCTs is the number of valid SCTs, from distinct logs, that a
+// certificate needs to meet CT policy.
+var MinSCTs = 2
+
+// A CTLog is a Certificate Transparency log whose SCTs are trusted.
+type CTLog struct {
+	Description string
+	Key         crypto.PublicKey
+	ID          [sha256.Size]byte
+}
+
+var (
+	ctLogsLock sync.RWMutex
+	ctLogs     map[[sha256.Size]byte]*CTLog
+)
+
+// ctLogEntry is a log in a log list file.
+type ctLogEntry struct {
+	Description string `json:"description"`
+	Key         []byte `json:"key"`
+}
+
+// ctLogList is a log list file, in either the current format, in which
+// logs are grouped by operator, or the earlier one, in which they
+// aren't.
+type ctLogList struct {
+	Operators []struct {
+		Logs []ctLogEntry `json:"logs"`
+	} `json:"operators"`
+	Logs []ctLogEntry `json:"logs"`
+}
+
+// LoadCTLogs loads the logs trusted by the CT scanners from a JSON log
+// list file, in the format of the log lists published for Chrome. The
+// CT scanners are skipped while no logs are loaded.
+func LoadCTLogs(logListFile string) error {
+	if logListFile == "" {
+		ctLogsLock.Lock()
+		defer ctLogsLock.Unlock()
+		ctLogs = nil
+		return nil
+	}
+	log.Debugf("Loading CT log list: %s", logListFile)
+
+	in, err := ioutil.ReadFile(logListFile)
+	if err != nil {
+		return err
+	}
+
+	var list ctLogList
+	if err = json.Unmarshal(in, &list); err != nil {
+		return fmt.Errorf("invalid CT log list: %v", err)
+	}
+	entries := list.Logs
+	for _, operator := range list.Operators {
+		entries = append(entries, operator.Logs...)
+	}
+
+	logs := make(map[[sha256.Size]byte]*CTLog)
+	for _, entry := range entries {
+		key, err := x509.ParsePKIXPublicKey(entry.Key)
+		if err != nil {
+			return fmt.Errorf("invalid key for CT log %s: %v", entry.Description, err)
+		}
+		ctLog := &CTLog{
+			Description: entry.Description,
+			Key:         key,
+			ID:          sha256.Sum256(entry.Key),
+		}
+		logs[ctLog.ID] = ctLog
+	}
+
+	ctLogsLock.Lock()
+	defer ctLogsLock.Unlock()
+	ctLogs = logs
+	return nil
+}
+
+// The following constants are where an SCT was found.
+const (
+	sctSourceCertificate = "certificate"
+	sctSourceTLS         = "tls"
+	sctSourceOCSP        = "ocsp"
+)
+
+var (
+	// oidSCTList is the OID of the certificate extension holding SCTs.
+	oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+	// oidOCSPSCTList is the OID of the OCSP extension holding SCTs.
+	oidOCSPSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 5}
+)
+
+// sct is a signed certificate timestamp, as defined by RFC 6962.
+type sct struct {
+	logID      [sha256.Size]byte
+	timestamp  uint64
+	extensions []byte
+	hashAlg    byte
+	sigAlg     byte
+	signature  []byte
+}
+
+// sctResult describes an SCT found for a host.
+type sctResult struct {
+	Source    string    `json:"source"`
+	LogID     string    `json:"log_id"`
+	Log       string    `json:"log,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Valid     bool      `json:"valid"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// sctOutput is the output of sctScan.
+type sctOutput struct {
+	SCTs     []sctResult `json:"scts"`
+	Valid    int         `json:"valid"`
+	Required int         `json:"required"`
+}
+
+var errShortSCT = errors.New("truncated SCT")
+
+// readOpaque reads a TLS opaque vector with a length prefix of n bytes.
+func readOpaque(in []byte, n int) (data, rest []byte, err error) {
+	if len(in) < n {
+		return nil, nil, errShortSCT
+	}
+	var length int
+	for _, b := range in[:n] {
+		length = length<<8 | int(b)
+	}
+	in = in[n:]
+	if len(in) < length {
+		return nil, nil, errShortSCT
+	}
+	return in[:length], in[length:], nil
+}
+
+// parseSCTList parses a TLS-encoded list of SCTs.
+func parseSCTList(in []byte) ([][]byte, error) {
+	list, rest, err := readOpaque(in, 2)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("trailing data after SCT list")
+	}
+
+	var scts [][]byte
+	for len(list) > 0 {
+		var raw []byte
+		if raw, list, err = readOpaque(list, 2); err != nil {
+			return nil, err
+		}
+		scts = append(scts, raw)
+	}
+	return scts, nil
+}
+
+// parseSCT parses a single TLS-encoded SCT.
+func parseSCT(in []byte) (*sct, error) {
+	if len(in) < 1+sha256.Size+8 {
+		return nil, errShortSCT
+	}
+	if in[0] != 0 {
+		return nil, fmt.Errorf("unknown SCT version %d", in[0])
+	}
+
+	s := new(sct)
+	copy(s.logID[:], in[1:])
+	s.timestamp = binary.BigEndian.Uint64(in[1+sha256.Size:])
+	in = in[1+sha256.Size+8:]
+
+	var err error
+	if s.extensions, in, err = readOpaque(in, 2); err != nil {
+		return nil, err
+	}
+	if len(in) < 2 {
+		return nil, errShortSCT
+	}
+	s.hashAlg, s.sigAlg = in[0], in[1]
+	if s.signature, in, err = readOpaque(in[2:], 2); err != nil {
+		return nil, err
+	}
+	if len(in) > 0 {
+		return nil, errors.New("trailing data after SCT")
+	}
+	return s, nil
+}
+
+// signedData returns the data an SCT's signature covers, given the
+// entry it was issued for: a certificate, or the issuer key hash and
+// TBSCertificate of a precertificate.
+func (s *sct) signedData(entryType uint16, entry []byte) []byte {
+	var b []byte
+	b = append(b, 0, 0) // version v1, certificate_timestamp
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], s.timestamp)
+	b = append(b, ts[:]...)
+	b = append(b, byte(entryType>>8), byte(entryType))
+	b = append(b, entry...)
+	b = append(b, byte(len(s.extensions)>>8), byte(len(s.extensions)))
+	return append(b, s.extensions...)
+}
+
+// verify checks an SCT's signature with the key of the log that issued
+// it.
+func (s *sct) verify(key crypto.PublicKey, data []byte) error {
+	if s.hashAlg != 4 {
+		return fmt.Errorf("unsupported SCT hash algorithm %d", s.hashAlg)
+	}
+	digest := sha256.Sum256(data)
+
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if s.sigAlg != 3 {
+			break
+		}
+		var sig struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(s.signature, &sig); err != nil {
+			return fmt.Errorf("invalid SCT signature: %v", err)
+		}
+		if !ecdsa.Verify(key, digest[:], sig.R, sig.S) {
+			return errors.New("SCT signature doesn't verify")
+		}
+		return nil
+	case *rsa.PublicKey:
+		if s.sigAlg != 1 {
+			break
+		}
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], s.signature); err != nil {
+			return errors.New("SCT signature doesn't verify")
+		}
+		return nil
+	}
+	return fmt.Errorf("SCT signature algorithm %d doesn't match the log's key", s.sigAlg)
+}
+
+// tbsCertificate is a TBSCertificate, decoded only as far as is needed
+// to remove extensions from it.
+type tbsCertificate struct {
+	Version            int `asn1:"optional,explicit,default:0,tag:0"`
+	SerialNumber       *big.Int
+	SignatureAlgorithm asn1.RawValue
+	Issuer             asn1.RawValue
+	Validity           asn1.RawValue
+	Subject            asn1.RawValue
+	PublicKey          asn1.RawValue
+	UniqueID           asn1.BitString   `asn1:"optional,tag:1"`
+	SubjectUniqueID    asn1.BitString   `asn1:"optional,tag:2"`
+	Extensions         []pkix.Extension `asn1:"optional,explicit,tag:3"`
+}
+
+// precertTBS returns the TBSCertificate of the precertificate a
+// certificate was issued from, which is the certificate's without the
+// SCT list extension.
+func precertTBS(cert *x509.Certificate) ([]byte, error) {
+	var tbs tbsCertificate
+	if _, err := asn1.Unmarshal(cert.RawTBSCertificate, &tbs); err != nil {
+		return nil, err
+	}
+
+	var exts []pkix.Extension
+	for _, ext := range tbs.Extensions {
+		if !ext.Id.Equal(oidSCTList) {
+			exts = append(exts, ext)
+		}
+	}
+	tbs.Extensions = exts
+	return asn1.Marshal(tbs)
+}
+
+// checkSCT parses and verifies an SCT for a certificate, found in the
+// given source. SCTs in the certificate itself are for the
+// precertificate it was issued from, which needs its issuer.
+func checkSCT(raw []byte, source string, cert, issuer *x509.Certificate) sctResult {
+	result := sctResult{Source: source}
+	s, err := parseSCT(raw)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.LogID = base64.StdEncoding.EncodeToString(s.logID[:])
+	result.Timestamp = time.Unix(0, int64(s.timestamp)*int64(time.Millisecond)).UTC()
+
+	ctLogsLock.RLock()
+	ctLog := ctLogs[s.logID]
+	ctLogsLock.RUnlock()
+	if ctLog == nil {
+		result.Error = "SCT is from an unknown log"
+		return result
+	}
+	result.Log = ctLog.Description
+
+	if result.Timestamp.After(time.Now()) {
+		result.Error = "SCT is timestamped in the future"
+		return result
+	}
+
+	var data []byte
+	if source == sctSourceCertificate {
+		if issuer == nil {
+			result.Error = "host didn't send the issuer of its certificate to check the SCT"
+			return result
+		}
+		tbs, err := precertTBS(cert)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		keyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+		entry := append(keyHash[:], byte(len(tbs)>>16), byte(len(tbs)>>8), byte(len(tbs)))
+		data = s.signedData(1, append(entry, tbs...))
+	} else {
+		der := cert.Raw
+		entry := []byte{byte(len(der) >> 16), byte(len(der) >> 8), byte(len(der))}
+		data = s.signedData(0, append(entry, der...))
+	}
+
+	if err = s.verify(ctLog.Key, data); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Valid = true
+	return result
+}
+
+// sctsFromExtension returns the SCTs in an extension holding an
+// OCTET STRING wrapping an SCT list.
+func sctsFromExtension(value []byte) ([][]byte, error) {
+	var list []byte
+	if _, err := asn1.Unmarshal(value, &list); err != nil {
+		return nil, err
+	}
+	return parseSCTList(list)
+}
+
+// sctScan collects the SCTs for the host's certificate from the
+// certificate itself, the TLS handshake and the stapled OCSP response,
+// and verifies them against the logs loaded with LoadCTLogs. It is
+// skipped if no logs have been loaded, and is graded Good if there are
+// valid SCTs from at least MinSCTs distinct logs, Warning if there are
+// fewer, and Bad if there are none.
+func sctScan(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
+	ctLogsLock.RLock()
+	loaded := len(ctLogs) > 0
+	ctLogsLock.RUnlock()
+	if !loaded {
+		return Skipped, nil, nil
+	}
+
+	conn, err := dialTLS(ctx, addr, defaultTLSConfig(hostname))
+	if err != nil {
+		return
+	}
+	conn.Close()
+
+	state := conn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		err = fmt.Errorf("%s returned empty certificate chain", addr)
+		return
+	}
+	cert := state.PeerCertificates[0]
+	var issuer *x509.Certificate
+	if len(state.PeerCertificates) > 1 {
+		issuer = state.PeerCertificates[1]
+	}
+
+	out := sctOutput{Required: MinSCTs}
+	var problems []string
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSCTList) {
+			continue
+		}
+		scts, e := sctsFromExtension(ext.Value)
+		if e != nil {
+			problems = append(problems, fmt.Sprintf("invalid SCT list in certificate: %v", e))
+		}
+		for _, raw := range scts {
+			out.SCTs = append(out.SCTs, checkSCT(raw, sctSourceCertificate, cert, issuer))
+		}
+	}
+
+	for _, raw := range state.SignedCertificateTimestamps {
+		out.SCTs = append(out.SCTs, checkSCT(raw, sctSourceTLS, cert, issuer))
+	}
+
+	if staple := conn.OCSPResponse(); len(staple) > 0 && issuer != nil {
+		if resp, e := ocsp.ParseResponseForCert(staple, cert, issuer); e != nil {
+			problems = append(problems, fmt.Sprintf("invalid stapled OCSP response: %v", e))
+		} else {
+			for _, ext := range resp.Extensions {
+				if !ext.Id.Equal(oidOCSPSCTList) {
+					continue
+				}
+				scts, e := sctsFromExtension(ext.Value)
+				if e != nil {
+					problems = append(problems, fmt.Sprintf("invalid SCT list in stapled OCSP response: %v", e))
+				}
+				for _, raw := range scts {
+					out.SCTs = append(out.SCTs, checkSCT(raw, sctSourceOCSP, cert, issuer))
+				}
+			}
+		}
+	}
+
+	// An SCT from the same log counts once, wherever it is found.
+	logs := make(map[string]bool)
+	for _, result := range out.SCTs {
+		if result.Valid && !logs[result.LogID] {
+			logs[result.LogID] = true
+			out.Valid++
+		}
+	}
+
+	switch {
+	case out.Valid >= MinSCTs:
+		grade = Good
+	case out.Valid > 0:
+		grade = Warning
+	default:
+		grade = Bad
+	}
+	if len(problems) > 0 {
+		err = errors.New(problems[0])
+	}
+	output = out
+	return
+}
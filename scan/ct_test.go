@@ -0,0 +1,239 @@
+package scan
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testLog is a CT log that issues SCTs in tests.
+type testLog struct {
+	key    crypto.Signer
+	keyDER []byte
+	sigAlg byte
+}
+
+func newTestLog(t *testing.T, useRSA bool) *testLog {
+	l := &testLog{sigAlg: 3}
+	var err error
+	if useRSA {
+		l.key, err = rsa.GenerateKey(rand.Reader, 2048)
+		l.sigAlg = 1
+	} else {
+		l.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.keyDER, err = x509.MarshalPKIXPublicKey(l.key.Public()); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// issue returns a TLS-encoded SCT for an entry.
+func (l *testLog) issue(t *testing.T, entryType uint16, entry []byte) []byte {
+	s := &sct{
+		logID:     sha256.Sum256(l.keyDER),
+		timestamp: uint64(time.Now().Add(-time.Minute).UnixNano() / int64(time.Millisecond)),
+		hashAlg:   4,
+		sigAlg:    l.sigAlg,
+	}
+	digest := sha256.Sum256(s.signedData(entryType, entry))
+	sig, err := l.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := []byte{0}
+	out = append(out, s.logID[:]...)
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], s.timestamp)
+	out = append(out, ts[:]...)
+	out = append(out, 0, 0, s.hashAlg, s.sigAlg, byte(len(sig)>>8), byte(len(sig)))
+	return append(out, sig...)
+}
+
+// loadTestLogs writes a log list file for logs and loads it.
+func loadTestLogs(t *testing.T, logs ...*testLog) {
+	var list struct {
+		Operators []struct {
+			Logs []ctLogEntry `json:"logs"`
+		} `json:"operators"`
+	}
+	list.Operators = make([]struct {
+		Logs []ctLogEntry `json:"logs"`
+	}, 1)
+	for i, l := range logs {
+		list.Operators[0].Logs = append(list.Operators[0].Logs, ctLogEntry{
+			Description: "Test log " + string(rune('A'+i)),
+			Key:         l.keyDER,
+		})
+	}
+
+	dir, err := ioutil.TempDir("", "ct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out, _ := json.Marshal(list)
+	path := filepath.Join(dir, "log_list.json")
+	if err = ioutil.WriteFile(path, out, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = LoadCTLogs(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tlsEntry(der []byte) []byte {
+	return append([]byte{byte(len(der) >> 16), byte(len(der) >> 8), byte(len(der))}, der...)
+}
+
+// serveCT starts a TLS server for a certificate chain that sends scts
+// in the TLS handshake.
+func serveCT(t *testing.T, chain [][]byte, key crypto.Signer, scts [][]byte) string {
+	cert := tls.Certificate{Certificate: chain, PrivateKey: key, SignedCertificateTimestamps: scts}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn.(*tls.Conn).Handshake()
+		conn.Close()
+	}()
+	return l.Addr().String()
+}
+
+func newTestKey(t *testing.T) crypto.Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// newTestChain issues a CA and a leaf certificate with the given extra
+// extensions, both with the same key.
+func newTestChain(t *testing.T, key crypto.Signer, exts []pkix.Extension) (ca, leaf *x509.Certificate) {
+	// The validity is fixed so that certificates issued with
+	// different extensions have otherwise identical TBSCertificates.
+	notBefore := time.Now().Truncate(time.Hour).Add(-time.Hour)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(3 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ = x509.ParseCertificate(der)
+
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		Subject:         pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:       notBefore,
+		NotAfter:        notBefore.Add(3 * time.Hour),
+		ExtraExtensions: exts,
+	}
+	if der, err = x509.CreateCertificate(rand.Reader, template, ca, key.Public(), key); err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ = x509.ParseCertificate(der)
+	return ca, leaf
+}
+
+func TestSCTScanTLS(t *testing.T) {
+	ecLog, rsaLog, unknownLog := newTestLog(t, false), newTestLog(t, true), newTestLog(t, false)
+	loadTestLogs(t, ecLog, rsaLog)
+	defer LoadCTLogs("")
+
+	key := newTestKey(t)
+	ca, leaf := newTestChain(t, key, nil)
+	entry := tlsEntry(leaf.Raw)
+	scts := [][]byte{ecLog.issue(t, 0, entry), rsaLog.issue(t, 0, entry), unknownLog.issue(t, 0, entry)}
+
+	addr := serveCT(t, [][]byte{leaf.Raw, ca.Raw}, key, scts)
+	grade, output, err := sctScan(context.Background(), addr, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := output.(sctOutput)
+	if grade != Good || out.Valid != 2 || len(out.SCTs) != 3 || out.SCTs[2].Valid {
+		t.Fatalf("unexpected result %v %+v", grade, out)
+	}
+
+	// Only one log's SCT now verifies.
+	scts[1] = ecLog.issue(t, 0, tlsEntry(ca.Raw))
+	addr = serveCT(t, [][]byte{leaf.Raw, ca.Raw}, key, scts)
+	if grade, output, _ = sctScan(context.Background(), addr, "127.0.0.1"); grade != Warning {
+		t.Fatalf("expected a Warning with one valid SCT, have %v %+v", grade, output)
+	}
+}
+
+func TestSCTScanEmbedded(t *testing.T) {
+	l := newTestLog(t, false)
+	loadTestLogs(t, l)
+	defer LoadCTLogs("")
+
+	// Issue the precertificate, get an SCT for it, and issue the
+	// certificate with the SCT embedded. Certificates issued with the
+	// same key differ only in their extensions.
+	key := newTestKey(t)
+	_, precert := newTestChain(t, key, nil)
+	ca, _ := newTestChain(t, key, nil)
+	keyHash := sha256.Sum256(ca.RawSubjectPublicKeyInfo)
+	raw := l.issue(t, 1, append(keyHash[:], tlsEntry(precert.RawTBSCertificate)...))
+
+	list := append([]byte{byte((len(raw) + 2) >> 8), byte(len(raw) + 2), byte(len(raw) >> 8), byte(len(raw))}, raw...)
+	value, _ := asn1.Marshal(list)
+	_, leaf := newTestChain(t, key, []pkix.Extension{{Id: oidSCTList, Value: value}})
+
+	tbs, err := precertTBS(leaf)
+	if err != nil || string(tbs) != string(precert.RawTBSCertificate) {
+		t.Fatalf("precertificate TBSCertificate not reconstructed: %v", err)
+	}
+
+	addr := serveCT(t, [][]byte{leaf.Raw, ca.Raw}, key, nil)
+	grade, output, err := sctScan(context.Background(), addr, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := output.(sctOutput)
+	if grade != Warning || out.Valid != 1 || out.SCTs[0].Source != sctSourceCertificate {
+		t.Fatalf("unexpected result %v %+v", grade, out)
+	}
+}
+
+func TestLoadCTLogsInvalid(t *testing.T) {
+	if err := LoadCTLogs("testdata/nonexistent.json"); err == nil {
+		t.Fatal("expected a missing log list to fail")
+	}
+}
@@ -0,0 +1,259 @@
+package scan
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A HistoryRecord holds the results of scanning a host at a point in
+// time.
+type HistoryRecord struct {
+	Host    string                  `json:"host"`
+	Time    time.Time               `json:"time"`
+	Results map[string]FamilyResult `json:"results"`
+}
+
+// HistoryFile stores scan results in a file, one JSON object per line,
+// so that scans of a host can be compared over time. The file is only
+// ever appended to.
+type HistoryFile struct {
+	lock sync.Mutex
+	f    *os.File
+}
+
+// OpenHistory opens, or creates, a scan history file.
+func OpenHistory(path string) (*HistoryFile, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &HistoryFile{f: f}, nil
+}
+
+// Append writes a record as a line at the end of the file.
+func (h *HistoryFile) Append(r *HistoryRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if _, err = h.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return h.f.Sync()
+}
+
+// Latest returns up to the last n records for host, oldest first.
+func (h *HistoryFile) Latest(host string, n int) ([]*HistoryRecord, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	fi, err := h.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return ReadHistory(io.NewSectionReader(h.f, 0, fi.Size()), host, n)
+}
+
+// ReadHistory reads records, one JSON object per line, and returns up to
+// the last n for host, oldest first. Lines that can't be parsed, such as
+// one left partly written by an interrupted scan, are skipped.
+func ReadHistory(r io.Reader, host string, n int) ([]*HistoryRecord, error) {
+	var records []*HistoryRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var r HistoryRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil || r.Host != host {
+			continue
+		}
+		records = append(records, &r)
+		if len(records) > n {
+			records = records[1:]
+		}
+	}
+	return records, scanner.Err()
+}
+
+// Close closes the file.
+func (h *HistoryFile) Close() error {
+	return h.f.Close()
+}
+
+var (
+	historyLock sync.RWMutex
+	history     *HistoryFile
+)
+
+// SetHistory sets the file that SaveHistory stores results in.
+func SetHistory(h *HistoryFile) {
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	history = h
+}
+
+// SaveHistory stores the results of scanning host in the file set with
+// SetHistory, if any. Only the complete results of a scan should be
+// stored: the scanners missing from those of a scan that failed or
+// timed out would show up as changes when compared with the next.
+func SaveHistory(host string, results map[string]FamilyResult) error {
+	historyLock.RLock()
+	defer historyLock.RUnlock()
+	if history == nil || results == nil {
+		return nil
+	}
+	return history.Append(&HistoryRecord{Host: host, Time: time.Now().UTC(), Results: results})
+}
+
+// SaveResult stores the results of a host scanned in a batch, as
+// SaveHistory does, unless the scan of the host failed or timed out.
+func SaveResult(result *HostResult) error {
+	if result.Error != "" {
+		return nil
+	}
+	return SaveHistory(result.Host, result.Results)
+}
+
+// A ScanChange describes how the result of a scanner changed between
+// two scans of a host. A grade is empty if the scanner has no result in
+// that scan.
+type ScanChange struct {
+	Family   string `json:"family"`
+	Scanner  string `json:"scanner"`
+	OldGrade string `json:"old_grade"`
+	NewGrade string `json:"new_grade"`
+	// Regression is set if the grade became worse.
+	Regression bool     `json:"regression,omitempty"`
+	Added      []string `json:"added,omitempty"`
+	Removed    []string `json:"removed,omitempty"`
+}
+
+// A HistoryDiff holds the changes between two scans of a host.
+type HistoryDiff struct {
+	Host    string       `json:"host"`
+	From    time.Time    `json:"from"`
+	To      time.Time    `json:"to"`
+	Changes []ScanChange `json:"changes"`
+}
+
+// DiffHistory compares two scans of a host, reporting each scanner whose
+// grade changed or whose output gained or lost items. Outputs that are
+// lists are compared item by item, and those that are objects key by
+// key; any other output is compared as a whole.
+func DiffHistory(before, after *HistoryRecord) *HistoryDiff {
+	diff := &HistoryDiff{Host: after.Host, From: before.Time, To: after.Time, Changes: []ScanChange{}}
+
+	type key struct{ family, scanner string }
+	keys := make(map[key]bool)
+	for _, results := range []map[string]FamilyResult{before.Results, after.Results} {
+		for familyName, familyResult := range results {
+			for scannerName := range familyResult {
+				keys[key{familyName, scannerName}] = true
+			}
+		}
+	}
+
+	for k := range keys {
+		oldResult, hadOld := before.Results[k.family][k.scanner]
+		newResult, hasNew := after.Results[k.family][k.scanner]
+		change := ScanChange{
+			Family:   k.family,
+			Scanner:  k.scanner,
+			OldGrade: oldResult.Grade,
+			NewGrade: newResult.Grade,
+		}
+		if hadOld && hasNew {
+			change.Regression = gradeRank(newResult.Grade) < gradeRank(oldResult.Grade)
+		}
+		change.Added, change.Removed = diffItems(outputItems(oldResult.Output), outputItems(newResult.Output))
+
+		if change.OldGrade != change.NewGrade || len(change.Added) > 0 || len(change.Removed) > 0 {
+			diff.Changes = append(diff.Changes, change)
+		}
+	}
+
+	sort.Slice(diff.Changes, func(i, j int) bool {
+		if diff.Changes[i].Family != diff.Changes[j].Family {
+			return diff.Changes[i].Family < diff.Changes[j].Family
+		}
+		return diff.Changes[i].Scanner < diff.Changes[j].Scanner
+	})
+	return diff
+}
+
+// gradeRank orders the grades of scanner results from worst to best.
+func gradeRank(grade string) Grade {
+	for _, g := range []Grade{Bad, Warning, Good} {
+		if g.String() == grade {
+			return g
+		}
+	}
+	return Skipped
+}
+
+// outputItems breaks a scanner's output down into the items compared by
+// DiffHistory, each as a string.
+func outputItems(output Output) map[string]bool {
+	if output == nil {
+		return nil
+	}
+	// Outputs read back from a history file have lost their types, so
+	// fresh outputs are put through JSON to compare them alike.
+	in, err := json.Marshal(output)
+	if err != nil {
+		return nil
+	}
+	var v interface{}
+	if err = json.Unmarshal(in, &v); err != nil {
+		return nil
+	}
+
+	items := make(map[string]bool)
+	switch v := v.(type) {
+	case nil:
+	case []interface{}:
+		for _, item := range v {
+			items[itemString(item)] = true
+		}
+	case map[string]interface{}:
+		for k, item := range v {
+			items[k+": "+itemString(item)] = true
+		}
+	default:
+		items[itemString(v)] = true
+	}
+	return items
+}
+
+func itemString(item interface{}) string {
+	if s, ok := item.(string); ok {
+		return s
+	}
+	out, _ := json.Marshal(item)
+	return string(out)
+}
+
+// diffItems returns the items only in after, and those only in before,
+// each sorted.
+func diffItems(before, after map[string]bool) (added, removed []string) {
+	for item := range after {
+		if !before[item] {
+			added = append(added, item)
+		}
+	}
+	for item := range before {
+		if !after[item] {
+			removed = append(removed, item)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
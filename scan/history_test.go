@@ -0,0 +1,99 @@
+package scan
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h, err := OpenHistory(filepath.Join(dir, "history.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	SetHistory(h)
+	defer SetHistory(nil)
+
+	families := FamilySet{"Testing": TestingFamily}
+	for _, host := range []string{"good.example.com", "other.example.com", "good.example.com", "good.example.com"} {
+		results, err := families.RunScans(context.Background(), host, "", "", "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = SaveHistory(host, results); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The results of a host whose scan timed out are incomplete, and
+	// aren't stored.
+	err = SaveResult(&HostResult{
+		Host:    "good.example.com",
+		Results: map[string]FamilyResult{},
+		Error:   context.DeadlineExceeded.Error(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A partly written line, as left by an interrupted scan.
+	h.f.Write([]byte(`{"host":"good.exam`))
+
+	records, err := h.Latest("good.example.com", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Time.After(records[1].Time) {
+		t.Fatalf("expected the latest two records in order, have %+v", records)
+	}
+	if records[1].Results["Testing"]["TestingScanner"].Grade != "Good" {
+		t.Fatalf("unexpected results read back: %+v", records[1].Results)
+	}
+
+	if diff := DiffHistory(records[0], records[1]); len(diff.Changes) != 0 {
+		t.Fatalf("expected no changes between identical scans, have %+v", diff.Changes)
+	}
+}
+
+func TestDiffHistory(t *testing.T) {
+	before := &HistoryRecord{Results: map[string]FamilyResult{
+		"TLSHandshake": {
+			"CipherSuite": {Grade: "Good", Output: []interface{}{"TLS_AES_128_GCM_SHA256", "TLS_RSA_WITH_RC4_128_SHA"}},
+			"SigAlgs":     {Grade: "Good", Output: map[string]interface{}{"SHA256": true}},
+		},
+		"Connectivity": {"TCPDial": {Grade: "Good"}},
+	}}
+	after := &HistoryRecord{Host: "example.com", Results: map[string]FamilyResult{
+		"TLSHandshake": {
+			"CipherSuite": {Grade: "Warning", Output: []string{"TLS_AES_128_GCM_SHA256", "TLS_RSA_WITH_3DES_EDE_CBC_SHA"}},
+			"SigAlgs":     {Grade: "Good", Output: map[string]bool{"SHA256": true}},
+		},
+		"PKI": {"ChainExpiration": {Grade: "Good"}},
+	}}
+
+	diff := DiffHistory(before, after)
+	var summary []string
+	for _, c := range diff.Changes {
+		summary = append(summary, strings.Join([]string{c.Family, c.Scanner, c.OldGrade, c.NewGrade,
+			strings.Join(c.Added, ","), strings.Join(c.Removed, ",")}, "/"))
+	}
+	expected := []string{
+		"Connectivity/TCPDial/Good///",
+		"PKI/ChainExpiration//Good//",
+		"TLSHandshake/CipherSuite/Good/Warning/TLS_RSA_WITH_3DES_EDE_CBC_SHA/TLS_RSA_WITH_RC4_128_SHA",
+	}
+	if strings.Join(summary, " ") != strings.Join(expected, " ") {
+		t.Fatalf("expected changes %v, have %v", expected, summary)
+	}
+	if !diff.Changes[2].Regression || diff.Changes[0].Regression || diff.Changes[1].Regression {
+		t.Fatalf("unexpected regressions: %+v", diff.Changes)
+	}
+}
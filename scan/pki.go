@@ -2,13 +2,19 @@ package scan
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"crypto/x509"
+	"encoding/asn1"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/cloudflare/cf-tls/tls"
 	"github.com/bbandix/cfssl/helpers"
 	"github.com/bbandix/cfssl/revoke"
+	"golang.org/x/crypto/ocsp"
 )
 
 // PKI contains scanners for the Public Key Infrastructure.
@@ -16,24 +22,43 @@ var PKI = &Family{
 	Description: "Scans for the Public Key Infrastructure",
 	Scanners: map[string]*Scanner{
 		"ChainExpiration": {
-			"Host's chain hasn't expired and won't expire in the next 30 days",
+			"Host's chain hasn't expired and, under the default policy, won't expire in the next 30 days",
 			chainExpiration,
 		},
 		"ChainValidation": {
 			"All certificates in host's chain are valid",
 			chainValidation,
 		},
+		"Keys": {
+			"Keys in host's chain are long enough for the policy",
+			keysScan,
+		},
 		"MultipleCerts": {
 			"Host serves same certificate chain across all IPs",
 			multipleCerts,
 		},
+		"OCSPStapling": {
+			"Host staples a valid, current OCSP response for its certificate",
+			ocspStaplingScan,
+		},
+		"Revocation": {
+			"All certificates in host's chain can be checked through OCSP and CRL and aren't revoked",
+			revocationScan,
+		},
 	},
 }
 
 // getChain is a helper function that retreives the host's certificate chain.
-func getChain(addr string, config *tls.Config) (chain []*x509.Certificate, err error) {
+func getChain(ctx context.Context, addr string, config *tls.Config) (chain []*x509.Certificate, err error) {
+	chain, _, err = getStapledChain(ctx, addr, config)
+	return
+}
+
+// getStapledChain retrieves the host's certificate chain and the OCSP
+// response it stapled, if any.
+func getStapledChain(ctx context.Context, addr string, config *tls.Config) (chain []*x509.Certificate, staple []byte, err error) {
 	var conn *tls.Conn
-	conn, err = tls.DialWithDialer(Dialer, Network, addr, config)
+	conn, err = dialTLS(ctx, addr, config)
 	if err != nil {
 		return
 	}
@@ -47,6 +72,7 @@ func getChain(addr string, config *tls.Config) (chain []*x509.Certificate, err e
 	if len(chain) == 0 {
 		err = fmt.Errorf("%s returned empty certificate chain", addr)
 	}
+	staple = conn.OCSPResponse()
 	return
 }
 
@@ -56,8 +82,8 @@ func (e expiration) String() string {
 	return time.Time(e).Format("Jan 2 15:04:05 2006 MST")
 }
 
-func chainExpiration(addr, hostname string) (grade Grade, output Output, err error) {
-	chain, err := getChain(addr, defaultTLSConfig(hostname))
+func chainExpiration(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
+	chain, err := getChain(ctx, addr, defaultTLSConfig(hostname))
 	if err != nil {
 		return
 	}
@@ -73,18 +99,48 @@ func chainExpiration(addr, hostname string) (grade Grade, output Output, err err
 		return
 	}
 
-	// Warn if cert will expire in the next 30 days
-	if time.Now().Add(time.Hour * 24 * 30).After(expirationTime) {
-		grade = Warning
+	// How soon before expiry to warn is left to the policy.
+	grade = Good
+	return
+}
+
+// keyInfo describes the key and signature of a certificate in a chain.
+type keyInfo struct {
+	Subject            string `json:"subject"`
+	KeyAlgorithm       string `json:"key_algorithm"`
+	KeySize            int    `json:"key_size"`
+	SignatureAlgorithm string `json:"signature_algorithm"`
+}
+
+// keysScan reports the key algorithm and size of each certificate in
+// the host's chain, leaving the sizes that are too short to the policy.
+func keysScan(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
+	chain, err := getChain(ctx, addr, defaultTLSConfig(hostname))
+	if err != nil {
 		return
 	}
 
-	grade = Good
-	return
+	var keys []keyInfo
+	for _, cert := range chain {
+		info := keyInfo{
+			Subject:            cert.Subject.CommonName,
+			SignatureAlgorithm: helpers.SignatureString(cert.SignatureAlgorithm),
+		}
+		switch key := cert.PublicKey.(type) {
+		case *rsa.PublicKey:
+			info.KeyAlgorithm, info.KeySize = "RSA", key.N.BitLen()
+		case *ecdsa.PublicKey:
+			info.KeyAlgorithm, info.KeySize = "ECDSA", key.Curve.Params().BitSize
+		default:
+			info.KeyAlgorithm = "Unknown"
+		}
+		keys = append(keys, info)
+	}
+	return Good, keys, nil
 }
 
-func chainValidation(addr, hostname string) (grade Grade, output Output, err error) {
-	chain, err := getChain(addr, defaultTLSConfig(hostname))
+func chainValidation(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
+	chain, err := getChain(ctx, addr, defaultTLSConfig(hostname))
 	if err != nil {
 		return
 	}
@@ -139,17 +195,17 @@ func chainValidation(addr, hostname string) (grade Grade, output Output, err err
 	return
 }
 
-func multipleCerts(addr, hostname string) (grade Grade, output Output, err error) {
+func multipleCerts(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
 	config := defaultTLSConfig(hostname)
 
-	firstChain, err := getChain(addr, config)
+	firstChain, err := getChain(ctx, addr, config)
 	if err != nil {
 		return
 	}
 
-	grade, _, err = multiscan(addr, func(addrport string) (g Grade, o Output, e error) {
+	grade, _, err = multiscan(ctx, addr, func(addrport string) (g Grade, o Output, e error) {
 		g = Good
-		chain, e1 := getChain(addrport, config)
+		chain, e1 := getChain(ctx, addrport, config)
 		if e1 != nil {
 			return
 		}
@@ -163,3 +219,177 @@ func multipleCerts(addr, hostname string) (grade Grade, output Output, err error
 	})
 	return
 }
+
+// oidTLSFeature is the OID of the TLS feature extension of RFC 7633.
+var oidTLSFeature = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+
+// statusRequest is the TLS feature a must-staple certificate requires.
+const statusRequest = 5
+
+// mustStaple reports whether a certificate requires an OCSP response to
+// be stapled to it.
+func mustStaple(cert *x509.Certificate) bool {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidTLSFeature) {
+			continue
+		}
+		var features []int
+		if _, err := asn1.Unmarshal(ext.Value, &features); err != nil {
+			return false
+		}
+		for _, feature := range features {
+			if feature == statusRequest {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ocspStatus names the status of an OCSP response.
+func ocspStatus(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
+
+// stapleStatus describes the OCSP response stapled by a host.
+type stapleStatus struct {
+	MustStaple bool       `json:"must_staple"`
+	Status     string     `json:"status,omitempty"`
+	ThisUpdate *time.Time `json:"this_update,omitempty"`
+	NextUpdate *time.Time `json:"next_update,omitempty"`
+}
+
+// ocspStaplingScan checks the OCSP response stapled by the host: that it
+// is signed by the issuer of its certificate, is current, and gives the
+// certificate's status as good. A host whose certificate is must-staple
+// but that staples no response is graded Bad, as is one that staples an
+// invalid response or a response that isn't good; a host that staples
+// no response when not required to is graded Warning.
+func ocspStaplingScan(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
+	chain, staple, err := getStapledChain(ctx, addr, defaultTLSConfig(hostname))
+	if err != nil {
+		return
+	}
+
+	status := stapleStatus{MustStaple: mustStaple(chain[0])}
+	output = status
+	if len(staple) == 0 {
+		if status.MustStaple {
+			return Bad, output, errors.New("certificate is must-staple but no OCSP response was stapled")
+		}
+		return Warning, output, nil
+	}
+	if len(chain) < 2 {
+		return Bad, output, errors.New("host didn't send the issuer of its certificate to check the stapled OCSP response")
+	}
+
+	resp, err := revoke.ParseOCSPResponse(staple, chain[0], chain[1])
+	if err != nil {
+		return Bad, output, fmt.Errorf("invalid stapled OCSP response: %v", err)
+	}
+
+	status.Status = ocspStatus(resp.Status)
+	status.ThisUpdate = &resp.ThisUpdate
+	if !resp.NextUpdate.IsZero() {
+		status.NextUpdate = &resp.NextUpdate
+	}
+	output = status
+
+	grade = Good
+	if resp.Status != ocsp.Good {
+		grade = Bad
+	}
+	return
+}
+
+// revocationStatus describes the revocation checks of a certificate in
+// a host's chain, identified by its subject and hex-encoded serial
+// number.
+type revocationStatus struct {
+	Subject string `json:"subject"`
+	Serial  string `json:"serial"`
+	OCSP    string `json:"ocsp"`
+	CRL     string `json:"crl"`
+}
+
+// revocationScan checks each certificate in the host's chain, other than
+// the last, through OCSP and CRL with the certificate after it as its
+// issuer. It is graded Bad if any certificate is revoked, Warning if any
+// certificate can't be checked through either of them or a check fails,
+// and Good otherwise. The output lists the checks in chain order.
+func revocationScan(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
+	chain, err := getChain(ctx, addr, defaultTLSConfig(hostname))
+	if err != nil {
+		return
+	}
+	return checkRevocation(ctx, chain)
+}
+
+// checkRevocation checks the revocation of each certificate in chain but
+// the last, as revocationScan does.
+func checkRevocation(ctx context.Context, chain []*x509.Certificate) (grade Grade, output Output, err error) {
+	grade = Good
+	lower := func(g Grade) {
+		if g < grade {
+			grade = g
+		}
+	}
+
+	var statuses []revocationStatus
+	for i := 0; i < len(chain)-1; i++ {
+		cert, issuer := chain[i], chain[i+1]
+		status := revocationStatus{
+			Subject: cert.Subject.CommonName,
+			Serial:  fmt.Sprintf("%x", cert.SerialNumber),
+		}
+
+		var checked bool
+		if len(cert.OCSPServer) == 0 {
+			status.OCSP = "none"
+		} else if resp, e := revoke.OCSPStatus(ctx, cert, issuer); e != nil {
+			status.OCSP = e.Error()
+			lower(Warning)
+		} else {
+			status.OCSP = ocspStatus(resp.Status)
+			checked = true
+			if resp.Status == ocsp.Revoked {
+				lower(Bad)
+			}
+		}
+
+		if len(cert.CRLDistributionPoints) == 0 {
+			status.CRL = "none"
+		} else if revoked, e := revoke.CRLStatus(ctx, cert, issuer); e != nil {
+			status.CRL = e.Error()
+			lower(Warning)
+		} else if revoked {
+			status.CRL = "revoked"
+			checked = true
+			lower(Bad)
+		} else {
+			status.CRL = "good"
+			checked = true
+		}
+
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		if !checked {
+			lower(Warning)
+		}
+		statuses = append(statuses, status)
+	}
+
+	if len(statuses) == 0 {
+		return Skipped, nil, nil
+	}
+	output = statuses
+	return
+}
@@ -0,0 +1,146 @@
+package scan
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// newStaplingServer starts a TLS server whose certificate is issued by a
+// test CA and is must-staple if mustStaple is set. If staple is set, the
+// server staples a response with that status.
+func newStaplingServer(t *testing.T, mustStaple bool, staple int) net.Listener {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+	}
+	if mustStaple {
+		value, _ := asn1.Marshal([]int{statusRequest})
+		template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{Id: oidTLSFeature, Value: value})
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := tls.Certificate{Certificate: [][]byte{der, caDER}, PrivateKey: key}
+	if staple >= 0 {
+		cert.OCSPStaple, err = ocsp.CreateResponse(ca, ca, ocsp.Response{
+			Status:       staple,
+			SerialNumber: template.SerialNumber,
+			ThisUpdate:   now.Add(-time.Minute),
+			NextUpdate:   now.Add(time.Hour),
+			RevokedAt:    now.Add(-time.Minute),
+		}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	return l
+}
+
+func TestOCSPStaplingScan(t *testing.T) {
+	cases := []struct {
+		mustStaple bool
+		staple     int
+		grade      Grade
+	}{
+		{false, -1, Warning},
+		{true, -1, Bad},
+		{true, ocsp.Good, Good},
+		{false, ocsp.Revoked, Bad},
+	}
+	for _, c := range cases {
+		l := newStaplingServer(t, c.mustStaple, c.staple)
+		grade, output, err := ocspStaplingScan(context.Background(), l.Addr().String(), "127.0.0.1")
+		l.Close()
+		if grade != c.grade {
+			t.Fatalf("must-staple %v, staple %d: expected %v, have %v (%+v, %v)", c.mustStaple, c.staple, c.grade, grade, output, err)
+		}
+		if output.(stapleStatus).MustStaple != c.mustStaple {
+			t.Fatalf("must-staple %v not detected", c.mustStaple)
+		}
+	}
+}
+
+func TestCheckRevocation(t *testing.T) {
+	// A leaf and intermediate with the same common name, as in
+	// cross-signed chains, are reported separately.
+	var chain []*x509.Certificate
+	for serial := int64(3); serial > 0; serial-- {
+		chain = append(chain, &x509.Certificate{
+			Subject:      pkix.Name{CommonName: "example.com"},
+			SerialNumber: big.NewInt(serial),
+		})
+	}
+
+	grade, output, err := checkRevocation(context.Background(), chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Warning {
+		t.Fatalf("expected a chain that can't be checked to warn, have %v", grade)
+	}
+	statuses, ok := output.([]revocationStatus)
+	if !ok || len(statuses) != 2 {
+		t.Fatalf("expected the status of two certificates, have %v", output)
+	}
+	for i, serial := range []string{"3", "2"} {
+		if statuses[i].Serial != serial || statuses[i].OCSP != "none" || statuses[i].CRL != "none" {
+			t.Fatalf("unexpected status of certificate %d: %+v", i, statuses[i])
+		}
+	}
+
+	if grade, _, _ = checkRevocation(context.Background(), chain[:1]); grade != Skipped {
+		t.Fatalf("expected a chain without issuers to be skipped, have %v", grade)
+	}
+}
@@ -0,0 +1,374 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bbandix/cfssl/log"
+)
+
+// A PolicyCondition tests a field of a scanner's output.
+type PolicyCondition struct {
+	// Field is the dot-separated path of the field in the output, as
+	// encoded in JSON, or empty for the output itself. Lists on the
+	// way are searched through, so that the condition holds if it
+	// holds for any of their items.
+	Field string `json:"field,omitempty"`
+	// Op is the test applied to the field: one of "<", "<=", ">",
+	// ">=", "==" and "!=", comparing it with Value; "matches", which
+	// matches the regular expression Value against the field or any
+	// string within it, including object keys; or "expires_within",
+	// which holds if the field is a time less than the duration
+	// Value from now, such as "336h" or "14d".
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+
+	re       *regexp.Regexp
+	duration time.Duration
+}
+
+// A PolicyRule grades the result of a scanner no better than Grade if
+// all of its conditions hold. When a scanner's output is a list, the
+// conditions are tested against each item in turn, so that they all
+// have to hold for the same item.
+type PolicyRule struct {
+	Description string `json:"description"`
+	// Family and Scanner are regular expressions selecting the
+	// scanners the rule applies to; empty ones select every one.
+	Family     string            `json:"family,omitempty"`
+	Scanner    string            `json:"scanner,omitempty"`
+	Conditions []PolicyCondition `json:"conditions"`
+	Grade      string            `json:"grade"`
+
+	familyRe, scannerRe *regexp.Regexp
+	grade               Grade
+}
+
+// A Policy grades scanner results against declarative rules, on top of
+// the grade each scanner gives itself. A rule can only make a grade
+// worse.
+type Policy struct {
+	Rules []*PolicyRule `json:"rules"`
+}
+
+// DefaultPolicy is the policy used unless a policy file is loaded with
+// LoadPolicy.
+var DefaultPolicy = &Policy{Rules: []*PolicyRule{
+	{
+		Description: "Chain expires within 30 days",
+		Family:      "^PKI$",
+		Scanner:     "^ChainExpiration$",
+		Conditions:  []PolicyCondition{{Op: "expires_within", Value: "30d"}},
+		Grade:       "Warning",
+	},
+	{
+		Description: "RSA key shorter than 2048 bits",
+		Family:      "^PKI$",
+		Scanner:     "^Keys$",
+		Conditions: []PolicyCondition{
+			{Field: "key_algorithm", Op: "==", Value: "RSA"},
+			{Field: "key_size", Op: "<", Value: 2048},
+		},
+		Grade: "Bad",
+	},
+}}
+
+func init() {
+	if err := DefaultPolicy.compile(); err != nil {
+		panic(err)
+	}
+}
+
+var (
+	policyLock sync.RWMutex
+	policy     = DefaultPolicy
+)
+
+// LoadPolicy loads the policy that scanner results are graded against
+// from a JSON file, merging its rules over those of the default policy:
+// a rule with the same description as a default rule replaces it, and
+// the others are added to the default rules. If policyFile is empty,
+// the default policy is used.
+func LoadPolicy(policyFile string) error {
+	p := DefaultPolicy
+	if policyFile != "" {
+		log.Debugf("Loading scan policy: %s", policyFile)
+		in, err := ioutil.ReadFile(policyFile)
+		if err != nil {
+			return err
+		}
+		over := new(Policy)
+		if err = json.Unmarshal(in, over); err != nil {
+			return fmt.Errorf("invalid scan policy: %v", err)
+		}
+		if err = over.compile(); err != nil {
+			return err
+		}
+		p = DefaultPolicy.merge(over)
+	}
+
+	policyLock.Lock()
+	defer policyLock.Unlock()
+	policy = p
+	return nil
+}
+
+func currentPolicy() *Policy {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+	return policy
+}
+
+// merge returns a policy with the rules of p followed by those of over,
+// where a rule of over with the same description as one of p replaces
+// it instead.
+func (p *Policy) merge(over *Policy) *Policy {
+	merged := &Policy{Rules: append([]*PolicyRule{}, p.Rules...)}
+	for _, rule := range over.Rules {
+		replaced := false
+		for i := range p.Rules {
+			if rule.Description != "" && rule.Description == p.Rules[i].Description {
+				merged.Rules[i] = rule
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged.Rules = append(merged.Rules, rule)
+		}
+	}
+	return merged
+}
+
+// compile checks the rules of a policy and prepares them for use.
+func (p *Policy) compile() (err error) {
+	for i, rule := range p.Rules {
+		if rule == nil {
+			return fmt.Errorf("scan policy rule %d is empty", i+1)
+		}
+		name := rule.Description
+		if name == "" {
+			name = strconv.Itoa(i + 1)
+		}
+
+		if rule.familyRe, err = regexp.Compile(rule.Family); err != nil {
+			return fmt.Errorf("scan policy rule %s: %v", name, err)
+		}
+		if rule.scannerRe, err = regexp.Compile(rule.Scanner); err != nil {
+			return fmt.Errorf("scan policy rule %s: %v", name, err)
+		}
+		if rule.grade = gradeRank(rule.Grade); rule.grade == Skipped {
+			return fmt.Errorf("scan policy rule %s: invalid grade %q", name, rule.Grade)
+		}
+		if len(rule.Conditions) == 0 {
+			return fmt.Errorf("scan policy rule %s has no conditions", name)
+		}
+		for j := range rule.Conditions {
+			if err = rule.Conditions[j].compile(); err != nil {
+				return fmt.Errorf("scan policy rule %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *PolicyCondition) compile() (err error) {
+	// Values are compared as they would be read from JSON.
+	if c.Value, err = normalize(c.Value); err != nil {
+		return err
+	}
+
+	switch c.Op {
+	case "==", "!=":
+	case "<", "<=", ">", ">=":
+		if _, ok := c.Value.(float64); !ok {
+			return fmt.Errorf("%s needs a number", c.Op)
+		}
+	case "matches":
+		s, ok := c.Value.(string)
+		if !ok {
+			return fmt.Errorf("matches needs a regular expression")
+		}
+		if c.re, err = regexp.Compile(s); err != nil {
+			return err
+		}
+	case "expires_within":
+		s, ok := c.Value.(string)
+		if !ok {
+			return fmt.Errorf("expires_within needs a duration")
+		}
+		if c.duration, err = parseDuration(s); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown condition %q", c.Op)
+	}
+	return nil
+}
+
+// parseDuration parses a duration as time.ParseDuration does, also
+// accepting a whole number of days such as "14d".
+func parseDuration(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// normalize puts v through JSON, so that it has the types it would
+// have if read from JSON.
+func normalize(v interface{}) (interface{}, error) {
+	in, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	err = json.Unmarshal(in, &out)
+	return out, err
+}
+
+// Grade grades the result of a scanner against the policy, returning
+// the grade and the descriptions of the rules that lowered it.
+func (p *Policy) Grade(familyName, scannerName string, grade Grade, output Output) (Grade, []string) {
+	if grade == Skipped || len(p.Rules) == 0 {
+		return grade, nil
+	}
+	v, err := normalize(output)
+	if err != nil {
+		return grade, nil
+	}
+	items, ok := v.([]interface{})
+	if !ok {
+		items = []interface{}{v}
+	}
+
+	var applied []string
+	for _, rule := range p.Rules {
+		if rule.grade >= grade || !rule.familyRe.MatchString(familyName) || !rule.scannerRe.MatchString(scannerName) {
+			continue
+		}
+		for _, item := range items {
+			if rule.holds(item) {
+				grade = rule.grade
+				applied = append(applied, rule.Description)
+				break
+			}
+		}
+	}
+	return grade, applied
+}
+
+// holds reports whether every condition of a rule holds for item.
+func (r *PolicyRule) holds(item interface{}) bool {
+	for i := range r.Conditions {
+		if !r.Conditions[i].holds(item) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *PolicyCondition) holds(item interface{}) bool {
+	var path []string
+	if c.Field != "" {
+		path = strings.Split(c.Field, ".")
+	}
+	for _, v := range lookup(item, path) {
+		if c.test(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *PolicyCondition) test(v interface{}) bool {
+	switch c.Op {
+	case "==":
+		return reflect.DeepEqual(v, c.Value)
+	case "!=":
+		return !reflect.DeepEqual(v, c.Value)
+	case "matches":
+		return anyString(v, c.re.MatchString)
+	case "expires_within":
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		return err == nil && time.Now().Add(c.duration).After(t)
+	}
+
+	n, ok := v.(float64)
+	if !ok {
+		return false
+	}
+	limit := c.Value.(float64)
+	switch c.Op {
+	case "<":
+		return n < limit
+	case "<=":
+		return n <= limit
+	case ">":
+		return n > limit
+	case ">=":
+		return n >= limit
+	}
+	return false
+}
+
+// lookup returns the values at path in v, searching through any lists
+// on the way.
+func lookup(v interface{}, path []string) []interface{} {
+	if list, ok := v.([]interface{}); ok && len(path) > 0 {
+		var values []interface{}
+		for _, item := range list {
+			values = append(values, lookup(item, path)...)
+		}
+		return values
+	}
+	if len(path) == 0 {
+		return []interface{}{v}
+	}
+	object, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	field, ok := object[path[0]]
+	if !ok {
+		return nil
+	}
+	return lookup(field, path[1:])
+}
+
+// anyString reports whether match holds for any string in v, including
+// the keys of objects.
+func anyString(v interface{}, match func(string) bool) bool {
+	switch v := v.(type) {
+	case string:
+		return match(v)
+	case []interface{}:
+		for _, item := range v {
+			if anyString(item, match) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for k, item := range v {
+			if match(k) || anyString(item, match) {
+				return true
+			}
+		}
+	}
+	return false
+}
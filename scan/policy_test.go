@@ -0,0 +1,148 @@
+package scan
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	keys := []keyInfo{
+		{Subject: "example.com", KeyAlgorithm: "ECDSA", KeySize: 256},
+		{Subject: "Test CA", KeyAlgorithm: "RSA", KeySize: 1024},
+	}
+	grade, applied := DefaultPolicy.Grade("PKI", "Keys", Good, keys)
+	if grade != Bad || len(applied) != 1 {
+		t.Fatalf("expected a short RSA key to be Bad, have %v %v", grade, applied)
+	}
+
+	// The conditions must hold for the same certificate.
+	keys[0].KeySize, keys[1].KeyAlgorithm = 1024, "DSA"
+	if grade, _ = DefaultPolicy.Grade("PKI", "Keys", Good, keys); grade != Good {
+		t.Fatalf("expected no RSA key to be Good, have %v", grade)
+	}
+
+	if grade, _ = DefaultPolicy.Grade("PKI", "ChainExpiration", Good, time.Now().Add(10*24*time.Hour)); grade != Warning {
+		t.Fatalf("expected a chain expiring in 10 days to be a Warning, have %v", grade)
+	}
+	if grade, _ = DefaultPolicy.Grade("PKI", "ChainExpiration", Good, time.Now().Add(60*24*time.Hour)); grade != Good {
+		t.Fatalf("expected a chain expiring in 60 days to be Good, have %v", grade)
+	}
+	if grade, _ = DefaultPolicy.Grade("PKI", "ChainExpiration", Bad, time.Now()); grade != Bad {
+		t.Fatalf("expected the policy not to raise a grade, have %v", grade)
+	}
+}
+
+func writePolicy(t *testing.T, dir, policy string) string {
+	path := filepath.Join(dir, "policy.json")
+	if err := ioutil.WriteFile(path, []byte(policy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer LoadPolicy("")
+
+	path := writePolicy(t, dir, `{"rules": [
+		{"description": "SHA-1 anywhere", "conditions": [{"op": "matches", "value": "(?i)sha-?1"}], "grade": "Warning"},
+		{"description": "Too slow", "family": "^Testing$", "conditions": [{"field": "ms", "op": ">", "value": 100}], "grade": "Bad"}
+	]}`)
+	if err = LoadPolicy(path); err != nil {
+		t.Fatal(err)
+	}
+
+	p := currentPolicy()
+	grade, applied := p.Grade("TLSHandshake", "SigAlgs", Good, map[string]string{"SHA1WithRSA": "ECDHE"})
+	if grade != Warning || len(applied) != 1 || applied[0] != "SHA-1 anywhere" {
+		t.Fatalf("expected SHA-1 in an object key to be a Warning, have %v %v", grade, applied)
+	}
+	if grade, _ = p.Grade("Other", "Scanner", Good, map[string]int{"ms": 200}); grade != Good {
+		t.Fatalf("expected a rule for another family not to apply, have %v", grade)
+	}
+	if grade, _ = p.Grade("Testing", "Scanner", Good, map[string]int{"ms": 200}); grade != Bad {
+		t.Fatalf("expected a slow result to be Bad, have %v", grade)
+	}
+	if grade, _ = p.Grade("PKI", "ChainExpiration", Good, time.Now()); grade != Warning {
+		t.Fatalf("expected the default rules to be kept, have %v", grade)
+	}
+
+	// A rule named after a default rule replaces it.
+	path = writePolicy(t, dir, `{"rules": [
+		{"description": "Chain expires within 30 days", "family": "^PKI$", "scanner": "^ChainExpiration$",
+			"conditions": [{"op": "expires_within", "value": "14d"}], "grade": "Bad"}
+	]}`)
+	if err = LoadPolicy(path); err != nil {
+		t.Fatal(err)
+	}
+	p = currentPolicy()
+	if len(p.Rules) != len(DefaultPolicy.Rules) {
+		t.Fatalf("expected the default rule to be replaced, have %d rules", len(p.Rules))
+	}
+	if grade, _ = p.Grade("PKI", "ChainExpiration", Good, time.Now().Add(20*24*time.Hour)); grade != Good {
+		t.Fatalf("expected the replaced rule not to apply, have %v", grade)
+	}
+	if grade, _ = p.Grade("PKI", "ChainExpiration", Good, time.Now()); grade != Bad {
+		t.Fatalf("expected the replacing rule to apply, have %v", grade)
+	}
+	if DefaultPolicy.Rules[0].Grade != "Warning" {
+		t.Fatal("the default policy was modified")
+	}
+
+	for _, invalid := range []string{
+		`{"rules": [{"conditions": [{"op": "<", "value": "a"}], "grade": "Bad"}]}`,
+		`{"rules": [{"conditions": [{"op": "matches", "value": "("}], "grade": "Bad"}]}`,
+		`{"rules": [{"conditions": [{"op": "expires_within", "value": "two weeks"}], "grade": "Bad"}]}`,
+		`{"rules": [{"conditions": [{"op": "~", "value": 1}], "grade": "Bad"}]}`,
+		`{"rules": [{"conditions": [{"op": "==", "value": 1}], "grade": "Terrible"}]}`,
+		`{"rules": [{"grade": "Bad"}]}`,
+	} {
+		if err = LoadPolicy(writePolicy(t, dir, invalid)); err == nil {
+			t.Fatalf("expected policy %s to be rejected", invalid)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	fs := FamilySet{"Testing": TestingFamily}
+	if err := fs.RegisterFamily("Testing", &Family{}); err == nil {
+		t.Fatal("expected registering a family twice to fail")
+	}
+	if err := fs.RegisterFamily("Broken", &Family{Scanners: map[string]*Scanner{"Nil": {}}}); err == nil {
+		t.Fatal("expected registering a scanner without a scan function to fail")
+	}
+
+	custom := NewScanner("Reports a weak key", func(ctx context.Context, addr, hostname string) (Grade, Output, error) {
+		return Good, []keyInfo{{KeyAlgorithm: "RSA", KeySize: 1024}}, nil
+	})
+	if err := fs.RegisterFamily("PKI", &Family{Description: "Custom"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RegisterScanner("PKI", "Keys", custom); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RegisterScanner("PKI", "Keys", custom); err == nil {
+		t.Fatal("expected registering a scanner twice to fail")
+	}
+	if err := fs.RegisterScanner("Missing", "Keys", custom); err == nil {
+		t.Fatal("expected registering a scanner in a missing family to fail")
+	}
+
+	// Custom scanners are graded against the policy too.
+	results, err := fs.RunScans(context.Background(), "good.example.com", "", "^PKI$", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := results["PKI"]["Keys"]
+	if result.Grade != "Bad" || len(result.Policy) != 1 {
+		t.Fatalf("expected the custom scanner's result to be graded by the policy, have %+v", result)
+	}
+}
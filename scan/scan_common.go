@@ -1,24 +1,33 @@
 package scan
 
 import (
+	"context"
 	"crypto/x509"
+	"fmt"
 	"net"
 	"net/http"
 	"regexp"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/cloudflare/cf-tls/tls"
 	"github.com/bbandix/cfssl/helpers"
 	"github.com/bbandix/cfssl/log"
+	"github.com/bbandix/cfssl/starttls"
 )
 
 var (
 	// Network is the default network to use.
 	Network = "tcp"
-	// Dialer is the default dialer to use, with a 1s timeout.
+	// Dialer is the default dialer to use, with a 1s timeout for each
+	// connection. Scanners as a whole are bounded by their context.
 	Dialer = &net.Dialer{Timeout: time.Second}
 	// Client is the default HTTP Client.
-	Client = &http.Client{Transport: &http.Transport{Dial: Dialer.Dial}}
+	Client = &http.Client{Transport: &http.Transport{DialContext: Dialer.DialContext}}
+	// DefaultWorkers is the number of scanners RunScans runs at once
+	// unless told otherwise.
+	DefaultWorkers = 8
 	// RootCAs defines the default root certificate authorities to be used for scan.
 	RootCAs *x509.CertPool
 )
@@ -56,12 +65,48 @@ func (g Grade) String() string {
 // Output is the result of a scan, to be stored for potential use by later Scanners.
 type Output interface{}
 
+// dialTLS connects to addr and performs a TLS handshake, giving up when
+// ctx is done.
+func dialTLS(ctx context.Context, addr string, config *tls.Config) (*tls.Conn, error) {
+	rawConn, err := dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := tls.Client(rawConn, config)
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Interrupt the handshake if ctx is done before it completes.
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			rawConn.Close()
+		case <-done:
+		}
+	}()
+	err = conn.Handshake()
+	close(done)
+
+	if err != nil {
+		rawConn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
 // multiscan scans all DNS addresses returned for the host, returning the lowest grade
 // and the concatenation of all the output.
-func multiscan(host string, scan func(string) (Grade, Output, error)) (grade Grade, output Output, err error) {
+func multiscan(ctx context.Context, host string, scan func(string) (Grade, Output, error)) (grade Grade, output Output, err error) {
 	domain, port, _ := net.SplitHostPort(host)
 	var addrs []string
-	addrs, err = net.LookupHost(domain)
+	addrs, err = net.DefaultResolver.LookupHost(ctx, domain)
 	if err != nil {
 		return
 	}
@@ -73,6 +118,11 @@ func multiscan(host string, scan func(string) (Grade, Output, error)) (grade Gra
 		var g Grade
 		var o Output
 
+		if err = ctx.Err(); err != nil {
+			grade = Bad
+			return
+		}
+
 		g, o, err = scan(net.JoinHostPort(addr, port))
 		if err != nil {
 			grade = Bad
@@ -89,17 +139,29 @@ func multiscan(host string, scan func(string) (Grade, Output, error)) (grade Gra
 	return
 }
 
+// A ScanFunc scans the host at addr, whose name is hostname, and
+// provides a Grade and Output. It should give up when ctx is done.
+type ScanFunc func(ctx context.Context, addr, hostname string) (Grade, Output, error)
+
 // Scanner describes a type of scan to perform on a host.
 type Scanner struct {
 	// Description describes the nature of the scan to be performed.
 	Description string `json:"description"`
 	// scan is the function that scans the given host and provides a Grade and Output.
-	scan func(string, string) (Grade, Output, error)
+	scan ScanFunc
+}
+
+// NewScanner returns a Scanner that scans hosts with scan, so that
+// scanners can be defined outside this package and registered with
+// RegisterScanner.
+func NewScanner(description string, scan ScanFunc) *Scanner {
+	return &Scanner{Description: description, scan: scan}
 }
 
 // Scan performs the scan to be performed on the given host and stores its result.
-func (s *Scanner) Scan(addr, hostname string) (Grade, Output, error) {
-	grade, output, err := s.scan(addr, hostname)
+// The scan is abandoned when ctx is done.
+func (s *Scanner) Scan(ctx context.Context, addr, hostname string) (Grade, Output, error) {
+	grade, output, err := s.scan(ctx, addr, hostname)
 	if err != nil {
 		log.Infof("scan: %v", err)
 		return grade, output, err
@@ -118,6 +180,45 @@ type Family struct {
 // FamilySet contains a set of Families to run Scans from.
 type FamilySet map[string]*Family
 
+// RegisterFamily adds a family to the set. Families and scanners should
+// be registered before any scans are run, for example from an init
+// function; registering them is not safe while scans are running.
+func (fs FamilySet) RegisterFamily(name string, family *Family) error {
+	if family == nil {
+		return fmt.Errorf("scan: family %s is nil", name)
+	}
+	if _, ok := fs[name]; ok {
+		return fmt.Errorf("scan: family %s is already registered", name)
+	}
+	for scannerName, scanner := range family.Scanners {
+		if scanner == nil || scanner.scan == nil {
+			return fmt.Errorf("scan: scanner %s/%s has no scan function", name, scannerName)
+		}
+	}
+	if family.Scanners == nil {
+		family.Scanners = make(map[string]*Scanner)
+	}
+	fs[name] = family
+	return nil
+}
+
+// RegisterScanner adds a scanner to a family in the set, which must
+// already have been registered.
+func (fs FamilySet) RegisterScanner(familyName, scannerName string, scanner *Scanner) error {
+	family, ok := fs[familyName]
+	if !ok {
+		return fmt.Errorf("scan: no family %s", familyName)
+	}
+	if scanner == nil || scanner.scan == nil {
+		return fmt.Errorf("scan: scanner %s/%s has no scan function", familyName, scannerName)
+	}
+	if _, ok = family.Scanners[scannerName]; ok {
+		return fmt.Errorf("scan: scanner %s/%s is already registered", familyName, scannerName)
+	}
+	family.Scanners[scannerName] = scanner
+	return nil
+}
+
 // Default contains each scan Family that is defined
 var Default = FamilySet{
 	"Connectivity": Connectivity,
@@ -125,6 +226,7 @@ var Default = FamilySet{
 	"TLSSession":   TLSSession,
 	"PKI":          PKI,
 	"Broad":        Broad,
+	"CT":           CT,
 }
 
 // ScannerResult contains the result for a single scan.
@@ -132,18 +234,46 @@ type ScannerResult struct {
 	Grade  string `json:"grade"`
 	Output Output `json:"output,omitempty"`
 	Error  string `json:"error,omitempty"`
+	// Duration is the time the scan took, in seconds.
+	Duration float64 `json:"duration"`
+	// Policy holds the descriptions of the policy rules that lowered
+	// the grade the scanner gave.
+	Policy []string `json:"policy,omitempty"`
 }
 
 // FamilyResult contains a scan response for a single Family
 type FamilyResult map[string]ScannerResult
 
+// scanJob is a single scanner to be run by RunScans.
+type scanJob struct {
+	familyName  string
+	scannerName string
+	scanner     *Scanner
+}
+
 // RunScans iterates over AllScans, running scans matching the family and scanner
-// regular expressions.
-func (fs FamilySet) RunScans(host, ip, family, scanner string, dur time.Duration) (map[string]FamilyResult, error) {
+// regular expressions. Up to workers scanners are run at once, or
+// DefaultWorkers if workers is less than one. Each result is graded
+// against the policy loaded with LoadPolicy.
+//
+// When ctx is done, the scanners still running are cancelled and RunScans
+// returns the results of those that had completed, along with ctx.Err().
+func (fs FamilySet) RunScans(ctx context.Context, host, ip, family, scanner string, workers int) (map[string]FamilyResult, error) {
 	hostname, port, err := net.SplitHostPort(host)
 	if err != nil {
 		hostname = host
-		port = "443"
+		port = ""
+	}
+
+	defaultPort := "443"
+	if opts, ok := startTLSFrom(ctx); ok {
+		if defaultPort, err = starttls.Port(opts.protocol); err != nil {
+			return nil, err
+		}
+		ctx = withStartTLSHostname(ctx, hostname)
+	}
+	if port == "" {
+		port = defaultPort
 	}
 
 	var addr string
@@ -163,57 +293,112 @@ func (fs FamilySet) RunScans(host, ip, family, scanner string, dur time.Duration
 		return nil, err
 	}
 
-	results := make(chan map[string]FamilyResult)
-	timeout := make(chan bool)
+	var jobs []scanJob
+	for familyName, family := range fs {
+		if !familyRegexp.MatchString(familyName) {
+			continue
+		}
+		for scannerName, scanner := range family.Scanners {
+			if scannerRegexp.MatchString(scannerName) {
+				jobs = append(jobs, scanJob{familyName, scannerName, scanner})
+			}
+		}
+	}
+	// Start scanners in a stable order, so that runs are repeatable.
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].familyName != jobs[j].familyName {
+			return jobs[i].familyName < jobs[j].familyName
+		}
+		return jobs[i].scannerName < jobs[j].scannerName
+	})
 
-	if dur > 0 {
-		go startTimer(dur, timeout)
+	if workers < 1 {
+		workers = DefaultWorkers
+	}
+	if workers > len(jobs) {
+		workers = len(jobs)
 	}
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	policy := currentPolicy()
+	var lock sync.Mutex
 	familyResults := make(map[string]FamilyResult)
 
-	go func() {
-		for familyName, family := range fs {
-			if familyRegexp.MatchString(familyName) {
-				scannerResults := make(map[string]ScannerResult)
-				for scannerName, scanner := range family.Scanners {
-					if scannerRegexp.MatchString(scannerName) {
-						grade, output, err := scanner.Scan(addr, hostname)
-						if grade != Skipped {
-							result := ScannerResult{
-								Grade:  grade.String(),
-								Output: output,
-							}
-							if err != nil {
-								result.Error = err.Error()
-							}
-
-							scannerResults[scannerName] = result
-						}
-					}
+	queue := make(chan scanJob)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				start := time.Now()
+				grade, output, err := job.scanner.Scan(ctx, addr, hostname)
+				if grade == Skipped || ctx.Err() != nil {
+					continue
+				}
+
+				duration := time.Since(start).Seconds()
+				grade, applied := policy.Grade(job.familyName, job.scannerName, grade, output)
+				result := ScannerResult{
+					Grade:    grade.String(),
+					Output:   output,
+					Duration: duration,
+					Policy:   applied,
+				}
+				if err != nil {
+					result.Error = err.Error()
 				}
 
-				if len(scannerResults) > 0 {
-					familyResults[familyName] = scannerResults
+				lock.Lock()
+				if familyResults[job.familyName] == nil {
+					familyResults[job.familyName] = make(FamilyResult)
 				}
+				familyResults[job.familyName][job.scannerName] = result
+				lock.Unlock()
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(queue)
+		for _, job := range jobs {
+			select {
+			case queue <- job:
+			case <-ctx.Done():
+				return
 			}
 		}
-		results <- familyResults
+	}()
+
+	finished := make(chan struct{})
+	go func() {
+		<-done
+		wg.Wait()
+		close(finished)
 	}()
 
 	select {
-	case <-timeout:
-		log.Warningf("scan: %s timed out after %v", host, dur)
+	case <-finished:
 		return familyResults, nil
-	case res := <-results:
-		return res, nil
+	case <-ctx.Done():
 	}
-}
 
-func startTimer(t time.Duration, timeout chan bool) {
-	time.AfterFunc(t, func() {
-		timeout <- true
-	})
+	// Scanners still running may yet complete, so return a copy of
+	// the results so far.
+	lock.Lock()
+	defer lock.Unlock()
+	snapshot := make(map[string]FamilyResult, len(familyResults))
+	for familyName, scannerResults := range familyResults {
+		snapshot[familyName] = make(FamilyResult, len(scannerResults))
+		for scannerName, result := range scannerResults {
+			snapshot[familyName][scannerName] = result
+		}
+	}
+	return snapshot, ctx.Err()
 }
 
 // LoadRootCAs loads the default root certificate authorities from file.
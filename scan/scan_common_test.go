@@ -1,13 +1,16 @@
 package scan
 
 import (
+	"context"
 	"fmt"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var TestingScanner = &Scanner{
 	Description: "Tests common scan functions",
-	scan: func(addr, hostname string) (Grade, Output, error) {
+	scan: func(ctx context.Context, addr, hostname string) (Grade, Output, error) {
 		switch addr {
 		case "bad.example.com:443":
 			return Bad, "bad.com", nil
@@ -39,28 +42,104 @@ func TestCommon(t *testing.T) {
 	var output Output
 	var err error
 
-	grade, output, err = TestingScanner.Scan("bad.example.com:443", "bad.example.com")
+	grade, output, err = TestingScanner.Scan(context.Background(), "bad.example.com:443", "bad.example.com")
 	if grade != Bad || output.(string) != "bad.com" || err != nil {
 		t.FailNow()
 	}
 
-	grade, output, err = TestingScanner.Scan("Warning.example.com:443", "Warning.example.com")
+	grade, output, err = TestingScanner.Scan(context.Background(), "Warning.example.com:443", "Warning.example.com")
 	if grade != Warning || output.(string) != "Warning.com" || err != nil {
 		t.FailNow()
 	}
 
-	grade, output, err = TestingScanner.Scan("good.example.com:443", "good.example.com")
+	grade, output, err = TestingScanner.Scan(context.Background(), "good.example.com:443", "good.example.com")
 	if grade != Good || output.(string) != "good.com" || err != nil {
 		t.FailNow()
 	}
 
-	grade, output, err = TestingScanner.Scan("skipped.example.com:443/0", "")
+	grade, output, err = TestingScanner.Scan(context.Background(), "skipped.example.com:443/0", "")
 	if grade != Skipped || output.(string) != "skipped" || err != nil {
 		t.FailNow()
 	}
 
-	_, _, err = TestingScanner.Scan("invalid", "invalid")
+	_, _, err = TestingScanner.Scan(context.Background(), "invalid", "invalid")
 	if err == nil {
 		t.FailNow()
 	}
 }
+
+// sleepScanner returns a scanner that takes d to complete, or until
+// its context is done, counting the scanners running at once in
+// running and the most seen in peak.
+func sleepScanner(d time.Duration, running, peak *int32) *Scanner {
+	return &Scanner{
+		Description: "Sleeps",
+		scan: func(ctx context.Context, addr, hostname string) (Grade, Output, error) {
+			n := atomic.AddInt32(running, 1)
+			defer atomic.AddInt32(running, -1)
+			for {
+				p := atomic.LoadInt32(peak)
+				if n <= p || atomic.CompareAndSwapInt32(peak, p, n) {
+					break
+				}
+			}
+
+			select {
+			case <-time.After(d):
+				return Good, nil, nil
+			case <-ctx.Done():
+				return Bad, nil, ctx.Err()
+			}
+		},
+	}
+}
+
+func TestRunScansWorkers(t *testing.T) {
+	var running, peak int32
+	fs := FamilySet{"Sleep": &Family{Scanners: map[string]*Scanner{}}}
+	for _, name := range []string{"A", "B", "C", "D", "E", "F"} {
+		fs["Sleep"].Scanners[name] = sleepScanner(20*time.Millisecond, &running, &peak)
+	}
+
+	results, err := fs.RunScans(context.Background(), "example.com", "", "", "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results["Sleep"]) != 6 {
+		t.Fatalf("expected 6 results, have %v", results)
+	}
+	if peak != 2 {
+		t.Fatalf("expected 2 scanners to run at once, have %d", peak)
+	}
+	for name, result := range results["Sleep"] {
+		if result.Grade != "Good" || result.Duration <= 0 {
+			t.Fatalf("unexpected result for %s: %+v", name, result)
+		}
+	}
+}
+
+func TestRunScansTimeout(t *testing.T) {
+	var running, peak int32
+	fs := FamilySet{"Sleep": &Family{Scanners: map[string]*Scanner{
+		"Fast": sleepScanner(0, &running, &peak),
+		"Slow": sleepScanner(time.Minute, &running, &peak),
+	}}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	results, err := fs.RunScans(ctx, "example.com", "", "", "", 2)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected the scan to time out, have %v", err)
+	}
+	if _, ok := results["Sleep"]["Fast"]; !ok || len(results["Sleep"]) != 1 {
+		t.Fatalf("expected only the fast scanner's result, have %v", results)
+	}
+
+	// The slow scanner is cancelled rather than left running.
+	for i := 0; atomic.LoadInt32(&running) != 0; i++ {
+		if i == 100 {
+			t.Fatal("scanner is still running after the scan timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,80 @@
+package scan
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/bbandix/cfssl/starttls"
+)
+
+// negotiateTimeout bounds STARTTLS negotiation when ctx has no deadline
+// of its own.
+const negotiateTimeout = 10 * time.Second
+
+// WithStartTLS returns a context that has scanners negotiate STARTTLS
+// using protocol, one of those supported by the starttls package,
+// before each TLS handshake. RunScans given such a context scans the
+// protocol's usual port when the host has none.
+func WithStartTLS(ctx context.Context, protocol string) context.Context {
+	return context.WithValue(ctx, startTLSKey, startTLSOptions{protocol: protocol})
+}
+
+// startTLSOptions is how a context asks for STARTTLS. The hostname is
+// filled in by RunScans, for protocols that introduce the client to
+// the server by name.
+type startTLSOptions struct {
+	protocol, hostname string
+}
+
+func startTLSFrom(ctx context.Context) (startTLSOptions, bool) {
+	opts, ok := ctx.Value(startTLSKey).(startTLSOptions)
+	return opts, ok && opts.protocol != ""
+}
+
+// withStartTLSHostname records the name of the host being scanned in a
+// context that asks for STARTTLS.
+func withStartTLSHostname(ctx context.Context, hostname string) context.Context {
+	opts, ok := startTLSFrom(ctx)
+	if !ok {
+		return ctx
+	}
+	opts.hostname = hostname
+	return context.WithValue(ctx, startTLSKey, opts)
+}
+
+// negotiateStartTLS negotiates STARTTLS on a new connection to addr,
+// giving up when ctx is done.
+func negotiateStartTLS(ctx context.Context, conn net.Conn, addr string, opts startTLSOptions) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(negotiateTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	host := opts.hostname
+	if host == "" {
+		var err error
+		if host, _, err = net.SplitHostPort(addr); err != nil {
+			host = addr
+		}
+	}
+	if err := starttls.Negotiate(conn, opts.protocol, host); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+	return nil
+}
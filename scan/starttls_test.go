@@ -0,0 +1,87 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveSMTP starts an SMTP server that offers STARTTLS, with the given
+// certificate chain and key, to any number of connections.
+func serveSMTP(t *testing.T, cert tls.Certificate) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				r := bufio.NewReader(conn)
+				io.WriteString(conn, "220 stub ESMTP\r\n")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch strings.TrimSpace(line) {
+					case "STARTTLS":
+						io.WriteString(conn, "220 go ahead\r\n")
+						tls.Server(conn, config).Handshake()
+						return
+					default:
+						io.WriteString(conn, "250-stub\r\n250 STARTTLS\r\n")
+					}
+				}
+			}()
+		}
+	}()
+	return l
+}
+
+func TestStartTLS(t *testing.T) {
+	key := newTestKey(t)
+	ca, leaf := newTestChain(t, key, nil)
+	l := serveSMTP(t, tls.Certificate{Certificate: [][]byte{leaf.Raw, ca.Raw}, PrivateKey: key})
+	defer l.Close()
+
+	fs := FamilySet{"Connectivity": Connectivity, "PKI": PKI}
+	ctx := WithStartTLS(context.Background(), "smtp")
+	results, err := fs.RunScans(ctx, l.Addr().String(), "", "", "^(TCPDial|TLSDial|Keys)$", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The chain can't be verified, but the handshake succeeds.
+	if grade := results["Connectivity"]["TLSDial"].Grade; grade != "Warning" {
+		t.Fatalf("expected the TLS handshake to succeed after STARTTLS, have %+v", results["Connectivity"])
+	}
+	keys, ok := results["PKI"]["Keys"].Output.([]keyInfo)
+	if !ok || len(keys) != 2 || keys[0].KeyAlgorithm != "ECDSA" {
+		t.Fatalf("expected the chain to be read after STARTTLS, have %+v", results["PKI"]["Keys"])
+	}
+
+	// Without STARTTLS, the handshake fails.
+	results, err = fs.RunScans(context.Background(), l.Addr().String(), "", "", "^TLSDial$", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := results["Connectivity"]["TLSDial"]; result.Grade != "Bad" || result.Error == "" {
+		t.Fatalf("expected a TLS handshake without STARTTLS to fail, have %+v", result)
+	}
+
+	if _, err = fs.RunScans(WithStartTLS(context.Background(), "gopher"), "example.com", "", "", "", 0); err == nil {
+		t.Fatal("expected an unknown STARTTLS protocol to fail")
+	}
+}
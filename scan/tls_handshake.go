@@ -2,10 +2,13 @@ package scan
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/cloudflare/cf-tls/tls"
 	"github.com/bbandix/cfssl/helpers"
@@ -34,6 +37,26 @@ var TLSHandshake = &Family{
 			"Determines host's certificate signature algorithm matching client's accepted ciphers",
 			certSigAlgsScanByCipher,
 		},
+		"Versions": {
+			"Determines host's supported protocol versions, from SSL 3.0 to TLS 1.3",
+			versionsScan,
+		},
+		"NamedGroups": {
+			"Determines host's supported named groups for TLS 1.3 key exchange",
+			namedGroupsScan,
+		},
+		"KeyExchangePreference": {
+			"Determines whether host prefers its own order of elliptic curves over the client's",
+			kexPreferenceScan,
+		},
+		"ALPN": {
+			"Determines host's application protocols negotiated through ALPN",
+			alpnScan,
+		},
+		"FallbackSCSV": {
+			"Host rejects protocol version fallbacks signalled with TLS_FALLBACK_SCSV",
+			fallbackSCSVScan,
+		},
 	},
 }
 
@@ -61,11 +84,52 @@ func getCurveIndex(curves []tls.CurveID, serverCurve tls.CurveID) (curveIndex in
 	return
 }
 
-func sayHello(addr, hostname string, ciphers []uint16, curves []tls.CurveID, vers uint16, sigAlgs []tls.SignatureAndHash) (cipherIndex, curveIndex int, certs [][]byte, err error) {
-	tcpConn, err := net.Dial(Network, addr)
+// helloTimeout bounds each handshake in sayHello when ctx has no
+// earlier deadline.
+var helloTimeout = 10 * time.Second
+
+// helloLock serialises the signature algorithms that sayHello sets
+// globally. They are only read when the ClientHello is built, so the
+// lock is held from setting them until the ClientHello is first
+// written, and never over network I/O.
+var helloLock sync.Mutex
+
+// helloConn calls release before the first write to the connection,
+// which carries the ClientHello.
+type helloConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *helloConn) Write(b []byte) (int, error) {
+	c.once.Do(c.release)
+	return c.Conn.Write(b)
+}
+
+func sayHello(ctx context.Context, addr, hostname string, ciphers []uint16, curves []tls.CurveID, vers uint16, sigAlgs []tls.SignatureAndHash) (cipherIndex, curveIndex int, certs [][]byte, err error) {
+	tcpConn, err := dial(ctx, addr)
 	if err != nil {
 		return
 	}
+	defer tcpConn.Close()
+
+	deadline := time.Now().Add(helloTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	tcpConn.SetDeadline(deadline)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			tcpConn.Close()
+		case <-done:
+		}
+	}()
+
 	config := defaultTLSConfig(hostname)
 	config.MinVersion = vers
 	config.MaxVersion = vers
@@ -82,15 +146,20 @@ func sayHello(addr, hostname string, ciphers []uint16, curves []tls.CurveID, ver
 	if sigAlgs == nil {
 		sigAlgs = tls.AllSignatureAndHashAlgorithms
 	}
+	hc := &helloConn{Conn: tcpConn, release: func() {
+		tls.ResetSupportedSKXSignatureAlgorithms()
+		helloLock.Unlock()
+	}}
+	helloLock.Lock()
 	tls.SetSupportedSKXSignatureAlgorithms(sigAlgs)
-	defer tls.ResetSupportedSKXSignatureAlgorithms()
-
-	conn := tls.Client(tcpConn, config)
+	conn := tls.Client(hc, config)
 	serverCipher, serverCurveType, serverCurve, serverVersion, certificates, err := conn.SayHello()
+	hc.once.Do(hc.release)
 	certs = certificates
-	conn.Close()
 	if err != nil {
-		err = errHelloFailed
+		if err = ctx.Err(); err == nil {
+			err = errHelloFailed
+		}
 		return
 	}
 
@@ -187,13 +256,13 @@ func (cvList cipherVersionList) MarshalJSON() ([]byte, error) {
 	return b.Bytes(), nil
 }
 
-func doCurveScan(addr, hostname string, vers, cipherID uint16, ciphers []uint16) (supportedCurves []tls.CurveID, err error) {
+func doCurveScan(ctx context.Context, addr, hostname string, vers, cipherID uint16, ciphers []uint16) (supportedCurves []tls.CurveID, err error) {
 	allCurves := allCurvesIDs()
 	curves := make([]tls.CurveID, len(allCurves))
 	copy(curves, allCurves)
 	for len(curves) > 0 {
 		var curveIndex int
-		_, curveIndex, _, err = sayHello(addr, hostname, []uint16{cipherID}, curves, vers, nil)
+		_, curveIndex, _, err = sayHello(ctx, addr, hostname, []uint16{cipherID}, curves, vers, nil)
 		if err != nil {
 			// This case is expected, because eventually we ask only for curves the server doesn't support
 			if err == errHelloFailed {
@@ -211,7 +280,7 @@ func doCurveScan(addr, hostname string, vers, cipherID uint16, ciphers []uint16)
 
 // cipherSuiteScan returns, by TLS Version, the sort list of cipher suites
 // supported by the host
-func cipherSuiteScan(addr, hostname string) (grade Grade, output Output, err error) {
+func cipherSuiteScan(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
 	var cvList cipherVersionList
 	allCiphers := allCiphersIDs()
 
@@ -221,7 +290,7 @@ func cipherSuiteScan(addr, hostname string) (grade Grade, output Output, err err
 		copy(ciphers, allCiphers)
 		for len(ciphers) > 0 {
 			var cipherIndex int
-			cipherIndex, _, _, err = sayHello(addr, hostname, ciphers, nil, vers, nil)
+			cipherIndex, _, _, err = sayHello(ctx, addr, hostname, ciphers, nil, vers, nil)
 			if err != nil {
 				if err == errHelloFailed {
 					err = nil
@@ -237,7 +306,7 @@ func cipherSuiteScan(addr, hostname string) (grade Grade, output Output, err err
 			// If this is an EC cipher suite, do a second scan for curve support
 			var supportedCurves []tls.CurveID
 			if tls.CipherSuites[cipherID].EllipticCurve {
-				supportedCurves, err = doCurveScan(addr, hostname, vers, cipherID, ciphers)
+				supportedCurves, err = doCurveScan(ctx, addr, hostname, vers, cipherID, ciphers)
 				if len(supportedCurves) == 0 {
 					err = errors.New("couldn't negotiate any curves")
 				}
@@ -268,10 +337,13 @@ func cipherSuiteScan(addr, hostname string) (grade Grade, output Output, err err
 }
 
 // sigAlgsScan returns the accepted signature and hash algorithms of the host
-func sigAlgsScan(addr, hostname string) (grade Grade, output Output, err error) {
+func sigAlgsScan(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
 	var supportedSigAlgs []tls.SignatureAndHash
 	for _, sigAlg := range tls.AllSignatureAndHashAlgorithms {
-		_, _, _, e := sayHello(addr, hostname, nil, nil, tls.VersionTLS12, []tls.SignatureAndHash{sigAlg})
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		_, _, _, e := sayHello(ctx, addr, hostname, nil, nil, tls.VersionTLS12, []tls.SignatureAndHash{sigAlg})
 		if e == nil {
 			supportedSigAlgs = append(supportedSigAlgs, sigAlg)
 		}
@@ -287,10 +359,13 @@ func sigAlgsScan(addr, hostname string) (grade Grade, output Output, err error)
 }
 
 // certSigAlgScan returns the server certificate with various sigature and hash algorithms in the ClientHello
-func certSigAlgsScan(addr, hostname string) (grade Grade, output Output, err error) {
+func certSigAlgsScan(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
 	var certSigAlgs = make(map[string]string)
 	for _, sigAlg := range tls.AllSignatureAndHashAlgorithms {
-		_, _, derCerts, e := sayHello(addr, hostname, nil, nil, tls.VersionTLS12, []tls.SignatureAndHash{sigAlg})
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		_, _, derCerts, e := sayHello(ctx, addr, hostname, nil, nil, tls.VersionTLS12, []tls.SignatureAndHash{sigAlg})
 		if e == nil {
 			if len(derCerts) == 0 {
 				return Bad, nil, errors.New("no certs returned")
@@ -316,10 +391,13 @@ func certSigAlgsScan(addr, hostname string) (grade Grade, output Output, err err
 }
 
 // certSigAlgScan returns the server certificate with various ciphers in the ClientHello
-func certSigAlgsScanByCipher(addr, hostname string) (grade Grade, output Output, err error) {
+func certSigAlgsScanByCipher(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
 	var certSigAlgs = make(map[string]string)
 	for cipherID := range tls.CipherSuites {
-		_, _, derCerts, e := sayHello(addr, hostname, []uint16{cipherID}, nil, tls.VersionTLS12, []tls.SignatureAndHash{})
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		_, _, derCerts, e := sayHello(ctx, addr, hostname, []uint16{cipherID}, nil, tls.VersionTLS12, []tls.SignatureAndHash{})
 		if e == nil {
 			if len(derCerts) == 0 {
 				return Bad, nil, errors.New("no certs returned")
@@ -0,0 +1,81 @@
+package scan
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cf-tls/tls"
+)
+
+// newStalledServer returns a listener that accepts connections but
+// never responds on them.
+func newStalledServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	return l
+}
+
+func TestSayHelloCancel(t *testing.T) {
+	l := newStalledServer(t)
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	// Hellos to a host that doesn't respond neither wait on each other
+	// nor outlive their context.
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, _, err := sayHello(ctx, l.Addr().String(), "127.0.0.1", nil, nil, tls.VersionTLS12, nil); err == nil {
+				t.Error("expected a hello to a stalled host to fail")
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("hellos took %v to give up", elapsed)
+	}
+
+	helloLock.Lock()
+	helloLock.Unlock()
+}
+
+func TestSayHelloTimeout(t *testing.T) {
+	l := newStalledServer(t)
+	defer l.Close()
+
+	defer func(d time.Duration) { helloTimeout = d }(helloTimeout)
+	helloTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	if _, _, _, err := sayHello(context.Background(), l.Addr().String(), "127.0.0.1", nil, nil, tls.VersionTLS12, nil); err == nil {
+		t.Fatal("expected a hello to a stalled host to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("hello took %v to time out", elapsed)
+	}
+}
@@ -0,0 +1,407 @@
+package scan
+
+import (
+	"context"
+	"crypto/rand"
+	stdtls "crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/cloudflare/cf-tls/tls"
+)
+
+// The scanners in this file cover what the cf-tls fork can't: TLS 1.3,
+// its named groups and ALPN are probed with the standard library, and
+// fallback signalling with a hand-built ClientHello.
+
+// versionTLS13 is the protocol version of TLS 1.3, which cf-tls predates.
+const versionTLS13 = 0x0304
+
+// versionNames names the protocol versions reported by versionsScan.
+var versionNames = map[uint16]string{
+	tls.VersionSSL30: "SSL 3.0",
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	versionTLS13:     "TLS 1.3",
+}
+
+// tls13Groups are the named groups probed by namedGroupsScan, named as
+// cf-tls names elliptic curves.
+var tls13Groups = []struct {
+	id   stdtls.CurveID
+	name string
+}{
+	{stdtls.X25519, "x25519"},
+	{stdtls.CurveP256, "secp256r1"},
+	{stdtls.CurveP384, "secp384r1"},
+	{stdtls.CurveP521, "secp521r1"},
+}
+
+// alpnProtocols are the application protocols probed by alpnScan.
+var alpnProtocols = []string{"h2", "http/1.1", "spdy/3.1", "http/1.0"}
+
+// stdHandshake performs a handshake with the standard library's TLS
+// client and returns the state of the connection.
+func stdHandshake(ctx context.Context, addr string, config *stdtls.Config) (state stdtls.ConnectionState, err error) {
+	conn, err := dial(ctx, addr)
+	if err != nil {
+		return
+	}
+	tlsConn := stdtls.Client(conn, config)
+	defer tlsConn.Close()
+
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		return
+	}
+	return tlsConn.ConnectionState(), nil
+}
+
+func stdTLSConfig(hostname string, vers uint16) *stdtls.Config {
+	return &stdtls.Config{
+		ServerName:         hostname,
+		InsecureSkipVerify: true,
+		MinVersion:         vers,
+		MaxVersion:         vers,
+	}
+}
+
+// versionsScan returns the protocol versions the host supports, newest
+// first. It is graded Bad if the host supports SSL 3.0 or supports
+// neither TLS 1.2 nor TLS 1.3, Warning if it supports TLS 1.0 or TLS 1.1
+// or doesn't support TLS 1.3, and Good otherwise.
+func versionsScan(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
+	supported := make(map[uint16]bool)
+	if _, e := stdHandshake(ctx, addr, stdTLSConfig(hostname, versionTLS13)); e == nil {
+		supported[versionTLS13] = true
+	}
+	for vers := uint16(tls.VersionTLS12); vers >= tls.VersionSSL30; vers-- {
+		if _, _, _, e := sayHello(ctx, addr, hostname, nil, nil, vers, nil); e == nil {
+			supported[vers] = true
+		}
+	}
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	var names []string
+	for vers := uint16(versionTLS13); vers >= tls.VersionSSL30; vers-- {
+		if supported[vers] {
+			names = append(names, versionNames[vers])
+		}
+	}
+	if len(names) == 0 {
+		err = errors.New("couldn't negotiate any protocol version")
+		return
+	}
+
+	switch {
+	case supported[tls.VersionSSL30], !supported[tls.VersionTLS12] && !supported[versionTLS13]:
+		grade = Bad
+	case supported[tls.VersionTLS10], supported[tls.VersionTLS11], !supported[versionTLS13]:
+		grade = Warning
+	default:
+		grade = Good
+	}
+	output = names
+	return
+}
+
+// namedGroupsScan returns the named groups the host supports for TLS 1.3
+// key exchange. It is skipped if the host doesn't support TLS 1.3, and is
+// graded Good if the host supports x25519 or secp256r1, which every TLS
+// 1.3 client does, and Warning otherwise.
+func namedGroupsScan(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
+	var groups []string
+	grade = Warning
+	for _, group := range tls13Groups {
+		config := stdTLSConfig(hostname, versionTLS13)
+		config.CurvePreferences = []stdtls.CurveID{group.id}
+		if _, e := stdHandshake(ctx, addr, config); e != nil {
+			continue
+		}
+		groups = append(groups, group.name)
+		if group.id == stdtls.X25519 || group.id == stdtls.CurveP256 {
+			grade = Good
+		}
+	}
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	if len(groups) == 0 {
+		return Skipped, nil, nil
+	}
+	output = groups
+	return
+}
+
+// kexPreference describes how the host chooses the elliptic curve for
+// an ECDHE key exchange.
+type kexPreference struct {
+	// ServerPreference is set if the host chooses by its own
+	// preference rather than the client's.
+	ServerPreference bool `json:"server_preference"`
+	// Curves are the curves the host supports, in the order it chooses
+	// them given the client's preference.
+	Curves []string `json:"curves"`
+}
+
+// kexPreferenceScan determines whether the host chooses the curve for a
+// TLS 1.2 ECDHE key exchange by its own preference, by offering the
+// curves it supports in opposite orders. It is skipped if the host
+// doesn't support ECDHE in TLS 1.2, and is graded Good if the host
+// follows its own preference, or supports only one curve, and Warning
+// if it follows the client's, letting a client pick a weak curve.
+func kexPreferenceScan(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
+	var ecCiphers []uint16
+	for _, cipherID := range allCiphersIDs() {
+		if tls.CipherSuites[cipherID].EllipticCurve {
+			ecCiphers = append(ecCiphers, cipherID)
+		}
+	}
+
+	var supported []tls.CurveID
+	curves := allCurvesIDs()
+	for len(curves) > 0 {
+		var curveIndex int
+		_, curveIndex, _, err = sayHello(ctx, addr, hostname, ecCiphers, curves, tls.VersionTLS12, nil)
+		if err == errHelloFailed {
+			err = nil
+			break
+		} else if err != nil {
+			return
+		}
+		supported = append(supported, curves[curveIndex])
+		curves = append(curves[:curveIndex], curves[curveIndex+1:]...)
+	}
+	if len(supported) == 0 {
+		return Skipped, nil, nil
+	}
+
+	pref := kexPreference{ServerPreference: true}
+	for _, curve := range supported {
+		pref.Curves = append(pref.Curves, tls.Curves[curve])
+	}
+
+	if len(supported) > 1 {
+		reversed := make([]tls.CurveID, len(supported))
+		for i, curve := range supported {
+			reversed[len(supported)-1-i] = curve
+		}
+
+		var curveIndex int
+		_, curveIndex, _, err = sayHello(ctx, addr, hostname, ecCiphers, reversed, tls.VersionTLS12, nil)
+		if err != nil {
+			return
+		}
+		pref.ServerPreference = reversed[curveIndex] == supported[0]
+	}
+
+	grade = Good
+	if !pref.ServerPreference {
+		grade = Warning
+	}
+	output = pref
+	return
+}
+
+// alpnScan returns the application protocols the host negotiates through
+// ALPN. It is graded Good if the host negotiates HTTP/2, and Warning
+// otherwise.
+func alpnScan(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
+	var protocols []string
+	var handshakes int
+	grade = Warning
+	for _, proto := range alpnProtocols {
+		config := &stdtls.Config{
+			ServerName:         hostname,
+			InsecureSkipVerify: true,
+			MinVersion:         stdtls.VersionTLS10,
+			NextProtos:         []string{proto},
+		}
+
+		state, e := stdHandshake(ctx, addr, config)
+		if e != nil {
+			continue
+		}
+		handshakes++
+		if state.NegotiatedProtocol == proto {
+			protocols = append(protocols, proto)
+			if proto == "h2" {
+				grade = Good
+			}
+		}
+	}
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	if handshakes == 0 {
+		err = errors.New("couldn't complete a handshake")
+		return
+	}
+	output = protocols
+	return
+}
+
+// The following constants are the TLS record types and alerts read by
+// fallbackSCSVScan.
+const (
+	recordTypeAlert     = 21
+	recordTypeHandshake = 22
+
+	alertProtocolVersion       = 70
+	alertInappropriateFallback = 86
+)
+
+// fallbackSCSVScan determines whether the host rejects a connection
+// signalled with TLS_FALLBACK_SCSV as a fallback to a protocol version
+// below the highest it supports, as RFC 7507 requires. It is graded Good
+// if the host rejects the fallback, or doesn't support the lower
+// version, and Bad if it accepts it, leaving its clients open to
+// downgrade attacks.
+func fallbackSCSVScan(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
+	var vers uint16
+	if _, e := stdHandshake(ctx, addr, stdTLSConfig(hostname, versionTLS13)); e == nil {
+		vers = tls.VersionTLS12
+	} else if _, e = stdHandshake(ctx, addr, stdTLSConfig(hostname, tls.VersionTLS12)); e == nil {
+		vers = tls.VersionTLS11
+	} else if _, _, _, e = sayHello(ctx, addr, hostname, nil, nil, tls.VersionTLS11, nil); e == nil {
+		vers = tls.VersionTLS10
+	} else {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+		// The host supports no version it could fall back from.
+		return Skipped, nil, nil
+	}
+
+	conn, err := dial(ctx, addr)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	hello, err := fallbackHello(vers, hostname)
+	if err != nil {
+		return
+	}
+	if _, err = conn.Write(hello); err != nil {
+		return
+	}
+
+	var header [7]byte
+	if _, err = io.ReadFull(conn, header[:5]); err != nil {
+		return
+	}
+	switch header[0] {
+	case recordTypeAlert:
+		if _, err = io.ReadFull(conn, header[5:]); err != nil {
+			return
+		}
+		switch header[6] {
+		case alertInappropriateFallback:
+			grade, output = Good, "fallback rejected"
+		case alertProtocolVersion:
+			grade, output = Good, fmt.Sprintf("%s not supported", versionNames[vers])
+		default:
+			err = fmt.Errorf("host sent unexpected alert %d", header[6])
+		}
+	case recordTypeHandshake:
+		grade, output = Bad, fmt.Sprintf("fallback to %s accepted", versionNames[vers])
+	default:
+		err = fmt.Errorf("host sent unexpected record type %d", header[0])
+	}
+	return
+}
+
+// fallbackCiphers are the cipher suites offered by fallbackHello, which
+// are supported by every protocol version it sends.
+var fallbackCiphers = []uint16{
+	0xc02f, // TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	0xc02b, // TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+	0xc013, // TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA
+	0xc009, // TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA
+	0xc014, // TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA
+	0xc00a, // TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA
+	0x002f, // TLS_RSA_WITH_AES_128_CBC_SHA
+	0x0035, // TLS_RSA_WITH_AES_256_CBC_SHA
+	0x000a, // TLS_RSA_WITH_3DES_EDE_CBC_SHA
+	0x5600, // TLS_FALLBACK_SCSV
+}
+
+// fallbackHello returns a ClientHello record for protocol version vers
+// that signals a fallback with TLS_FALLBACK_SCSV.
+func fallbackHello(vers uint16, hostname string) ([]byte, error) {
+	var body []byte
+	body = appendUint16(body, vers)
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	body = append(body, random...)
+	body = append(body, 0) // no session ID
+
+	body = appendUint16(body, uint16(2*len(fallbackCiphers)))
+	for _, cipherID := range fallbackCiphers {
+		body = appendUint16(body, cipherID)
+	}
+	body = append(body, 1, 0) // null compression only
+
+	var exts []byte
+	if hostname != "" && net.ParseIP(hostname) == nil {
+		exts = appendExtension(exts, 0x0000, func(b []byte) []byte {
+			b = appendUint16(b, uint16(3+len(hostname)))
+			b = append(b, 0)
+			b = appendUint16(b, uint16(len(hostname)))
+			return append(b, hostname...)
+		})
+	}
+	// supported_groups: x25519, secp256r1 and secp384r1.
+	exts = appendExtension(exts, 0x000a, func(b []byte) []byte {
+		return append(b, 0, 6, 0x00, 0x1d, 0x00, 0x17, 0x00, 0x18)
+	})
+	// ec_point_formats: uncompressed.
+	exts = appendExtension(exts, 0x000b, func(b []byte) []byte {
+		return append(b, 1, 0)
+	})
+	if vers >= tls.VersionTLS12 {
+		// signature_algorithms: ECDSA and RSA (PKCS #1 v1.5 and PSS)
+		// with SHA-256, SHA-384 and SHA-1.
+		exts = appendExtension(exts, 0x000d, func(b []byte) []byte {
+			return append(b, 0, 14,
+				0x04, 0x03, 0x08, 0x04, 0x04, 0x01,
+				0x05, 0x03, 0x08, 0x05, 0x05, 0x01,
+				0x02, 0x01)
+		})
+	}
+	body = appendUint16(body, uint16(len(exts)))
+	body = append(body, exts...)
+
+	handshake := []byte{1, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}
+	handshake = append(handshake, body...)
+
+	record := []byte{recordTypeHandshake, 0x03, 0x01}
+	record = appendUint16(record, uint16(len(handshake)))
+	return append(record, handshake...), nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// appendExtension appends an extension of type extType, with the data
+// appended by data, to b.
+func appendExtension(b []byte, extType uint16, data func([]byte) []byte) []byte {
+	ext := data(nil)
+	b = appendUint16(b, extType)
+	b = appendUint16(b, uint16(len(ext)))
+	return append(b, ext...)
+}
@@ -0,0 +1,109 @@
+package scan
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTLSServer(config *tls.Config, http2 bool) *httptest.Server {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = config
+	srv.EnableHTTP2 = http2
+	srv.StartTLS()
+	return srv
+}
+
+func TestVersionsScan(t *testing.T) {
+	srv := newTLSServer(&tls.Config{MinVersion: tls.VersionTLS12}, false)
+	defer srv.Close()
+
+	grade, output, err := versionsScan(context.Background(), srv.Listener.Addr().String(), "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Good || strings.Join(output.([]string), ",") != "TLS 1.3,TLS 1.2" {
+		t.Fatalf("unexpected result %v %v", grade, output)
+	}
+
+	srv = newTLSServer(&tls.Config{MinVersion: tls.VersionTLS12, MaxVersion: tls.VersionTLS12}, false)
+	defer srv.Close()
+	grade, output, err = versionsScan(context.Background(), srv.Listener.Addr().String(), "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Warning || strings.Join(output.([]string), ",") != "TLS 1.2" {
+		t.Fatalf("expected a host without TLS 1.3 to be graded Warning, have %v %v", grade, output)
+	}
+}
+
+func TestNamedGroupsScan(t *testing.T) {
+	srv := newTLSServer(&tls.Config{CurvePreferences: []tls.CurveID{tls.CurveP384}}, false)
+	defer srv.Close()
+
+	grade, output, err := namedGroupsScan(context.Background(), srv.Listener.Addr().String(), "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Warning || strings.Join(output.([]string), ",") != "secp384r1" {
+		t.Fatalf("unexpected result %v %v", grade, output)
+	}
+
+	srv = newTLSServer(&tls.Config{MaxVersion: tls.VersionTLS12}, false)
+	defer srv.Close()
+	if grade, _, _ = namedGroupsScan(context.Background(), srv.Listener.Addr().String(), "127.0.0.1"); grade != Skipped {
+		t.Fatalf("expected a host without TLS 1.3 to be skipped, have %v", grade)
+	}
+}
+
+func TestALPNScan(t *testing.T) {
+	srv := newTLSServer(&tls.Config{NextProtos: []string{"h2", "http/1.1"}}, true)
+	defer srv.Close()
+
+	grade, output, err := alpnScan(context.Background(), srv.Listener.Addr().String(), "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Good || strings.Join(output.([]string), ",") != "h2,http/1.1" {
+		t.Fatalf("unexpected result %v %v", grade, output)
+	}
+}
+
+func TestFallbackSCSVScan(t *testing.T) {
+	srv := newTLSServer(&tls.Config{}, false)
+	defer srv.Close()
+
+	grade, output, err := fallbackSCSVScan(context.Background(), srv.Listener.Addr().String(), "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Good || output != "fallback rejected" {
+		t.Fatalf("unexpected result %v %v", grade, output)
+	}
+}
+
+func TestKexPreferenceScan(t *testing.T) {
+	srv := newTLSServer(&tls.Config{
+		MaxVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.CurveP384, tls.CurveP256},
+	}, false)
+	defer srv.Close()
+
+	grade, output, err := kexPreferenceScan(context.Background(), srv.Listener.Addr().String(), "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pref, _ := output.(kexPreference)
+	if grade != Good || !pref.ServerPreference || strings.Join(pref.Curves, ",") != "secp384r1,secp256r1" {
+		t.Fatalf("unexpected result %v %+v", grade, output)
+	}
+
+	srv = newTLSServer(&tls.Config{MinVersion: tls.VersionTLS13}, false)
+	defer srv.Close()
+	if grade, _, _ = kexPreferenceScan(context.Background(), srv.Listener.Addr().String(), "127.0.0.1"); grade != Skipped {
+		t.Fatalf("expected a host without TLS 1.2 to be skipped, have %v", grade)
+	}
+}
@@ -1,6 +1,10 @@
 package scan
 
-import "github.com/cloudflare/cf-tls/tls"
+import (
+	"context"
+
+	"github.com/cloudflare/cf-tls/tls"
+)
 
 // TLSSession contains tests of host TLS Session Resumption via
 // Session Tickets and Session IDs
@@ -15,11 +19,11 @@ var TLSSession = &Family{
 }
 
 // SessionResumeScan tests that host is able to resume sessions across all addresses.
-func sessionResumeScan(addr, hostname string) (grade Grade, output Output, err error) {
+func sessionResumeScan(ctx context.Context, addr, hostname string) (grade Grade, output Output, err error) {
 	config := defaultTLSConfig(hostname)
 	config.ClientSessionCache = tls.NewLRUClientSessionCache(1)
 
-	conn, err := tls.DialWithDialer(Dialer, Network, addr, config)
+	conn, err := dialTLS(ctx, addr, config)
 	if err != nil {
 		return
 	}
@@ -27,9 +31,9 @@ func sessionResumeScan(addr, hostname string) (grade Grade, output Output, err e
 		return
 	}
 
-	return multiscan(addr, func(addrport string) (g Grade, o Output, e error) {
+	return multiscan(ctx, addr, func(addrport string) (g Grade, o Output, e error) {
 		var conn *tls.Conn
-		if conn, e = tls.DialWithDialer(Dialer, Network, addrport, config); e != nil {
+		if conn, e = dialTLS(ctx, addrport, config); e != nil {
 			return
 		}
 		conn.Close()
@@ -15,6 +15,7 @@ import (
 	"math/big"
 	"net"
 
+	"github.com/bbandix/cfssl/audit"
 	"github.com/bbandix/cfssl/config"
 	cferr "github.com/bbandix/cfssl/errors"
 	"github.com/bbandix/cfssl/helpers"
@@ -98,7 +99,10 @@ func (s *Signer) sign(template *x509.Certificate, profile *config.SigningProfile
 		initRoot = true
 		template.MaxPathLen = signer.MaxPathLen
 	} else if template.IsCA {
-		template.MaxPathLen = 1
+		// A cross-signed CA keeps its own path length.
+		if template.MaxPathLen == 0 && !template.MaxPathLenZero {
+			template.MaxPathLen = 1
+		}
 		template.DNSNames = nil
 	}
 
@@ -114,7 +118,6 @@ func (s *Signer) sign(template *x509.Certificate, profile *config.SigningProfile
 	}
 
 	cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
-	log.Infof("signed certificate with serial number %d", template.SerialNumber)
 	return
 }
 
@@ -172,6 +175,17 @@ func OverrideHosts(template *x509.Certificate, hosts []string) {
 // certificate or certificate request with the signing profile,
 // specified by profileName.
 func (s *Signer) Sign(req signer.SignRequest) (cert []byte, err error) {
+	rec := &audit.Record{
+		Operation: audit.OpSign,
+		RequestID: req.RequestID,
+		Requester: req.Requester,
+		Profile:   req.Profile,
+	}
+	if s.ca == nil {
+		rec.Operation = audit.OpInitCA
+	}
+	defer func() { cert, err = recordSign(rec, cert, err) }()
+
 	profile, err := signer.Profile(s, req.Profile)
 	if err != nil {
 		return
@@ -182,46 +196,87 @@ func (s *Signer) Sign(req signer.SignRequest) (cert []byte, err error) {
 		return nil, cferr.New(cferr.CSRError, cferr.DecodeFailed)
 	}
 
-	if block.Type != "CERTIFICATE REQUEST" {
-		return nil, cferr.Wrap(cferr.CSRError,
-			cferr.BadRequest, errors.New("not a certificate or csr"))
-	}
-
-	csrTemplate, err := signer.ParseCertificateRequest(s, block.Bytes)
-	if err != nil {
-		return nil, err
-	}
-
-	// Copy out only the fields from the CSR authorized by policy.
 	safeTemplate := x509.Certificate{}
-	// If the profile contains no explicit whitelist, assume that all fields
-	// should be copied from the CSR.
-	if profile.CSRWhitelist == nil {
-		safeTemplate = *csrTemplate
-	} else {
-		if profile.CSRWhitelist.Subject {
-			safeTemplate.Subject = csrTemplate.Subject
-		}
-		if profile.CSRWhitelist.PublicKeyAlgorithm {
-			safeTemplate.PublicKeyAlgorithm = csrTemplate.PublicKeyAlgorithm
+	switch block.Type {
+	case "CERTIFICATE REQUEST":
+		csrTemplate, err := signer.ParseCertificateRequest(s, block.Bytes)
+		if err != nil {
+			return nil, err
 		}
-		if profile.CSRWhitelist.PublicKey {
-			safeTemplate.PublicKey = csrTemplate.PublicKey
+
+		// Copy out only the fields from the CSR authorized by policy.
+		// If the profile contains no explicit whitelist, assume that all fields
+		// should be copied from the CSR.
+		if profile.CSRWhitelist == nil {
+			safeTemplate = *csrTemplate
+		} else {
+			if profile.CSRWhitelist.Subject {
+				safeTemplate.Subject = csrTemplate.Subject
+			}
+			if profile.CSRWhitelist.PublicKeyAlgorithm {
+				safeTemplate.PublicKeyAlgorithm = csrTemplate.PublicKeyAlgorithm
+			}
+			if profile.CSRWhitelist.PublicKey {
+				safeTemplate.PublicKey = csrTemplate.PublicKey
+			}
+			if profile.CSRWhitelist.SignatureAlgorithm {
+				safeTemplate.SignatureAlgorithm = csrTemplate.SignatureAlgorithm
+			}
+			if profile.CSRWhitelist.DNSNames {
+				safeTemplate.DNSNames = csrTemplate.DNSNames
+			}
+			if profile.CSRWhitelist.IPAddresses {
+				safeTemplate.IPAddresses = csrTemplate.IPAddresses
+			}
 		}
-		if profile.CSRWhitelist.SignatureAlgorithm {
-			safeTemplate.SignatureAlgorithm = csrTemplate.SignatureAlgorithm
+
+		OverrideHosts(&safeTemplate, req.Hosts)
+		safeTemplate.Subject = PopulateSubjectFromCSR(req.Subject, safeTemplate.Subject)
+	case "CERTIFICATE":
+		// An existing certificate is re-issued: its subject, key,
+		// SANs and name constraints are kept as they are, so hosts
+		// and subject overrides do not apply. A CA certificate is
+		// cross-signed, and needs a CA profile; any other
+		// certificate can only be renewed, through the renew
+		// package, and must have been issued by this signer.
+		certTemplate, err := signer.ParseCertificate(s, block.Bytes)
+		if err != nil {
+			return nil, err
 		}
-		if profile.CSRWhitelist.DNSNames {
-			safeTemplate.DNSNames = csrTemplate.DNSNames
+
+		if certTemplate.IsCA != profile.CA {
+			if certTemplate.IsCA {
+				return nil, cferr.Wrap(cferr.PolicyError, cferr.InvalidRequest,
+					errors.New("cross-signing a certificate requires a CA profile"))
+			}
+			return nil, cferr.Wrap(cferr.PolicyError, cferr.InvalidRequest,
+				errors.New("renewing a certificate requires a non-CA profile"))
 		}
-		if profile.CSRWhitelist.IPAddresses {
-			safeTemplate.IPAddresses = csrTemplate.IPAddresses
+
+		if !certTemplate.IsCA {
+			if req.Renewal == nil {
+				return nil, cferr.Wrap(cferr.PolicyError, cferr.InvalidRequest,
+					errors.New("end-entity certificates can only be re-issued by renewal"))
+			}
+
+			cert, _ := x509.ParseCertificate(block.Bytes)
+			if s.ca == nil || cert.CheckSignatureFrom(s.ca) != nil {
+				return nil, cferr.Wrap(cferr.CertificateError, cferr.VerifyFailed,
+					errors.New("certificate was not issued by this signer"))
+			}
+
+			if req.Renewal.Request != "" {
+				if err = renewalKey(s, certTemplate, req.Renewal.Request); err != nil {
+					return nil, err
+				}
+			}
 		}
+		safeTemplate = *certTemplate
+	default:
+		return nil, cferr.Wrap(cferr.CSRError,
+			cferr.BadRequest, errors.New("not a certificate or csr"))
 	}
 
-	OverrideHosts(&safeTemplate, req.Hosts)
-	safeTemplate.Subject = PopulateSubjectFromCSR(req.Subject, safeTemplate.Subject)
-
 	// If there is a whitelist, ensure that both the Common Name and SAN DNSNames match
 	if profile.NameWhitelist != nil {
 		if safeTemplate.Subject.CommonName != "" {
@@ -250,7 +305,54 @@ func (s *Signer) Sign(req signer.SignRequest) (cert []byte, err error) {
 		safeTemplate.SerialNumber = serialNumber
 	}
 
-	return s.sign(&safeTemplate, profile)
+	cert, err = s.sign(&safeTemplate, profile)
+	if err != nil {
+		return
+	}
+
+	signedCertificates.WithLabelValues(s.profileLabel(req.Profile), keyAlgorithm(safeTemplate.PublicKey)).Inc()
+	log.ForRequest(req.RequestID).Info("signed certificate",
+		"serial", safeTemplate.SerialNumber, "profile", req.Profile)
+	return
+}
+
+// renewalKey replaces the key of template, an end-entity certificate
+// being renewed, with the key of the PEM-encoded CSR csrPEM. The SKI is
+// cleared so that it is derived from the new key.
+func renewalKey(s *Signer, template *x509.Certificate, csrPEM string) error {
+	block, _ := pem.Decode([]byte(csrPEM))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return cferr.New(cferr.CSRError, cferr.DecodeFailed)
+	}
+
+	csrTemplate, err := signer.ParseCertificateRequest(s, block.Bytes)
+	if err != nil {
+		return err
+	}
+
+	template.PublicKey = csrTemplate.PublicKey
+	template.PublicKeyAlgorithm = csrTemplate.PublicKeyAlgorithm
+	template.SubjectKeyId = nil
+	return nil
+}
+
+// recordSign records a signing operation in the audit log. The
+// certificate is withheld if its issuance can't be recorded.
+func recordSign(rec *audit.Record, cert []byte, err error) ([]byte, error) {
+	rec.SetOutcome(err)
+	if err == nil {
+		if parsed, perr := helpers.ParseCertificatePEM(cert); perr == nil {
+			rec.SetCertificate(parsed)
+		}
+	}
+
+	if auditErr := audit.Write(rec); auditErr != nil {
+		log.ForRequest(rec.RequestID).Error("failed to write audit record", "error", auditErr)
+		if err == nil {
+			return nil, cferr.Wrap(cferr.CertificateError, cferr.Unknown, auditErr)
+		}
+	}
+	return cert, err
 }
 
 // Info return a populated info.Resp struct or an error.
@@ -1,10 +1,12 @@
 package local
 
 import (
+	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"io/ioutil"
+	"math/big"
 	"reflect"
 	"regexp"
 	"sort"
@@ -12,6 +14,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/bbandix/cfssl/api/metrics"
+	"github.com/bbandix/cfssl/audit"
 	"github.com/bbandix/cfssl/config"
 	"github.com/bbandix/cfssl/csr"
 	"github.com/bbandix/cfssl/helpers"
@@ -278,6 +282,24 @@ func TestPolicy(t *testing.T) {
 	}
 }
 
+func TestProfileLabel(t *testing.T) {
+	s := newTestSigner(t)
+	s.SetPolicy(&config.Signing{
+		Profiles: map[string]*config.SigningProfile{"www": {}},
+		Default:  config.DefaultConfig(),
+	})
+
+	for profile, label := range map[string]string{
+		"":      "",
+		"www":   "www",
+		"bogus": metrics.UnknownProfile,
+	} {
+		if got := s.profileLabel(profile); got != label {
+			t.Fatalf("profile %q recorded as %q, expected %q", profile, got, label)
+		}
+	}
+}
+
 func newCustomSigner(t *testing.T, testCaFile, testCaKeyFile string) (s *Signer) {
 	s, err := NewSignerFromFile(testCaFile, testCaKeyFile, nil)
 	if err != nil {
@@ -880,3 +902,203 @@ func TestNameWhitelistSign(t *testing.T) {
 	}
 
 }
+
+func TestCrossSign(t *testing.T) {
+	s := newTestSigner(t)
+	s.SetPolicy(&config.Signing{
+		Profiles: map[string]*config.SigningProfile{
+			"leaf": &config.SigningProfile{
+				Usage:  []string{"digital signature"},
+				Expiry: expiry,
+			},
+		},
+		Default: &config.SigningProfile{
+			Usage:  []string{"cert sign", "crl sign"},
+			Expiry: expiry,
+			CA:     true,
+		},
+	})
+
+	caPEM, err := ioutil.ReadFile(testECDSACaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := helpers.ParseCertificatePEM(caPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM, err := s.Sign(signer.SignRequest{
+		Request: string(caPEM),
+		Hosts:   []string{"example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(cert.RawSubject, ca.RawSubject) {
+		t.Fatal("cross-signed certificate subject mismatch")
+	}
+	if !reflect.DeepEqual(cert.RawSubjectPublicKeyInfo, ca.RawSubjectPublicKeyInfo) {
+		t.Fatal("cross-signed certificate public key mismatch")
+	}
+	if !reflect.DeepEqual(cert.SubjectKeyId, ca.SubjectKeyId) {
+		t.Fatal("cross-signed certificate SKI mismatch")
+	}
+	if !cert.IsCA || len(cert.DNSNames) != 0 {
+		t.Fatal("cross-signed certificate should be a CA without SANs")
+	}
+	if err = cert.CheckSignatureFrom(s.ca); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cross-signing needs a CA profile.
+	_, err = s.Sign(signer.SignRequest{Request: string(caPEM), Profile: "leaf"})
+	if err == nil {
+		t.Fatal("cross-signing with a non-CA profile should fail")
+	}
+
+	// Only CA certificates can be cross-signed.
+	csrPEM, err := ioutil.ReadFile(testCSR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafPEM, err := s.Sign(signer.SignRequest{Request: string(csrPEM), Profile: "leaf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Sign(signer.SignRequest{Request: string(leafPEM)})
+	if err == nil {
+		t.Fatal("cross-signing a non-CA certificate should fail")
+	}
+}
+
+func TestCrossSignNameConstraints(t *testing.T) {
+	s := newTestSigner(t)
+	s.SetPolicy(&config.Signing{
+		Default: &config.SigningProfile{
+			Usage:  []string{"cert sign", "crl sign"},
+			Expiry: expiry,
+			CA:     true,
+		},
+	})
+
+	keyPEM, err := ioutil.ReadFile(testECDSACaKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := helpers.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:                big.NewInt(1),
+		Subject:                     pkix.Name{CommonName: "constrained CA"},
+		NotBefore:                   time.Now(),
+		NotAfter:                    time.Now().Add(expiry),
+		KeyUsage:                    x509.KeyUsageCertSign,
+		BasicConstraintsValid:       true,
+		IsCA:                        true,
+		MaxPathLen:                  0,
+		MaxPathLenZero:              true,
+		PermittedDNSDomainsCritical: true,
+		PermittedDNSDomains:         []string{"example.com"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, priv.Public(), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM, err := s.Sign(signer.SignRequest{
+		Request: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cert.PermittedDNSDomainsCritical || !reflect.DeepEqual(cert.PermittedDNSDomains, []string{"example.com"}) {
+		t.Fatalf("name constraints were not copied: %v", cert.PermittedDNSDomains)
+	}
+	if cert.MaxPathLen != 0 || !cert.MaxPathLenZero {
+		t.Fatalf("path length was not kept: %d", cert.MaxPathLen)
+	}
+}
+
+// memStore is an audit.Store that keeps records in memory.
+type memStore []*audit.Record
+
+func (s *memStore) Append(r *audit.Record) error {
+	*s = append(*s, r)
+	return nil
+}
+
+func (s *memStore) Last() (*audit.Record, error) {
+	if len(*s) == 0 {
+		return nil, nil
+	}
+	return (*s)[len(*s)-1], nil
+}
+
+// The checkpoint is only read by NewLog, which these tests call on
+// empty stores, so it isn't kept.
+func (s *memStore) SetCheckpoint(c *audit.Checkpoint) error {
+	return nil
+}
+
+func (s *memStore) Checkpoint() (*audit.Checkpoint, error) {
+	return nil, nil
+}
+
+func TestSignAudit(t *testing.T) {
+	store := &memStore{}
+	l, err := audit.NewLog(store, []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.SetLog(l)
+	defer audit.SetLog(nil)
+
+	s := newTestSigner(t)
+	csrPEM, err := ioutil.ReadFile(testSANCSR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM, err := s.Sign(signer.SignRequest{Request: string(csrPEM), RequestID: "abc", Requester: "client@127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Sign(signer.SignRequest{Request: "not a CSR"})
+	if err == nil {
+		t.Fatal("expected an error for an invalid request")
+	}
+
+	if len(*store) != 2 {
+		t.Fatalf("expected 2 audit records, have %d", len(*store))
+	}
+
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := (*store)[0]
+	if rec.Operation != audit.OpSign || rec.Outcome != audit.OutcomeSuccess ||
+		rec.RequestID != "abc" || rec.Requester != "client@127.0.0.1" ||
+		rec.Serial != cert.SerialNumber.String() || len(rec.SANs) == 0 {
+		t.Fatalf("unexpected audit record %+v", rec)
+	}
+
+	rec = (*store)[1]
+	if rec.Outcome != audit.OutcomeFailure || rec.Error == "" || rec.PrevHash != (*store)[0].Hash {
+		t.Fatalf("unexpected audit record for a failed request %+v", rec)
+	}
+}
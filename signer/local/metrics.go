@@ -0,0 +1,51 @@
+package local
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+
+	"github.com/bbandix/cfssl/api/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var signedCertificates = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "cfssl",
+		Name:      "certificates_signed_total",
+		Help:      "Number of certificates signed, by signing profile and key algorithm.",
+	},
+	[]string{"profile", "key_algorithm"},
+)
+
+func init() {
+	prometheus.MustRegister(signedCertificates)
+}
+
+// keyAlgorithm names the algorithm of a public key for metrics.
+func keyAlgorithm(pub interface{}) string {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return "rsa"
+	case *ecdsa.PublicKey:
+		return "ecdsa"
+	case ed25519.PublicKey:
+		return "ed25519"
+	default:
+		return "unknown"
+	}
+}
+
+// profileLabel returns the profile recorded for a request naming
+// profile. As with the API's request metrics, a profile that isn't in
+// the policy is recorded as metrics.UnknownProfile, so that requests
+// can't create new metrics at will.
+func (s *Signer) profileLabel(profile string) string {
+	if profile == "" {
+		return ""
+	}
+	if policy := s.Policy(); policy == nil || policy.Profiles[profile] == nil {
+		return metrics.UnknownProfile
+	}
+	return profile
+}
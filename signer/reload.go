@@ -0,0 +1,85 @@
+package signer
+
+import (
+	"crypto/x509"
+	"sync"
+
+	"github.com/bbandix/cfssl/config"
+	"github.com/bbandix/cfssl/info"
+)
+
+// A Reloadable is a Signer that delegates to another signer, which may
+// be swapped out while it is in use, so that a server can pick up new
+// configuration and CA material without restarting. Each method uses
+// the signer current at the time it is called: a request that makes
+// several calls should take a Snapshot first, so that it is handled by
+// one signer even if a reload happens in between.
+type Reloadable struct {
+	lock   sync.RWMutex
+	signer Signer
+}
+
+// A Stopper is a Signer with background work, such as health checks of
+// its remotes, that must be stopped once the signer is replaced.
+type Stopper interface {
+	Stop()
+}
+
+// NewReloadable returns a Reloadable that delegates to s.
+func NewReloadable(s Signer) *Reloadable {
+	return &Reloadable{signer: s}
+}
+
+// Swap replaces the signer delegated to with s. If the replaced signer
+// is a Stopper, it is stopped.
+func (r *Reloadable) Swap(s Signer) {
+	r.lock.Lock()
+	old := r.signer
+	r.signer = s
+	r.lock.Unlock()
+
+	if stopper, ok := old.(Stopper); ok && old != s {
+		stopper.Stop()
+	}
+}
+
+// Current returns the signer currently delegated to.
+func (r *Reloadable) Current() Signer {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.signer
+}
+
+// Snapshot returns the signer that s currently delegates to if s is a
+// Reloadable, and s itself otherwise.
+func Snapshot(s Signer) Signer {
+	if r, ok := s.(*Reloadable); ok {
+		return r.Current()
+	}
+	return s
+}
+
+// Info returns information about the current signer.
+func (r *Reloadable) Info(req info.Req) (*info.Resp, error) {
+	return r.Current().Info(req)
+}
+
+// Policy returns the current signer's signature policy.
+func (r *Reloadable) Policy() *config.Signing {
+	return r.Current().Policy()
+}
+
+// SetPolicy sets the current signer's signature policy.
+func (r *Reloadable) SetPolicy(policy *config.Signing) {
+	r.Current().SetPolicy(policy)
+}
+
+// SigAlgo returns the current signer's signature algorithm.
+func (r *Reloadable) SigAlgo() x509.SignatureAlgorithm {
+	return r.Current().SigAlgo()
+}
+
+// Sign signs the request with the current signer.
+func (r *Reloadable) Sign(req SignRequest) ([]byte, error) {
+	return r.Current().Sign(req)
+}
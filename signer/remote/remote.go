@@ -4,6 +4,7 @@ import (
 	"crypto/x509"
 	"encoding/json"
 	"errors"
+	"sync"
 
 	"github.com/bbandix/cfssl/api/client"
 	"github.com/bbandix/cfssl/config"
@@ -16,6 +17,56 @@ import (
 // fulfills the signer.Signer interface
 type Signer struct {
 	policy *config.Signing
+
+	// remotes caches the client for each remote, so that
+	// strategies that keep state across requests (round robin,
+	// health checks) see every request.
+	lock    sync.Mutex
+	remotes map[remoteKey]client.Remote
+	stopped bool
+}
+
+// remoteKey identifies the client for a profile's remote.
+type remoteKey struct {
+	server  string
+	options *config.RemoteOptions
+}
+
+// remote returns the client for the remote of profile p.
+func (s *Signer) remote(p *config.SigningProfile) client.Remote {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := remoteKey{p.RemoteServer, p.RemoteOptions}
+	if server, ok := s.remotes[key]; ok {
+		return server
+	}
+
+	server := client.NewServerWithOptions(p.RemoteServer, p.RemoteOptions.ClientOptions())
+	if server != nil && s.stopped {
+		// A request still being handled by a replaced signer
+		// gets a client of its own, without health checks.
+		client.Stop(server)
+	} else if server != nil {
+		if s.remotes == nil {
+			s.remotes = make(map[remoteKey]client.Remote)
+		}
+		s.remotes[key] = server
+	}
+	return server
+}
+
+// Stop ends the background work, such as health checks, of the clients
+// for the signer's remotes. The signer remains usable, so that
+// requests still being handled when it is replaced can complete.
+func (s *Signer) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.stopped = true
+	for _, server := range s.remotes {
+		client.Stop(server)
+	}
 }
 
 // NewSigner creates a new remote Signer directly from a
@@ -73,7 +124,7 @@ func (s *Signer) remoteOp(req interface{}, profile, target string) (resp interfa
 		return
 	}
 
-	server := client.NewServer(p.RemoteServer)
+	server := s.remote(p)
 	if server == nil {
 		return nil, cferr.Wrap(cferr.PolicyError, cferr.InvalidRequest,
 			errors.New("failed to connect to remote"))
@@ -10,6 +10,7 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
+	"encoding/pem"
 	"errors"
 	"math/big"
 	"strings"
@@ -41,6 +42,22 @@ type SignRequest struct {
 	Profile   string   `json:"profile"`
 	Label     string   `json:"label"`
 	Serial    *big.Int `json:"serial,omitempty"`
+	RequestID string   `json:"-"` // ID of the API request being served, for logging
+	Requester string   `json:"-"` // identity of the client, for the audit log
+	Renewal   *Renewal `json:"-"` // set only for verified renewals; see Renewal
+}
+
+// A Renewal marks a sign request as the renewal of the end-entity
+// certificate given as its Request. Signers only re-issue end-entity
+// certificates for renewals, which are made by the renew package once
+// it has checked the certificate's issuer, expiry and revocation status
+// and the proof of possession of its key. A Renewal can't be set in a
+// JSON sign request.
+type Renewal struct {
+	// Request is a PEM-encoded CSR for the key the certificate is
+	// renewed for, or empty to keep the certificate's key. Only the
+	// key is used.
+	Request string
 }
 
 // appendIf appends to a if s is not an empty string.
@@ -75,6 +92,60 @@ func SplitHosts(hostList string) []string {
 	return strings.Split(hostList, ",")
 }
 
+// RequestedNames returns the names that a certificate signed for req
+// would carry: its common name, DNS names and IP addresses. For a CSR,
+// the hosts and subject of the request take precedence over those in
+// the CSR; an existing certificate keeps its own names, including its
+// email addresses and URIs.
+func RequestedNames(req SignRequest) ([]string, error) {
+	block, _ := pem.Decode([]byte(req.Request))
+	if block == nil {
+		return nil, cferr.New(cferr.CSRError, cferr.DecodeFailed)
+	}
+
+	var names []string
+	switch block.Type {
+	case "CERTIFICATE REQUEST":
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			return nil, cferr.Wrap(cferr.CSRError, cferr.ParseFailed, err)
+		}
+
+		cn := csr.Subject.CommonName
+		if req.Subject != nil && req.Subject.CN != "" {
+			cn = req.Subject.CN
+		}
+		appendIf(cn, &names)
+
+		if req.Hosts != nil {
+			names = append(names, req.Hosts...)
+		} else {
+			names = append(names, csr.DNSNames...)
+			for _, ip := range csr.IPAddresses {
+				names = append(names, ip.String())
+			}
+		}
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, err)
+		}
+
+		appendIf(cert.Subject.CommonName, &names)
+		names = append(names, cert.DNSNames...)
+		for _, ip := range cert.IPAddresses {
+			names = append(names, ip.String())
+		}
+		names = append(names, cert.EmailAddresses...)
+		for _, uri := range cert.URIs {
+			names = append(names, uri.String())
+		}
+	default:
+		return nil, cferr.Wrap(cferr.CSRError, cferr.BadRequest, errors.New("not a certificate or csr"))
+	}
+	return names, nil
+}
+
 // A Signer contains a CA's certificate and private key for signing
 // certificates, a Signing policy to refer to and a SignatureAlgorithm.
 type Signer interface {
@@ -163,6 +234,53 @@ func ParseCertificateRequest(s Signer, csrBytes []byte) (template *x509.Certific
 	return
 }
 
+// ParseCertificate takes an existing certificate and builds a
+// certificate template from it for re-issuing it, either to cross-sign
+// a CA certificate or to renew an end-entity certificate. The subject,
+// public key, SKI and SANs of the certificate are kept, as are the
+// path length and name constraints of a CA certificate; everything
+// else comes from the signing profile. The template's IsCA reflects
+// the existing certificate, so that callers can match it against the
+// profile.
+func ParseCertificate(s Signer, certBytes []byte) (template *x509.Certificate, err error) {
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		err = cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, err)
+		return
+	}
+
+	template = &x509.Certificate{
+		Subject:            cert.Subject,
+		RawSubject:         cert.RawSubject,
+		PublicKeyAlgorithm: cert.PublicKeyAlgorithm,
+		PublicKey:          cert.PublicKey,
+		SignatureAlgorithm: s.SigAlgo(),
+		SubjectKeyId:       cert.SubjectKeyId,
+		IsCA:               cert.BasicConstraintsValid && cert.IsCA,
+	}
+
+	if !template.IsCA {
+		template.DNSNames = cert.DNSNames
+		template.EmailAddresses = cert.EmailAddresses
+		template.IPAddresses = cert.IPAddresses
+		template.URIs = cert.URIs
+		return
+	}
+
+	template.MaxPathLen = cert.MaxPathLen
+	template.MaxPathLenZero = cert.MaxPathLenZero
+	template.PermittedDNSDomainsCritical = cert.PermittedDNSDomainsCritical
+	template.PermittedDNSDomains = cert.PermittedDNSDomains
+	template.ExcludedDNSDomains = cert.ExcludedDNSDomains
+	template.PermittedIPRanges = cert.PermittedIPRanges
+	template.ExcludedIPRanges = cert.ExcludedIPRanges
+	template.PermittedEmailAddresses = cert.PermittedEmailAddresses
+	template.ExcludedEmailAddresses = cert.ExcludedEmailAddresses
+	template.PermittedURIDomains = cert.PermittedURIDomains
+	template.ExcludedURIDomains = cert.ExcludedURIDomains
+	return
+}
+
 type subjectPublicKeyInfo struct {
 	Algorithm        pkix.AlgorithmIdentifier
 	SubjectPublicKey asn1.BitString
@@ -191,9 +309,14 @@ func ComputeSKI(template *x509.Certificate) ([]byte, error) {
 // FillTemplate is a utility function that tries to load as much of
 // the certificate template as possible from the profiles and current
 // template. It fills in the key uses, expiration, revocation URLs
-// and SKI.
+// and SKI. An SKI already present in the template, as when
+// cross-signing an existing certificate, is kept.
 func FillTemplate(template *x509.Certificate, defaultProfile, profile *config.SigningProfile) error {
-	ski, err := ComputeSKI(template)
+	ski := template.SubjectKeyId
+	var err error
+	if len(ski) == 0 {
+		ski, err = ComputeSKI(template)
+	}
 
 	var (
 		eku             []x509.ExtKeyUsage
@@ -6,10 +6,12 @@ import (
 	"encoding/asn1"
 	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"reflect"
 	"testing"
 
 	"github.com/bbandix/cfssl/config"
+	"github.com/bbandix/cfssl/info"
 )
 
 func TestSplitHosts(t *testing.T) {
@@ -91,3 +93,92 @@ func TestAddPoliciesWithQualifiers(t *testing.T) {
 			hex.EncodeToString(ext.Value), hex.EncodeToString(expectedBytes)))
 	}
 }
+
+func TestRequestedNames(t *testing.T) {
+	csrPEM, err := ioutil.ReadFile("../api/testdata/csr.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := RequestedNames(SignRequest{Request: string(csrPEM)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"cloudflare-inter.com", "cloudflare-inter.com", "wwwcloudflare-inter.com"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("names from CSR: expected %v, have %v", expected, names)
+	}
+
+	names, err = RequestedNames(SignRequest{
+		Request: string(csrPEM),
+		Hosts:   []string{"a.example.com", "10.0.0.1"},
+		Subject: &Subject{CN: "example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"example.com", "a.example.com", "10.0.0.1"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("names with overrides: expected %v, have %v", expected, names)
+	}
+
+	if _, err = RequestedNames(SignRequest{Request: "not PEM"}); err == nil {
+		t.Fatal("expected an error for a request that is not PEM")
+	}
+}
+
+// stubSigner is a Signer that returns a fixed certificate.
+type stubSigner struct {
+	cert    []byte
+	policy  *config.Signing
+	stopped bool
+}
+
+func (s *stubSigner) Info(info.Req) (*info.Resp, error) {
+	return &info.Resp{Certificate: string(s.cert)}, nil
+}
+func (s *stubSigner) Policy() *config.Signing          { return s.policy }
+func (s *stubSigner) SetPolicy(policy *config.Signing) { s.policy = policy }
+func (s *stubSigner) SigAlgo() x509.SignatureAlgorithm { return x509.ECDSAWithSHA256 }
+func (s *stubSigner) Sign(SignRequest) ([]byte, error) { return s.cert, nil }
+func (s *stubSigner) Stop()                            { s.stopped = true }
+
+func TestReloadable(t *testing.T) {
+	old := &stubSigner{cert: []byte("old")}
+	r := NewReloadable(old)
+
+	cert, err := r.Sign(SignRequest{})
+	if err != nil || string(cert) != "old" {
+		t.Fatalf("expected the old signer to sign, have %q (%v)", cert, err)
+	}
+
+	policy := &config.Signing{Default: config.DefaultConfig()}
+	r.SetPolicy(policy)
+	if old.policy != policy || r.Policy() != policy {
+		t.Fatal("policy was not set on the current signer")
+	}
+
+	snapshot := Snapshot(r)
+	r.Swap(&stubSigner{cert: []byte("new")})
+	if cert, _ = snapshot.Sign(SignRequest{}); string(cert) != "old" {
+		t.Fatalf("expected a snapshot to keep the old signer, have %q", cert)
+	}
+	if !old.stopped {
+		t.Fatal("the replaced signer was not stopped")
+	}
+	if r.Policy() != nil {
+		t.Fatal("policy was not swapped with the signer")
+	}
+	cert, err = r.Sign(SignRequest{})
+	if err != nil || string(cert) != "new" {
+		t.Fatalf("expected the new signer to sign, have %q (%v)", cert, err)
+	}
+	resp, err := r.Info(info.Req{})
+	if err != nil || resp.Certificate != "new" {
+		t.Fatal("info was not taken from the new signer")
+	}
+
+	if Snapshot(old) != old {
+		t.Fatal("expected the snapshot of a plain signer to be itself")
+	}
+}
@@ -0,0 +1,441 @@
+// Package starttls negotiates TLS over protocols that start out in
+// plaintext and switch to TLS on request, so that the certificates and
+// TLS configuration of mail, directory, chat and database servers can
+// be inspected. Negotiate takes a connection as far as the point where
+// the client sends its ClientHello; the caller then performs the TLS
+// handshake over the same connection.
+package starttls
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+)
+
+// The following constants are the protocols STARTTLS is negotiated for.
+const (
+	SMTP     = "smtp"
+	IMAP     = "imap"
+	POP3     = "pop3"
+	LDAP     = "ldap"
+	XMPP     = "xmpp"
+	FTP      = "ftp"
+	Postgres = "postgres"
+)
+
+// ports holds the port each protocol is usually served on.
+var ports = map[string]string{
+	SMTP:     "25",
+	IMAP:     "143",
+	POP3:     "110",
+	LDAP:     "389",
+	XMPP:     "5222",
+	FTP:      "21",
+	Postgres: "5432",
+}
+
+// Protocols returns the names of the protocols supported, sorted.
+func Protocols() []string {
+	var protocols []string
+	for protocol := range ports {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+	return protocols
+}
+
+// Port returns the port protocol is usually served on, or an error if
+// the protocol isn't supported.
+func Port(protocol string) (string, error) {
+	port, ok := ports[protocol]
+	if !ok {
+		return "", fmt.Errorf("starttls: unknown protocol %q; supported protocols are %s",
+			protocol, strings.Join(Protocols(), ", "))
+	}
+	return port, nil
+}
+
+// Negotiate asks the server at the other end of conn to switch to TLS
+// using protocol, introducing the client as hostname where the protocol
+// needs it. Callers should set a deadline on conn beforehand.
+func Negotiate(conn net.Conn, protocol, hostname string) error {
+	if _, err := Port(protocol); err != nil {
+		return err
+	}
+
+	var err error
+	switch protocol {
+	case SMTP:
+		err = smtp(conn)
+	case IMAP:
+		err = imap(conn)
+	case POP3:
+		err = pop3(conn)
+	case LDAP:
+		err = ldap(conn)
+	case XMPP:
+		err = xmpp(conn, hostname)
+	case FTP:
+		err = ftp(conn)
+	case Postgres:
+		err = postgres(conn)
+	}
+	if err != nil {
+		return fmt.Errorf("starttls: %s: %v", protocol, err)
+	}
+	return nil
+}
+
+// The line-based protocols below read with a bufio.Reader. The server
+// sends nothing after agreeing to switch to TLS until it has received
+// the ClientHello, so nothing the handshake needs is left buffered.
+
+// readReply reads a reply of the form used by SMTP and FTP, which may
+// span several lines, and checks that its code is code.
+func readReply(r *bufio.Reader, code string) (string, error) {
+	var reply []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) < 3 {
+			return "", fmt.Errorf("invalid reply %q", line)
+		}
+		if line[:3] != code {
+			return "", fmt.Errorf("unexpected reply %q", line)
+		}
+		reply = append(reply, line[3:])
+		// The last line of a reply has a space after the code.
+		if len(line) == 3 || line[3] == ' ' {
+			return strings.Join(reply, "\n"), nil
+		}
+	}
+}
+
+func smtp(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	if _, err := readReply(r, "220"); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(conn, "EHLO cfssl\r\n"); err != nil {
+		return err
+	}
+	extensions, err := readReply(r, "250")
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(strings.ToUpper(extensions), "STARTTLS") {
+		return errors.New("server doesn't offer STARTTLS")
+	}
+	if _, err = io.WriteString(conn, "STARTTLS\r\n"); err != nil {
+		return err
+	}
+	_, err = readReply(r, "220")
+	return err
+}
+
+func ftp(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	if _, err := readReply(r, "220"); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(conn, "AUTH TLS\r\n"); err != nil {
+		return err
+	}
+	_, err := readReply(r, "234")
+	return err
+}
+
+// readLine reads a line and checks that it starts with prefix.
+func readLine(r *bufio.Reader, prefix string) error {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, prefix) {
+		return fmt.Errorf("unexpected response %q", line)
+	}
+	return nil
+}
+
+func pop3(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	if err := readLine(r, "+OK"); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(conn, "STLS\r\n"); err != nil {
+		return err
+	}
+	return readLine(r, "+OK")
+}
+
+func imap(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	if err := readLine(r, "* OK"); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(conn, "a1 STARTTLS\r\n"); err != nil {
+		return err
+	}
+	// Untagged responses may come before the tagged one.
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, "* ") {
+			continue
+		}
+		if !strings.HasPrefix(line, "a1 OK") {
+			return fmt.Errorf("unexpected response %q", line)
+		}
+		return nil
+	}
+}
+
+// ldapStartTLSOID is the OID of the LDAP StartTLS extended operation,
+// from RFC 4511.
+const ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// The following are the BER tags of the LDAP messages used.
+const (
+	ldapExtendedRequest  = 23
+	ldapExtendedResponse = 24
+)
+
+func ldap(conn net.Conn) error {
+	// ExtendedRequest ::= [APPLICATION 23] SEQUENCE {
+	//         requestName [0] LDAPOID }
+	requestName, err := asn1.Marshal(asn1.RawValue{
+		Class: asn1.ClassContextSpecific,
+		Tag:   0,
+		Bytes: []byte(ldapStartTLSOID),
+	})
+	if err != nil {
+		return err
+	}
+	request, err := asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassApplication,
+		Tag:        ldapExtendedRequest,
+		IsCompound: true,
+		Bytes:      requestName,
+	})
+	if err != nil {
+		return err
+	}
+	message, err := asn1.Marshal(struct {
+		MessageID int
+		Request   asn1.RawValue
+	}{1, asn1.RawValue{FullBytes: request}})
+	if err != nil {
+		return err
+	}
+	if _, err = conn.Write(message); err != nil {
+		return err
+	}
+
+	// LDAP servers encode responses in BER, which encoding/asn1 is too
+	// strict to read, so the response is picked apart by hand.
+	in, err := readBER(conn)
+	if err != nil {
+		return err
+	}
+	// LDAPMessage ::= SEQUENCE { messageID, protocolOp, ... }
+	_, message, _, err = parseBER(in)
+	if err != nil {
+		return err
+	}
+	if _, _, message, err = parseBER(message); err != nil {
+		return err
+	}
+	tag, response, _, err := parseBER(message)
+	if err != nil {
+		return err
+	}
+	if tag != 0x60|ldapExtendedResponse {
+		return fmt.Errorf("unexpected response with tag %#x", tag)
+	}
+	// The response starts with the LDAPResult, whose first field is
+	// the result code.
+	tag, resultCode, _, err := parseBER(response)
+	if err != nil {
+		return err
+	}
+	if tag != 0x0a || len(resultCode) == 0 {
+		return errors.New("invalid result code")
+	}
+	var code int
+	for _, b := range resultCode {
+		code = code<<8 | int(b)
+	}
+	if code != 0 {
+		return fmt.Errorf("server refused StartTLS with result code %d", code)
+	}
+	return nil
+}
+
+// maxBER is the largest LDAP response accepted.
+const maxBER = 1 << 16
+
+var errBER = errors.New("invalid BER encoding")
+
+// berLength decodes a BER length from the start of in, returning it and
+// the number of bytes it took.
+func berLength(in []byte) (length, n int, err error) {
+	if len(in) == 0 {
+		return 0, 0, errBER
+	}
+	if in[0]&0x80 == 0 {
+		return int(in[0]), 1, nil
+	}
+	n = int(in[0] & 0x7f)
+	if n == 0 || n > 4 || len(in) < 1+n {
+		return 0, 0, errBER
+	}
+	for _, b := range in[1 : 1+n] {
+		length = length<<8 | int(b)
+	}
+	if length > maxBER {
+		return 0, 0, errors.New("response too long")
+	}
+	return length, 1 + n, nil
+}
+
+// parseBER splits the BER element at the start of in, which must have
+// a single-byte tag and a definite length, into its tag and contents.
+func parseBER(in []byte) (tag byte, contents, rest []byte, err error) {
+	if len(in) < 2 {
+		return 0, nil, nil, errBER
+	}
+	length, n, err := berLength(in[1:])
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	body := in[1+n:]
+	if len(body) < length {
+		return 0, nil, nil, errBER
+	}
+	return in[0], body[:length], body[length:], nil
+}
+
+// readBER reads a single BER element from r.
+func readBER(r io.Reader) ([]byte, error) {
+	header := make([]byte, 2, 6)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[1]&0x80 != 0 {
+		if header[1]&0x7f > 4 {
+			return nil, errBER
+		}
+		extra := make([]byte, header[1]&0x7f)
+		if _, err := io.ReadFull(r, extra); err != nil {
+			return nil, err
+		}
+		header = append(header, extra...)
+	}
+	length, _, err := berLength(header[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	body := make([]byte, length)
+	if _, err = io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return append(header, body...), nil
+}
+
+// The following are the XMPP namespaces used.
+const (
+	xmppStreamNS = "http://etherx.jabber.org/streams"
+	xmppTLSNS    = "urn:ietf:params:xml:ns:xmpp-tls"
+)
+
+func xmpp(conn net.Conn, hostname string) error {
+	var to bytes.Buffer
+	xml.EscapeText(&to, []byte(hostname))
+	_, err := fmt.Fprintf(conn, "<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' "+
+		"xmlns:stream='%s' version='1.0'>", to.String(), xmppStreamNS)
+	if err != nil {
+		return err
+	}
+
+	// The decoder is done with once the server proceeds, after which
+	// it sends nothing until the ClientHello.
+	d := xml.NewDecoder(conn)
+	offered := false
+	for features := false; ; {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			if t.Name.Space == xmppStreamNS && t.Name.Local == "features" {
+				features = true
+			}
+			if features && t.Name.Space == xmppTLSNS && t.Name.Local == "starttls" {
+				offered = true
+			}
+		case xml.EndElement:
+			if t.Name.Space == xmppStreamNS && t.Name.Local == "features" {
+				if !offered {
+					return errors.New("server doesn't offer STARTTLS")
+				}
+				if _, err = fmt.Fprintf(conn, "<starttls xmlns='%s'/>", xmppTLSNS); err != nil {
+					return err
+				}
+				return xmppProceed(d)
+			}
+		}
+	}
+}
+
+func xmppProceed(d *xml.Decoder) error {
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		if t, ok := t.(xml.StartElement); ok {
+			if t.Name.Space == xmppTLSNS && t.Name.Local == "proceed" {
+				return nil
+			}
+			return fmt.Errorf("server refused STARTTLS with <%s>", t.Name.Local)
+		}
+	}
+}
+
+// postgresSSLRequest is the code of the PostgreSQL SSLRequest message.
+const postgresSSLRequest = 80877103
+
+func postgres(conn net.Conn) error {
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request, 8)
+	binary.BigEndian.PutUint32(request[4:], postgresSSLRequest)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	response := make([]byte, 1)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return err
+	}
+	if response[0] != 'S' {
+		return errors.New("server doesn't support SSL")
+	}
+	return nil
+}
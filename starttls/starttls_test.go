@@ -0,0 +1,256 @@
+package starttls
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"example.com"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// A stub is the plaintext half of a server, which returns nil once it
+// has agreed to switch to TLS.
+type stub func(conn net.Conn, r *bufio.Reader) error
+
+// serveStub accepts a single connection, runs stub on it and, if it
+// agrees to switch to TLS, completes a TLS handshake. The outcome is
+// sent on the returned channel.
+func serveStub(t *testing.T, s stub) (string, <-chan error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := testCertificate(t)
+	errc := make(chan error, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		if err = s(conn, bufio.NewReader(conn)); err != nil {
+			errc <- err
+			return
+		}
+		errc <- tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}}).Handshake()
+	}()
+	return l.Addr().String(), errc
+}
+
+// expect reads a line and checks that it is line.
+func expect(r *bufio.Reader, line string) error {
+	in, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if in != line+"\r\n" {
+		return fmt.Errorf("expected %q, have %q", line, in)
+	}
+	return nil
+}
+
+func smtpStub(extensions string) stub {
+	return func(conn net.Conn, r *bufio.Reader) error {
+		io.WriteString(conn, "220-stub.example.com ESMTP\r\n220 ready\r\n")
+		if err := expect(r, "EHLO cfssl"); err != nil {
+			return err
+		}
+		io.WriteString(conn, "250-stub.example.com\r\n250-PIPELINING\r\n250 "+extensions+"\r\n")
+		if err := expect(r, "STARTTLS"); err != nil {
+			return err
+		}
+		io.WriteString(conn, "220 go ahead\r\n")
+		return nil
+	}
+}
+
+func imapStub(conn net.Conn, r *bufio.Reader) error {
+	io.WriteString(conn, "* OK IMAP4rev1 ready\r\n")
+	if err := expect(r, "a1 STARTTLS"); err != nil {
+		return err
+	}
+	io.WriteString(conn, "* CAPABILITY IMAP4rev1 STARTTLS\r\na1 OK begin TLS\r\n")
+	return nil
+}
+
+func pop3Stub(conn net.Conn, r *bufio.Reader) error {
+	io.WriteString(conn, "+OK POP3 ready\r\n")
+	if err := expect(r, "STLS"); err != nil {
+		return err
+	}
+	io.WriteString(conn, "+OK begin TLS\r\n")
+	return nil
+}
+
+func ftpStub(conn net.Conn, r *bufio.Reader) error {
+	io.WriteString(conn, "220-Welcome\r\n220 ready\r\n")
+	if err := expect(r, "AUTH TLS"); err != nil {
+		return err
+	}
+	io.WriteString(conn, "234 AUTH TLS successful\r\n")
+	return nil
+}
+
+func ldapStub(resultCode byte) stub {
+	return func(conn net.Conn, r *bufio.Reader) error {
+		request, err := readBER(r)
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(string(request), ldapStartTLSOID) {
+			return fmt.Errorf("unexpected request %x", request)
+		}
+		// Lengths are in the long form, as some servers send them.
+		conn.Write([]byte{
+			0x30, 0x84, 0, 0, 0, 0x10,
+			0x02, 0x01, 0x01,
+			0x78, 0x84, 0, 0, 0, 0x07,
+			0x0a, 0x01, resultCode, 0x04, 0x00, 0x04, 0x00,
+		})
+		return nil
+	}
+}
+
+func xmppStub(conn net.Conn, r *bufio.Reader) error {
+	d := xml.NewDecoder(r)
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "stream" {
+			for _, attr := range start.Attr {
+				if attr.Name.Local == "to" && attr.Value != "example.com" {
+					return fmt.Errorf("unexpected stream to %s", attr.Value)
+				}
+			}
+			break
+		}
+	}
+	fmt.Fprintf(conn, "<?xml version='1.0'?><stream:stream xmlns='jabber:client' xmlns:stream='%s' "+
+		"id='1' from='example.com' version='1.0'><stream:features><starttls xmlns='%s'><required/></starttls>"+
+		"<mechanisms xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><mechanism>PLAIN</mechanism></mechanisms>"+
+		"</stream:features>", xmppStreamNS, xmppTLSNS)
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			if start.Name.Space != xmppTLSNS || start.Name.Local != "starttls" {
+				return fmt.Errorf("unexpected element %s", start.Name.Local)
+			}
+			break
+		}
+	}
+	fmt.Fprintf(conn, "<proceed xmlns='%s'/>", xmppTLSNS)
+	return nil
+}
+
+func postgresStub(response byte) stub {
+	return func(conn net.Conn, r *bufio.Reader) error {
+		request := make([]byte, 8)
+		if _, err := io.ReadFull(r, request); err != nil {
+			return err
+		}
+		if binary.BigEndian.Uint32(request[4:]) != postgresSSLRequest {
+			return fmt.Errorf("unexpected request %x", request)
+		}
+		conn.Write([]byte{response})
+		return nil
+	}
+}
+
+func negotiate(t *testing.T, protocol string, s stub) (<-chan error, error) {
+	addr, errc := serveStub(t, s)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if err = Negotiate(conn, protocol, "example.com"); err != nil {
+		return errc, err
+	}
+	return errc, tls.Client(conn, &tls.Config{ServerName: "example.com", InsecureSkipVerify: true}).Handshake()
+}
+
+func TestNegotiate(t *testing.T) {
+	stubs := map[string]stub{
+		SMTP:     smtpStub("STARTTLS"),
+		IMAP:     imapStub,
+		POP3:     pop3Stub,
+		LDAP:     ldapStub(0),
+		XMPP:     xmppStub,
+		FTP:      ftpStub,
+		Postgres: postgresStub('S'),
+	}
+	if len(stubs) != len(Protocols()) {
+		t.Fatalf("expected a stub for each of %v", Protocols())
+	}
+
+	for protocol, s := range stubs {
+		errc, err := negotiate(t, protocol, s)
+		if err != nil {
+			t.Fatalf("%s: %v", protocol, err)
+		}
+		if err = <-errc; err != nil {
+			t.Fatalf("%s: server: %v", protocol, err)
+		}
+	}
+}
+
+func TestNegotiateRefused(t *testing.T) {
+	refusals := map[string]stub{
+		SMTP:     smtpStub("8BITMIME"),
+		LDAP:     ldapStub(2),
+		Postgres: postgresStub('N'),
+	}
+	for protocol, s := range refusals {
+		if _, err := negotiate(t, protocol, s); err == nil || !strings.HasPrefix(err.Error(), "starttls: "+protocol) {
+			t.Fatalf("%s: expected the server's refusal to fail, have %v", protocol, err)
+		}
+	}
+
+	if _, err := Port("gopher"); err == nil {
+		t.Fatal("expected an unknown protocol to fail")
+	}
+	if err := Negotiate(nil, "gopher", "example.com"); err == nil {
+		t.Fatal("expected negotiating an unknown protocol to fail")
+	}
+}